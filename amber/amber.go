@@ -0,0 +1,203 @@
+/*
+ * amber.go, part of gochem
+ *
+ * Copyright 2021 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+
+//Package amber implements readers for the trajectory formats of current AMBER versions: the AMBER NetCDF
+//trajectory convention, restart (inpcrd/rst7) files, both ASCII and NetCDF, and ASCII mdcrd trajectories.
+//The NetCDF and restart readers obtain the number of atoms and the presence of a periodic box from the files
+//themselves. Topologies can be read from prmtop files with chem.PrmtopFileRead.
+package amber
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//The AMBER formats are registered so chem.OpenTraj can read them. The NetCDF trajectories and restart files
+//share the magic number, so they are told apart by the convention, which is usually at the beginning of the file.
+func init() {
+	chem.RegisterFormat(&chem.FileFormat{Name: "amber-netcdf", Extensions: []string{"nc", "ncdf", "netcdf"},
+		Magic: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("CDF")) && bytes.Contains(header, []byte("AMBER")) && !bytes.Contains(header, []byte("AMBERRESTART"))
+		},
+		OpenTraj: func(name string, top chem.Atomer) (chem.Traj, error) {
+			traj, err := NewNetCDF(name)
+			if err != nil {
+				return nil, err
+			}
+			return traj, nil
+		}})
+	chem.RegisterFormat(&chem.FileFormat{Name: "amber-restart", Extensions: []string{"rst7", "ncrst", "restrt", "inpcrd"},
+		Magic: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("CDF")) && bytes.Contains(header, []byte("AMBERRESTART"))
+		},
+		OpenTraj: func(name string, top chem.Atomer) (chem.Traj, error) {
+			traj, err := NewRestart(name)
+			if err != nil {
+				return nil, err
+			}
+			return traj, nil
+		}})
+	chem.RegisterFormat(&chem.FileFormat{Name: "amber-mdcrd", Extensions: []string{"mdcrd", "crd", "trj"}, Streams: true,
+		OpenTraj: func(name string, top chem.Atomer) (chem.Traj, error) {
+			if top == nil {
+				return nil, Error{"The number of atoms in the topology is needed to read ASCII AMBER trajectories", name, []string{"OpenTraj"}, true}
+			}
+			traj, err := NewMdcrd(name, top.Len())
+			if err != nil {
+				return nil, err
+			}
+			return traj, nil
+		}})
+}
+
+//boxFromParams returns a box from the 3 lengths and the 3 angles in vals, or nil if vals is nil or
+//doesn't contain a valid box.
+func boxFromParams(vals []float64) *chem.Box {
+	if len(vals) < 6 {
+		return nil
+	}
+	box, err := chem.NewBoxFromParams(vals[0], vals[1], vals[2], vals[3], vals[4], vals[5])
+	if err != nil {
+		return nil
+	}
+	return box
+}
+
+//setCoords copies the coordinates in vals to the first natoms rows of out.
+//It panics if out doesn't have enough rows.
+func setCoords(out *v3.Matrix, vals []float64, natoms int) {
+	if out.NVecs() < natoms {
+		panic("Buffer v3.Matrix too small to hold trajectory frame")
+	}
+	for i := 0; i < natoms; i++ {
+		for j := 0; j < 3; j++ {
+			out.Set(i, j, vals[3*i+j])
+		}
+	}
+}
+
+//parseFixed parses the numbers, each of the given width, in line. AMBER ASCII files
+//use Fortran formats, where numbers are not necessarily separated by spaces.
+func parseFixed(line string, width int) ([]float64, error) {
+	line = strings.TrimRight(line, " \r\n")
+	vals := make([]float64, 0, len(line)/width+1)
+	for i := 0; i < len(line); i += width {
+		end := i + width
+		if end > len(line) {
+			end = len(line)
+		}
+		f := strings.TrimSpace(line[i:end])
+		if f == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+//Errors
+
+//errDecorate is a helper function that asserts that the error is
+//implements chem.Error and decorates the error with the caller's name before returning it.
+//if used with a non-chem.Error error, it will cause a panic.
+func errDecorate(err error, caller string) error {
+	err2 := err.(chem.Error) //I know that is the type returned byt initRead
+	err2.Decorate(caller)
+	return err2
+}
+
+//Error is the general structure for AMBER trajectory errors. It fullfills  chem.Error and chem.TrajError
+type Error struct {
+	message  string
+	filename string //the input file that has problems, or empty string if none.
+	deco     []string
+	critical bool
+}
+
+func (err Error) Error() string {
+	return fmt.Sprintf("Amber file %s error: %s", err.filename, err.message)
+}
+
+func (E Error) Decorate(deco string) []string {
+	//Even thought this method does not use a pointer as a receiver, and tries to alter the received,
+	//it should work, since E.deco is a slice, and hence a pointer itself.
+
+	if deco != "" {
+		E.deco = append(E.deco, deco)
+	}
+	return E.deco
+}
+
+func (err Error) FileName() string { return err.filename }
+
+func (err Error) Format() string { return "Amber" }
+
+func (err Error) Critical() bool { return err.critical }
+
+const (
+	TrajUnIni    = "Traj object uninitialized to read"
+	ReadError    = "Error reading frame"
+	UnableToOpen = "Unable to open file"
+	WrongFormat  = "Wrong format in the trajectory file or frame"
+	EOF          = "EOF"
+)
+
+//lastFrameError implements chem.LastFrameError
+type lastFrameError struct {
+	deco     []string
+	fileName string
+}
+
+//lastFrameError does nothing
+func (E lastFrameError) NormalLastFrameTermination() {}
+
+func (E lastFrameError) FileName() string { return E.fileName }
+
+func (E lastFrameError) Error() string { return "EOF" }
+
+func (E lastFrameError) Critical() bool { return false }
+
+func (E lastFrameError) Format() string { return "Amber" }
+
+func (E lastFrameError) Decorate(deco string) []string {
+	//Even thought this method does not use a pointer as a receiver, and tries to alter the received,
+	//it should work, since E.deco is a slice, and hence a pointer itself.
+	if deco != "" {
+		E.deco = append(E.deco, deco)
+	}
+	return E.deco
+}
+
+func newlastFrameError(filename string, caller string) *lastFrameError {
+	e := new(lastFrameError)
+	e.fileName = filename
+	e.deco = []string{caller}
+	return e
+}
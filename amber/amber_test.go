@@ -0,0 +1,313 @@
+/*
+ * amber_test.go, part of gochem
+ *
+ * Copyright 2021 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+
+package amber
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+type testNCVar struct {
+	name string
+	dims []int
+	typ  int32
+	atts map[string]string
+	data []float64 //all the records, one after the other, for record variables.
+}
+
+//testNetCDF encodes a CDF-2 (64-bit offset) NetCDF file.
+func testNetCDF(dims []ncDim, gatts map[string]string, vars []testNCVar, nrecs int) []byte {
+	name := func(b *bytes.Buffer, s string) {
+		binary.Write(b, binary.BigEndian, int32(len(s)))
+		b.WriteString(s)
+		b.Write(make([]byte, (4-len(s)%4)%4))
+	}
+	atts := func(b *bytes.Buffer, atts map[string]string) {
+		if len(atts) == 0 {
+			binary.Write(b, binary.BigEndian, []int32{0, 0})
+			return
+		}
+		binary.Write(b, binary.BigEndian, []int32{ncAttributeTag, int32(len(atts))})
+		for k, v := range atts {
+			name(b, k)
+			binary.Write(b, binary.BigEndian, int32(ncChar))
+			name(b, v)
+		}
+	}
+	size := func(v testNCVar) (int64, bool) {
+		n, rec := int64(1), false
+		for i, d := range v.dims {
+			if i == 0 && dims[d].length == 0 {
+				rec = true
+				continue
+			}
+			n *= dims[d].length
+		}
+		return n, rec
+	}
+	header := func(begins []int64) []byte {
+		b := new(bytes.Buffer)
+		b.WriteString("CDF\x02")
+		binary.Write(b, binary.BigEndian, []int32{int32(nrecs), ncDimensionTag, int32(len(dims))})
+		for _, d := range dims {
+			name(b, d.name)
+			binary.Write(b, binary.BigEndian, int32(d.length))
+		}
+		atts(b, gatts)
+		binary.Write(b, binary.BigEndian, []int32{ncVariableTag, int32(len(vars))})
+		for i, v := range vars {
+			name(b, v.name)
+			binary.Write(b, binary.BigEndian, int32(len(v.dims)))
+			for _, d := range v.dims {
+				binary.Write(b, binary.BigEndian, int32(d))
+			}
+			atts(b, v.atts)
+			n, _ := size(v)
+			vsize := n * ncTypeSize(v.typ)
+			binary.Write(b, binary.BigEndian, []int32{v.typ, int32(vsize + (4-vsize%4)%4)})
+			binary.Write(b, binary.BigEndian, begins[i])
+		}
+		return b.Bytes()
+	}
+	begins := make([]int64, len(vars))
+	offset := int64(len(header(begins)))
+	var recsize int64
+	for i, v := range vars {
+		if n, rec := size(v); !rec {
+			begins[i] = offset
+			offset += n*ncTypeSize(v.typ) + (4-(n*ncTypeSize(v.typ))%4)%4
+		}
+	}
+	for i, v := range vars {
+		if n, rec := size(v); rec {
+			begins[i] = offset + recsize
+			recsize += n*ncTypeSize(v.typ) + (4-(n*ncTypeSize(v.typ))%4)%4
+		}
+	}
+	b := bytes.NewBuffer(header(begins))
+	encode := func(v testNCVar, vals []float64) {
+		for _, x := range vals {
+			switch v.typ {
+			case ncFloat:
+				binary.Write(b, binary.BigEndian, float32(x))
+			case ncDouble:
+				binary.Write(b, binary.BigEndian, x)
+			case ncChar:
+				b.WriteByte(byte(x))
+			}
+		}
+		s := int64(len(vals)) * ncTypeSize(v.typ)
+		b.Write(make([]byte, (4-s%4)%4))
+	}
+	for _, v := range vars {
+		if _, rec := size(v); !rec {
+			encode(v, v.data)
+		}
+	}
+	for r := 0; r < nrecs; r++ {
+		for _, v := range vars {
+			if n, rec := size(v); rec {
+				encode(v, v.data[int64(r)*n:int64(r+1)*n])
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+func testFile(Te *testing.T, dir, name string, content []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		Te.Fatal(err)
+	}
+	return path
+}
+
+func TestNetCDF(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "goamber")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const natoms, nframes = 4, 3
+	dims := []ncDim{{"frame", 0}, {"spatial", 3}, {"atom", natoms}, {"cell_spatial", 3}, {"cell_angular", 3}}
+	var coords, lengths, angles, time []float64
+	for f := 0; f < nframes; f++ {
+		for i := 0; i < natoms*3; i++ {
+			coords = append(coords, float64(f*100+i)+0.5)
+		}
+		lengths = append(lengths, 30+float64(f), 31, 32)
+		angles = append(angles, 90, 90, 90)
+		time = append(time, 2*float64(f+1))
+	}
+	vars := []testNCVar{
+		{"spatial", []int{1}, ncChar, nil, []float64{'x', 'y', 'z'}},
+		{"time", []int{0}, ncFloat, map[string]string{"units": "picosecond"}, time},
+		{"coordinates", []int{0, 2, 1}, ncFloat, map[string]string{"units": "angstrom"}, coords},
+		{"cell_lengths", []int{0, 3}, ncDouble, nil, lengths},
+		{"cell_angles", []int{0, 4}, ncDouble, nil, angles},
+	}
+	name := testFile(Te, dir, "traj.nc", testNetCDF(dims, map[string]string{"Conventions": "AMBER", "ConventionVersion": "1.0"}, vars, nframes))
+	traj, err := NewNetCDF(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	var _ chem.SeekableTraj = traj
+	var _ chem.BoxTraj = traj
+	if traj.Len() != natoms || traj.NFrames() != nframes {
+		Te.Fatalf("Got %d atoms and %d frames, expected %d and %d", traj.Len(), traj.NFrames(), natoms, nframes)
+	}
+	frame := v3.Zeros(natoms)
+	for f := 0; ; f++ {
+		err := traj.Next(frame)
+		if _, ok := err.(chem.LastFrameError); ok {
+			if f != nframes {
+				Te.Errorf("Read %d frames, expected %d", f, nframes)
+			}
+			break
+		}
+		if err != nil {
+			Te.Fatal(err)
+		}
+		if frame.At(3, 2) != float64(f*100+11)+0.5 || traj.Time() != 2*float64(f+1) {
+			Te.Errorf("Wrong frame %d read: %v, time %f", f, frame, traj.Time())
+		}
+		if a, _, _, _, _, _ := traj.Box().Params(); math.Abs(a-30-float64(f)) > 1e-6 {
+			Te.Errorf("Wrong box in frame %d: %v", f, traj.Box())
+		}
+	}
+	if err := traj.Seek(1); err != nil {
+		Te.Fatal(err)
+	}
+	if err := traj.Next(frame); err != nil || frame.At(0, 0) != 100.5 {
+		Te.Errorf("Wrong frame after seeking: %v %v", frame, err)
+	}
+	//A restart file, which is not a trajectory.
+	rdims := []ncDim{{"spatial", 3}, {"atom", natoms}, {"cell_spatial", 3}, {"cell_angular", 3}}
+	rvars := []testNCVar{
+		{"time", nil, ncDouble, nil, []float64{10}},
+		{"coordinates", []int{1, 0}, ncDouble, nil, coords[:natoms*3]},
+		{"cell_lengths", []int{2}, ncDouble, nil, lengths[:3]},
+		{"cell_angles", []int{3}, ncDouble, nil, []float64{109.4712206, 109.4712206, 109.4712206}},
+	}
+	rname := testFile(Te, dir, "rst.nc", testNetCDF(rdims, map[string]string{"Conventions": "AMBERRESTART"}, rvars, 0))
+	if _, err := NewNetCDF(rname); err == nil {
+		Te.Errorf("A restart file was opened as a trajectory")
+	}
+	rst, err := NewRestart(rname)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if rst.Len() != natoms || rst.Time() != 10 || rst.Box() == nil {
+		Te.Fatalf("Wrong restart file read")
+	}
+	if _, _, _, alpha, _, _ := rst.Box().Params(); math.Abs(alpha-109.4712206) > 1e-4 {
+		Te.Errorf("Wrong box in restart file: %v", rst.Box())
+	}
+	if err := rst.Next(frame); err != nil || frame.At(1, 0) != 3.5 {
+		Te.Errorf("Wrong coordinates in restart file: %v %v", frame, err)
+	}
+	if _, ok := rst.Next(frame).(chem.LastFrameError); !ok {
+		Te.Errorf("A restart file should have only one frame")
+	}
+}
+
+const testRst7 = `water box
+     4  0.1000000E+02
+   1.0000000   2.0000000   3.0000000-100.0000000   5.0000000   6.0000000
+   7.0000000   8.0000000   9.0000000  10.0000000  11.0000000  12.0000000
+   0.1000000   0.2000000   0.3000000   0.4000000   0.5000000   0.6000000
+   0.7000000   0.8000000   0.9000000   1.0000000   1.1000000   1.2000000
+  30.0000000  31.0000000  32.0000000  90.0000000  90.0000000  90.0000000
+`
+
+const testMdcrd = `Cpptraj Generated trajectory
+   1.000   2.000   3.000-100.000   5.000   6.000   7.000   8.000   9.000  10.000
+  11.000  12.000
+  30.000  31.000  32.000
+   2.000   2.000   3.000-100.000   5.000   6.000   7.000   8.000   9.000  10.000
+  11.000  12.000
+  30.500  31.000  32.000
+`
+
+func TestASCII(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "goamber")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rst, err := NewRestart(testFile(Te, dir, "test.rst7", []byte(testRst7)))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	frame := v3.Zeros(4)
+	if err := rst.Next(frame); err != nil || frame.At(1, 0) != -100 || frame.At(3, 2) != 12 {
+		Te.Errorf("Wrong coordinates in restart file: %v %v", frame, err)
+	}
+	if rst.Time() != 10 || rst.Box() == nil {
+		Te.Errorf("Wrong time or box in restart file")
+	}
+	md, err := NewMdcrd(testFile(Te, dir, "test.mdcrd", []byte(testMdcrd)), 4)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for f := 0; ; f++ {
+		err := md.Next(frame)
+		if _, ok := err.(chem.LastFrameError); ok {
+			if f != 2 {
+				Te.Errorf("Read %d frames, expected 2", f)
+			}
+			break
+		}
+		if err != nil {
+			Te.Fatal(err)
+		}
+		if frame.At(0, 0) != float64(f+1) || frame.At(1, 0) != -100 || md.Box() == nil {
+			Te.Errorf("Wrong frame %d: %v", f, frame)
+		}
+	}
+	if a, _, _, _, _, _ := md.Box().Params(); math.Abs(a-30.5) > 1e-6 {
+		Te.Errorf("Wrong box in the last frame: %v", md.Box())
+	}
+	//Compressed files are read without uncompressing them first.
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(testMdcrd))
+	w.Close()
+	top := chem.NewTopology(0, 1, []*chem.Atom{{Symbol: "C"}, {Symbol: "C"}, {Symbol: "C"}, {Symbol: "C"}})
+	traj, err := chem.OpenTraj(testFile(Te, dir, "test.mdcrd.gz", gz.Bytes()), top)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	traj.Next(nil)
+	if err := traj.Next(frame); err != nil || frame.At(0, 0) != 2 || traj.(*MdcrdObj).Box() == nil {
+		Te.Errorf("Wrong frame read from the compressed file: %v %v", frame, err)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ * mdcrd.go, part of gochem
+ *
+ * Copyright 2021 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+
+package amber
+
+import (
+	"bufio"
+	"io"
+	"runtime"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//MdcrdObj is a container for an ASCII AMBER trajectory (mdcrd/crd) file. It implements chem.Traj and chem.BoxTraj.
+type MdcrdObj struct {
+	readable bool
+	natoms   int
+	filename string
+	file     io.ReadCloser
+	crd      *bufio.Reader
+	hasbox   bool
+	buffer   []float64
+	box      *chem.Box
+}
+
+//NewMdcrd opens an ASCII AMBER trajectory with natoms atoms, which the format doesn't store, so
+//it must be taken from the topology. Whether the frames have a box is detected from the file.
+//Only the box lengths are stored in these files, so the box is assumed to be orthorhombic.
+//The file can be compressed (see chem.OpenCompressed), as it is only read sequentially.
+func NewMdcrd(filename string, natoms int) (*MdcrdObj, error) {
+	f, err := chem.OpenCompressed(filename)
+	if err != nil {
+		return nil, Error{UnableToOpen, filename, []string{"chem.OpenCompressed", "NewMdcrd"}, true}
+	}
+	M := &MdcrdObj{filename: filename, file: f, natoms: natoms, crd: bufio.NewReader(f)}
+	_, err = M.crd.ReadString('\n')
+	if err != nil {
+		f.Close()
+		return nil, Error{WrongFormat + ": Missing title", filename, []string{"NewMdcrd"}, true}
+	}
+	//The coordinates of each frame start in a new line, 10 per line, and the box, if present,
+	//is in its own line, with 3 values. We read the line after the first frame to find out if there is a box.
+	read := 0
+	for read < 3*natoms {
+		line, err := M.crd.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			f.Close()
+			return nil, Error{WrongFormat + ": Incomplete first frame", filename, []string{"NewMdcrd"}, true}
+		}
+		vals, err := parseFixed(line, 8)
+		if err != nil {
+			f.Close()
+			return nil, Error{WrongFormat + ": " + err.Error(), filename, []string{"parseFixed", "NewMdcrd"}, true}
+		}
+		read += len(vals)
+	}
+	if line, err := M.crd.ReadString('\n'); err == nil || line != "" {
+		vals, _ := parseFixed(line, 8)
+		M.hasbox = len(vals) == 3 && 3*natoms != 3
+	}
+	//We start again from the first frame. Compressed files can't seek, so we just open the file again.
+	f.Close()
+	if f, err = chem.OpenCompressed(filename); err != nil {
+		return nil, Error{UnableToOpen, filename, []string{"chem.OpenCompressed", "NewMdcrd"}, true}
+	}
+	M.file = f
+	M.crd.Reset(f)
+	if _, err := M.crd.ReadString('\n'); err != nil {
+		f.Close()
+		return nil, Error{UnableToOpen + ": " + err.Error(), filename, []string{"NewMdcrd"}, true}
+	}
+	M.buffer = make([]float64, 0, 3*natoms+3)
+	runtime.SetFinalizer(M, func(M *MdcrdObj) {
+		M.file.Close()
+	})
+	M.readable = true
+	return M, nil
+}
+
+//Readable returns true if the object is ready to be read from
+//false otherwise. It doesnt guarantee that there is something
+//to read.
+func (M *MdcrdObj) Readable() bool {
+	return M.readable
+}
+
+//Next reads the next frame of the trajectory into output, or discards it, if output is nil.
+//It returns a chem.LastFrameError when there are no more frames.
+func (M *MdcrdObj) Next(output *v3.Matrix) error {
+	if !M.readable {
+		return Error{TrajUnIni, M.filename, []string{"Next"}, true}
+	}
+	n := 3 * M.natoms
+	M.buffer = M.buffer[:0]
+	for len(M.buffer) < n {
+		line, err := M.crd.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			M.readable = false
+			if err == io.EOF && len(M.buffer) == 0 {
+				return newlastFrameError(M.filename, "Next")
+			}
+			return Error{ReadError + ": Incomplete frame", M.filename, []string{"Next"}, true}
+		}
+		vals, err := parseFixed(line, 8)
+		if err != nil {
+			M.readable = false
+			return Error{ReadError + ": " + err.Error(), M.filename, []string{"parseFixed", "Next"}, true}
+		}
+		M.buffer = append(M.buffer, vals...)
+	}
+	if M.hasbox {
+		line, err := M.crd.ReadString('\n')
+		vals, err2 := parseFixed(line, 8)
+		if (err != nil && line == "") || err2 != nil || len(vals) < 3 {
+			M.readable = false
+			return Error{ReadError + ": Missing box", M.filename, []string{"Next"}, true}
+		}
+		M.box = boxFromParams([]float64{vals[0], vals[1], vals[2], 90, 90, 90})
+	}
+	if output != nil {
+		setCoords(output, M.buffer, M.natoms)
+	}
+	return nil
+}
+
+//Box returns the periodic box of the last frame read, or nil if the
+//trajectory has no box.
+func (M *MdcrdObj) Box() *chem.Box {
+	return M.box
+}
+
+//Len returns the number of atoms per frame in the trajectory.
+func (M *MdcrdObj) Len() int {
+	return M.natoms
+}
@@ -0,0 +1,194 @@
+/*
+ * nctraj.go, part of gochem
+ *
+ * Copyright 2021 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+
+package amber
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//NetCDFObj is a container for an AMBER NetCDF trajectory file. It implements chem.SeekableTraj
+//and chem.BoxTraj.
+type NetCDFObj struct {
+	readable bool
+	natoms   int
+	filename string
+	file     *os.File
+	nc       *ncFile
+	current  int //the index of the next frame to be read
+	coords   *ncVar
+	lengths  *ncVar //nil if there is no box
+	angles   *ncVar
+	time     *ncVar
+	buffer   []float64
+	cell     []float64
+	box      *chem.Box
+	t        float64
+}
+
+//openNetCDF opens a NetCDF file and checks that it follows the given AMBER convention.
+func openNetCDF(filename, convention, caller string) (*os.File, *ncFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, Error{UnableToOpen, filename, []string{"os.Open", caller}, true}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, Error{UnableToOpen + ": " + err.Error(), filename, []string{"os.File.Stat", caller}, true}
+	}
+	nc, err := ncOpen(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, Error{WrongFormat + ": " + err.Error(), filename, []string{"ncOpen", caller}, true}
+	}
+	conventions := strings.Fields(strings.Replace(nc.atts["Conventions"].text, ",", " ", -1))
+	for _, v := range conventions {
+		if v == convention {
+			return f, nc, nil
+		}
+	}
+	f.Close()
+	return nil, nil, Error{fmt.Sprintf("%s: The file doesn't follow the %s convention", WrongFormat, convention), filename, []string{caller}, true}
+}
+
+//NewNetCDF opens an AMBER NetCDF trajectory file, and returns a NetCDFObj ready to be read.
+func NewNetCDF(filename string) (*NetCDFObj, error) {
+	f, nc, err := openNetCDF(filename, "AMBER", "NewNetCDF")
+	if err != nil {
+		return nil, err
+	}
+	N := &NetCDFObj{filename: filename, file: f, nc: nc}
+	N.coords = nc.vars["coordinates"]
+	if N.coords == nil || !nc.hasDims(N.coords, "frame", "atom", "spatial") {
+		f.Close()
+		return nil, Error{WrongFormat + ": No coordinates in the trajectory", filename, []string{"NewNetCDF"}, true}
+	}
+	N.natoms = int(nc.dim("atom"))
+	N.lengths, N.angles = nc.vars["cell_lengths"], nc.vars["cell_angles"]
+	if N.lengths == nil || N.angles == nil || !N.lengths.record || !N.angles.record {
+		N.lengths, N.angles = nil, nil
+	}
+	if N.time = nc.vars["time"]; N.time != nil && !N.time.record {
+		N.time = nil
+	}
+	N.buffer = make([]float64, N.natoms*3)
+	N.cell = make([]float64, 6)
+	runtime.SetFinalizer(N, func(N *NetCDFObj) {
+		N.file.Close()
+	})
+	N.readable = true
+	return N, nil
+}
+
+//Readable returns true if the object is ready to be read from
+//false otherwise. It doesnt guarantee that there is something
+//to read.
+func (N *NetCDFObj) Readable() bool {
+	return N.readable
+}
+
+//Next reads the next frame of the trajectory into output. If output is nil, the
+//coordinates are discarded, but the box and time of the frame are still read.
+//It returns a chem.LastFrameError when there are no more frames.
+func (N *NetCDFObj) Next(output *v3.Matrix) error {
+	if !N.readable {
+		return Error{TrajUnIni, N.filename, []string{"Next"}, true}
+	}
+	if int64(N.current) >= N.nc.numrecs {
+		N.readable = false
+		return newlastFrameError(N.filename, "Next")
+	}
+	if N.lengths != nil {
+		if err := N.nc.read(N.lengths, N.current, N.cell[:3]); err != nil {
+			return Error{ReadError + ": " + err.Error(), N.filename, []string{"ncFile.read", "Next"}, true}
+		}
+		if err := N.nc.read(N.angles, N.current, N.cell[3:]); err != nil {
+			return Error{ReadError + ": " + err.Error(), N.filename, []string{"ncFile.read", "Next"}, true}
+		}
+		N.box = boxFromParams(N.cell)
+	}
+	if N.time != nil {
+		if err := N.nc.read(N.time, N.current, N.cell[:1]); err == nil {
+			N.t = N.cell[0]
+		}
+	}
+	if output != nil {
+		if err := N.nc.read(N.coords, N.current, N.buffer); err != nil {
+			return Error{ReadError + ": " + err.Error(), N.filename, []string{"ncFile.read", "Next"}, true}
+		}
+		setCoords(output, N.buffer, N.natoms)
+	}
+	N.current++
+	return nil
+}
+
+//NFrames returns the number of frames in the trajectory.
+func (N *NetCDFObj) NFrames() int {
+	return int(N.nc.numrecs)
+}
+
+//Seek sets the trajectory so the next call to Next will read the frame
+//with the given index (starting from 0).
+func (N *NetCDFObj) Seek(frame int) error {
+	if frame < 0 || frame >= N.NFrames() {
+		return Error{fmt.Sprintf("Frame %d out of range", frame), N.filename, []string{"Seek"}, true}
+	}
+	N.current = frame
+	N.readable = true
+	return nil
+}
+
+//CurrentFrame returns the index of the next frame to be read.
+func (N *NetCDFObj) CurrentFrame() int {
+	return N.current
+}
+
+//Box returns the periodic box of the last frame read, or nil if the
+//trajectory has no box.
+func (N *NetCDFObj) Box() *chem.Box {
+	return N.box
+}
+
+//Time returns the simulation time, in ps, of the last frame read.
+func (N *NetCDFObj) Time() float64 {
+	return N.t
+}
+
+//Step returns the index of the last frame read, as AMBER NetCDF
+//trajectories don't store the simulation step.
+func (N *NetCDFObj) Step() int {
+	if N.current == 0 {
+		return 0
+	}
+	return N.current - 1
+}
+
+//Len returns the number of atoms per frame in the trajectory.
+func (N *NetCDFObj) Len() int {
+	return N.natoms
+}
@@ -0,0 +1,355 @@
+/*
+ * netcdf.go, part of gochem
+ *
+ * Copyright 2021 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+
+package amber
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+//This file implements a reader for the "classic" NetCDF formats (CDF-1, the 64-bit offset CDF-2, and
+//the 64-bit data CDF-5), which are the ones used by AMBER. NetCDF-4 (HDF5-based) files are not supported.
+
+//NetCDF data types
+const (
+	ncByte   = 1
+	ncChar   = 2
+	ncShort  = 3
+	ncInt    = 4
+	ncFloat  = 5
+	ncDouble = 6
+	ncUByte  = 7
+	ncUShort = 8
+	ncUInt   = 9
+	ncInt64  = 10
+	ncUInt64 = 11
+)
+
+//tags for the lists in the header
+const (
+	ncDimensionTag = 0x0A
+	ncVariableTag  = 0x0B
+	ncAttributeTag = 0x0C
+)
+
+const ncStreaming = 0xFFFFFFFF
+
+//ncTypeSize returns the size in bytes of a value of the given NetCDF type, or 0 for unknown types.
+func ncTypeSize(t int32) int64 {
+	switch t {
+	case ncByte, ncChar, ncUByte:
+		return 1
+	case ncShort, ncUShort:
+		return 2
+	case ncInt, ncFloat, ncUInt:
+		return 4
+	case ncDouble, ncInt64, ncUInt64:
+		return 8
+	}
+	return 0
+}
+
+type ncDim struct {
+	name   string
+	length int64 //0 for the record (unlimited) dimension
+}
+
+//ncAtt is an attribute. Text attributes are kept as a string, numeric ones as a slice of float64.
+type ncAtt struct {
+	text   string
+	values []float64
+}
+
+type ncVar struct {
+	name   string
+	dims   []int
+	atts   map[string]ncAtt
+	typ    int32
+	vsize  int64
+	begin  int64
+	record bool
+	size   int64 //number of values in the variable (in each record, for record variables)
+}
+
+//ncFile is an open NetCDF file.
+type ncFile struct {
+	r       io.ReaderAt
+	version byte
+	numrecs int64
+	recsize int64
+	dims    []ncDim
+	atts    map[string]ncAtt
+	vars    map[string]*ncVar
+}
+
+//ncHeaderReader reads the header of a NetCDF file, keeping the first error found.
+type ncHeaderReader struct {
+	r       *bufio.Reader
+	version byte
+	err     error
+}
+
+func (h *ncHeaderReader) read(n int64) []byte {
+	if h.err != nil {
+		return nil
+	}
+	if n < 0 || n > 1<<30 {
+		h.err = fmt.Errorf("Wrong size in NetCDF header: %d", n)
+		return nil
+	}
+	b := make([]byte, n)
+	_, h.err = io.ReadFull(h.r, b)
+	return b
+}
+
+func (h *ncHeaderReader) int32() int32 {
+	b := h.read(4)
+	if h.err != nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (h *ncHeaderReader) int64() int64 {
+	b := h.read(8)
+	if h.err != nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+//nonNeg reads a length, which is 8 bytes long in CDF-5 files, and 4 bytes long otherwise.
+func (h *ncHeaderReader) nonNeg() int64 {
+	if h.version == 5 {
+		return h.int64()
+	}
+	return int64(h.int32())
+}
+
+//offset reads the beginning of a variable, which is 4 bytes long in CDF-1 files, and 8 bytes long otherwise.
+func (h *ncHeaderReader) offset() int64 {
+	if h.version == 1 {
+		return int64(h.int32())
+	}
+	return h.int64()
+}
+
+//name reads a name, which is padded to a multiple of 4 bytes.
+func (h *ncHeaderReader) name() string {
+	n := h.nonNeg()
+	b := h.read(n)
+	h.read((4 - n%4) % 4)
+	return string(b)
+}
+
+//list reads the tag and the number of elements of a list. An absent list has a tag of 0.
+func (h *ncHeaderReader) list(tag int32) int64 {
+	t := h.int32()
+	n := h.nonNeg()
+	if h.err == nil && t != tag && !(t == 0 && n == 0) {
+		h.err = fmt.Errorf("Wrong tag in NetCDF header: %d", t)
+	}
+	return n
+}
+
+func (h *ncHeaderReader) atts() map[string]ncAtt {
+	n := h.list(ncAttributeTag)
+	atts := make(map[string]ncAtt, n)
+	for i := int64(0); i < n && h.err == nil; i++ {
+		name := h.name()
+		typ := h.int32()
+		nelems := h.nonNeg()
+		size := ncTypeSize(typ)
+		if size == 0 && h.err == nil {
+			h.err = fmt.Errorf("Unknown type for attribute %s: %d", name, typ)
+		}
+		b := h.read(nelems * size)
+		h.read((4 - (nelems*size)%4) % 4)
+		if h.err != nil {
+			break
+		}
+		if typ == ncChar {
+			atts[name] = ncAtt{text: string(b)}
+			continue
+		}
+		v := make([]float64, nelems)
+		ncDecode(b, typ, v)
+		atts[name] = ncAtt{values: v}
+	}
+	return atts
+}
+
+//ncOpen reads the header of a NetCDF file of the given size, from r.
+func ncOpen(r io.ReaderAt, size int64) (*ncFile, error) {
+	h := &ncHeaderReader{r: bufio.NewReader(io.NewSectionReader(r, 0, size))}
+	magic := h.read(4)
+	if h.err != nil {
+		return nil, h.err
+	}
+	if string(magic[:3]) != "CDF" || (magic[3] != 1 && magic[3] != 2 && magic[3] != 5) {
+		return nil, fmt.Errorf("Not a classic NetCDF file")
+	}
+	nc := &ncFile{r: r, version: magic[3]}
+	h.version = nc.version
+	nc.numrecs = h.nonNeg()
+	if nc.version != 5 {
+		nc.numrecs = int64(uint32(nc.numrecs))
+	}
+	ndims := h.list(ncDimensionTag)
+	for i := int64(0); i < ndims && h.err == nil; i++ {
+		nc.dims = append(nc.dims, ncDim{h.name(), h.nonNeg()})
+	}
+	nc.atts = h.atts()
+	nvars := h.list(ncVariableTag)
+	nc.vars = make(map[string]*ncVar, nvars)
+	var recvars []*ncVar
+	for i := int64(0); i < nvars && h.err == nil; i++ {
+		v := &ncVar{name: h.name(), size: 1}
+		n := h.nonNeg()
+		for j := int64(0); j < n && h.err == nil; j++ {
+			d := int(h.nonNeg())
+			if d < 0 || d >= len(nc.dims) {
+				return nil, fmt.Errorf("Wrong dimension in variable %s", v.name)
+			}
+			v.dims = append(v.dims, d)
+			if j == 0 && nc.dims[d].length == 0 {
+				v.record = true
+				continue
+			}
+			v.size *= nc.dims[d].length
+		}
+		v.atts = h.atts()
+		v.typ = h.int32()
+		v.vsize = h.nonNeg()
+		v.begin = h.offset()
+		if h.err == nil && ncTypeSize(v.typ) == 0 {
+			return nil, fmt.Errorf("Unknown type for variable %s: %d", v.name, v.typ)
+		}
+		nc.vars[v.name] = v
+		if v.record {
+			nc.recsize += v.vsize
+			recvars = append(recvars, v)
+		}
+	}
+	if h.err != nil {
+		return nil, h.err
+	}
+	//With only one record variable, records are not padded.
+	if len(recvars) == 1 {
+		nc.recsize = recvars[0].size * ncTypeSize(recvars[0].typ)
+	}
+	if nc.numrecs == ncStreaming && nc.version != 5 || nc.numrecs < 0 {
+		nc.numrecs = 0
+		if len(recvars) > 0 && nc.recsize > 0 {
+			first := recvars[0].begin
+			for _, v := range recvars {
+				if v.begin < first {
+					first = v.begin
+				}
+			}
+			nc.numrecs = (size - first) / nc.recsize
+		}
+	}
+	return nc, nil
+}
+
+//dim returns the length of the dimension with the given name, or -1 if it doesn't exist.
+func (nc *ncFile) dim(name string) int64 {
+	for _, d := range nc.dims {
+		if d.name == name {
+			return d.length
+		}
+	}
+	return -1
+}
+
+//hasDims returns true if the variable has the given dimensions.
+func (nc *ncFile) hasDims(v *ncVar, names ...string) bool {
+	if len(v.dims) != len(names) {
+		return false
+	}
+	for i, d := range v.dims {
+		if nc.dims[d].name != names[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//read reads the values of the variable v in the record rec (ignored for non-record variables) into out,
+//which must have room for them. The scale_factor attribute, if present, is applied.
+func (nc *ncFile) read(v *ncVar, rec int, out []float64) error {
+	if int64(len(out)) < v.size {
+		return fmt.Errorf("Buffer too small for variable %s", v.name)
+	}
+	offset := v.begin
+	if v.record {
+		if int64(rec) >= nc.numrecs || rec < 0 {
+			return fmt.Errorf("Record %d out of range", rec)
+		}
+		offset += int64(rec) * nc.recsize
+	}
+	b := make([]byte, v.size*ncTypeSize(v.typ))
+	if _, err := nc.r.ReadAt(b, offset); err != nil {
+		return err
+	}
+	ncDecode(b, v.typ, out[:v.size])
+	if s, ok := v.atts["scale_factor"]; ok && len(s.values) > 0 {
+		for i := range out[:v.size] {
+			out[i] *= s.values[0]
+		}
+	}
+	return nil
+}
+
+//ncDecode converts the big-endian values of type typ in b to float64, and puts them in out.
+func ncDecode(b []byte, typ int32, out []float64) {
+	size := int(ncTypeSize(typ))
+	for i := range out {
+		c := b[i*size : (i+1)*size]
+		switch typ {
+		case ncByte:
+			out[i] = float64(int8(c[0]))
+		case ncChar, ncUByte:
+			out[i] = float64(c[0])
+		case ncShort:
+			out[i] = float64(int16(binary.BigEndian.Uint16(c)))
+		case ncUShort:
+			out[i] = float64(binary.BigEndian.Uint16(c))
+		case ncInt:
+			out[i] = float64(int32(binary.BigEndian.Uint32(c)))
+		case ncUInt:
+			out[i] = float64(binary.BigEndian.Uint32(c))
+		case ncFloat:
+			out[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(c)))
+		case ncDouble:
+			out[i] = math.Float64frombits(binary.BigEndian.Uint64(c))
+		case ncInt64:
+			out[i] = float64(int64(binary.BigEndian.Uint64(c)))
+		case ncUInt64:
+			out[i] = float64(binary.BigEndian.Uint64(c))
+		}
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * restart.go, part of gochem
+ *
+ * Copyright 2021 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+
+package amber
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//RestartObj contains the coordinates of an AMBER restart or inpcrd file, ASCII or NetCDF.
+//It implements chem.Traj and chem.BoxTraj, as a trajectory with only one frame.
+type RestartObj struct {
+	readable bool
+	natoms   int
+	filename string
+	coords   []float64
+	box      *chem.Box
+	t        float64
+}
+
+//NewRestart reads an AMBER restart file, either in the ASCII (rst7/inpcrd) or in the NetCDF format, which is detected
+//from the file. The number of atoms, and whether there is a box, are also obtained from the file.
+func NewRestart(filename string) (*RestartObj, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, Error{UnableToOpen, filename, []string{"os.Open", "NewRestart"}, true}
+	}
+	magic := make([]byte, 3)
+	_, err = f.Read(magic)
+	f.Close()
+	if err == nil && string(magic) == "CDF" {
+		return ncRestart(filename)
+	}
+	return asciiRestart(filename)
+}
+
+func ncRestart(filename string) (*RestartObj, error) {
+	f, nc, err := openNetCDF(filename, "AMBERRESTART", "NewRestart")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	R := &RestartObj{filename: filename, readable: true, natoms: int(nc.dim("atom"))}
+	coords := nc.vars["coordinates"]
+	if coords == nil || !nc.hasDims(coords, "atom", "spatial") {
+		return nil, Error{WrongFormat + ": No coordinates in the restart file", filename, []string{"NewRestart"}, true}
+	}
+	R.coords = make([]float64, R.natoms*3)
+	if err := nc.read(coords, 0, R.coords); err != nil {
+		return nil, Error{ReadError + ": " + err.Error(), filename, []string{"ncFile.read", "NewRestart"}, true}
+	}
+	lengths, angles := nc.vars["cell_lengths"], nc.vars["cell_angles"]
+	if lengths != nil && angles != nil {
+		cell := make([]float64, 6)
+		if nc.read(lengths, 0, cell[:3]) == nil && nc.read(angles, 0, cell[3:]) == nil {
+			R.box = boxFromParams(cell)
+		}
+	}
+	if t := nc.vars["time"]; t != nil {
+		v := make([]float64, 1)
+		if nc.read(t, 0, v) == nil {
+			R.t = v[0]
+		}
+	}
+	return R, nil
+}
+
+//asciiRestart reads a restart file in the ASCII format: a title line, a line with the number of
+//atoms and, optionally, the time, the coordinates, optionally the velocities, and, optionally, the box.
+func asciiRestart(filename string) (*RestartObj, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, Error{UnableToOpen, filename, []string{"os.Open", "NewRestart"}, true}
+	}
+	defer f.Close()
+	in := bufio.NewScanner(f)
+	in.Scan() //the title
+	if !in.Scan() {
+		return nil, Error{WrongFormat + ": Missing number of atoms", filename, []string{"NewRestart"}, true}
+	}
+	head := strings.Fields(in.Text())
+	if len(head) == 0 {
+		return nil, Error{WrongFormat + ": Missing number of atoms", filename, []string{"NewRestart"}, true}
+	}
+	R := &RestartObj{filename: filename, readable: true}
+	if R.natoms, err = strconv.Atoi(head[0]); err != nil || R.natoms <= 0 {
+		return nil, Error{WrongFormat + ": Wrong number of atoms", filename, []string{"NewRestart"}, true}
+	}
+	if len(head) > 1 {
+		R.t, _ = strconv.ParseFloat(head[1], 64)
+	}
+	var vals []float64
+	for in.Scan() {
+		v, err := parseFixed(in.Text(), 12)
+		if err != nil {
+			return nil, Error{fmt.Sprintf("%s: %s", ReadError, err.Error()), filename, []string{"parseFixed", "NewRestart"}, true}
+		}
+		vals = append(vals, v...)
+	}
+	if err := in.Err(); err != nil {
+		return nil, Error{ReadError + ": " + err.Error(), filename, []string{"bufio.Scanner.Scan", "NewRestart"}, true}
+	}
+	n := R.natoms * 3
+	//After the coordinates, there can be velocities (n values), a box (6 values) or both.
+	switch len(vals) - n {
+	case 0, n:
+	case 6, n + 6:
+		R.box = boxFromParams(vals[len(vals)-6:])
+	default:
+		return nil, Error{fmt.Sprintf("%s: %d values for %d atoms", WrongFormat, len(vals), R.natoms), filename, []string{"NewRestart"}, true}
+	}
+	R.coords = vals[:n]
+	return R, nil
+}
+
+//Readable returns true if the coordinates have not been read yet.
+func (R *RestartObj) Readable() bool {
+	return R.readable
+}
+
+//Next puts the coordinates in the restart file in output, the first time it is called, and
+//returns a chem.LastFrameError afterwards.
+func (R *RestartObj) Next(output *v3.Matrix) error {
+	if !R.readable {
+		return newlastFrameError(R.filename, "Next")
+	}
+	R.readable = false
+	if output != nil {
+		setCoords(output, R.coords, R.natoms)
+	}
+	return nil
+}
+
+//Box returns the periodic box in the restart file, or nil if there is none.
+func (R *RestartObj) Box() *chem.Box {
+	return R.box
+}
+
+//Time returns the simulation time, in ps, in the restart file.
+func (R *RestartObj) Time() float64 {
+	return R.t
+}
+
+//Len returns the number of atoms in the restart file.
+func (R *RestartObj) Len() int {
+	return R.natoms
+}
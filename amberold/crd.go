@@ -51,7 +51,8 @@ type CrdObj struct {
 	ioread    *os.File //The crd file
 	crd       *bufio.Reader
 	remaining []float64
-	box       bool
+	box       bool      //Does the trajectory have a box line after each frame?
+	lastbox   *chem.Box //The box of the last frame read
 }
 
 //New creates a new Old Amber trajectory object from a file.
@@ -163,7 +164,9 @@ func (C *CrdObj) Next(keep *v3.Matrix) error {
 		//	println("wei") ////////////
 	}
 	if C.box {
-		C.nextBox()
+		if err := C.nextBox(); err != nil {
+			return errDecorate(err, "Next")
+		}
 	}
 	return nil
 
@@ -248,83 +251,47 @@ func (C *CrdObj) nextVelBox() error {
 
 }
 
+//nextBox reads the box line that follows the coordinates in each frame
+//and keeps the box.
 func (C *CrdObj) nextBox() error {
-	var keep *v3.Matrix //nil
-	const ncoords = 3
 	if !C.readable {
-		return Error{TrajUnIni, C.filename, []string{"Next"}, true}
-	}
-	var natoms int = 1
-	//What do we do with remaining?
-	cont := 0
-	line := 0
-	//The following allows discarding a frame while still keeping track of it.
-	//Everything is the same if you read or discard, except the function that
-	//would set the values to the matrix simply does nothing in the discard case.
-	var setter func(file, col int, val float64)
-	if keep != nil {
-		setter = func(file, col int, val float64) { keep.Set(file, col, val) }
-	} else {
-		setter = func(file, col int, val float64) {}
-	}
-	//Here we read the coords that were left remaining in the last read.
-	for _, coord := range C.remaining {
-		if cont < ncoords {
-			setter(line, cont, coord)
-			coord++
-			continue
-		}
-		if cont >= ncoords && line < natoms-1 {
-			line++
-			cont = 0
-			setter(line, cont, coord)
-			cont++
-			continue
-		}
+		return Error{TrajUnIni, C.filename, []string{"nextBox"}, true}
 	}
-	C.remaining = C.remaining[0:0] //This might not work as expected. I need it to set C.remaining to zero length.
-	//	println("remaining:", len(C.remaining))
-	for line < natoms-1 {
+	//values left from the coordinates line, if any, are part of the box.
+	vals := append([]float64{}, C.remaining...)
+	C.remaining = C.remaining[0:0]
+	for len(vals) < 3 {
 		i, err := C.crd.ReadString('\n')
 		//here we assume the error is an EOF. I need to change this to actually check.
 		if err != nil {
 			C.readable = false
-			//			println(err.Error()) //////
-			return newlastFrameError(C.filename, "Next")
+			return newlastFrameError(C.filename, "nextBox")
 		}
-		l := strings.Fields(i)
-		//	fmt.Println(l) ////////////////////////////////////////////////////////////
-		//	println("no") ///////////////////////
-		const ncoords = 3
-		for _, j := range l {
-			coord, err := strconv.ParseFloat(j, 64)
+		for _, j := range strings.Fields(i) {
+			v, err := strconv.ParseFloat(j, 64)
 			if err != nil {
-				return Error{fmt.Sprint("Unable to read coordinates from Amber trajectory", err.Error()), C.filename, []string{"strconv.ParseFloat", "Next"}, true}
-			}
-			if cont < ncoords {
-				//	println("no")////
-				setter(line, cont, coord)
-				cont++
-				continue
-			}
-			if cont >= ncoords && line < natoms-1 {
-				//		println("wei")////
-				line++
-				cont = 0
-				setter(line, cont, coord)
-				cont++
-				continue
-			}
-			if cont >= cont && line >= natoms-1 {
-
-				//				println("ql")////
-				C.remaining = append(C.remaining, coord)
+				return Error{fmt.Sprint("Unable to read box from Amber trajectory", err.Error()), C.filename, []string{"strconv.ParseFloat", "nextBox"}, true}
 			}
+			vals = append(vals, v)
 		}
-		//	println("wei") ////////////
 	}
+	//The box line has the 3 lengths, and sometimes the 3 angles.
+	angles := []float64{90, 90, 90}
+	if len(vals) >= 6 {
+		angles = vals[3:6]
+	}
+	box, err := chem.NewBoxFromParams(vals[0], vals[1], vals[2], angles[0], angles[1], angles[2])
+	if err != nil {
+		return Error{fmt.Sprint("Wrong box in Amber trajectory", err.Error()), C.filename, []string{"chem.NewBoxFromParams", "nextBox"}, true}
+	}
+	C.lastbox = box
 	return nil
+}
 
+//Box returns the periodic box of the last frame read. It
+//returns nil if the trajectory has no box information.
+func (C *CrdObj) Box() *chem.Box {
+	return C.lastbox
 }
 
 //Natoms returns the number of atoms per frame in the XtcObj.
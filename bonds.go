@@ -213,7 +213,7 @@ func (R *Ring) Planarity(coord *v3.Matrix) float64 {
 	if R.planarity != 0 {
 		return R.planarity
 	}
-	c := v3.Zeros(coord.NVecs())
+	c := v3.Zeros(len(R.Atoms))
 	c.SomeVecs(coord, R.Atoms)
 	_, plan, err := EasyShape(c, 0.01)
 	if err != nil {
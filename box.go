@@ -0,0 +1,173 @@
+/*
+ * box.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"fmt"
+	"math"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//Box is a periodic simulation cell. It is represented by the three cell vectors
+//a, b and c (in A), which are the rows of a 3x3 matrix. As in GROMACS, the vector a is
+//expected to lie along the x axis, and b in the xy plane, which is what the functions
+//that build a box from lengths and angles produce.
+type Box struct {
+	vecs  *v3.Matrix
+	h     [3][3]float64 //the cell vectors, and the inverse of that matrix, kept as arrays
+	inv   [3][3]float64 //for the minimum image calculations.
+	ortho bool
+}
+
+//NewBox returns a Box with the cell vectors given as rows of vecs, which is copied.
+//It returns an error if vecs is not a 3x3 matrix or if its volume is zero.
+func NewBox(vecs *v3.Matrix) (*Box, error) {
+	if vecs == nil || vecs.NVecs() != 3 {
+		return nil, CError{"A box requires exactly 3 cell vectors", []string{"NewBox"}}
+	}
+	B := &Box{vecs: v3.Zeros(3)}
+	B.vecs.Copy(vecs)
+	if B.Volume() <= 0 {
+		return nil, CError{fmt.Sprintf("Degenerate or left-handed cell vectors (volume %5.3f)", B.Volume()), []string{"NewBox"}}
+	}
+	B.setArrays()
+	return B, nil
+}
+
+//setArrays fills the array versions of the cell vectors
+//and of their inverse.
+func (B *Box) setArrays() {
+	B.ortho = true
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			B.h[i][j] = B.vecs.At(i, j)
+			if i != j && B.h[i][j] != 0 {
+				B.ortho = false
+			}
+		}
+	}
+	h := B.h
+	det := B.Volume()
+	B.inv[0][0] = (h[1][1]*h[2][2] - h[1][2]*h[2][1]) / det
+	B.inv[0][1] = (h[0][2]*h[2][1] - h[0][1]*h[2][2]) / det
+	B.inv[0][2] = (h[0][1]*h[1][2] - h[0][2]*h[1][1]) / det
+	B.inv[1][0] = (h[1][2]*h[2][0] - h[1][0]*h[2][2]) / det
+	B.inv[1][1] = (h[0][0]*h[2][2] - h[0][2]*h[2][0]) / det
+	B.inv[1][2] = (h[0][2]*h[1][0] - h[0][0]*h[1][2]) / det
+	B.inv[2][0] = (h[1][0]*h[2][1] - h[1][1]*h[2][0]) / det
+	B.inv[2][1] = (h[0][1]*h[2][0] - h[0][0]*h[2][1]) / det
+	B.inv[2][2] = (h[0][0]*h[1][1] - h[0][1]*h[1][0]) / det
+}
+
+//NewBoxFromParams returns a box from the lengths of the cell vectors a, b and c (in A)
+//and the angles alpha (between b and c), beta (between a and c) and gamma (between
+//a and b), in degrees, as given, for instance, in the PDB CRYST1 record.
+func NewBoxFromParams(a, b, c, alpha, beta, gamma float64) (*Box, error) {
+	if a <= 0 || b <= 0 || c <= 0 {
+		return nil, CError{fmt.Sprintf("Box lengths must be positive: %5.3f %5.3f %5.3f", a, b, c), []string{"NewBoxFromParams"}}
+	}
+	alpha, beta, gamma = alpha*deg2Rad, beta*deg2Rad, gamma*deg2Rad
+	vecs := v3.Zeros(3)
+	vecs.Set(0, 0, a)
+	vecs.Set(1, 0, b*cosOrZero(gamma))
+	vecs.Set(1, 1, b*math.Sin(gamma))
+	cx := c * cosOrZero(beta)
+	cy := c * (cosOrZero(alpha) - cosOrZero(beta)*cosOrZero(gamma)) / math.Sin(gamma)
+	cz2 := c*c - cx*cx - cy*cy
+	if cz2 <= 0 {
+		return nil, CError{"Impossible combination of box angles", []string{"NewBoxFromParams"}}
+	}
+	vecs.Set(2, 0, cx)
+	vecs.Set(2, 1, cy)
+	vecs.Set(2, 2, math.Sqrt(cz2))
+	return NewBox(vecs)
+}
+
+//cosOrZero returns the cosine of the angle, or exactly zero
+//for right angles, so orthorhombic boxes stay exactly orthorhombic.
+func cosOrZero(angle float64) float64 {
+	if math.Abs(angle-math.Pi/2) < 1e-9 {
+		return 0
+	}
+	return math.Cos(angle)
+}
+
+//deg2Rad is more precise than Deg2Rad, which matters for nearly-orthogonal boxes.
+const deg2Rad = math.Pi / 180
+
+//Vecs returns a copy of the matrix with the cell vectors (one per row) of the box.
+func (B *Box) Vecs() *v3.Matrix {
+	r := v3.Zeros(3)
+	r.Copy(B.vecs)
+	return r
+}
+
+//At returns the component j of the cell vector i.
+func (B *Box) At(i, j int) float64 {
+	return B.vecs.At(i, j)
+}
+
+//Copy returns a copy of the box.
+func (B *Box) Copy() *Box {
+	r := *B
+	r.vecs = B.Vecs()
+	return &r
+}
+
+//Params returns the lengths of the cell vectors a, b and c (in A) and the
+//angles alpha, beta and gamma between them (in degrees).
+func (B *Box) Params() (a, b, c, alpha, beta, gamma float64) {
+	va, vb, vc := B.vecs.VecView(0), B.vecs.VecView(1), B.vecs.VecView(2)
+	a, b, c = va.Norm(2), vb.Norm(2), vc.Norm(2)
+	angle := func(v1, v2 *v3.Matrix, n1, n2 float64) float64 {
+		cos := v1.Dot(v2) / (n1 * n2)
+		return math.Acos(math.Max(-1, math.Min(1, cos))) / deg2Rad
+	}
+	alpha = angle(vb, vc, b, c)
+	beta = angle(va, vc, a, c)
+	gamma = angle(va, vb, a, b)
+	return
+}
+
+//Orthorhombic returns true if all the cell vectors are along the Cartesian axes.
+func (B *Box) Orthorhombic() bool {
+	return B.ortho
+}
+
+//Volume returns the volume of the box, in A^3.
+func (B *Box) Volume() float64 {
+	c := v3.Zeros(1)
+	c.Cross(B.vecs.VecView(1), B.vecs.VecView(2))
+	return B.vecs.VecView(0).Dot(c)
+}
+
+//String returns a string with the cell vectors of the box.
+func (B *Box) String() string {
+	return B.vecs.String()
+}
@@ -0,0 +1,202 @@
+/*
+ * box_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chem
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//boxTestMol returns a small molecule with 2 frames.
+func boxTestMol() *Molecule {
+	ats := make([]*Atom, 0, 3)
+	for i, n := range []string{"OW", "HW1", "HW2"} {
+		ats = append(ats, &Atom{Name: n, ID: i + 1, Molname: "SOL", MolID: 1, Chain: "A", Symbol: n[:1]})
+	}
+	coords := make([]*v3.Matrix, 0, 2)
+	for i := 0; i < 2; i++ {
+		c, _ := v3.NewMatrix([]float64{1 + float64(i), 1, 1, 2, 1, 1, 1, 2 + float64(i), 1})
+		coords = append(coords, c)
+	}
+	mol, _ := NewMolecule(coords, NewTopology(0, 1, ats), nil)
+	return mol
+}
+
+func sameBox(Te *testing.T, a, b *Box, tol float64) {
+	if a == nil || b == nil {
+		Te.Fatalf("Missing box: %v %v", a, b)
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if math.Abs(a.At(i, j)-b.At(i, j)) > tol {
+				Te.Fatalf("Boxes differ:\n%v\n%v", a, b)
+			}
+		}
+	}
+}
+
+func TestBoxParams(Te *testing.T) {
+	box, err := NewBoxFromParams(30, 40, 50, 70, 80, 100)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	a, b, c, alpha, beta, gamma := box.Params()
+	for i, v := range [][2]float64{{a, 30}, {b, 40}, {c, 50}, {alpha, 70}, {beta, 80}, {gamma, 100}} {
+		if math.Abs(v[0]-v[1]) > 1e-8 {
+			Te.Errorf("Parameter %d: got %f, expected %f", i, v[0], v[1])
+		}
+	}
+	if box.Orthorhombic() {
+		Te.Errorf("Triclinic box reported as orthorhombic")
+	}
+	ortho, err := NewBoxFromParams(30, 40, 50, 90, 90, 90)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if !ortho.Orthorhombic() || math.Abs(ortho.Volume()-60000) > 1e-8 {
+		Te.Errorf("Wrong orthorhombic box: %v, volume %f", ortho, ortho.Volume())
+	}
+	if _, err := NewBox(v3.Zeros(3)); err == nil {
+		Te.Errorf("A box of zeros should not be accepted")
+	}
+}
+
+func TestGroBox(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "gochembox")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mol := boxTestMol()
+	tric, _ := NewBoxFromParams(30, 35, 40, 90, 90, 60)
+	ortho, _ := NewBoxFromParams(30, 35, 40, 90, 90, 90)
+	boxes := []*Box{tric, ortho}
+	name := filepath.Join(dir, "box.gro")
+	if err := GroFileBoxWrite(name, mol.Coords, boxes, mol); err != nil {
+		Te.Fatal(err)
+	}
+	mol2, err := GroFileRead(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if len(mol2.Coords) != 2 || len(mol2.Boxes) != 2 {
+		Te.Fatalf("Read %d frames and %d boxes, expected 2", len(mol2.Coords), len(mol2.Boxes))
+	}
+	for i := range boxes {
+		sameBox(Te, mol2.Boxes[i], boxes[i], 1e-4)
+	}
+	//the BoxTraj interface
+	var traj BoxTraj = mol2
+	traj.Next(nil)
+	sameBox(Te, traj.Box(), tric, 1e-4)
+	//no box
+	if err := GroFileWrite(name, mol.Coords, mol); err != nil {
+		Te.Fatal(err)
+	}
+	mol2, err = GroFileRead(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if mol2.Boxes != nil || len(mol2.Coords) != 2 {
+		Te.Errorf("Expected 2 frames without boxes, got %d frames and boxes %v", len(mol2.Coords), mol2.Boxes)
+	}
+}
+
+func TestPDBBox(Te *testing.T) {
+	mol := boxTestMol()
+	tric, _ := NewBoxFromParams(30, 35, 40, 75, 85, 95)
+	ortho, _ := NewBoxFromParams(31, 36, 41, 90, 90, 90)
+	boxes := []*Box{tric, ortho}
+	var out bytes.Buffer
+	if err := MultiPDBBoxWrite(&out, mol.Coords, mol, nil, boxes); err != nil {
+		Te.Fatal(err)
+	}
+	mol2, err := PDBRead(&out, false)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if len(mol2.Coords) != 2 || len(mol2.Boxes) != 2 {
+		Te.Fatalf("Read %d frames and %d boxes, expected 2", len(mol2.Coords), len(mol2.Boxes))
+	}
+	for i := range boxes {
+		sameBox(Te, mol2.Boxes[i], boxes[i], 2e-3)
+	}
+	//The 1 A cubic cell means no box
+	placeholder := "CRYST1    1.000    1.000    1.000  90.00  90.00  90.00 P 1           1\n"
+	out.Reset()
+	if err := PDBWrite(&out, mol.Coords[0], mol, nil); err != nil {
+		Te.Fatal(err)
+	}
+	mol2, err = PDBRead(strings.NewReader(placeholder+out.String()), false)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if mol2.Boxes != nil {
+		Te.Errorf("The placeholder unit cell should not be read as a box")
+	}
+}
+
+//TestBoxFrameAlignment checks that each box stays with its frame when the frames
+//or the coordinates of the molecule change.
+func TestBoxFrameAlignment(Te *testing.T) {
+	mol := boxTestMol()
+	b0, _ := NewBoxFromParams(10, 10, 10, 90, 90, 90)
+	b2, _ := NewBoxFromParams(12, 12, 12, 90, 90, 90)
+	//Without box information, none is added.
+	mol.AddFrame(v3.Zeros(3))
+	if mol.Boxes != nil {
+		Te.Errorf("Boxes added to a molecule without box information")
+	}
+	mol.Boxes = []*Box{b0}
+	mol.AddFrameBox(v3.Zeros(3), b2)
+	mol.AddFrame(v3.Zeros(3))
+	mol.AddManyFrames([]*v3.Matrix{v3.Zeros(3), v3.Zeros(3)})
+	if len(mol.Boxes) != len(mol.Coords) || len(mol.Coords) != 7 {
+		Te.Fatalf("%d boxes for %d frames", len(mol.Boxes), len(mol.Coords))
+	}
+	for i := range mol.Coords {
+		want := (*Box)(nil)
+		switch i {
+		case 0:
+			want = b0
+		case 3:
+			want = b2
+		}
+		if mol.FrameBox(i) != want {
+			Te.Errorf("Wrong box for frame %d: %v", i, mol.FrameBox(i))
+		}
+	}
+	if err := mol.DelCoord(1); err != nil {
+		Te.Fatal(err)
+	}
+	if mol.Coords[0].NVecs() != 2 || len(mol.Boxes) != len(mol.Coords) || mol.FrameBox(3) != b2 {
+		Te.Errorf("Boxes misaligned after deleting coordinates")
+	}
+}
@@ -26,8 +26,10 @@ package chem
 
 import (
 	"fmt"
+	"math"
 	"sort"
 
+	"github.com/rmera/gochem/neighbors"
 	v3 "github.com/rmera/gochem/v3"
 )
 
@@ -51,12 +53,15 @@ type Atom struct {
 	Char16    byte    //Whatever is in the column 16 (counting from 0) in a PDB file, anything.
 	MolID     int     //PDB index of the corresponding residue or molecule
 	Chain     string  //One-character PDB name for a chain.
+	Segment   string  //Segment identifier (CHARMM, NAMD), if any.
 	Mass      float64 //hopefully all these float64 are not too much memory
 	Occupancy float64 //a PDB crystallographic field, often used to store values of interest.
 	Vdw       float64 //radius
 	Charge    float64 //Partial charge on an atom
 	Symbol    string
+	Type      string  //Force-field atom type, if known.
 	Het       bool    // is the atom an hetatm in the pdb file? (if applicable)
+	InsCode   byte    //PDB insertion code of the residue, 0 if there is none.
 	Bonds     []*Bond //The bonds connecting the atom to others.
 }
 
@@ -75,12 +80,16 @@ func (N *Atom) Copy(A *Atom) {
 	N.Molname1 = A.Molname1
 	N.MolID = A.MolID
 	N.Chain = A.Chain
+	N.Segment = A.Segment
 	N.Mass = A.Mass
 	N.Occupancy = A.Occupancy
 	N.Vdw = A.Vdw
 	N.Charge = A.Charge
 	N.Symbol = A.Symbol
+	N.Type = A.Type
 	N.Het = A.Het
+	N.Char16 = A.Char16
+	N.InsCode = A.InsCode
 }
 
 func (N *Atom) Index() int {
@@ -290,50 +299,57 @@ func (T *Topology) Masses() ([]float64, error) {
 //AssignBonds assigns bonds to a molecule based on a simple distance
 //criterium, similar to that described in DOI:10.1186/1758-2946-3-33
 func (T *Topology) AssignBonds(coord *v3.Matrix) error {
-	// might get slow for
-	//large systems. It's really not thought
-	//for proteins or macromolecules.
-	//For this reason, this method is not called automatically when building a new topology.
-	//Well, that and that it requires a Matrix object, which would mean changing the
+	return errDecorate(T.assignBonds(coord, nil), "AssignBonds")
+}
+
+//AssignBondsPBC is like AssignBonds, but it uses minimum image distances in the periodic box box,
+//so bonds across the boundaries of the box are also assigned.
+func (T *Topology) AssignBondsPBC(coord *v3.Matrix, box *Box) error {
+	return errDecorate(T.assignBonds(coord, box), "AssignBondsPBC")
+}
+
+//assignBonds assigns the bonds using minimum image distances in box, unless box is nil.
+func (T *Topology) assignBonds(coord *v3.Matrix, box *Box) error {
+	//The candidate pairs are obtained from a cell list, so
+	//this is not quadratic with the number of atoms anymore.
+	//This method is still not called automatically when building a new topology,
+	//as it requires a Matrix object, which would mean changing the
 	//signature of the NewTopology function.
-	var t1, t2 *v3.Matrix
-	var at1, at2 *Atom
+	if T.Len() == 0 {
+		return nil //nothing to bond.
+	}
 	T.FillIndexes()
-	t3 := v3.Zeros(1)
-	bonds := make([]*Bond, 0, 10)
 	tot := T.Len()
-	var nextIndex int
+	covs := make([]float64, tot)
+	var maxcov float64
 	for i := 0; i < tot; i++ {
-		t1 = coord.VecView(i)
-		at1 = T.Atoms[i]
-		cov1 := symbolCovrad[at1.Symbol]
-		if cov1 == 0 {
+		at := T.Atoms[i]
+		covs[i] = symbolCovrad[at.Symbol]
+		if covs[i] == 0 {
 			err := new(CError)
-			err.msg = fmt.Sprintf("Couldn't find the covalent radii  for %s %d", at1.Symbol, i)
-			err.Decorate("AssignBonds")
+			err.msg = fmt.Sprintf("Couldn't find the covalent radii  for %s %d", at.Symbol, i)
+			err.Decorate("assignBonds")
 			return err
 		}
-		for j := i + 1; j < tot; j++ {
-			t2 = coord.VecView(j)
-			at2 = T.Atoms[j]
-			cov2 := symbolCovrad[at2.Symbol]
-			if cov2 == 0 {
-				err := new(CError)
-				err.msg = fmt.Sprintf("Couldn't find the covalent radii  for %s %d", at2.Symbol, j)
-				err.Decorate("AssignBonds")
-				return err
-			}
-
-			t3.Sub(t2, t1)
-			d := t3.Norm(2)
-			if d < cov1+cov2+bondtol && d > tooclose {
-				b := &Bond{Index: nextIndex, Dist: d, At1: at1, At2: at2}
-				at1.Bonds = append(at1.Bonds, b)
-				at2.Bonds = append(at2.Bonds, b)
-				bonds = append(bonds, b) //just to easily keep track of them.
-				nextIndex++
-			}
-
+		maxcov = math.Max(maxcov, covs[i])
+	}
+	maxd := 2*maxcov + bondtol
+	var nbox neighbors.Box //a nil *Box would not give a nil interface.
+	if box != nil {
+		nbox = box
+	}
+	grid, err := neighbors.New(coord, maxd, nbox)
+	if err != nil {
+		return errDecorate(err, "assignBonds")
+	}
+	var nextIndex int
+	for _, p := range grid.Pairs(maxd) {
+		if p.Dist < covs[p.I]+covs[p.J]+bondtol && p.Dist > tooclose {
+			at1, at2 := T.Atoms[p.I], T.Atoms[p.J]
+			b := &Bond{Index: nextIndex, Dist: p.Dist, At1: at1, At2: at2}
+			at1.Bonds = append(at1.Bonds, b)
+			at2.Bonds = append(at2.Bonds, b)
+			nextIndex++
 		}
 	}
 
@@ -350,7 +366,7 @@ func (T *Topology) AssignBonds(coord *v3.Matrix) error {
 		for i := len(at.Bonds); i > max; i = len(at.Bonds) {
 			err := at.Bonds[len(at.Bonds)-1].Remove() //we remove the longest bond
 			if err != nil {
-				return errDecorate(err, "AssignBonds")
+				return errDecorate(err, "assignBonds")
 			}
 		}
 
@@ -370,7 +386,11 @@ type Molecule struct {
 	XYZFileData []string //This can be anything. The main rationale for including it is that XYZ files have a "comment"
 	//line after the first one. This line is sometimes used to write the energy of the structure.
 	//So here the line can be kept for each XYZ frame, and parse later
-	current int
+	Boxes     []*Box                 //The periodic box for each frame. It can be nil, and so can each element, if there is no box information.
+	Records   *PDBRecords            //Additional records read from a PDB file (SEQRES, HELIX, etc.). It can be nil.
+	XYZProps  []XYZProps             //The key=value pairs in the comment line of each frame of an extended XYZ file. It can be nil, and so can each element.
+	AtomProps []map[string]*AtomProp //The per-atom arrays (forces, charges, etc.) for each frame of an extended XYZ file, by name. It can be nil, and so can each element.
+	current   int
 }
 
 //NewMolecule makes a molecule with ats atoms, coords coordinates, bfactors b-factors
@@ -410,7 +430,9 @@ func NewMolecule(coords []*v3.Matrix, ats Atomer, bfactors [][]float64) (*Molecu
 
 //The molecule methods:
 
-//DelCoord the coodinate i from every frame of the molecule.
+//DelCoord the coodinate i from every frame of the molecule, and the values for the
+//atom i from the per-atom arrays in AtomProps.
+//The number of frames doesn't change, so the periodic boxes are kept as they are.
 func (M *Molecule) DelCoord(i int) error {
 	r, _ := M.Coords[0].Dims()
 	var err error
@@ -422,6 +444,17 @@ func (M *Molecule) DelCoord(i int) error {
 			return err
 		}
 	}
+	//The per-atom arrays of each frame also lose the atom. An array could be shared
+	//by several frames, so we make sure each one is trimmed only once.
+	done := make(map[*AtomProp]bool)
+	for _, props := range M.AtomProps {
+		for _, p := range props {
+			if p != nil && !done[p] {
+				p.delAtom(i)
+				done[p] = true
+			}
+		}
+	}
 	return nil
 }
 
@@ -459,6 +492,41 @@ func (M *Molecule) Copy(A *Molecule) {
 		tmp2 := copyB(A.Bfactors[key])
 		M.Bfactors = append(M.Bfactors, tmp2)
 	}
+	M.Boxes = nil
+	if A.Boxes != nil {
+		M.Boxes = make([]*Box, len(A.Boxes))
+		for key, val := range A.Boxes {
+			if val != nil {
+				M.Boxes[key] = val.Copy()
+			}
+		}
+	}
+	M.Records = nil
+	if A.Records != nil {
+		M.Records = A.Records.Copy()
+	}
+	M.XYZProps = nil
+	for _, val := range A.XYZProps {
+		var props XYZProps
+		if val != nil {
+			props = make(XYZProps, len(val))
+			for k, v := range val {
+				props[k] = v
+			}
+		}
+		M.XYZProps = append(M.XYZProps, props)
+	}
+	M.AtomProps = nil
+	for _, val := range A.AtomProps {
+		var props map[string]*AtomProp
+		if val != nil {
+			props = make(map[string]*AtomProp, len(val))
+			for k, v := range val {
+				props[k] = v.Copy()
+			}
+		}
+		M.AtomProps = append(M.AtomProps, props)
+	}
 	if err := M.Corrupted(); err != nil {
 		panic(PanicMsg(fmt.Sprintf("goChem: Molecule creation error: %s", err.Error())))
 	}
@@ -475,6 +543,12 @@ func copyB(b []float64) []float64 {
 //AddFrame akes a matrix of coordinates and appends them at the end of the Coords.
 // It checks that the number of coordinates matches the number of atoms.
 func (M *Molecule) AddFrame(newframe *v3.Matrix) {
+	M.AddFrameBox(newframe, nil)
+}
+
+//AddFrameBox is like AddFrame, but it also adds box as the periodic box for the new frame.
+//box can be nil. The Boxes of the molecule are kept aligned with its frames.
+func (M *Molecule) AddFrameBox(newframe *v3.Matrix, box *Box) {
 	if newframe == nil {
 		panic(ErrNilFrame)
 	}
@@ -488,6 +562,13 @@ func (M *Molecule) AddFrame(newframe *v3.Matrix) {
 	if M.Coords == nil {
 		M.Coords = make([]*v3.Matrix, 1, 1)
 	}
+	//If there is no box information at all, we don't need to add any.
+	if box != nil || M.Boxes != nil {
+		for len(M.Boxes) < len(M.Coords) {
+			M.Boxes = append(M.Boxes, nil)
+		}
+		M.Boxes = append(M.Boxes[:len(M.Coords)], box)
+	}
 	M.Coords = append(M.Coords, newframe)
 }
 
@@ -704,6 +785,52 @@ func (M *Molecule) NextConc(frames []bool) ([]chan *v3.Matrix, error) {
 	return toreturn, nil
 }
 
+//Seek sets the molecule so the next call to Next will
+//return the frame with the given index.
+func (M *Molecule) Seek(frame int) error {
+	if frame < 0 || frame >= len(M.Coords) {
+		return CError{fmt.Sprintf("Frame %d out of range (%d frames)", frame, len(M.Coords)), []string{"Molecule.Seek"}}
+	}
+	M.current = frame
+	return nil
+}
+
+//CurrentFrame returns the index of the next frame to be read.
+//It is equivalent to Current.
+func (M *Molecule) CurrentFrame() int {
+	return M.Current()
+}
+
+//Step returns the index of the last frame read, as a
+//Molecule has no information on the simulation step.
+func (M *Molecule) Step() int {
+	if M.current == 0 {
+		return 0
+	}
+	return M.current - 1
+}
+
+//Time returns the index of the last frame read, as a
+//Molecule has no information on the simulation time.
+func (M *Molecule) Time() float64 {
+	return float64(M.Step())
+}
+
+//Box returns the periodic box of the last frame read, or nil
+//if there is no box information for that frame.
+func (M *Molecule) Box() *Box {
+	return M.FrameBox(M.current - 1)
+}
+
+//FrameBox returns the periodic box for the given frame, or nil if there is no
+//box information for it.
+func (M *Molecule) FrameBox(frame int) *Box {
+	if frame < 0 || frame >= len(M.Boxes) {
+		return nil
+	}
+	return M.Boxes[frame]
+}
+
 /**End Traj interface implementation***********/
 
 //End Molecule methods
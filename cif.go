@@ -0,0 +1,540 @@
+/*
+ * cif.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+/***mmCIF/PDBx part***/
+
+//cifLexer splits a CIF file into tokens. It deals with comments, quoted strings
+//and semicolon-delimited text fields.
+type cifLexer struct {
+	r       *bufio.Reader
+	pending []string
+	line    int
+	eof     bool
+}
+
+func newCIFLexer(r io.Reader) *cifLexer {
+	return &cifLexer{r: bufio.NewReader(r)}
+}
+
+//next returns the next token, and whether it was quoted (quoted tokens
+//are never keywords, tags, or missing values). It returns io.EOF at the end of the file.
+func (L *cifLexer) next() (string, bool, error) {
+	for len(L.pending) == 0 {
+		if L.eof {
+			return "", false, io.EOF
+		}
+		line, err := L.r.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				return "", false, CError{err.Error(), []string{"bufio.Reader.ReadString", "cifLexer.next"}}
+			}
+			L.eof = true
+			if line == "" {
+				continue
+			}
+		}
+		L.line++
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, ";") {
+			text, err := L.textField(line[1:])
+			if err != nil {
+				return "", false, err
+			}
+			return text, true, nil
+		}
+		if err := L.split(line); err != nil {
+			return "", false, err
+		}
+	}
+	t := L.pending[0]
+	L.pending = L.pending[1:]
+	//Quoted tokens are marked with a leading zero byte by split.
+	if strings.HasPrefix(t, "\x00") {
+		return t[1:], true, nil
+	}
+	return t, false, nil
+}
+
+//textField reads a semicolon-delimited text field, which started with first.
+func (L *cifLexer) textField(first string) (string, error) {
+	lines := []string{first}
+	for {
+		line, err := L.r.ReadString('\n')
+		L.line++
+		if err != nil {
+			return "", CError{fmt.Sprintf("Unterminated text field at line %d", L.line), []string{"cifLexer.textField"}}
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, ";") {
+			//Anything after the closing semicolon is tokenized as usual.
+			if err := L.split(line[1:]); err != nil {
+				return "", err
+			}
+			return strings.Join(lines, "\n"), nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+//split tokenizes a line, adding the tokens to the pending slice.
+func (L *cifLexer) split(line string) error {
+	i := 0
+	for i < len(line) {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '#':
+			return nil
+		case c == '\'' || c == '"':
+			//A quote only closes the string if followed by whitespace or the end of the line.
+			j := i + 1
+			for ; j < len(line); j++ {
+				if line[j] == c && (j+1 == len(line) || line[j+1] == ' ' || line[j+1] == '\t') {
+					break
+				}
+			}
+			if j >= len(line) {
+				return CError{fmt.Sprintf("Unterminated quoted string at line %d", L.line), []string{"cifLexer.split"}}
+			}
+			L.pending = append(L.pending, "\x00"+line[i+1:j])
+			i = j + 1
+		default:
+			j := i
+			for j < len(line) && line[j] != ' ' && line[j] != '\t' {
+				j++
+			}
+			L.pending = append(L.pending, line[i:j])
+			i = j
+		}
+	}
+	return nil
+}
+
+//cifLoop is a loop_ construct, with the tags (lowercased) and all the values, row by row.
+type cifLoop struct {
+	tags   []string
+	values []string
+}
+
+//cifBlock contains the items and loops of a CIF data block.
+type cifBlock struct {
+	items map[string]string
+	loops []*cifLoop
+}
+
+//loop returns the loop that contains the given tag, or nil.
+func (B *cifBlock) loop(tag string) *cifLoop {
+	for _, l := range B.loops {
+		for _, t := range l.tags {
+			if t == tag {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+//cifMissing is used in CIF values for unknown (?) or not applicable (.) values.
+const cifMissing = "\x01"
+
+//cifParse reads the first data block of a CIF file.
+func cifParse(r io.Reader) (*cifBlock, error) {
+	L := newCIFLexer(r)
+	B := &cifBlock{items: make(map[string]string)}
+	var loop *cifLoop
+	inLoopHeader := false
+	var pendingTag string
+	started := false
+	for {
+		t, quoted, err := L.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errDecorate(err, "cifParse")
+		}
+		lower := strings.ToLower(t)
+		if !quoted {
+			switch {
+			case strings.HasPrefix(lower, "data_"):
+				if started {
+					//We only read the first block.
+					return B, nil
+				}
+				started = true
+				loop = nil
+				continue
+			case lower == "loop_":
+				loop = &cifLoop{}
+				B.loops = append(B.loops, loop)
+				inLoopHeader = true
+				continue
+			case strings.HasPrefix(t, "_"):
+				if inLoopHeader {
+					loop.tags = append(loop.tags, lower)
+					continue
+				}
+				if pendingTag != "" {
+					return nil, CError{fmt.Sprintf("Tag %s without value at line %d", pendingTag, L.line), []string{"cifParse"}}
+				}
+				loop = nil
+				pendingTag = lower
+				continue
+			case t == "." || t == "?":
+				t = cifMissing
+			}
+		}
+		inLoopHeader = false
+		if pendingTag != "" {
+			B.items[pendingTag] = t
+			pendingTag = ""
+			continue
+		}
+		if loop == nil {
+			return nil, CError{fmt.Sprintf("Value '%s' without tag at line %d", t, L.line), []string{"cifParse"}}
+		}
+		loop.values = append(loop.values, t)
+	}
+	if !started {
+		return nil, CError{"No data block found", []string{"cifParse"}}
+	}
+	for _, l := range B.loops {
+		if len(l.tags) == 0 || len(l.values)%len(l.tags) != 0 {
+			return nil, CError{fmt.Sprintf("Wrong number of values in loop with tags %v", l.tags), []string{"cifParse"}}
+		}
+	}
+	return B, nil
+}
+
+//cifAtomKey identifies an atom, to deal with alternative locations.
+type cifAtomKey struct {
+	model   string
+	chain   string
+	resid   string
+	inscode string
+	resname string
+	name    string
+}
+
+//CIFFileRead reads the atoms, coordinates, b-factors and unit cell from an mmCIF/PDBx file. Each model
+//is read as a frame. Only the first alternative location for each atom is kept, and its label is
+//stored in the Char16 field of the atom (as with PDB files).
+//Insertion codes are stored in the InsCode field.
+func CIFFileRead(name string) (*Molecule, error) {
+	f, err := OpenCompressed(name)
+	if err != nil {
+		return nil, errDecorate(err, "CIFFileRead")
+	}
+	defer f.Close()
+	mol, err := CIFRead(f)
+	return mol, errDecorate(err, "CIFFileRead")
+}
+
+//CIFRead reads an mmCIF/PDBx file from an io.Reader. See CIFFileRead.
+func CIFRead(r io.Reader) (*Molecule, error) {
+	B, err := cifParse(r)
+	if err != nil {
+		return nil, errDecorate(err, "CIFRead")
+	}
+	loop := B.loop("_atom_site.cartn_x")
+	if loop == nil {
+		return nil, CError{"No _atom_site loop with coordinates found", []string{"CIFRead"}}
+	}
+	col := make(map[string]int, len(loop.tags))
+	for i, t := range loop.tags {
+		col[strings.TrimPrefix(t, "_atom_site.")] = i
+	}
+	ntags := len(loop.tags)
+	//get returns the value of the first of the given fields present in the row, or "".
+	get := func(row int, fields ...string) string {
+		for _, f := range fields {
+			if c, ok := col[f]; ok {
+				if v := loop.values[row*ntags+c]; v != cifMissing {
+					return v
+				}
+			}
+		}
+		return ""
+	}
+	for _, f := range []string{"cartn_y", "cartn_z"} {
+		if _, ok := col[f]; !ok {
+			return nil, CError{"Missing _atom_site." + f, []string{"CIFRead"}}
+		}
+	}
+	atoms := make([]*Atom, 0, len(loop.values)/ntags)
+	coords := make([][]float64, 0, 1)
+	bfactors := make([][]float64, 0, 1)
+	models := make([]string, 0, 1)
+	seen := make(map[cifAtomKey]bool)
+	for row := 0; row < len(loop.values)/ntags; row++ {
+		model := get(row, "pdbx_pdb_model_num")
+		name := get(row, "auth_atom_id", "label_atom_id")
+		resname := get(row, "auth_comp_id", "label_comp_id")
+		chain := get(row, "auth_asym_id", "label_asym_id")
+		resid := get(row, "auth_seq_id", "label_seq_id")
+		inscode := get(row, "pdbx_pdb_ins_code")
+		alt := get(row, "label_alt_id")
+		if alt != "" {
+			key := cifAtomKey{model, chain, resid, inscode, resname, name}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		if len(models) == 0 || models[len(models)-1] != model {
+			for _, m := range models {
+				if m == model {
+					return nil, CError{fmt.Sprintf("Atoms of model %s are not contiguous", model), []string{"CIFRead"}}
+				}
+			}
+			models = append(models, model)
+			coords = append(coords, make([]float64, 0, 3*len(atoms)))
+			bfactors = append(bfactors, make([]float64, 0, len(atoms)))
+		}
+		frame := len(models) - 1
+		var c [3]float64
+		for j, f := range []string{"cartn_x", "cartn_y", "cartn_z"} {
+			c[j], err = strconv.ParseFloat(get(row, f), 64)
+			if err != nil {
+				return nil, CError{fmt.Sprintf("Can't read coordinate in row %d: %s", row+1, err.Error()), []string{"strconv.ParseFloat", "CIFRead"}}
+			}
+		}
+		coords[frame] = append(coords[frame], c[0], c[1], c[2])
+		var bfac float64
+		if v := get(row, "b_iso_or_equiv"); v != "" {
+			bfac, _ = strconv.ParseFloat(v, 64)
+		}
+		bfactors[frame] = append(bfactors[frame], bfac)
+		if frame > 0 {
+			continue //atom data other than coords is the same in all models so just read for the first.
+		}
+		at := &Atom{Name: name, Molname: resname, Chain: chain}
+		at.Het = get(row, "group_pdb") == "HETATM"
+		at.Molname1 = three2OneLetter[at.Molname]
+		if alt != "" {
+			at.Char16 = alt[0]
+		}
+		if inscode != "" {
+			at.InsCode = inscode[0]
+		}
+		if at.ID, err = strconv.Atoi(get(row, "id")); err != nil {
+			at.ID = len(atoms) + 1
+		}
+		at.MolID, _ = strconv.Atoi(resid) //waters and other non-polymers might lack it.
+		if v := get(row, "occupancy"); v != "" {
+			at.Occupancy, _ = strconv.ParseFloat(v, 64)
+		}
+		if v := get(row, "pdbx_formal_charge"); v != "" {
+			at.Charge, _ = strconv.ParseFloat(v, 64)
+		}
+		at.Symbol = strings.Title(strings.ToLower(get(row, "type_symbol")))
+		if at.Symbol == "" {
+			at.Symbol, _ = symbolFromName(at.Name)
+		}
+		at.Mass = symbolMass[at.Symbol]
+		atoms = append(atoms, at)
+	}
+	if len(atoms) == 0 {
+		return nil, CError{"No atoms found", []string{"CIFRead"}}
+	}
+	mcoords := make([]*v3.Matrix, len(coords))
+	for i := range coords {
+		if len(coords[i]) != 3*len(atoms) {
+			return nil, CError{fmt.Sprintf("Model %s has %d atoms, the first has %d", models[i], len(coords[i])/3, len(atoms)), []string{"CIFRead"}}
+		}
+		mcoords[i], err = v3.NewMatrix(coords[i])
+		if err != nil {
+			return nil, errDecorate(err, "CIFRead")
+		}
+	}
+	mol, err := NewMolecule(mcoords, NewTopology(0, 1, atoms), bfactors)
+	if err != nil {
+		return nil, errDecorate(err, "CIFRead")
+	}
+	box, err := cifCell(B)
+	if err != nil {
+		return nil, errDecorate(err, "CIFRead")
+	}
+	if box != nil {
+		mol.Boxes = make([]*Box, len(mcoords))
+		for i := range mol.Boxes {
+			mol.Boxes[i] = box
+		}
+	}
+	return mol, nil
+}
+
+//cifCell returns the box in the _cell items of the block, or nil if there is
+//no cell, or the cell is the 1 A cubic placeholder.
+func cifCell(B *cifBlock) (*Box, error) {
+	params := make([]float64, 6)
+	for i, t := range []string{"length_a", "length_b", "length_c", "angle_alpha", "angle_beta", "angle_gamma"} {
+		v, ok := B.items["_cell."+t]
+		if !ok || v == cifMissing {
+			return nil, nil
+		}
+		//Values can have the standard uncertainty in parentheses.
+		if p := strings.Index(v, "("); p > 0 {
+			v = v[:p]
+		}
+		var err error
+		params[i], err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, CError{"Can't read cell parameter: " + err.Error(), []string{"strconv.ParseFloat", "cifCell"}}
+		}
+	}
+	if params[0] == 1 && params[1] == 1 && params[2] == 1 {
+		return nil, nil
+	}
+	box, err := NewBoxFromParams(params[0], params[1], params[2], params[3], params[4], params[5])
+	return box, errDecorate(err, "cifCell")
+}
+
+//cifQuote returns the string s as a CIF value, quoted if needed.
+func cifQuote(s string) string {
+	if s == "" {
+		return "."
+	}
+	needs := strings.ContainsAny(s, " \t") || strings.ContainsAny(s[:1], "_#$'\";[]") || s == "." || s == "?"
+	lower := strings.ToLower(s)
+	for _, k := range []string{"data_", "loop_", "save_", "global_", "stop_"} {
+		if strings.HasPrefix(lower, k) {
+			needs = true
+		}
+	}
+	if !needs {
+		return s
+	}
+	if strings.Contains(s, "' ") || strings.HasSuffix(s, "'") {
+		return "\"" + s + "\""
+	}
+	return "'" + s + "'"
+}
+
+//cifAtomSiteTags are the fields written to the _atom_site loop.
+var cifAtomSiteTags = []string{"group_PDB", "id", "type_symbol", "label_atom_id", "label_alt_id", "label_comp_id", "label_asym_id",
+	"label_seq_id", "pdbx_PDB_ins_code", "Cartn_x", "Cartn_y", "Cartn_z", "occupancy", "B_iso_or_equiv", "pdbx_formal_charge",
+	"auth_seq_id", "auth_comp_id", "auth_asym_id", "auth_atom_id", "pdbx_PDB_model_num"}
+
+//CIFFileWrite writes an mmCIF/PDBx file with the atoms in mol and the coordinates coords.
+//bfact can be nil.
+func CIFFileWrite(name string, coords *v3.Matrix, mol Atomer, bfact []float64) error {
+	out, err := CreateCompressed(name)
+	if err != nil {
+		return errDecorate(err, "CIFFileWrite")
+	}
+	defer out.Close()
+	return errDecorate(CIFWrite(out, coords, mol, bfact), "CIFFileWrite")
+}
+
+//CIFWrite writes the atoms in mol and the coordinates coords in mmCIF/PDBx format to out.
+//bfact can be nil.
+func CIFWrite(out io.Writer, coords *v3.Matrix, mol Atomer, bfact []float64) error {
+	var bfactors [][]float64
+	if bfact != nil {
+		bfactors = [][]float64{bfact}
+	}
+	return errDecorate(MultiCIFWrite(out, []*v3.Matrix{coords}, mol, bfactors, nil), "CIFWrite")
+}
+
+//MultiCIFWrite writes the atoms in mol and each set of coordinates in Coords as a model, in mmCIF/PDBx
+//format, to out. Bfactors can be nil, in which case all b-factors are zero. If box is not nil, it is written as the
+//unit cell.
+func MultiCIFWrite(out io.Writer, Coords []*v3.Matrix, mol Atomer, Bfactors [][]float64, box *Box) error {
+	if !correctBfactors(Coords, Bfactors) {
+		Bfactors = make([][]float64, len(Coords))
+	}
+	for i, c := range Coords {
+		if c.NVecs() != mol.Len() {
+			return CError{fmt.Sprintf("Frame %d has %d atoms, the molecule has %d", i, c.NVecs(), mol.Len()), []string{"MultiCIFWrite"}}
+		}
+		if Bfactors[i] == nil {
+			Bfactors[i] = make([]float64, mol.Len())
+		}
+	}
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "data_gochem\n#\n")
+	if box != nil {
+		a, b, c, alpha, beta, gamma := box.Params()
+		fmt.Fprintf(w, "_cell.length_a %.4f\n_cell.length_b %.4f\n_cell.length_c %.4f\n", a, b, c)
+		fmt.Fprintf(w, "_cell.angle_alpha %.3f\n_cell.angle_beta %.3f\n_cell.angle_gamma %.3f\n#\n", alpha, beta, gamma)
+	}
+	w.WriteString("loop_\n")
+	for _, t := range cifAtomSiteTags {
+		fmt.Fprintf(w, "_atom_site.%s\n", t)
+	}
+	for f, coords := range Coords {
+		for i := 0; i < mol.Len(); i++ {
+			at := mol.Atom(i)
+			group := "ATOM"
+			if at.Het {
+				group = "HETATM"
+			}
+			alt := "."
+			if at.Char16 != 0 && at.Char16 != ' ' {
+				alt = string(at.Char16)
+			}
+			name := cifQuote(at.Name)
+			resname := cifQuote(at.Molname)
+			chain := cifQuote(at.Chain)
+			symbol := cifQuote(strings.ToUpper(at.Symbol))
+			//Partial charges can't be written as formal charges.
+			charge := "?"
+			if at.Charge == math.Trunc(at.Charge) {
+				charge = strconv.Itoa(int(at.Charge))
+			}
+			ins := "?"
+			if at.InsCode != 0 {
+				ins = string(at.InsCode)
+			}
+			fmt.Fprintf(w, "%s %d %s %s %s %s %s %d %s %.3f %.3f %.3f %.2f %.2f %s %d %s %s %s %d\n", group, at.ID, symbol, name, alt,
+				resname, chain, at.MolID, ins, coords.At(i, 0), coords.At(i, 1), coords.At(i, 2), at.Occupancy, Bfactors[f][i],
+				charge, at.MolID, resname, chain, name, f+1)
+		}
+	}
+	w.WriteString("#\n")
+	if err := w.Flush(); err != nil {
+		return CError{"Failed to write in io.Writer: " + err.Error(), []string{"bufio.Writer.Flush", "MultiCIFWrite"}}
+	}
+	return nil
+}
+
+/***End of mmCIF part***/
@@ -0,0 +1,149 @@
+/*
+ * cif_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chem
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+const testCIF = `data_TEST
+#
+_entry.id TEST
+_struct.title
+;A test entry, with a text field
+spanning two lines
+;
+_struct.pdbx_descriptor 'Some protein; with a "quoted" name'
+_cell.length_a 40.000
+_cell.length_b 50.000(2)
+_cell.length_c 60.000
+_cell.angle_alpha 90.00
+_cell.angle_beta 100.00
+_cell.angle_gamma 90.00
+#
+loop_
+_atom_site.group_PDB
+_atom_site.id
+_atom_site.type_symbol
+_atom_site.label_atom_id
+_atom_site.label_alt_id
+_atom_site.label_comp_id
+_atom_site.label_asym_id
+_atom_site.label_seq_id
+_atom_site.Cartn_x
+_atom_site.Cartn_y
+_atom_site.Cartn_z
+_atom_site.occupancy
+_atom_site.B_iso_or_equiv
+_atom_site.pdbx_formal_charge
+_atom_site.auth_seq_id
+_atom_site.auth_asym_id
+_atom_site.pdbx_PDB_model_num
+ATOM 1 N N . SER A 1 1.000 2.000 3.000 1.00 10.00 ? 5 A 1
+ATOM 2 C CA A SER A 1 2.000 2.000 3.000 0.60 11.00 ? 5 A 1
+ATOM 3 C CA B SER A 1 2.100 2.100 3.100 0.40 11.50 ? 5 A 1
+ATOM 4 O "O5'" . SER A 1 3.000 2.000 3.000 1.00 12.00 -1 5 A 1
+HETATM 5 ZN ZN . ZN B . 9.000 9.000 9.000 1.00 20.00 2 101 B 1
+ATOM 6 N N . SER A 1 1.500 2.000 3.000 1.00 10.00 ? 5 A 2
+ATOM 7 C CA A SER A 1 2.500 2.000 3.000 0.60 11.00 ? 5 A 2
+ATOM 8 C CA B SER A 1 2.600 2.100 3.100 0.40 11.50 ? 5 A 2
+ATOM 9 O "O5'" . SER A 1 3.500 2.000 3.000 1.00 12.00 -1 5 A 2
+HETATM 10 ZN ZN . ZN B . 9.500 9.000 9.000 1.00 20.00 2 101 B 2
+#
+`
+
+func TestCIFRead(Te *testing.T) {
+	mol, err := CIFRead(strings.NewReader(testCIF))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if mol.Len() != 4 || len(mol.Coords) != 2 {
+		Te.Fatalf("Read %d atoms and %d frames, expected 4 and 2", mol.Len(), len(mol.Coords))
+	}
+	ca := mol.Atom(1)
+	if ca.Name != "CA" || ca.Char16 != 'A' || ca.Occupancy != 0.6 || mol.Bfactors[0][1] != 11 {
+		Te.Errorf("Wrong alternative location kept: %v", ca)
+	}
+	o := mol.Atom(2)
+	if o.Name != "O5'" || o.MolID != 5 || o.Charge != -1 || o.Chain != "A" {
+		Te.Errorf("Wrong atom read: %v", o)
+	}
+	zn := mol.Atom(3)
+	if !zn.Het || zn.Symbol != "Zn" || zn.MolID != 101 || zn.Mass == 0 {
+		Te.Errorf("Wrong HETATM read: %v", zn)
+	}
+	if x := mol.Coords[1].At(0, 0); x != 1.5 {
+		Te.Errorf("Wrong coordinate in the second model: %f", x)
+	}
+	if mol.Boxes == nil {
+		Te.Fatal("The cell was not read")
+	}
+	_, b, _, _, beta, _ := mol.Boxes[0].Params()
+	if math.Abs(b-50) > 1e-6 || math.Abs(beta-100) > 1e-6 {
+		Te.Errorf("Wrong cell: %v", mol.Boxes[0])
+	}
+}
+
+func TestCIFWrite(Te *testing.T) {
+	mol, err := CIFRead(strings.NewReader(testCIF))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := MultiCIFWrite(&out, mol.Coords, mol, mol.Bfactors, mol.Boxes[0]); err != nil {
+		Te.Fatal(err)
+	}
+	mol2, err := CIFRead(&out)
+	if err != nil {
+		Te.Fatalf("%s\n%s", err, out.String())
+	}
+	if mol2.Len() != mol.Len() || len(mol2.Coords) != 2 || mol2.Boxes == nil {
+		Te.Fatalf("Read back %d atoms, %d frames, expected %d and 2, with a cell", mol2.Len(), len(mol2.Coords), mol.Len())
+	}
+	sameBox(Te, mol.Boxes[0], mol2.Boxes[0], 1e-3)
+	for i := 0; i < mol.Len(); i++ {
+		a, b := mol.Atom(i), mol2.Atom(i)
+		if a.Name != b.Name || a.Molname != b.Molname || a.MolID != b.MolID || a.Chain != b.Chain || a.Het != b.Het || a.Symbol != b.Symbol || a.Charge != b.Charge {
+			Te.Errorf("Atom %d changed when written and read: %v %v", i, a, b)
+		}
+		for f := range mol.Coords {
+			for j := 0; j < 3; j++ {
+				if math.Abs(mol.Coords[f].At(i, j)-mol2.Coords[f].At(i, j)) > 1e-3 {
+					Te.Errorf("Coordinates of atom %d, frame %d changed", i, f)
+				}
+			}
+		}
+	}
+	//single-frame writing, from a PDB-like molecule without b-factors.
+	out.Reset()
+	pdb := boxTestMol()
+	if err := CIFWrite(&out, pdb.Coords[0], pdb, nil); err != nil {
+		Te.Fatal(err)
+	}
+	if mol3, err := CIFRead(&out); err != nil || mol3.Len() != 3 || mol3.Boxes != nil {
+		Te.Errorf("Couldn't read back a single-frame mmCIF: %v", err)
+	}
+}
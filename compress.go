@@ -0,0 +1,175 @@
+/*
+ * compress.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+//magicCompression returns the registered compression format for a file that starts with header,
+//or nil if there is none. The registry must be locked by the caller.
+func magicCompression(header []byte) *Compression {
+	for _, c := range registry.compressions {
+		if bytes.HasPrefix(header, c.Magic) {
+			return c
+		}
+	}
+	return nil
+}
+
+//extCompression returns the registered compression format for the extension of the file name,
+//or nil if there is none.
+func extCompression(name string) *Compression {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	if ext == "" {
+		return nil
+	}
+	registry.RLock()
+	defer registry.RUnlock()
+	for _, c := range registry.compressions {
+		for _, e := range c.Extensions {
+			if e == ext {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+//OpenCompressed opens the file name for reading. If the file is compressed in a registered format
+//(gzip, bzip2, xz and zstd, by default) the returned reader gives the uncompressed data, otherwise, it
+//reads the file as it is. The compression is detected from the content of the file, so a wrong extension is not a problem.
+//Closing the reader closes the file.
+func OpenCompressed(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, CError{err.Error(), []string{"os.Open", "OpenCompressed"}}
+	}
+	buf := bufio.NewReader(f)
+	header, _ := buf.Peek(16) //We only need as many bytes as the longest magic number. Errors will show up when reading.
+	registry.RLock()
+	comp := magicCompression(header)
+	registry.RUnlock()
+	if comp == nil {
+		return &compressedReader{ioutil.NopCloser(buf), f}, nil
+	}
+	r, err := comp.NewReader(buf)
+	if err != nil {
+		f.Close()
+		return nil, CError{fmt.Sprintf("Can't read %s file: %s", comp.Name, err.Error()), []string{"Compression.NewReader", "OpenCompressed"}}
+	}
+	return &compressedReader{r, f}, nil
+}
+
+//CreateCompressed creates the file name for writing. If the extension of name is that of a registered
+//compression format with a writer (gz, xz and zst, by default), the data written is compressed in that format.
+//Otherwise, the file is written as it is. The returned writer must be closed, as that
+//writes the end of the compressed data, and closes the file.
+func CreateCompressed(name string) (io.WriteCloser, error) {
+	comp := extCompression(name)
+	if comp != nil && comp.NewWriter == nil {
+		return nil, CError{fmt.Sprintf("Writing %s compressed files is not supported", comp.Name), []string{"CreateCompressed"}}
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, CError{err.Error(), []string{"os.Create", "CreateCompressed"}}
+	}
+	if comp == nil {
+		return f, nil
+	}
+	w, err := comp.NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, CError{fmt.Sprintf("Can't write %s file: %s", comp.Name, err.Error()), []string{"Compression.NewWriter", "CreateCompressed"}}
+	}
+	return &compressedWriter{w, f}, nil
+}
+
+//compressedReader closes both the decompressing reader and the underlying file.
+type compressedReader struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (C *compressedReader) Close() error {
+	C.ReadCloser.Close()
+	return C.file.Close()
+}
+
+//compressedWriter closes both the compressing writer, which writes the end of the compressed data,
+//and the underlying file.
+type compressedWriter struct {
+	io.WriteCloser
+	file *os.File
+}
+
+func (C *compressedWriter) Close() error {
+	err := C.WriteCloser.Close()
+	if ferr := C.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+func init() {
+	RegisterCompression(&Compression{Name: "gzip", Extensions: []string{"gz", "gzip"}, Magic: []byte{0x1f, 0x8b},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }})
+	RegisterCompression(&Compression{Name: "bzip2", Extensions: []string{"bz2", "bzip2"}, Magic: []byte("BZh"),
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return ioutil.NopCloser(bzip2.NewReader(r)), nil }})
+	RegisterCompression(&Compression{Name: "xz", Extensions: []string{"xz"}, Magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			x, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(x), nil
+		},
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) }})
+	RegisterCompression(&Compression{Name: "zstd", Extensions: []string{"zst", "zstd"}, Magic: []byte{0x28, 0xb5, 0x2f, 0xfd},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			z, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return z.IOReadCloser(), nil
+		},
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }})
+}
@@ -0,0 +1,118 @@
+/*
+ * compress_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+func TestCompressed(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "gochem")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mol, err := PDBRead(strings.NewReader(testFormatPDB), true)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	magic := map[string][]byte{"gz": {0x1f, 0x8b}, "xz": []byte("\xfd7zXZ"), "zst": {0x28, 0xb5, 0x2f, 0xfd}}
+	for ext, m := range magic {
+		//The writers and readers for each format.
+		files := []struct {
+			name  string
+			write func(string) error
+			read  func(string) (*Molecule, error)
+		}{
+			{"water.pdb", func(n string) error { return PDBFileWrite(n, mol.Coords[0], mol, nil) },
+				func(n string) (*Molecule, error) { return PDBFileRead(n, true) }},
+			{"water.xyz", func(n string) error { return XYZFileWrite(n, mol.Coords[0], mol) }, XYZFileRead},
+			{"water.gro", func(n string) error { return GroFileWrite(n, mol.Coords, mol) }, GroFileRead},
+			{"water.mol2", func(n string) error { return Mol2FileWrite(n, mol.Coords[0], mol) }, Mol2FileRead},
+			{"water.sdf", func(n string) error { return SDFFileWrite(n, mol.Coords, mol) }, SDFFileRead},
+		}
+		for _, f := range files {
+			name := filepath.Join(dir, f.name+"."+ext)
+			if err := f.write(name); err != nil {
+				Te.Fatal(err)
+			}
+			data, err := ioutil.ReadFile(name)
+			if err != nil {
+				Te.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, m) {
+				Te.Errorf("%s was not compressed", name)
+			}
+			read, err := f.read(name)
+			if err != nil {
+				Te.Fatalf("%s: %v", name, err)
+			}
+			if read.Len() != 2 || math.Abs(read.Coords[0].At(1, 0)-0.957) > 0.01 { //gro files have less precision
+				Te.Errorf("%s: wrong structure read: %v", name, read.Coords[0])
+			}
+		}
+		//A trajectory, which is read as it is uncompressed.
+		name := filepath.Join(dir, "traj.xyz."+ext)
+		w, err := NewXYZTrajWriter(name, mol)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		c := v3.Zeros(2)
+		for i := 0; i < 3; i++ {
+			c.Set(1, 2, float64(i))
+			if err := w.WNext(c); err != nil {
+				Te.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			Te.Fatal(err)
+		}
+		traj, err := OpenTraj(name, mol)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		frames := 0
+		for ; traj.Next(c) == nil; frames++ {
+		}
+		if frames != 3 || c.At(1, 2) != 2 {
+			Te.Errorf("%s: read %d frames, last: %v", name, frames, c)
+		}
+	}
+	//bzip2 can be read, but not written.
+	if err := XYZFileWrite(filepath.Join(dir, "water.xyz.bz2"), mol.Coords[0], mol); err == nil {
+		Te.Errorf("A bzip2 file was written")
+	}
+}
@@ -33,8 +33,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
+	"math/bits"
 	"os"
 	"runtime"
+	"strings"
 
 	chem "github.com/rmera/gochem"
 	v3 "github.com/rmera/gochem/v3"
@@ -53,9 +57,20 @@ type DCDObj struct {
 	charmm     bool //Charmm traj?
 	extrablock bool
 	fourdim    bool
-	new        bool     //Still no frame read from it?
-	fixed      int32    //Fixed atoms (not supported)
-	dcd        *os.File //The DCD file
+	new        bool        //Still no frame read from it?
+	fixed      int32       //Number of fixed atoms
+	free       []int32     //Indexes of the free atoms, if there are fixed atoms
+	fixedFrame [][]float32 //The first frame, which contains the coordinates of the fixed atoms
+	freeFields [][]float32 //Buffer for the free atom coordinates
+	firstSize  int64       //The size of the first frame, which is different from the others if there are fixed atoms
+	istart     int32       //The step of the first frame
+	nsavc      int32       //Steps between frames
+	delta      float32     //The time step, in AKMA units
+	titles     []string    //The title records in the header
+	current    int         //The index of the next frame to be read
+	headerSize int64       //The size of the header, i.e. the offset of the first frame
+	unitcell   []float64   //The unit cell of the last frame read, as stored in the file. nil if there was none.
+	dcd        *os.File    //The DCD file
 	dcdFields  [][]float32
 	concBuffer [][][]float32
 	endian     binary.ByteOrder
@@ -76,6 +91,24 @@ func New(filename string) (*DCDObj, error) {
 
 }
 
+//The DCD format is registered so chem.OpenTraj can read DCD files.
+func init() {
+	chem.RegisterFormat(&chem.FileFormat{Name: "dcd", Extensions: []string{"dcd"},
+		Magic: func(header []byte) bool {
+			if len(header) < 8 || string(header[4:8]) != "CORD" {
+				return false
+			}
+			return binary.LittleEndian.Uint32(header) == 84 || binary.BigEndian.Uint32(header) == 84
+		},
+		OpenTraj: func(name string, top chem.Atomer) (chem.Traj, error) {
+			traj, err := New(name)
+			if err != nil {
+				return nil, err
+			}
+			return traj, nil
+		}})
+}
+
 //Readable returns true if the object is ready to be read from
 //false otherwise. It doesnt guarantee that there is something
 //to read.
@@ -85,7 +118,7 @@ func (D *DCDObj) Readable() bool {
 
 //initRead initializes a XtcObj for reading.
 //It requires only the filename, which must be valid.
-//It support big and little endianness, charmm or (namd>=2.1) and no
+//It support big and little endianness, charmm, namd>=2.1 and X-plor files, and
 //fixed atoms.
 func (D *DCDObj) initRead(name string) error {
 	wrapbinerr := func(err error) error {
@@ -96,6 +129,7 @@ func (D *DCDObj) initRead(name string) error {
 	D.endian = binary.LittleEndian
 	_ = rec_scale
 	NB := bytes.NewBuffer //shortness sake
+	D.filename = name
 	var err error
 	D.dcd, err = os.Open(name)
 	if err != nil {
@@ -109,6 +143,9 @@ func (D *DCDObj) initRead(name string) error {
 	//If this fails it means that the file is big endian.
 	if check != 84 {
 		D.endian = binary.BigEndian //
+		if bits.ReverseBytes32(uint32(check)) != 84 {
+			return Error{WrongFormat + ": Wrong header", D.filename, []string{"initRead"}, true}
+		}
 	}
 	//Then the magic number "CORD", also for some unknown reason.
 	magic := make([]byte, 4, 4)
@@ -139,7 +176,7 @@ func (D *DCDObj) initRead(name string) error {
 			//			fmt.Println("block", check) ///////////
 			D.extrablock = true
 		}
-		if err := binary.Read(NB(buf[40:]), D.endian, &check); err != nil {
+		if err := binary.Read(NB(buf[44:]), D.endian, &check); err != nil {
 			return wrapbinerr(err)
 		}
 		if check == 1 {
@@ -147,18 +184,30 @@ func (D *DCDObj) initRead(name string) error {
 			D.fourdim = true
 		}
 
-	} else {
-		return Error{WrongFormat + ": X-plor DCD not supported", D.filename, []string{"initRead"}, true}
 	}
 	if err := binary.Read(NB(buf[32:]), D.endian, &D.fixed); err != nil {
 		return Error{err.Error(), D.filename, []string{"initRead"}, true}
 
 	}
 	//	fmt.Println("fixed", D.fixed)
-	var delta float32 //This should work only on Charmm and namd >=2.1
-	if err := binary.Read(NB(buf[36:]), D.endian, &delta); err != nil {
+	if err := binary.Read(NB(buf[4:]), D.endian, &D.istart); err != nil {
+		return wrapbinerr(err)
+	}
+	if err := binary.Read(NB(buf[8:]), D.endian, &D.nsavc); err != nil {
 		return wrapbinerr(err)
 	}
+	//This should work only on Charmm and namd >=2.1. X-plor stores the time step as a double.
+	if D.charmm {
+		if err := binary.Read(NB(buf[36:]), D.endian, &D.delta); err != nil {
+			return wrapbinerr(err)
+		}
+	} else {
+		var delta float64
+		if err := binary.Read(NB(buf[36:]), D.endian, &delta); err != nil {
+			return wrapbinerr(err)
+		}
+		D.delta = float32(delta)
+	}
 	//	fmt.Println("delta:", delta)///////////////////////////////////////
 
 	if err := binary.Read(D.dcd, D.endian, &check); err != nil {
@@ -180,7 +229,9 @@ func (D *DCDObj) initRead(name string) error {
 	if err := binary.Read(D.dcd, D.endian, title); err != nil {
 		return wrapbinerr(err)
 	}
-	//	fmt.Println("Title:", string(title))///////////////////////////////////////
+	for i := 0; i < int(ntitle); i++ {
+		D.titles = append(D.titles, strings.TrimRight(string(title[i*int(mAXTITLE):(i+1)*int(mAXTITLE)]), " \x00"))
+	}
 	if err := binary.Read(D.dcd, D.endian, &input_int); err != nil {
 		return wrapbinerr(err)
 
@@ -202,16 +253,67 @@ func (D *DCDObj) initRead(name string) error {
 	if check != 4 { //and one more 4
 		return Error{WrongFormat, D.filename, []string{"initRead"}, true}
 	}
-	if D.fixed == 0 {
-		runtime.SetFinalizer(D, func(D *DCDObj) {
-			D.dcd.Close()
-		})
-		D.readable = true
-		return nil //nothing else to do
+	if D.fixed < 0 || D.fixed >= D.natoms {
+		return Error{WrongFormat + ": Wrong number of fixed atoms", D.filename, []string{"initRead"}, true}
 	}
+	if D.fixed > 0 {
+		if err := D.readFree(); err != nil {
+			return errDecorate(err, "initRead")
+		}
+	}
+	D.headerSize, err = D.dcd.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Error{err.Error(), D.filename, []string{"os.File.Seek", "initRead"}, true}
+	}
+	D.firstSize = D.frameSize()
+	runtime.SetFinalizer(D, func(D *DCDObj) {
+		D.dcd.Close()
+	})
+	D.readable = true
 	D.new = true //nothing read yet
-	return Error{"Fixed atoms not supported", D.filename, []string{"initRead"}, true}
+	if D.fixed > 0 {
+		//The coordinates of the fixed atoms are only in the first frame, so we read it now,
+		//to have them available even if other frames are read first, with Seek.
+		D.firstSize = D.frameSizeN(D.natoms)
+		D.fixedFrame = [][]float32{make([]float32, D.natoms), make([]float32, D.natoms), make([]float32, D.natoms)}
+		if err := D.nextRaw(D.fixedFrame); err != nil {
+			if _, ok := err.(*lastFrameError); !ok {
+				return errDecorate(err, "initRead")
+			}
+		}
+		if err := D.Seek(0); err != nil && D.NFrames() > 0 {
+			return errDecorate(err, "initRead")
+		}
+		D.readable = true
+	}
+	return nil
+
+}
 
+//readFree reads the block with the indexes of the free atoms, that follows the header
+//in trajectories with fixed atoms.
+func (D *DCDObj) readFree() error {
+	nfree := D.natoms - D.fixed
+	var blocksize int32
+	if err := binary.Read(D.dcd, D.endian, &blocksize); err != nil {
+		return Error{err.Error(), D.filename, []string{"binary.Read", "readFree"}, true}
+	}
+	if blocksize != 4*nfree {
+		return Error{WrongFormat + ": Wrong free atoms block", D.filename, []string{"readFree"}, true}
+	}
+	block, err := D.readByteBlock(blocksize)
+	if err != nil {
+		return errDecorate(err, "readFree")
+	}
+	D.free = make([]int32, nfree)
+	for i := range D.free {
+		D.free[i] = int32(D.endian.Uint32(block[4*i:])) - 1
+		if D.free[i] < 0 || D.free[i] >= D.natoms {
+			return Error{WrongFormat + ": Wrong free atom index", D.filename, []string{"readFree"}, true}
+		}
+	}
+	D.freeFields = [][]float32{make([]float32, nfree), make([]float32, nfree), make([]float32, nfree)}
+	return nil
 }
 
 //Next Reads the next frame in a DcDObj that has been initialized for read
@@ -267,29 +369,50 @@ func (D *DCDObj) nextRaw(blocks [][]float32) error {
 	//snapshots for some trajectories, so we must use the block size to see if
 	//there is an extra block or if the X block starts inmediately
 	var blocksize int32
-	if D.extrablock {
-		if err := binary.Read(D.dcd, D.endian, &blocksize); err != nil {
-			return Error{err.Error(), D.filename, []string{"binary.Read", "nextRaw"}, true}
+	//In trajectories with fixed atoms, only the first frame contains all atoms.
+	//For the others, we read the free atoms and copy the fixed ones from the first frame.
+	fields, n := blocks, D.natoms
+	if D.fixed > 0 && D.current > 0 {
+		fields, n = D.freeFields, D.natoms-D.fixed
+	}
+	//The first read of a frame is the only place where an EOF is expected.
+	if err := binary.Read(D.dcd, D.endian, &blocksize); err != nil {
+		if err == io.EOF {
+			D.readLast = true
+			D.readable = false
+			return newlastFrameError(D.filename, "nextRaw")
 		}
+		return Error{err.Error(), D.filename, []string{"binary.Read", "nextRaw"}, true}
+	}
+	D.unitcell = nil
+	if D.extrablock {
 		//If the blocksize is 4*natoms it means that the block is not an
 		//extra block, but the X coordinates, and thus we must skip the following
-		if blocksize != D.natoms*4 {
-			if _, err := D.readByteBlock(blocksize); err != nil {
+		if blocksize != n*4 {
+			cell, err := D.readByteBlock(blocksize)
+			if err != nil {
 				return err
 			}
+			//The unit cell is given as 6 doubles.
+			if blocksize == 48 {
+				D.unitcell = make([]float64, 6)
+				for i := range D.unitcell {
+					D.unitcell[i] = math.Float64frombits(D.endian.Uint64(cell[i*8:]))
+				}
+			}
 			blocksize = 0
 		}
 	}
 	//now get the coords, each as a slice of float32
 	//X
-	//we collect the X block size again only if it has not been collected before
+	//we collect the X block size again only if it has been used by the extra block.
 	if blocksize == 0 {
 		if err := binary.Read(D.dcd, D.endian, &blocksize); err != nil {
 			return Error{err.Error(), D.filename, []string{"binary.Read", "nextRaw"}, true}
 
 		}
 	}
-	err := D.readFloat32Block(blocksize, blocks[0])
+	err := D.readFloat32Block(blocksize, fields[0])
 	if err != nil {
 		return errDecorate(err, "nextRaw")
 	}
@@ -300,7 +423,7 @@ func (D *DCDObj) nextRaw(blocks [][]float32) error {
 	if err := binary.Read(D.dcd, D.endian, &blocksize); err != nil {
 		return err
 	}
-	err = D.readFloat32Block(blocksize, blocks[1])
+	err = D.readFloat32Block(blocksize, fields[1])
 	if err != nil {
 		return errDecorate(err, "nextRaw")
 	}
@@ -309,28 +432,29 @@ func (D *DCDObj) nextRaw(blocks [][]float32) error {
 	if err := binary.Read(D.dcd, D.endian, &blocksize); err != nil {
 		return Error{err.Error(), D.filename, []string{"binary.Read", "nextRaw"}, true}
 	}
-	err = D.readFloat32Block(blocksize, blocks[2])
+	err = D.readFloat32Block(blocksize, fields[2])
 	if err != nil {
 		return errDecorate(err, "nextRaw")
 	}
 	//	fmt.Println("Z", blocks[2])
-	//we skip the 4-D values if they exist. Apparently this is not present in the
-	//last snapshot, so we use an EOF here to signal that we have read the last snapshot.
+	//we skip the 4-D values if they exist.
 	if D.charmm && D.fourdim {
 		if err := binary.Read(D.dcd, D.endian, &blocksize); err != nil {
-			if err.Error() == "EOF" {
-				D.readLast = true
-				//	fmt.Println("LAST!")
-			} else {
-				return Error{err.Error(), D.filename, []string{"binary.Read", "nextRaw"}, true}
-			}
+			return Error{err.Error(), D.filename, []string{"binary.Read", "nextRaw"}, true}
+		}
+		if _, err := D.readByteBlock(blocksize); err != nil {
+			return errDecorate(err, "nextRaw")
 		}
-		if !D.readLast {
-			if _, err := D.readByteBlock(blocksize); err != nil {
-				return errDecorate(err, "nextRaw")
+	}
+	if n != D.natoms {
+		for k := range blocks {
+			copy(blocks[k], D.fixedFrame[k])
+			for i, j := range D.free {
+				blocks[k][j] = fields[k][i]
 			}
 		}
 	}
+	D.current++
 	return nil
 
 }
@@ -374,6 +498,95 @@ func (D *DCDObj) readByteBlock(blocksize int32) ([]byte, error) {
 	return block, nil
 }
 
+//frameSize returns the size, in bytes, of each frame in the trajectory.
+//It assumes that all frames have the same size, which is true for
+//any DCD file where the unit cell is present in every frame.
+//In trajectories with fixed atoms, this is the size of all the frames but the first.
+func (D *DCDObj) frameSize() int64 {
+	return D.frameSizeN(D.natoms - D.fixed)
+}
+
+//frameSizeN returns the size, in bytes, of a frame with n atoms.
+func (D *DCDObj) frameSizeN(n int32) int64 {
+	block := 4*int64(n) + 8 //each block is surrounded by its size.
+	size := 3 * block
+	if D.extrablock {
+		size += 48 + 8 //6 doubles.
+	}
+	if D.charmm && D.fourdim {
+		size += block
+	}
+	return size
+}
+
+//NFrames returns the number of frames in the trajectory, as obtained from the
+//size of the file. The number of frames stored in the DCD header is not used,
+//as it is often wrong for trajectories from simulations that didn't finish.
+func (D *DCDObj) NFrames() int {
+	info, err := D.dcd.Stat()
+	if err != nil {
+		return 0
+	}
+	size := info.Size() - D.headerSize
+	if size < D.firstSize {
+		return 0
+	}
+	return int((size-D.firstSize)/D.frameSize()) + 1
+}
+
+//frameOffset returns the offset in the file of the frame with the given index.
+func (D *DCDObj) frameOffset(frame int) int64 {
+	if frame == 0 {
+		return D.headerSize
+	}
+	return D.headerSize + D.firstSize + int64(frame-1)*D.frameSize()
+}
+
+//Seek sets the trajectory so the next call to Next or NextConc
+//will read the frame with the given index (starting from 0).
+func (D *DCDObj) Seek(frame int) error {
+	if frame < 0 || frame >= D.NFrames() {
+		return Error{fmt.Sprintf("Frame %d out of range", frame), D.filename, []string{"Seek"}, true}
+	}
+	if _, err := D.dcd.Seek(D.frameOffset(frame), io.SeekStart); err != nil {
+		return Error{err.Error(), D.filename, []string{"os.File.Seek", "Seek"}, true}
+	}
+	D.current = frame
+	D.readLast = false
+	D.readable = true
+	return nil
+}
+
+//CurrentFrame returns the index of the next frame to be read.
+func (D *DCDObj) CurrentFrame() int {
+	return D.current
+}
+
+//Step returns the simulation step of the last frame read, or
+//of the first frame, if nothing has been read.
+func (D *DCDObj) Step() int {
+	last := D.current - 1
+	if last < 0 {
+		last = 0
+	}
+	return int(D.istart) + last*int(D.nsavc)
+}
+
+//akma2ps converts CHARMM's AKMA time units to ps.
+const akma2ps = 0.04888821
+
+//Time returns the simulation time, in ps, of the last frame read, or
+//of the first frame, if nothing has been read. The time is obtained
+//from the step and the time step in the DCD header.
+func (D *DCDObj) Time() float64 {
+	return float64(D.Step()) * float64(D.delta) * akma2ps
+}
+
+//Titles returns the title records in the header of the trajectory.
+func (D *DCDObj) Titles() []string {
+	return D.titles
+}
+
 //Len returns the number of atoms per frame in the XtcObj.
 //XtcObj must be initialized. 0 means an uninitialized object.
 func (D *DCDObj) Len() int {
@@ -447,6 +660,27 @@ func (D *DCDObj) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
 	return framechans, nil
 }
 
+//Box returns the periodic box of the last frame read, or nil if the frame had no unit cell.
+func (D *DCDObj) Box() *chem.Box {
+	c := D.unitcell
+	if c == nil || c[0] == 0 || c[2] == 0 || c[5] == 0 {
+		return nil
+	}
+	//The cell is stored as A, gamma, B, beta, alpha, C. Newer versions of CHARMM and NAMD
+	//store the cosines of the angles, instead of the angles in degrees.
+	alpha, beta, gamma := c[4], c[3], c[1]
+	if math.Abs(alpha) <= 1 && math.Abs(beta) <= 1 && math.Abs(gamma) <= 1 {
+		alpha = math.Acos(alpha) * 180 / math.Pi
+		beta = math.Acos(beta) * 180 / math.Pi
+		gamma = math.Acos(gamma) * 180 / math.Pi
+	}
+	box, err := chem.NewBoxFromParams(c[0], c[2], c[5], alpha, beta, gamma)
+	if err != nil {
+		return nil
+	}
+	return box
+}
+
 //Errors
 
 //errDecorate is a helper function that asserts that the error is
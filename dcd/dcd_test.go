@@ -25,7 +25,14 @@
 package dcd
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
 	"testing"
 
 	chem "github.com/rmera/gochem"
@@ -246,3 +253,277 @@ func SecondRow(channelin, channelout chan *VecMatrix, current, other int) {
 	return
 }
 */
+
+//TestDCDSeek writes a small trajectory and checks random access to its frames.
+func TestDCDSeek(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "godcd")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "seek.dcd")
+	natoms := 5
+	w, err := NewWriter(name, natoms)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	nframes := 6
+	for i := 0; i < nframes; i++ {
+		c := v3.Zeros(natoms)
+		for j := 0; j < natoms; j++ {
+			c.Set(j, 0, float64(i))
+			c.Set(j, 1, float64(j))
+			c.Set(j, 2, float64(i*j))
+		}
+		if err := w.WNext(c); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if n := traj.NFrames(); n != nframes {
+		Te.Fatalf("NFrames returned %d, expected %d", n, nframes)
+	}
+	c := v3.Zeros(natoms)
+	for _, f := range []int{3, 0, 1, 5} {
+		if err := traj.Seek(f); err != nil {
+			Te.Fatal(err)
+		}
+		if err := traj.Next(c); err != nil {
+			Te.Fatal(err)
+		}
+		if c.At(2, 0) != float64(f) || c.At(2, 2) != float64(2*f) {
+			Te.Errorf("Read the wrong frame after seeking to %d: %v", f, c.VecView(2))
+		}
+		if traj.CurrentFrame() != f+1 || traj.Step() != f {
+			Te.Errorf("Wrong frame information after seeking to %d: current %d, step %d", f, traj.CurrentFrame(), traj.Step())
+		}
+	}
+	if err := traj.Next(c); err != nil {
+		if _, ok := err.(chem.LastFrameError); !ok {
+			Te.Fatal(err)
+		}
+	} else {
+		Te.Errorf("Expected a last frame error after the last frame")
+	}
+	if err := traj.Seek(nframes); err == nil {
+		Te.Errorf("Seeking out of range should fail")
+	}
+}
+
+//The writers must be usable with chem.TrajConvert.
+var _ chem.BoxTrajWriter = (*DCDWObj)(nil)
+
+//testDCDFrame returns the coordinates of frame f in the test trajectories.
+func testDCDFrame(f, natoms int) *v3.Matrix {
+	c := v3.Zeros(natoms)
+	for j := 0; j < natoms; j++ {
+		c.Set(j, 0, float64(f))
+		c.Set(j, 1, float64(j))
+		c.Set(j, 2, float64(f*j))
+	}
+	return c
+}
+
+//TestDCDWriterOptions checks the unit cells, time information and titles written, appending frames and big-endian files.
+func TestDCDWriterOptions(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "godcd")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	natoms := 4
+	for _, bigendian := range []bool{false, true} {
+		name := filepath.Join(dir, fmt.Sprintf("opts%t.dcd", bigendian))
+		opts := &WriterOptions{UnitCell: true, Istart: 100, Nsavc: 10, Delta: 0.002, Titles: []string{"REMARKS test", "REMARKS second title"}, BigEndian: bigendian}
+		box, err := chem.NewBoxFromParams(30, 31, 32, 90, 100, 90)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		for i := 0; i < 4; i++ {
+			//The last 2 frames are appended, the options other than Append should be ignored.
+			if i == 2 {
+				opts = &WriterOptions{Append: true, Istart: 3}
+			}
+			w, err := NewWriterWithOptions(name, natoms, opts)
+			if err != nil {
+				Te.Fatal(err)
+			}
+			if err := w.WNextBox(testDCDFrame(i, natoms), box); err != nil {
+				Te.Fatal(err)
+			}
+			i++
+			if err := w.WNextBox(testDCDFrame(i, natoms), box); err != nil {
+				Te.Fatal(err)
+			}
+			w.Close()
+		}
+		traj, err := New(name)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		if bigendian != (traj.endian == binary.BigEndian) {
+			Te.Errorf("Wrong endianness read")
+		}
+		if n := traj.NFrames(); n != 4 {
+			Te.Fatalf("NFrames returned %d, expected 4", n)
+		}
+		if t := traj.Titles(); len(t) != 2 || t[1] != "REMARKS second title" {
+			Te.Errorf("Wrong titles read: %q", t)
+		}
+		c := v3.Zeros(natoms)
+		for f := 0; f < 4; f++ {
+			if err := traj.Next(c); err != nil {
+				Te.Fatal(err)
+			}
+			if c.At(3, 2) != float64(3*f) {
+				Te.Errorf("Wrong coordinates in frame %d: %v", f, c)
+			}
+			if traj.Step() != 100+10*f || math.Abs(traj.Time()-0.002*float64(traj.Step())) > 1e-6 {
+				Te.Errorf("Wrong step or time in frame %d: %d %f", f, traj.Step(), traj.Time())
+			}
+			a, b, cc, alpha, beta, gamma := traj.Box().Params()
+			if math.Abs(a-30) > 1e-4 || math.Abs(b-31) > 1e-4 || math.Abs(cc-32) > 1e-4 || math.Abs(alpha-90) > 1e-4 || math.Abs(beta-100) > 1e-4 || math.Abs(gamma-90) > 1e-4 {
+				Te.Errorf("Wrong box in frame %d: %v", f, traj.Box())
+			}
+		}
+		traj.dcd.Close()
+	}
+	box, err := chem.NewBoxFromParams(10, 10, 10, 90, 90, 90)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	w, err := NewWriter(filepath.Join(dir, "nocell.dcd"), natoms)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.WNextBox(testDCDFrame(0, natoms), box); err == nil {
+		Te.Errorf("Writing a box to a trajectory without unit cells should fail")
+	}
+}
+
+//TestDCDFixed reads a small CHARMM trajectory with fixed atoms.
+func TestDCDFixed(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "godcd")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	natoms, nframes := 4, 3
+	free := []int32{2, 4} //1-based, atoms 1 and 3 are fixed.
+	b := new(bytes.Buffer)
+	w := func(data ...interface{}) {
+		for _, v := range data {
+			binary.Write(b, binary.LittleEndian, v)
+		}
+	}
+	icntrl := make([]int32, 20)
+	icntrl[0], icntrl[8], icntrl[19] = int32(nframes), int32(natoms-len(free)), 24
+	w(int32(84), []byte("CORD"), icntrl, int32(84))
+	w(int32(84), int32(1), []byte(fmt.Sprintf("%-80s", "REMARKS fixed")), int32(84))
+	w(int32(4), int32(natoms), int32(4))
+	w(int32(4*len(free)), free, int32(4*len(free)))
+	for f := 0; f < nframes; f++ {
+		atoms := []int{0, 1, 2, 3}
+		if f > 0 {
+			atoms = []int{1, 3}
+		}
+		for k := 0; k < 3; k++ {
+			w(int32(4 * len(atoms)))
+			for _, i := range atoms {
+				w(float32(100*k + 10*i + f))
+			}
+			w(int32(4 * len(atoms)))
+		}
+	}
+	name := filepath.Join(dir, "fixed.dcd")
+	if err := ioutil.WriteFile(name, b.Bytes(), 0644); err != nil {
+		Te.Fatal(err)
+	}
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if n := traj.NFrames(); n != nframes {
+		Te.Fatalf("NFrames returned %d, expected %d", n, nframes)
+	}
+	c := v3.Zeros(natoms)
+	check := func(f int) {
+		for i := 0; i < natoms; i++ {
+			for k := 0; k < 3; k++ {
+				expected := float64(100*k + 10*i)
+				if i == 1 || i == 3 {
+					expected += float64(f)
+				}
+				if c.At(i, k) != expected {
+					Te.Errorf("Wrong coordinates in frame %d: %v", f, c)
+					return
+				}
+			}
+		}
+	}
+	for _, f := range []int{2, 0, 1, 2} {
+		if err := traj.Seek(f); err != nil {
+			Te.Fatal(err)
+		}
+		if err := traj.Next(c); err != nil {
+			Te.Fatal(err)
+		}
+		check(f)
+	}
+	if _, ok := traj.Next(c).(chem.LastFrameError); !ok {
+		Te.Errorf("Expected a last frame error after the last frame")
+	}
+}
+
+//TestDCDOpenTraj checks that DCD files, compressed or not, can be opened with chem.OpenTraj.
+func TestDCDOpenTraj(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "godcd")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	natoms := 3
+	name := filepath.Join(dir, "traj.dcd")
+	w, err := NewWriter(name, natoms)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.WNext(testDCDFrame(i, natoms)); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	gz.Write(data)
+	gz.Close()
+	for n, content := range map[string][]byte{"traj.dcd.gz": b.Bytes(), "noext": data} {
+		if err := ioutil.WriteFile(filepath.Join(dir, n), content, 0644); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	for _, n := range []string{"traj.dcd", "traj.dcd.gz", "noext"} {
+		traj, err := chem.OpenTraj(filepath.Join(dir, n), nil)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		if _, ok := traj.(*DCDObj); !ok || traj.Len() != natoms {
+			Te.Fatalf("%s: wrong trajectory opened: %T", n, traj)
+		}
+		c := v3.Zeros(natoms)
+		for f := 0; f < 3; f++ {
+			if err := traj.Next(c); err != nil || c.At(2, 2) != float64(2*f) {
+				Te.Errorf("%s: wrong frame %d: %v %v", n, f, c, err)
+			}
+		}
+	}
+}
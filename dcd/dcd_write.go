@@ -27,9 +27,12 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"runtime"
+	"strings"
 
+	chem "github.com/rmera/gochem"
 	v3 "github.com/rmera/gochem/v3"
 )
 
@@ -50,166 +53,183 @@ func (B WB) Write(w []byte) (int, error) {
 	return len(w), nil
 }
 
+//WriterOptions contains the options for writing DCD files. The zero value gives the
+//same file as NewWriter.
+type WriterOptions struct {
+	UnitCell  bool     //Write a unit cell block in each frame. Frames written without a box get a cell of zeros.
+	Istart    int      //The step of the first frame.
+	Nsavc     int      //The number of steps between frames. 0 means 1.
+	Delta     float64  //The time step, in ps. 0 means 1 AKMA unit.
+	Titles    []string //The title records, of up to 80 characters each.
+	BigEndian bool     //Write a big-endian file. Files are little-endian by default.
+	Append    bool     //Add the frames to an existing file, if it exists. The other options are then taken from the file.
+}
+
 //Container for an Charmm/NAMD binary trajectory file.
 //opened for writing
 type DCDWObj struct {
-	natoms     int32
-	buffSize   int
-	readLast   bool //Have we read the last frame?
-	writable   bool //Is it ready to be written on
-	filename   string
-	charmm     bool //Charmm traj?
-	extrablock bool
-	fourdim    bool
-	frames     int32
-	new        bool     //Still no frame written to it it?
-	fixed      int32    //Fixed atoms (not supported)
-	dcd        *os.File //The DCD file
-	dcdFields  [][]float32
-	concBuffer [][][]float32
-	endian     binary.ByteOrder
+	natoms    int32
+	writable  bool //Is it ready to be written on
+	filename  string
+	unitcell  bool  //Does each frame have a unit cell block?
+	frames    int32 //Frames in the file
+	istart    int32
+	nsavc     int32
+	dcd       *os.File //The DCD file
+	dcdFields [][]float32
+	endian    binary.ByteOrder
 }
 
-//New writer initializes a DCD trajectory for writing.
+//NewWriter initializes a DCD trajectory for writing, in the CHARMM format, without unit cells.
 func NewWriter(filename string, natoms int) (*DCDWObj, error) {
-	traj := new(DCDWObj)
-	traj.natoms = int32(natoms)
-	if err := traj.initWrite(filename); err != nil {
-		return nil, errDecorate(err, "New")
+	traj, err := NewWriterWithOptions(filename, natoms, nil)
+	if err != nil {
+		return nil, errDecorate(err, "NewWriter")
 	}
-	//	traj.dcdFields = make([][]float32, 3, 3)
-	//	traj.dcdFields[0] = make([]float32, natoms, natoms)
-	//	traj.dcdFields[1] = make([]float32, natoms, natoms)
-	//	traj.dcdFields[2] = make([]float32, natoms, natoms)
-
 	return traj, nil
-
 }
 
-//InitRead initializes a XtcObj for reading.
-//It requires only the filename, which must be valid.
-//It support big and little endianness, charmm or (namd>=2.1) and no
-//fixed atoms.
-func (D *DCDWObj) initWrite(name string) error {
-	wrapbinerr := func(err error) error {
-		return Error{err.Error(), D.filename, []string{"binary.Write", "initWrite"}, true}
-	}
-
-	D.endian = binary.LittleEndian
-	var err error
-	D.dcd, err = os.Create(name)
-	if err != nil {
-		return Error{err.Error(), D.filename, []string{"os.Open", "initWrite"}, true}
-	}
-	if err := binary.Write(D.dcd, D.endian, int32(84)); err != nil {
-		return wrapbinerr(err)
-	}
-	//For some reason, we have to write this magic number.
-	magic := []byte("CORD")
-	if err := binary.Write(D.dcd, D.endian, magic); err != nil {
-		return wrapbinerr(err)
+//NewWriterWithOptions initializes a DCD trajectory for writing, in the CHARMM format, with the given options,
+//which can be nil. If opts.Append is true and the file exists, its header is checked against natoms and the
+//frames are added after the ones already in the file. A truncated last frame, if any, is overwritten.
+func NewWriterWithOptions(filename string, natoms int, opts *WriterOptions) (*DCDWObj, error) {
+	if opts == nil {
+		opts = &WriterOptions{}
 	}
-	//The frames in the file go here. No frames written yet, but will update this part after every write.
-	if err := binary.Write(D.dcd, D.endian, int32(0)); err != nil {
-		return wrapbinerr(err)
+	traj := new(DCDWObj)
+	traj.natoms = int32(natoms)
+	traj.filename = filename
+	if traj.natoms <= 0 {
+		return nil, Error{"Trajectory not initialized correctly, the number of atoms is set to zero!", filename, []string{"NewWriterWithOptions"}, true}
 	}
-	//Initial time
-	if err := binary.Write(D.dcd, D.endian, int32(0)); err != nil {
-		return wrapbinerr(err)
+	var err error
+	if _, staterr := os.Stat(filename); opts.Append && staterr == nil {
+		err = traj.initAppend(filename)
+	} else {
+		err = traj.initWrite(filename, opts)
 	}
-	//step interval (nsavc)
-	if err := binary.Write(D.dcd, D.endian, int32(1)); err != nil {
-		return wrapbinerr(err)
+	if err != nil {
+		return nil, errDecorate(err, "NewWriterWithOptions")
 	}
-	//5 zeros plus natom-rfreat
-	for i := 0; i < 6; i++ {
-		if err := binary.Write(D.dcd, D.endian, int32(0)); err != nil {
-			return wrapbinerr(err)
-		}
+	runtime.SetFinalizer(traj, func(D *DCDWObj) {
+		D.dcd.Close()
+	})
+	traj.writable = true
+	return traj, nil
+}
+
+//Len returns the number of atoms per frame in the DCDWObj.
+func (D *DCDWObj) Len() int {
+	return int(D.natoms)
+}
 
+//initWrite creates the file and writes the header.
+func (D *DCDWObj) initWrite(name string, opts *WriterOptions) error {
+	D.endian = binary.LittleEndian
+	if opts.BigEndian {
+		D.endian = binary.BigEndian
 	}
-	//delta time
-	if err := binary.Write(D.dcd, D.endian, float32(1)); err != nil {
-		return wrapbinerr(err)
+	D.unitcell = opts.UnitCell
+	D.istart = int32(opts.Istart)
+	D.nsavc = int32(opts.Nsavc)
+	if D.nsavc <= 0 {
+		D.nsavc = 1
 	}
-	//No unit cell
-	if err := binary.Write(D.dcd, D.endian, int32(0)); err != nil {
-		return wrapbinerr(err)
+	delta := float32(1)
+	if opts.Delta > 0 {
+		delta = float32(opts.Delta / akma2ps)
 	}
-	//8 zeros for charmm
-	for i := 0; i < 8; i++ {
-		if err := binary.Write(D.dcd, D.endian, int32(0)); err != nil {
-			return wrapbinerr(err)
+	var err error
+	D.dcd, err = os.Create(name)
+	if err != nil {
+		return Error{err.Error(), D.filename, []string{"os.Create", "initWrite"}, true}
+	}
+	//The first block has the magic number "CORD" and 20 integers (the CHARMM ICNTRL array).
+	icntrl := make([]int32, 20)
+	icntrl[1] = D.istart
+	icntrl[2] = D.nsavc
+	if D.unitcell {
+		icntrl[10] = 1
+	}
+	icntrl[19] = 24 //The CHARMM version, let's say, 24
+	titles := opts.Titles
+	if len(titles) == 0 {
+		titles = []string{"REMARKS CREATED BY GOCHEM"}
+	}
+	title := make([]byte, 0, int(mAXTITLE)*len(titles))
+	for _, v := range titles {
+		if len(v) > int(mAXTITLE) {
+			v = v[:mAXTITLE]
 		}
+		title = append(title, v+strings.Repeat(" ", int(mAXTITLE)-len(v))...)
 	}
-	//charmm version, let's say, 24
-	if err := binary.Write(D.dcd, D.endian, int32(24)); err != nil {
-		return wrapbinerr(err)
-	}
-
-	//don't ask me why
-	if err := binary.Write(D.dcd, D.endian, int32(84)); err != nil {
-		return wrapbinerr(err)
-	}
-	//same as above
-	if err := binary.Write(D.dcd, D.endian, int32(244)); err != nil {
-		return wrapbinerr(err)
+	w := &errWriter{w: D.dcd, endian: D.endian}
+	w.write(int32(84), []byte("CORD"), icntrl[:9], delta, icntrl[10:], int32(84))
+	w.write(int32(4+len(title)), int32(len(titles)), title, int32(4+len(title)))
+	//ok, this is important, the number of atoms in each snapshot
+	w.write(int32(4), D.natoms, int32(4))
+	if w.err != nil {
+		D.dcd.Close()
+		return Error{w.err.Error(), D.filename, []string{"binary.Write", "initWrite"}, true}
 	}
+	return nil //nothing else to do
+}
 
-	//how many units of mAXTITLE does the title have?
-	var ntitle int32 = 2 //just a dummy title.
-	if err := binary.Write(D.dcd, D.endian, ntitle); err != nil {
-		return wrapbinerr(err)
-	}
-	title := make([]byte, 2*mAXTITLE, 2*mAXTITLE)
-	//Not a very good title, I suppose
-	for j := range title {
-		title[j] = byte('l')
-	}
-	title[len(title)-1] = byte('\000') //null-ended
-	if err := binary.Write(D.dcd, D.endian, title); err != nil {
-		return wrapbinerr(err)
-	}
-	//no idea
-	if err := binary.Write(D.dcd, D.endian, int32(244)); err != nil {
-		return wrapbinerr(err)
+//initAppend opens an existing file to add frames to it.
+func (D *DCDWObj) initAppend(name string) error {
+	old, err := New(name)
+	if err != nil {
+		return errDecorate(err, "initAppend")
 	}
-	//no idea
-	if err := binary.Write(D.dcd, D.endian, int32(4)); err != nil {
-		return wrapbinerr(err)
+	defer old.dcd.Close()
+	if old.natoms != D.natoms {
+		return Error{fmt.Sprintf("The file has %d atoms, not %d", old.natoms, D.natoms), D.filename, []string{"initAppend"}, true}
 	}
-	//ok, this is important, the number of atoms in each snapshot
-	//We should have got the number of atoms when we created the object. Will check just in case.
-	//if it's zero it means it hasn't been set.
-	if D.natoms == 0 {
-		return Error{"Trajectory not initialized correctly, the number of atoms is set to zero!", D.filename, []string{"initWrite"}, true}
+	if old.fixed != 0 || old.fourdim || !old.charmm {
+		return Error{"Can only append to CHARMM files without fixed atoms or 4th dimension", D.filename, []string{"initAppend"}, true}
 	}
-	if err := binary.Write(D.dcd, D.endian, D.natoms); err != nil {
-		return wrapbinerr(err)
+	D.endian = old.endian
+	D.unitcell = old.extrablock
+	D.istart, D.nsavc = old.istart, old.nsavc
+	D.frames = int32(old.NFrames())
+	D.dcd, err = os.OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		return Error{err.Error(), D.filename, []string{"os.OpenFile", "initAppend"}, true}
 	}
-	//same as above
-	if err := binary.Write(D.dcd, D.endian, int32(4)); err != nil {
-		return wrapbinerr(err)
+	end := old.headerSize + int64(D.frames)*old.frameSize()
+	if err := D.dcd.Truncate(end); err != nil {
+		D.dcd.Close()
+		return Error{err.Error(), D.filename, []string{"os.File.Truncate", "initAppend"}, true}
 	}
-	runtime.SetFinalizer(D, func(D *DCDWObj) {
+	if _, err := D.dcd.Seek(end, io.SeekStart); err != nil {
 		D.dcd.Close()
-	})
-	D.writable = true
-	D.new = true //nothing read yet
-	return nil   //nothing else to do
+		return Error{err.Error(), D.filename, []string{"os.File.Seek", "initAppend"}, true}
+	}
+	return nil
 }
 
-//WNext rites the next frame to the trajectory.
+//WNext writes the next frame to the trajectory. If the trajectory has unit cells,
+//a cell of zeros (meaning no cell) is written.
 func (D *DCDWObj) WNext(towrite *v3.Matrix) error {
+	return D.WNextBox(towrite, nil)
+}
+
+//WNextBox writes the next frame to the trajectory, with the periodic
+//box box, which can be nil. The trajectory must have been created with
+//unit cells for a non-nil box to be written.
+func (D *DCDWObj) WNextBox(towrite *v3.Matrix, box *chem.Box) error {
 	if !D.writable {
-		return Error{TrajUnIni, D.filename, []string{"WNext"}, true}
+		return Error{TrajUnIni, D.filename, []string{"WNextBox"}, true}
 	}
 	if towrite == nil {
-		return Error{"got nil coordinates", D.filename, []string{"WNext"}, true}
+		return Error{"got nil coordinates", D.filename, []string{"WNextBox"}, true}
 
 	}
 	if int32(towrite.NVecs()) != D.natoms {
-		return Error{"Coordinates don't match the trajectory size", D.filename, []string{"WNext"}, true}
+		return Error{"Coordinates don't match the trajectory size", D.filename, []string{"WNextBox"}, true}
+	}
+	if box != nil && !D.unitcell {
+		return Error{"The trajectory was not created with unit cells", D.filename, []string{"WNextBox"}, true}
 	}
 	if D.dcdFields == nil {
 		D.dcdFields = make([][]float32, 3, 3)
@@ -218,109 +238,87 @@ func (D *DCDWObj) WNext(towrite *v3.Matrix) error {
 		D.dcdFields[2] = make([]float32, int(D.natoms), int(D.natoms))
 	}
 	//This is easier to write to the dcd
-	for i := 0; i < int(D.natoms); i++ {
-		k := i - i*(i/int(D.natoms)) //magic xD
+	for k := 0; k < int(D.natoms); k++ {
 		D.dcdFields[0][k] = float32(towrite.At(k, 0))
 		D.dcdFields[1][k] = float32(towrite.At(k, 1))
 		D.dcdFields[2][k] = float32(towrite.At(k, 2))
 	}
-	D.wnextRaw(D.dcdFields)
-	D.frames++
-	D.updateFrames()
-	return nil
-}
-
-//Next Reads the next frame in a XtcObj that has been initialized for read
-//With initread. If keep is true, returns a pointer to matrix.DenseMatrix
-//With the coordinates read, otherwiser, it discards the coordinates and
-//returns nil.
-func (D *DCDWObj) wnextRaw(blocks [][]float32) error {
-	if len(blocks[0]) != int(D.natoms) || len(blocks[1]) != int(D.natoms) || len(blocks[2]) != int(D.natoms) {
-		return Error{NotEnoughSpace, D.filename, []string{"nextRaw"}, true}
-	}
-	D.new = false
-	var blocksize int32 = int32(len(blocks[0])) * 4 //the "4" is because the size is required in bytes, apparently.
-	//now get the coords, each as a slice of float32
-	//X
-	if err := binary.Write(D.dcd, D.endian, blocksize); err != nil {
-		return err
-	}
-
-	err := D.writeFloat32Block(blocks[0])
-	if err != nil {
-		return errDecorate(err, "nextRaw")
+	w := &errWriter{w: D.dcd, endian: D.endian}
+	if D.unitcell {
+		w.write(int32(48), dcdCell(box), int32(48))
 	}
-	//Y
-	//Collect the size first, then the rest
-	if err := binary.Write(D.dcd, D.endian, blocksize); err != nil {
-		return err
+	for _, block := range D.dcdFields {
+		w.write(D.natoms*4, block, D.natoms*4)
 	}
-	err = D.writeFloat32Block(blocks[1])
-	if err != nil {
-		return errDecorate(err, "nextRaw")
+	if w.err != nil {
+		return Error{w.err.Error(), D.filename, []string{"binary.Write", "WNextBox"}, true}
 	}
-	//Z
-	if err := binary.Write(D.dcd, D.endian, blocksize); err != nil {
-		return Error{err.Error(), D.filename, []string{"binary.Write", "wnextRaw"}, true}
-	}
-	err = D.writeFloat32Block(blocks[2])
-	if err != nil {
-		return errDecorate(err, "nextRaw")
+	D.frames++
+	if err := D.updateFrames(); err != nil {
+		return errDecorate(err, "WNextBox")
 	}
-	//	fmt.Println("Z", blocks[2])
-	//we skip the 4-D values if they exist. Apparently this is not present in the
-	//last snapshot, so we use an EOF here to signal that we have read the last snapshot.
 	return nil
-
 }
 
-//Writes a block of float32s to the file, adding its size
-func (D *DCDWObj) writeFloat32Block(block []float32) error {
-	var blocksize int32 = int32(len(block)) * 4
-	if err := binary.Write(D.dcd, D.endian, block); err != nil {
-		return Error{err.Error(), D.filename, []string{"binary.Write", "writeFloat32Block"}, true}
+//dcdCell returns the unit cell block for the box, which can be nil, as
+//A, cos(gamma), B, cos(beta), cos(alpha), C, as NAMD does.
+func dcdCell(box *chem.Box) []float64 {
+	if box == nil {
+		return make([]float64, 6)
+	}
+	a, b, c, alpha, beta, gamma := box.Params()
+	cos := func(angle float64) float64 {
+		return math.Cos(angle * math.Pi / 180)
+	}
+	return []float64{a, cos(gamma), b, cos(beta), cos(alpha), c}
+}
 
+//Close closes the file associated to the trajectory. After
+//closing, the object can't be written to anymore.
+func (D *DCDWObj) Close() error {
+	if !D.writable {
+		return nil
 	}
-	if err := binary.Write(D.dcd, D.endian, blocksize); err != nil {
-		return Error{err.Error(), D.filename, []string{"binary.Write", "writeFloat32Block"}, true}
+	D.writable = false
+	if err := D.dcd.Close(); err != nil {
+		return Error{err.Error(), D.filename, []string{"os.File.Close", "Close"}, true}
 	}
 	return nil
 }
 
 //DCD is silly enough to require the number of frames at the begining.
+//The step of the last frame is also kept in the header.
 func (D *DCDWObj) updateFrames() error {
-	currentoffset, err := D.dcd.Seek(0, io.SeekCurrent) //we'll need it to go back
-	if err != nil {
-		return Error{err.Error(), D.filename, []string{"dcd.Seek", "updateFrame"}, true}
+	w := &errWriter{w: D.dcd, endian: D.endian}
+	if _, err := D.dcd.Seek(8, io.SeekStart); err != nil {
+		return Error{err.Error(), D.filename, []string{"dcd.Seek", "updateFrames"}, true}
 	}
-	//now we go to the begining of the file
-	_, err = D.dcd.Seek(0, io.SeekStart)
-	if err != nil {
-		return Error{err.Error(), D.filename, []string{"dcd.Seek", "updateFrame"}, true}
-	}
-
-	wrapbinerr := func(err error) error {
-		return Error{err.Error(), D.filename, []string{"binary.Write", "updateFrame"}, true}
+	w.write(D.frames)
+	if _, err := D.dcd.Seek(20, io.SeekStart); err != nil {
+		return Error{err.Error(), D.filename, []string{"dcd.Seek", "updateFrames"}, true}
 	}
-	//I could try to get directly to the part we need to write, but it's so close to the begining that I think it's best to just write a couple
-	//of unnecesary numbers.
-	if err := binary.Write(D.dcd, D.endian, int32(84)); err != nil {
-		return wrapbinerr(err)
+	w.write(D.istart + (D.frames-1)*D.nsavc)
+	if w.err != nil {
+		return Error{w.err.Error(), D.filename, []string{"binary.Write", "updateFrames"}, true}
 	}
-	//For some reason, we have to write this magic number.
-	magic := []byte("CORD")
-	if err := binary.Write(D.dcd, D.endian, magic); err != nil {
-		return wrapbinerr(err)
+	if _, err := D.dcd.Seek(0, io.SeekEnd); err != nil {
+		return Error{err.Error(), D.filename, []string{"dcd.Seek", "updateFrames"}, true}
 	}
-	if err := binary.Write(D.dcd, D.endian, int32(D.frames)); err != nil {
-		return wrapbinerr(err)
-	}
-	//we go back to the part of the file we were reading
-	_, err = D.dcd.Seek(currentoffset, io.SeekStart)
-	if err != nil {
-		return Error{err.Error(), D.filename, []string{"dcd.Seek", "updateFrame"}, true}
-	}
-
 	return nil
+}
 
+//errWriter writes binary data, keeping the first error found.
+type errWriter struct {
+	w      io.Writer
+	endian binary.ByteOrder
+	err    error
+}
+
+func (E *errWriter) write(data ...interface{}) {
+	for _, v := range data {
+		if E.err != nil {
+			return
+		}
+		E.err = binary.Write(E.w, E.endian, v)
+	}
 }
@@ -0,0 +1,478 @@
+/*
+ * extxyz.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+/***Extended XYZ part***/
+
+//XYZProps contains the key=value pairs in the comment line of a frame in an extended XYZ file.
+//The values are kept as strings, without the quotes or braces that may surround them in the file.
+//Keys without a value (flags) have the value "T".
+type XYZProps map[string]string
+
+//Float returns the value for key as a number.
+func (P XYZProps) Float(key string) (float64, error) {
+	v, ok := P[key]
+	if !ok {
+		return 0, CError{fmt.Sprintf("No %s property", key), []string{"XYZProps.Float"}}
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, CError{err.Error(), []string{"strconv.ParseFloat", "XYZProps.Float"}}
+	}
+	return f, nil
+}
+
+//Floats returns the value for key as a slice of numbers, separated by spaces or commas.
+func (P XYZProps) Floats(key string) ([]float64, error) {
+	v, ok := P[key]
+	if !ok {
+		return nil, CError{fmt.Sprintf("No %s property", key), []string{"XYZProps.Floats"}}
+	}
+	fields := strings.Fields(strings.Replace(v, ",", " ", -1))
+	ret := make([]float64, len(fields))
+	for i, f := range fields {
+		var err error
+		if ret[i], err = strconv.ParseFloat(f, 64); err != nil {
+			return nil, CError{err.Error(), []string{"strconv.ParseFloat", "XYZProps.Floats"}}
+		}
+	}
+	return ret, nil
+}
+
+//AtomProp is a per-atom array from an extended XYZ file, such as the forces or the charges.
+type AtomProp struct {
+	Type    byte      //'R' (real), 'I' (integer), 'L' (logical) or 'S' (string).
+	Cols    int       //The number of values per atom.
+	Values  []float64 //The Cols values for each atom, one atom after the other. Logical values are 1 (true) or 0 (false). nil for the S type.
+	Strings []string  //The values, for the S type, in the same order as Values.
+}
+
+//NewAtomProp returns a real (type R) per-atom array with the values in m, one row per atom.
+func NewAtomProp(m *v3.Matrix) *AtomProp {
+	r, c := m.Dims()
+	A := &AtomProp{Type: 'R', Cols: c, Values: make([]float64, 0, r*c)}
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			A.Values = append(A.Values, m.At(i, j))
+		}
+	}
+	return A
+}
+
+//At returns the value in the column col for the atom atom.
+func (A *AtomProp) At(atom, col int) float64 {
+	return A.Values[atom*A.Cols+col]
+}
+
+//Matrix returns the values in a matrix, one row per atom. The array must have 3 values per atom,
+//like the forces do.
+func (A *AtomProp) Matrix() (*v3.Matrix, error) {
+	if A.Cols != 3 || A.Values == nil {
+		return nil, CError{"Only numeric arrays with 3 columns can be turned into matrices", []string{"AtomProp.Matrix"}}
+	}
+	vals := make([]float64, len(A.Values))
+	copy(vals, A.Values)
+	m, err := v3.NewMatrix(vals)
+	return m, errDecorate(err, "AtomProp.Matrix")
+}
+
+//Copy returns a copy of A.
+func (A *AtomProp) Copy() *AtomProp {
+	C := &AtomProp{Type: A.Type, Cols: A.Cols}
+	if A.Values != nil {
+		C.Values = append([]float64(nil), A.Values...)
+	}
+	if A.Strings != nil {
+		C.Strings = append([]string(nil), A.Strings...)
+	}
+	return C
+}
+
+//delAtom removes the values for the atom i from the array.
+func (A *AtomProp) delAtom(i int) {
+	if i < 0 || i >= A.len() {
+		return
+	}
+	start, end := i*A.Cols, (i+1)*A.Cols
+	if A.Values != nil {
+		A.Values = append(A.Values[:start], A.Values[end:]...)
+	}
+	if A.Strings != nil {
+		A.Strings = append(A.Strings[:start], A.Strings[end:]...)
+	}
+}
+
+//len returns the number of atoms in the array.
+func (A *AtomProp) len() int {
+	if A.Cols <= 0 {
+		return 0
+	}
+	if A.Type == 'S' {
+		return len(A.Strings) / A.Cols
+	}
+	return len(A.Values) / A.Cols
+}
+
+//xyzColumn is an element of the Properties schema of an extended XYZ file.
+type xyzColumn struct {
+	name string
+	typ  byte
+	cols int
+}
+
+//The schema of a regular XYZ file.
+var xyzDefaultSchema = []xyzColumn{{"species", 'S', 1}, {"pos", 'R', 3}}
+
+//xyzInfo contains the information in the comment line of an XYZ frame.
+type xyzInfo struct {
+	comment   string
+	props     XYZProps //nil if the comment line is not an extended XYZ one.
+	schema    []xyzColumn
+	box       *Box
+	atomprops map[string]*AtomProp
+}
+
+//parseXYZComment parses the comment line of an XYZ frame. If the line is not in the extended
+//XYZ format, the information contains only the comment itself and the default schema.
+func parseXYZComment(comment string) (*xyzInfo, error) {
+	info := &xyzInfo{comment: comment, schema: xyzDefaultSchema}
+	if !strings.Contains(comment, "=") {
+		return info, nil
+	}
+	props, err := xyzKeyValues(strings.TrimSpace(comment))
+	if err != nil {
+		return info, nil //Not extended XYZ, just a comment with an "=" sign.
+	}
+	for k, v := range props {
+		switch {
+		case strings.EqualFold(k, "Lattice"):
+			vals, err := props.Floats(k)
+			if err != nil || len(vals) != 9 {
+				return nil, CError{"Wrong Lattice in extended XYZ file: " + v, []string{"parseXYZComment"}}
+			}
+			vecs, _ := v3.NewMatrix(vals)
+			//A lattice can have zero vectors for non-periodic directions, in which case we don't set a box.
+			info.box, _ = NewBox(vecs)
+			delete(props, k)
+		case strings.EqualFold(k, "Properties"):
+			if info.schema, err = xyzSchema(v); err != nil {
+				return nil, errDecorate(err, "parseXYZComment")
+			}
+			delete(props, k)
+		}
+	}
+	info.props = props
+	return info, nil
+}
+
+//xyzKeyValues splits an extended XYZ comment line into key=value pairs. Keys and values can be quoted,
+//and values can also be surrounded by braces.
+func xyzKeyValues(line string) (XYZProps, error) {
+	props := make(XYZProps)
+	i := 0
+	//token reads a key or a value starting at i, and leaves i after it.
+	token := func(stops string) (string, error) {
+		if i < len(line) && line[i] == '"' {
+			var b strings.Builder
+			for i++; i < len(line); i++ {
+				switch line[i] {
+				case '\\':
+					if i+1 < len(line) {
+						i++
+						b.WriteByte(line[i])
+					}
+				case '"':
+					i++
+					return b.String(), nil
+				default:
+					b.WriteByte(line[i])
+				}
+			}
+			return "", fmt.Errorf("Unterminated quote")
+		}
+		if i < len(line) && line[i] == '{' {
+			end := strings.IndexByte(line[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("Unterminated brace")
+			}
+			t := line[i+1 : i+end]
+			i += end + 1
+			return t, nil
+		}
+		start := i
+		for i < len(line) && !strings.ContainsRune(stops, rune(line[i])) {
+			i++
+		}
+		return line[start:i], nil
+	}
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+		key, err := token("= \t")
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			return nil, fmt.Errorf("Empty key")
+		}
+		props[key] = "T"
+		if i < len(line) && line[i] == '=' {
+			i++
+			if props[key], err = token(" \t"); err != nil {
+				return nil, err
+			}
+		}
+		if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			return nil, fmt.Errorf("Unexpected character %c", line[i])
+		}
+	}
+	return props, nil
+}
+
+//xyzSchema parses the Properties value of an extended XYZ file, which has the form name:type:columns,
+//repeated for each per-atom array, such as species:S:1:pos:R:3.
+func xyzSchema(prop string) ([]xyzColumn, error) {
+	f := strings.Split(prop, ":")
+	if len(f)%3 != 0 {
+		return nil, CError{"Wrong Properties in extended XYZ file: " + prop, []string{"xyzSchema"}}
+	}
+	schema := make([]xyzColumn, 0, len(f)/3)
+	pos := false
+	for i := 0; i < len(f); i += 3 {
+		c := xyzColumn{name: f[i]}
+		n, err := strconv.Atoi(f[i+2])
+		if err != nil || n <= 0 || len(f[i+1]) != 1 || !strings.Contains("RILS", f[i+1]) {
+			return nil, CError{"Wrong Properties in extended XYZ file: " + prop, []string{"xyzSchema"}}
+		}
+		c.typ, c.cols = f[i+1][0], n
+		if c.name == "pos" {
+			if c.typ != 'R' || c.cols != 3 {
+				return nil, CError{"Wrong pos property in extended XYZ file: " + prop, []string{"xyzSchema"}}
+			}
+			pos = true
+		}
+		schema = append(schema, c)
+	}
+	if !pos {
+		return nil, CError{"No pos property in extended XYZ file: " + prop, []string{"xyzSchema"}}
+	}
+	return schema, nil
+}
+
+//parseLine reads the atom i from the fields of its line, according to the schema. It puts the coordinates in coords,
+//the symbol in at, unless at is nil, and the other values in the per-atom arrays of the info.
+func (info *xyzInfo) parseLine(fields []string, i, natoms int, coords []float64, at *Atom) error {
+	col := 0
+	for _, c := range info.schema {
+		if col+c.cols > len(fields) {
+			return fmt.Errorf("Not enough columns")
+		}
+		vals := fields[col : col+c.cols]
+		col += c.cols
+		switch {
+		case c.name == "pos":
+			for k, v := range vals {
+				var err error
+				if coords[i*3+k], err = strconv.ParseFloat(v, 64); err != nil {
+					return err
+				}
+			}
+			continue
+		case c.name == "species" && c.typ == 'S' && c.cols == 1:
+			if at != nil {
+				at.Symbol = strings.Title(strings.ToLower(vals[0]))
+			}
+			continue
+		}
+		if info.atomprops == nil {
+			info.atomprops = make(map[string]*AtomProp)
+		}
+		p, ok := info.atomprops[c.name]
+		if !ok {
+			p = &AtomProp{Type: c.typ, Cols: c.cols}
+			if c.typ == 'S' {
+				p.Strings = make([]string, 0, natoms*c.cols)
+			} else {
+				p.Values = make([]float64, 0, natoms*c.cols)
+			}
+			info.atomprops[c.name] = p
+		}
+		for _, v := range vals {
+			var f float64
+			var err error
+			switch c.typ {
+			case 'S':
+				p.Strings = append(p.Strings, v)
+				continue
+			case 'L':
+				var b bool
+				b, err = strconv.ParseBool(v)
+				if b {
+					f = 1
+				}
+			default:
+				f, err = strconv.ParseFloat(v, 64)
+			}
+			if err != nil {
+				return fmt.Errorf("Wrong value for %s: %s", c.name, v)
+			}
+			p.Values = append(p.Values, f)
+		}
+		if c.name == "Z" && c.typ == 'I' && at != nil && at.Symbol == "" {
+			if z := int(p.Values[len(p.Values)-1]); z > 0 && z < len(zSymbol) {
+				at.Symbol = zSymbol[z]
+			}
+		}
+	}
+	return nil
+}
+
+//xyzComment returns the comment line of an extended XYZ frame, with the box (if not nil), the schema
+//for the per-atom arrays with the given names, and the properties, sorted by key.
+func xyzComment(box *Box, props XYZProps, atomprops map[string]*AtomProp, names []string) string {
+	var fields []string
+	if box != nil {
+		fields = append(fields, xyzLattice(box))
+	}
+	schema := []string{"species:S:1:pos:R:3"}
+	for _, n := range names {
+		p := atomprops[n]
+		schema = append(schema, fmt.Sprintf("%s:%c:%d", n, p.Type, p.Cols))
+	}
+	fields = append(fields, "Properties="+strings.Join(schema, ":"))
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		if !strings.EqualFold(k, "Lattice") && !strings.EqualFold(k, "Properties") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fields = append(fields, xyzQuote(k)+"="+xyzQuote(props[k]))
+	}
+	return strings.Join(fields, " ")
+}
+
+//xyzQuote quotes s if needed for an extended XYZ comment line.
+func xyzQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"={}\\") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+//ExtXYZWrite writes the coordinates coords for the atoms in mol, in the extended XYZ format, to out. The box and
+//the per-frame properties in props, and the per-atom arrays in atomprops, are also written. Any of them can be nil.
+//The arrays are written in alphabetical order, after the species and the positions, which are always the first columns.
+func ExtXYZWrite(out io.Writer, coords *v3.Matrix, mol Atomer, box *Box, props XYZProps, atomprops map[string]*AtomProp) error {
+	if mol.Len() != coords.NVecs() {
+		return CError{"Ref and Coords dont have the same number of atoms", []string{"ExtXYZWrite"}}
+	}
+	names := make([]string, 0, len(atomprops))
+	for k, v := range atomprops {
+		if k == "species" || k == "pos" {
+			continue
+		}
+		if v.len() != mol.Len() {
+			return CError{fmt.Sprintf("The %s array doesn't have one element per atom", k), []string{"ExtXYZWrite"}}
+		}
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-4d\n%s\n", mol.Len(), xyzComment(box, props, atomprops, names))
+	c := make([]float64, 3, 3)
+	for i := 0; i < mol.Len(); i++ {
+		c = coords.Row(c, i)
+		fmt.Fprintf(&b, "%-2s  %12.6f%12.6f%12.6f", mol.Atom(i).Symbol, c[0], c[1], c[2])
+		for _, n := range names {
+			p := atomprops[n]
+			for j := i * p.Cols; j < (i+1)*p.Cols; j++ {
+				switch p.Type {
+				case 'S':
+					fmt.Fprintf(&b, " %s", p.Strings[j])
+				case 'I':
+					fmt.Fprintf(&b, " %d", int(p.Values[j]))
+				case 'L':
+					if p.Values[j] != 0 {
+						b.WriteString(" T")
+					} else {
+						b.WriteString(" F")
+					}
+				default:
+					fmt.Fprintf(&b, " %14.8f", p.Values[j])
+				}
+			}
+		}
+		b.WriteString("\n")
+	}
+	if _, err := io.WriteString(out, b.String()); err != nil {
+		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Writer.Write", "ExtXYZWrite"}}
+	}
+	return nil
+}
+
+//ExtXYZFileWrite writes all the frames in mol to the file name, in the extended XYZ format, with the boxes,
+//per-frame properties and per-atom arrays of the molecule, if any.
+func ExtXYZFileWrite(name string, mol *Molecule) error {
+	out, err := CreateCompressed(name)
+	if err != nil {
+		return errDecorate(err, "ExtXYZFileWrite")
+	}
+	defer out.Close()
+	for i, c := range mol.Coords {
+		var props XYZProps
+		var atomprops map[string]*AtomProp
+		if i < len(mol.XYZProps) {
+			props = mol.XYZProps[i]
+		}
+		if i < len(mol.AtomProps) {
+			atomprops = mol.AtomProps[i]
+		}
+		if err := ExtXYZWrite(out, c, mol, mol.FrameBox(i), props, atomprops); err != nil {
+			return errDecorate(err, "ExtXYZFileWrite")
+		}
+	}
+	return nil
+}
+
+/***End of extended XYZ part***/
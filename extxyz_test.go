@@ -0,0 +1,163 @@
+/*
+ * extxyz_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testExtXYZ = `3
+Lattice="10.0 0.0 0.0 0.0 11.0 0.0 0.0 0.0 12.0" Properties=species:S:1:pos:R:3:forces:R:3:Z:I:1:fixed:L:1 energy=-76.25 config_type="water \"monomer\"" pbc="T T T" relaxed
+O 0.000 0.000 0.119 0.1 0.2 0.3 8 T
+H 0.000 0.763 -0.477 -0.1 0.0 0.0 1 F
+H 0.000 -0.763 -0.477 0.0 -0.2 -0.3 1 F
+3
+Lattice="10.5 0.0 0.0 0.0 11.0 0.0 0.0 0.0 12.0" Properties=species:S:1:pos:R:3:forces:R:3:Z:I:1:fixed:L:1 energy=-76.5
+O 0.000 0.000 0.120 1.1 1.2 1.3 8 T
+H 0.000 0.760 -0.470 0.0 0.0 0.0 1 F
+H 0.000 -0.760 -0.470 0.0 0.0 0.0 1 F
+`
+
+func checkExtXYZ(Te *testing.T, mol *Molecule) {
+	if mol.Len() != 3 || len(mol.Coords) != 2 {
+		Te.Fatalf("Read %d atoms and %d frames, expected 3 and 2", mol.Len(), len(mol.Coords))
+	}
+	if mol.Atom(1).Symbol != "H" || math.Abs(mol.Coords[1].At(1, 1)-0.76) > 1e-6 {
+		Te.Errorf("Wrong atoms or coordinates read: %s %v", mol.Atom(1).Symbol, mol.Coords[1])
+	}
+	if len(mol.Boxes) != 2 || mol.Boxes[1] == nil || math.Abs(mol.Boxes[1].At(0, 0)-10.5) > 1e-6 {
+		Te.Fatalf("Wrong boxes read: %v", mol.Boxes)
+	}
+	if len(mol.XYZProps) != 2 || len(mol.AtomProps) != 2 {
+		Te.Fatalf("Wrong number of frame properties read: %d %d", len(mol.XYZProps), len(mol.AtomProps))
+	}
+	if e, err := mol.XYZProps[1].Float("energy"); err != nil || e != -76.5 {
+		Te.Errorf("Wrong energy read: %f %v", e, err)
+	}
+	p := mol.XYZProps[0]
+	if p["config_type"] != `water "monomer"` || p["pbc"] != "T T T" || p["relaxed"] != "T" {
+		Te.Errorf("Wrong properties read: %v", p)
+	}
+	forces, err := mol.AtomProps[1]["forces"].Matrix()
+	if err != nil || forces.At(0, 2) != 1.3 {
+		Te.Errorf("Wrong forces read: %v %v", forces, err)
+	}
+	a := mol.AtomProps[0]
+	if a["Z"].Type != 'I' || a["Z"].At(0, 0) != 8 || a["fixed"].At(0, 0) != 1 || a["fixed"].At(1, 0) != 0 {
+		Te.Errorf("Wrong per-atom arrays read: %v %v", a["Z"], a["fixed"])
+	}
+}
+
+func TestExtXYZ(Te *testing.T) {
+	mol, err := XYZRead(strings.NewReader(testExtXYZ))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	checkExtXYZ(Te, mol)
+	dir, err := ioutil.TempDir("", "gochem")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "ext.xyz")
+	if err := ExtXYZFileWrite(name, mol); err != nil {
+		Te.Fatal(err)
+	}
+	mol2, err := XYZFileRead(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	checkExtXYZ(Te, mol2)
+	//The trajectory interface also gives the information for each frame.
+	_, traj, err := XYZFileAsTraj(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	traj.Next(nil)
+	if err := traj.Next(mol.Coords[0]); err != nil {
+		Te.Fatal(err)
+	}
+	if e, _ := traj.Props().Float("energy"); e != -76.5 || traj.Box() == nil || traj.AtomProps()["forces"] == nil {
+		Te.Errorf("Wrong information for the second frame of the trajectory")
+	}
+	//A regular XYZ file, with a comment that is not a key=value list.
+	plain, err := XYZRead(strings.NewReader("1\nE = -1.0 Hartree\nHe 0.0 0.0 0.0\n"))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if plain.XYZProps != nil || plain.AtomProps != nil || plain.Boxes != nil || plain.XYZFileData[0] != "E = -1.0 Hartree\n" {
+		Te.Errorf("A regular XYZ file was read as extended XYZ")
+	}
+}
+
+//TestExtXYZDel checks that the per-atom arrays follow the deletion of atoms.
+func TestExtXYZDel(Te *testing.T) {
+	mol, err := XYZRead(strings.NewReader(testExtXYZ))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	mol.AtomProps[0]["label"] = &AtomProp{Type: 'S', Cols: 1, Strings: []string{"a", "b", "c"}}
+	mol.AtomProps[1]["label"] = mol.AtomProps[0]["label"] //shared by both frames.
+	if err := mol.Del(1); err != nil {
+		Te.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "gochem")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "del.xyz")
+	if err := ExtXYZFileWrite(name, mol); err != nil {
+		Te.Fatal(err)
+	}
+	mol2, err := XYZFileRead(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if mol2.Len() != 2 || mol2.Atom(1).Symbol != "H" {
+		Te.Fatalf("Wrong molecule read back: %d atoms", mol2.Len())
+	}
+	forces, err := mol2.AtomProps[1]["forces"].Matrix()
+	if err != nil || forces.NVecs() != 2 || forces.At(0, 2) != 1.3 || forces.At(1, 1) != 0 {
+		Te.Errorf("Wrong forces read back: %v %v", forces, err)
+	}
+	f0 := mol2.AtomProps[0]["forces"]
+	if f0.At(1, 1) != -0.2 || mol2.AtomProps[0]["fixed"].At(1, 0) != 0 {
+		Te.Errorf("The wrong atom was deleted from the per-atom arrays")
+	}
+	for i, p := range mol2.AtomProps {
+		if l := p["label"]; l == nil || len(l.Strings) != 2 || l.Strings[0] != "a" || l.Strings[1] != "c" {
+			Te.Errorf("Wrong labels for frame %d: %v", i, l)
+		}
+	}
+}
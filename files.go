@@ -31,7 +31,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"os"
+	"math"
 	"strconv"
 	"strings"
 
@@ -129,7 +129,14 @@ func read_full_pdb_line(line string, read_additional bool, contlines int) (*Atom
 	atom.Molname = line[17:20]
 	atom.Molname1 = three2OneLetter[atom.Molname]
 	atom.Chain = string(line[21])
-	atom.MolID, err[1] = strconv.Atoi(strings.TrimSpace(line[22:30]))
+	//The column 26 has the insertion code. If it's not a letter, we assume that
+	//the residue number is too large and it overflows into the following columns.
+	if ins := line[26]; (ins >= 'A' && ins <= 'Z') || (ins >= 'a' && ins <= 'z') {
+		atom.InsCode = ins
+		atom.MolID, err[1] = strconv.Atoi(strings.TrimSpace(line[22:26]))
+	} else {
+		atom.MolID, err[1] = strconv.Atoi(strings.TrimSpace(line[22:30]))
+	}
 	//Here we shouldn't need TrimSpace, but I keep it just in case someone
 	// doesn's use all the fields when writting a PDB*/
 	coords[0], err[2] = strconv.ParseFloat(strings.TrimSpace(line[30:38]), 64)
@@ -147,12 +154,11 @@ func read_full_pdb_line(line string, read_additional bool, contlines int) (*Atom
 	//we try to read the additional only if indicated and if it is there
 	// In this part we don't catch errors. If something is missing we
 	// just ommit it
-	if read_additional && len(line) >= 80 {
+	if read_additional && len(line) >= 78 {
 		atom.Symbol = strings.TrimSpace(line[76:78])
 		atom.Symbol = strings.Title(strings.ToLower(atom.Symbol))
-		atom.Charge = float64(line[78]) //strconv.ParseFloat(strings.TrimSpace(line[78:78]),64)
-		if strings.Contains(line[79:79], "-") {
-			atom.Charge = -1.0 * atom.Charge
+		if len(line) >= 80 {
+			atom.Charge = pdbFormalCharge(line[78:80])
 		}
 	}
 
@@ -174,6 +180,23 @@ func read_full_pdb_line(line string, read_additional bool, contlines int) (*Atom
 	return atom, coords, bfactor, nil
 }
 
+//pdbFormalCharge parses the formal charge columns of a PDB atom line, which contain
+//a digit and a sign (for instance, "2+"). It returns 0 if the columns are blank or invalid.
+func pdbFormalCharge(s string) float64 {
+	s = strings.TrimSpace(s)
+	if len(s) != 2 || s[0] < '0' || s[0] > '9' {
+		return 0
+	}
+	c := float64(s[0] - '0')
+	switch s[1] {
+	case '+':
+		return c
+	case '-':
+		return -c
+	}
+	return 0
+}
+
 /*Parses a PDB line if only the coordinates and bfactors are to be read*/
 func read_onlycoords_pdb_line(line string, contlines int) ([]float64, float64, error) {
 	coords := make([]float64, 3, 3)
@@ -201,23 +224,45 @@ func read_onlycoords_pdb_line(line string, contlines int) ([]float64, float64, e
 // really well set up right now.
 func PDBRead(pdb io.Reader, read_additional bool) (*Molecule, error) {
 	bufiopdb := bufio.NewReader(pdb)
-	mol, err := pdbBufIORead(bufiopdb, read_additional)
+	mol, err := pdbBufIORead(bufiopdb, &PDBOptions{Additional: read_additional, AltLoc: '*'})
 	return mol, errDecorate(err, "PDBReaderREad")
 }
 
+//PDBReadWithOptions reads a PDB file from an io.Reader, with the options given. Unlike PDBRead,
+//it keeps only the first alternative location for each atom by default.
+//If opts is nil, the default options are used.
+func PDBReadWithOptions(pdb io.Reader, opts *PDBOptions) (*Molecule, error) {
+	if opts == nil {
+		opts = new(PDBOptions)
+	}
+	mol, err := pdbBufIORead(bufio.NewReader(pdb), opts)
+	return mol, errDecorate(err, "PDBReadWithOptions")
+}
+
+//PDBFileReadWithOptions reads a PDB file with the options given. See PDBReadWithOptions.
+func PDBFileReadWithOptions(pdbname string, opts *PDBOptions) (*Molecule, error) {
+	pdbfile, err := OpenCompressed(pdbname)
+	if err != nil {
+		return nil, errDecorate(err, "PDBFileReadWithOptions")
+	}
+	defer pdbfile.Close()
+	mol, err := PDBReadWithOptions(pdbfile, opts)
+	return mol, errDecorate(err, "PDBFileReadWithOptions")
+}
+
 //PDBFileRead reads the atomic entries for a PDB file, returns a bunch of without coordinates,
 // and the coordinates in a separate array of arrays. If there is one frame in the PDB
 // the coordinates array will be of lenght 1. It also returns an error which is not
 // really well set up right now.
 func PDBFileRead(pdbname string, read_additional bool) (*Molecule, error) {
-	pdbfile, err := os.Open(pdbname)
+	pdbfile, err := OpenCompressed(pdbname)
 	if err != nil {
 		//fmt.Println("Unable to open file!!")
 		return nil, err
 	}
 	defer pdbfile.Close()
 	pdb := bufio.NewReader(pdbfile)
-	mol, err := pdbBufIORead(pdb, read_additional)
+	mol, err := pdbBufIORead(pdb, &PDBOptions{Additional: read_additional, AltLoc: '*'})
 	return mol, err
 }
 
@@ -225,7 +270,8 @@ func PDBFileRead(pdbname string, read_additional bool) (*Molecule, error) {
 // and the coordinates in a separate array of arrays. If there is one frame in the PDB
 // the coordinates array will be of lenght 1. It also returns an error which is not
 // really well set up right now.
-func pdbBufIORead(pdb *bufio.Reader, read_additional bool) (*Molecule, error) {
+func pdbBufIORead(pdb *bufio.Reader, opts *PDBOptions) (*Molecule, error) {
+	read_additional := opts.Additional
 	molecule := make([]*Atom, 0)
 	modelnumber := 0 //This is the number of frames read
 	coords := make([][]float64, 1, 1)
@@ -234,6 +280,13 @@ func pdbBufIORead(pdb *bufio.Reader, read_additional bool) (*Molecule, error) {
 	bfactors[0] = make([]float64, 0)
 	first_model := true //are we reading the first model? if not we only save coordinates
 	contlines := 1      //count the lines read to better report errors
+	//The box in the last CRYST1 record read applies to every frame that follows it,
+	//until another CRYST1 record is found.
+	var box *Box
+	boxes := make([]*Box, 1, 1)
+	records := new(PDBRecords)
+	conect := make([][2]int, 0)
+	altseen := make(map[string]bool) //the atoms for which an alternative location has been read, in this model.
 	for {
 		line, err := pdb.ReadString('\n')
 		if err != nil {
@@ -242,6 +295,7 @@ func pdbBufIORead(pdb *bufio.Reader, read_additional bool) (*Molecule, error) {
 			//	contlines++ //count all the lines even if empty. This is unreachable but I'm not sure at this point if it's better this way! goChem does read PDBs correctly as far as I can see.
 		}
 		if len(line) < 4 {
+			contlines++
 			continue
 		}
 		//here we start actually reading
@@ -252,6 +306,18 @@ func pdbBufIORead(pdb *bufio.Reader, read_additional bool) (*Molecule, error) {
 		var atomtmp *Atom
 		//	var foo string // not really needed
 		if strings.HasPrefix(line, "ATOM") || strings.HasPrefix(line, "HETATM") {
+			if len(line) > 27 && line[16] != ' ' && opts.AltLoc != '*' {
+				if opts.AltLoc != 0 && line[16] != opts.AltLoc {
+					contlines++
+					continue
+				}
+				key := line[12:16] + line[17:27] //atom name, residue name, chain, residue number and insertion code.
+				if opts.AltLoc == 0 && altseen[key] {
+					contlines++
+					continue
+				}
+				altseen[key] = true
+			}
 			if !first_model {
 				c, bfactemp, err = read_onlycoords_pdb_line(line, contlines)
 				if err != nil {
@@ -270,14 +336,27 @@ func pdbBufIORead(pdb *bufio.Reader, read_additional bool) (*Molecule, error) {
 			//we add the coords to the latest frame of coordinaates
 			coords[len(coords)-1] = append(coords[len(coords)-1], c[0], c[1], c[2])
 			bfactors[len(bfactors)-1] = append(bfactors[len(bfactors)-1], bfactemp)
+			boxes[len(boxes)-1] = box
 		} else if strings.HasPrefix(line, "MODEL") {
+			altseen = make(map[string]bool)
 			modelnumber++        //,_=strconv.Atoi(strings.TrimSpace(line[6:]))
 			if modelnumber > 1 { //will be one for the first model, 2 for the second.
 				first_model = false
 				coords = append(coords, make([]float64, 0)) //new bunch of coords for a new frame
 				bfactors = append(bfactors, make([]float64, 0))
+				boxes = append(boxes, nil)
 			}
+		} else if strings.HasPrefix(line, "CRYST1") {
+			box, err = readCRYST1(line, contlines)
+			if err != nil {
+				return nil, errDecorate(err, "pdbBufIORead")
+			}
+		} else if strings.HasPrefix(line, "CONECT") {
+			conect = append(conect, readCONECT(line)...)
+		} else {
+			records.readRecord(line)
 		}
+		contlines++
 	}
 	//This could be done faster if done in the same loop where the coords are read
 	//Instead of having another loop just for them.
@@ -295,8 +374,61 @@ func pdbBufIORead(pdb *bufio.Reader, read_additional bool) (*Molecule, error) {
 	if err != nil {
 		return nil, errDecorate(err, "pdbBufIORead")
 	}
+	conectBonds(molecule, mcoords[0], conect)
 	returned, err := NewMolecule(mcoords, top, bfactors)
-	return returned, errDecorate(err, "pdbBufIORead")
+	if err != nil {
+		return nil, errDecorate(err, "pdbBufIORead")
+	}
+	if len(records.Remarks)+len(records.Seqres)+len(records.SSBonds)+len(records.Helices)+len(records.Strands) > 0 {
+		returned.Records = records
+	}
+	for _, v := range boxes {
+		if v != nil {
+			returned.Boxes = boxes
+			break
+		}
+	}
+	return returned, nil
+}
+
+//readCRYST1 parses a CRYST1 record and returns the corresponding box.
+//It returns nil if the record is the 1 A cubic cell placeholder
+//used for structures without a unit cell.
+func readCRYST1(line string, contlines int) (*Box, error) {
+	var fields []string
+	if len(line) >= 54 {
+		limits := []int{6, 15, 24, 33, 40, 47, 54}
+		for i := 1; i < len(limits); i++ {
+			fields = append(fields, strings.TrimSpace(line[limits[i-1]:limits[i]]))
+		}
+	} else {
+		fields = strings.Fields(line)[1:]
+	}
+	if len(fields) < 6 {
+		return nil, CError{fmt.Sprintf("Malformed CRYST1 record in line %d", contlines), []string{"readCRYST1"}}
+	}
+	p := make([]float64, 6)
+	var err error
+	for i := range p {
+		p[i], err = strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, CError{fmt.Sprintf("Malformed CRYST1 record in line %d: %s", contlines, err.Error()), []string{"strconv.ParseFloat", "readCRYST1"}}
+		}
+	}
+	if p[0] == 1 && p[1] == 1 && p[2] == 1 {
+		return nil, nil
+	}
+	box, err := NewBoxFromParams(p[0], p[1], p[2], p[3], p[4], p[5])
+	if err != nil {
+		return nil, errDecorate(err, fmt.Sprintf("readCRYST1: line %d", contlines))
+	}
+	return box, nil
+}
+
+//cryst1Line returns a CRYST1 record for the box, which must not be nil.
+func cryst1Line(box *Box) string {
+	a, b, c, alpha, beta, gamma := box.Params()
+	return fmt.Sprintf("CRYST1%9.3f%9.3f%9.3f%7.2f%7.2f%7.2f P 1           1\n", a, b, c, alpha, beta, gamma)
 }
 
 //End PDB_read family
@@ -329,32 +461,50 @@ func writePDBLine(atom *Atom, coord *v3.Matrix, bfact float64, chainprev string)
 	if atom.Het {
 		first = "HETATM"
 	}
-	formatstring := "%-6s%5d  %-3s %-4s%1s%4d    %8.3f%8.3f%8.3f%6.2f%6.2f          %2s  \n"
+	formatstring := "%-6s%5d  %-3s%1s%-4s%1s%4d%1s   %8.3f%8.3f%8.3f%6.2f%6.2f          %2s%2s\n"
 	//4 chars for the atom name are used when hydrogens are included.
-	//This has not been tested
+	//These start one column before the shorter names.
 	if len(atom.Name) == 4 {
-		formatstring = strings.Replace(formatstring, "%-3s ", "%-4s", 1)
+		formatstring = strings.Replace(formatstring, "  %-3s", " %-4s", 1)
 	} else if len(atom.Name) > 4 {
 		return "", chainprev, CError{"Cant print PDB line", []string{"writePDBLine"}}
 	}
+	altloc := " "
+	if atom.Char16 != 0 {
+		altloc = string(atom.Char16)
+	}
+	//Only integer charges are written, as formal charges.
+	charge := "  "
+	if c := atom.Charge; c != 0 && c == math.Trunc(c) && math.Abs(c) < 10 {
+		charge = fmt.Sprintf("%1.0f+", c)
+		if c < 0 {
+			charge = fmt.Sprintf("%1.0f-", -c)
+		}
+	}
 	//"%-6s%5d  %-3s %3s %1c%4d    %8.3f%8.3f%8.3f%6.2f%6.2f          %2s  \n"
-	out = fmt.Sprintf(formatstring, first, atom.ID, atom.Name, atom.Molname, atom.Chain,
-		atom.MolID, coord.At(0, 0), coord.At(0, 1), coord.At(0, 2), atom.Occupancy, bfact, atom.Symbol)
+	out = fmt.Sprintf(formatstring, first, atom.ID, atom.Name, altloc, atom.Molname, atom.Chain,
+		atom.MolID, insCode(atom.InsCode), coord.At(0, 0), coord.At(0, 1), coord.At(0, 2), atom.Occupancy, bfact, atom.Symbol, charge)
 	out = strings.Join([]string{ter, out}, "")
 	return out, chainprev, nil
 }
 
 //PDBFileWrite writes a PDB for the molecule mol and the coordinates Coords.
 func PDBFileWrite(pdbname string, coords *v3.Matrix, mol Atomer, Bfactors []float64) error {
-	out, err := os.Create(pdbname)
+	return errDecorate(PDBFileBoxWrite(pdbname, coords, mol, Bfactors, nil), "PDBFileWrite")
+}
+
+//PDBFileBoxWrite writes a PDB for the molecule mol and the coordinates Coords, with
+//a CRYST1 record for the periodic box box, unless box is nil.
+func PDBFileBoxWrite(pdbname string, coords *v3.Matrix, mol Atomer, Bfactors []float64, box *Box) error {
+	out, err := CreateCompressed(pdbname)
 	if err != nil {
-		return CError{err.Error(), []string{"os.Create", "PDBFileWrite"}}
+		return errDecorate(err, "PDBFileBoxWrite")
 	}
 	defer out.Close()
 	fmt.Fprintf(out, "REMARK WRITTEN WITH GOCHEM :-) \n")
-	err = PDBWrite(out, coords, mol, Bfactors)
+	err = PDBBoxWrite(out, coords, mol, Bfactors, box)
 	if err != nil {
-		return errDecorate(err, "PDBFileWrite")
+		return errDecorate(err, "PDBFileBoxWrite")
 	}
 	return nil
 }
@@ -362,19 +512,28 @@ func PDBFileWrite(pdbname string, coords *v3.Matrix, mol Atomer, Bfactors []floa
 //PDBWrite writes a PDB formatted sequence of bytes to an io.Writer for a given reference, coordinate set and bfactor set, which must match each other
 //returns error or nil.
 func PDBWrite(out io.Writer, coords *v3.Matrix, mol Atomer, bfact []float64) error {
-	err := pdbWrite(out, coords, mol, bfact)
+	return errDecorate(PDBBoxWrite(out, coords, mol, bfact, nil), "PDBWrite")
+}
+
+//PDBBoxWrite writes a PDB formatted sequence of bytes to an io.Writer for a given reference, coordinate set and bfactor set, which must match each other,
+//and a CRYST1 record for the periodic box box, unless box is nil. Returns error or nil.
+func PDBBoxWrite(out io.Writer, coords *v3.Matrix, mol Atomer, bfact []float64, box *Box) error {
+	err := pdbWrite(out, coords, mol, bfact, box, true)
 	if err != nil {
-		errDecorate(err, "PDBWrite")
+		return errDecorate(err, "PDBBoxWrite")
 	}
 	_, err = out.Write([]byte{'\n'}) //This function is just a wrapper to add the newline to what pdbWrite does.
 	if err != nil {
-		return CError{"Failed to write in io.Writer", []string{"io.Write.Write", "PDBWrite"}}
+		return CError{"Failed to write in io.Writer", []string{"io.Write.Write", "PDBBoxWrite"}}
 
 	}
 	return nil
 }
 
-func pdbWrite(out io.Writer, coords *v3.Matrix, mol Atomer, bfact []float64) error {
+//pdbWrite writes the atoms in mol with the coordinates coords and b-factors bfact. If box is not nil,
+//a CRYST1 record is written. If full is true, the additional records (if mol is a *Molecule with records) and
+//the CONECT records are also written.
+func pdbWrite(out io.Writer, coords *v3.Matrix, mol Atomer, bfact []float64, box *Box, full bool) error {
 	if bfact == nil {
 		bfact = make([]float64, mol.Len())
 	}
@@ -389,6 +548,16 @@ func pdbWrite(out io.Writer, coords *v3.Matrix, mol Atomer, bfact []float64) err
 	iowriteError := func(err error) error {
 		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Write.Write", "pdbWrite"}}
 	}
+	if full {
+		if err := writePDBRecords(out, mol); err != nil {
+			return errDecorate(err, "pdbWrite")
+		}
+	}
+	if box != nil {
+		if _, err := out.Write([]byte(cryst1Line(box))); err != nil {
+			return iowriteError(err)
+		}
+	}
 	for i := 0; i < mol.Len(); i++ {
 		//	r,c:=coords.Dims()
 		//	fmt.Println("IIIIIIIIIIIi", i,coords,r,c, "lllllll")
@@ -403,13 +572,26 @@ func pdbWrite(out io.Writer, coords *v3.Matrix, mol Atomer, bfact []float64) err
 		}
 	}
 	_, err = out.Write([]byte("TER\n")) // New Addition, should help to recognize the end of the chain.
-	_, err = out.Write([]byte("END"))   //no newline, this is in case the write is part of a PDB and one needs to write "ENDMDEL".
+	if full {
+		if err := writeCONECT(out, mol); err != nil {
+			return errDecorate(err, "pdbWrite")
+		}
+	}
+	_, err = out.Write([]byte("END")) //no newline, this is in case the write is part of a PDB and one needs to write "ENDMDEL".
 	if err != nil {
 		return iowriteError(err)
 	}
 	return nil
 }
 
+//writePDBRecords writes the additional PDB records of mol, if it is a *Molecule with records.
+func writePDBRecords(out io.Writer, mol Atomer) error {
+	if m, ok := mol.(*Molecule); ok && m.Records != nil {
+		return errDecorate(m.Records.write(out), "writePDBRecords")
+	}
+	return nil
+}
+
 //PDBStringWrite writes a string in PDB format for a given reference, coordinate set and bfactor set, which must match each other
 //returns the written string and error or nil.
 func PDBStringWrite(coords *v3.Matrix, mol Atomer, bfact []float64) (string, error) {
@@ -444,26 +626,40 @@ func PDBStringWrite(coords *v3.Matrix, mol Atomer, bfact []float64) (string, err
 //Bfactors. If it has one element, all b-factors will be zero.
 //Returns an error if fails, or nil if succeeds.
 func MultiPDBWrite(out io.Writer, Coords []*v3.Matrix, mol Atomer, Bfactors [][]float64) error {
+	return errDecorate(MultiPDBBoxWrite(out, Coords, mol, Bfactors, nil), "MultiPDBWrite")
+}
+
+//MultiPDBBoxWrite is like MultiPDBWrite, but it also writes a CRYST1 record in each model, with
+//the corresponding element of boxes. boxes can be nil, or shorter than Coords, in which case no
+//CRYST1 record is written for the models without a box.
+func MultiPDBBoxWrite(out io.Writer, Coords []*v3.Matrix, mol Atomer, Bfactors [][]float64, boxes []*Box) error {
 	if !correctBfactors(Coords, Bfactors) {
 		Bfactors = make([][]float64, len(Coords), len(Coords))
 	}
 	iowriterError := func(err error) error {
-		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Writer.Write", "MultiPDBWrite"}}
+		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Writer.Write", "MultiPDBBoxWrite"}}
 	}
 
-	_, err := out.Write([]byte("REMARK WRITTEN WITH GOCHEM :-)")) //The model number starts with one
+	_, err := out.Write([]byte("REMARK WRITTEN WITH GOCHEM :-)\n"))
 	if err != nil {
 		return iowriterError(err)
 	}
+	if err := writePDBRecords(out, mol); err != nil {
+		return errDecorate(err, "MultiPDBBoxWrite")
+	}
 	//OK now the real business.
 	for j := range Coords {
 		_, err := out.Write([]byte(fmt.Sprintf("MODEL %d\n", j+1))) //The model number starts with one
 		if err != nil {
 			return iowriterError(err)
 		}
-		err = pdbWrite(out, Coords[j], mol, Bfactors[j])
+		var box *Box
+		if j < len(boxes) {
+			box = boxes[j]
+		}
+		err = pdbWrite(out, Coords[j], mol, Bfactors[j], box, false)
 		if err != nil {
-			return errDecorate(err, "MultiPDBWrite")
+			return errDecorate(err, "MultiPDBBoxWrite")
 		}
 		_, err = out.Write([]byte("MDL\n"))
 		if err != nil {
@@ -471,6 +667,9 @@ func MultiPDBWrite(out io.Writer, Coords []*v3.Matrix, mol Atomer, Bfactors [][]
 		}
 
 	}
+	if err := writeCONECT(out, mol); err != nil {
+		return errDecorate(err, "MultiPDBBoxWrite")
+	}
 
 	_, err = out.Write([]byte("END\n"))
 	if err != nil {
@@ -484,10 +683,10 @@ func MultiPDBWrite(out io.Writer, Coords []*v3.Matrix, mol Atomer, Bfactors [][]
 
 //XYZFileRead Reads an xyz or multixyz file (as produced by Turbomole). Returns a Molecule and error or nil.
 func XYZFileRead(xyzname string) (*Molecule, error) {
-	xyzfile, err := os.Open(xyzname)
+	xyzfile, err := OpenCompressed(xyzname)
 	if err != nil {
 		//fmt.Println("Unable to open file!!")
-		return nil, CError{err.Error(), []string{"os.Open", "XYZFileRead"}}
+		return nil, errDecorate(err, "XYZFileRead")
 	}
 	defer xyzfile.Close()
 	mol, err := XYZRead(xyzfile)
@@ -499,6 +698,8 @@ func XYZFileRead(xyzname string) (*Molecule, error) {
 }
 
 //Reads an xyz or multixyz formatted bufio.Reader (as produced by Turbomole). Returns a Molecule and error or nil.
+//Files in the extended XYZ format are supported: the periodic boxes (Lattice), per-frame properties and
+//per-atom arrays are put in the Boxes, XYZProps and AtomProps fields of the molecule, respectively.
 func XYZRead(xyzp io.Reader) (*Molecule, error) {
 	snaps := 1
 	xyz := bufio.NewReader(xyzp)
@@ -506,13 +707,13 @@ func XYZRead(xyzp io.Reader) (*Molecule, error) {
 	var top *Topology
 	var molecule []*Atom
 	Coords := make([]*v3.Matrix, 1, 1)
-	Data := make([]string, 1, 1)
+	Info := make([]*xyzInfo, 1, 1)
 
 	for {
 		//When we read the first snapshot we collect also the topology data, later
 		//only coords are collected.
 		if snaps == 1 {
-			Coords[0], molecule, Data[0], err = xyzReadSnap(xyz, nil, true)
+			Coords[0], molecule, Info[0], err = xyzReadSnap(xyz, nil, true)
 			if err != nil {
 				return nil, errDecorate(err, "XYZRead")
 			}
@@ -523,7 +724,7 @@ func XYZRead(xyzp io.Reader) (*Molecule, error) {
 			snaps++
 			continue
 		}
-		tmpcoords, _, data, err := xyzReadSnap(xyz, nil, false)
+		tmpcoords, _, info, err := xyzReadSnap(xyz, nil, false)
 		if err != nil {
 			//An error here simply means that there are no more snapshots
 			errm := err.Error()
@@ -534,24 +735,59 @@ func XYZRead(xyzp io.Reader) (*Molecule, error) {
 			return nil, errDecorate(err, "XYZRead")
 		}
 		Coords = append(Coords, tmpcoords)
-		Data = append(Data, data)
+		Info = append(Info, info)
 	}
 	bfactors := make([][]float64, len(Coords), len(Coords))
 	for key, _ := range bfactors {
 		bfactors[key] = make([]float64, top.Len())
 	}
 	returned, err := NewMolecule(Coords, top, bfactors)
-	returned.XYZFileData = Data
-	return returned, errDecorate(err, "XYZRead")
+	if err != nil {
+		return nil, errDecorate(err, "XYZRead")
+	}
+	returned.XYZFileData = make([]string, len(Info))
+	var boxes []*Box
+	var props []XYZProps
+	var atomprops []map[string]*AtomProp
+	for i, v := range Info {
+		returned.XYZFileData[i] = v.comment
+		boxes = append(boxes, v.box)
+		props = append(props, v.props)
+		atomprops = append(atomprops, v.atomprops)
+		if v.box != nil {
+			returned.Boxes = boxes
+		}
+		if v.props != nil {
+			returned.XYZProps = props
+		}
+		if v.atomprops != nil {
+			returned.AtomProps = atomprops
+		}
+	}
+	//The slices are only set if there is some information in them, but they must have one element per frame.
+	if returned.Boxes != nil {
+		returned.Boxes = boxes
+	}
+	if returned.XYZProps != nil {
+		returned.XYZProps = props
+	}
+	if returned.AtomProps != nil {
+		returned.AtomProps = atomprops
+	}
+	return returned, nil
 }
 
+//XYZTraj is a multi-XYZ file, read as a trajectory. It implements BoxTraj, as
+//extended XYZ files can have a periodic box (Lattice) for each frame.
 type XYZTraj struct {
 	natoms     int
 	xyz        *bufio.Reader //The DCD file
 	frames     int
-	xyzfile    *os.File
+	xyzfile    io.ReadCloser
 	readable   bool
 	firstframe *v3.Matrix
+	info       *xyzInfo //The information in the comment line of the last frame read.
+	firstinfo  *xyzInfo
 }
 
 func (X *XYZTraj) Readable() bool {
@@ -579,41 +815,66 @@ func (X *XYZTraj) xyztrajerror(err error) error {
 //Next reads the next snapshot of the trajectory into coords, or discards it, if coords
 //is nil
 func (X *XYZTraj) Next(coords *v3.Matrix) error {
-	if coords == nil {
-		_, _, _, err := xyzReadSnap(X.xyz, coords, false)
-		if err != nil {
-			//An error here probably means that there are no more snapshots
-			return X.xyztrajerror(err)
+	if X.frames == 0 && X.firstframe != nil {
+		//The first frame has already been read.
+		if coords != nil {
+			coords.Copy(X.firstframe) //slow, but I don't want to mess with the pointer I got.
 		}
 		X.frames++
-		return nil
-	}
-	if X.frames == 0 {
-		coords.Copy(X.firstframe) //slow, but I don't want to mess with the pointer I got.
-		X.frames++
 		X.firstframe = nil
+		X.info = X.firstinfo
 		return nil
 	}
-	_, _, _, err := xyzReadSnap(X.xyz, coords, false)
+	_, _, info, err := xyzReadSnap(X.xyz, coords, false)
 	if err != nil {
 		//An error here probably means that there are no more snapshots
 		return X.xyztrajerror(err)
 	}
-
+	X.info = info
 	X.frames++
-	return err
+	return nil
+}
+
+//Box returns the periodic box of the last frame read, or nil if there is none.
+func (X *XYZTraj) Box() *Box {
+	if X.info == nil {
+		return nil
+	}
+	return X.info.box
+}
+
+//Props returns the key=value pairs in the comment line of the last frame read, or nil if
+//it was not an extended XYZ frame.
+func (X *XYZTraj) Props() XYZProps {
+	if X.info == nil {
+		return nil
+	}
+	return X.info.props
+}
+
+//AtomProps returns the per-atom arrays of the last frame read, by name, or nil if
+//the frame had none.
+func (X *XYZTraj) AtomProps() map[string]*AtomProp {
+	if X.info == nil {
+		return nil
+	}
+	return X.info.atomprops
 }
 
 //Reads a multi-xyz file. Returns the first snapshot as a molecule, and the other ones as a XYZTraj
 func XYZFileAsTraj(xyzname string) (*Molecule, *XYZTraj, error) {
-	xyzfile, err := os.Open(xyzname)
+	xyzfile, err := OpenCompressed(xyzname)
 	if err != nil {
 		//fmt.Println("Unable to open file!!")
-		return nil, nil, CError{err.Error(), []string{"os.Open", "XYZFileRead"}}
+		return nil, nil, errDecorate(err, "XYZFileAsTraj")
 	}
 	xyz := bufio.NewReader(xyzfile)
 	//the molecule first
-	coords, atoms, _, err := xyzReadSnap(xyz, nil, true)
+	coords, atoms, info, err := xyzReadSnap(xyz, nil, true)
+	if err != nil {
+		xyzfile.Close()
+		return nil, nil, errDecorate(err, "XYZFileAsTraj")
+	}
 	top := NewTopology(0, 1, atoms)
 	bfactors := make([][]float64, 1, 1)
 	bfactors[0] = make([]float64, top.Len())
@@ -625,19 +886,20 @@ func XYZFileAsTraj(xyzname string) (*Molecule, *XYZTraj, error) {
 	traj.natoms = returned.Len()
 	traj.readable = true
 	traj.firstframe = coords
+	traj.firstinfo = info
 	return returned, traj, nil
 }
 
 //xyzReadSnap reads an xyz file snapshot from a bufio.Reader, returns a slice of Atom objects, which will be nil if ReadTopol is false,
-// a slice of matrix.DenseMatrix and an error or nil.
-func xyzReadSnap(xyz *bufio.Reader, toplace *v3.Matrix, ReadTopol bool) (*v3.Matrix, []*Atom, string, error) {
+// a slice of matrix.DenseMatrix, the information in the comment line (which can be in the extended XYZ format) and an error or nil.
+func xyzReadSnap(xyz *bufio.Reader, toplace *v3.Matrix, ReadTopol bool) (*v3.Matrix, []*Atom, *xyzInfo, error) {
 	line, err := xyz.ReadString('\n')
 	if err != nil {
-		return nil, nil, "", CError{fmt.Sprintf("Empty XYZ File: %s", err.Error()), []string{"bufio.Reader.ReadString", "xyzReadSnap"}}
+		return nil, nil, nil, CError{fmt.Sprintf("Empty XYZ File: %s", err.Error()), []string{"bufio.Reader.ReadString", "xyzReadSnap"}}
 	}
 	natoms, err := strconv.Atoi(strings.TrimSpace(line))
 	if err != nil {
-		return nil, nil, "", CError{fmt.Sprintf("Wrong header for an XYZ file %s", err.Error()), []string{"strconv.Atoi", "xyzReadSnap"}}
+		return nil, nil, nil, CError{fmt.Sprintf("Wrong header for an XYZ file %s", err.Error()), []string{"strconv.Atoi", "xyzReadSnap"}}
 	}
 	var molecule []*Atom
 	if ReadTopol {
@@ -651,54 +913,45 @@ func xyzReadSnap(xyz *bufio.Reader, toplace *v3.Matrix, ReadTopol bool) (*v3.Mat
 	}
 	data, err := xyz.ReadString('\n') //The text in "data" could be anything, including just "\n"
 	if err != nil {
-		return nil, nil, "", CError{fmt.Sprintf("Ill formatted XYZ file: %s", err.Error()), []string{"bufio.Reader.ReadString", "xyzReadSnap"}}
+		return nil, nil, nil, CError{fmt.Sprintf("Ill formatted XYZ file: %s", err.Error()), []string{"bufio.Reader.ReadString", "xyzReadSnap"}}
 
 	}
-	errs := make([]error, 3, 3)
+	info, err := parseXYZComment(data)
+	if err != nil {
+		return nil, nil, nil, errDecorate(err, "xyzReadSnap")
+	}
 	for i := 0; i < natoms; i++ {
-		line, errs[0] = xyz.ReadString('\n')
-		if errs[0] != nil { //inefficient, (errs[1] can be checked once before), but clearer.
-			if strings.Contains(errs[0].Error(), "EOF") && i == natoms-1 { //This allows that an XYZ ends without a newline
-				errs[0] = nil
-			} else {
-				break
+		line, err = xyz.ReadString('\n')
+		if err != nil {
+			if !strings.Contains(err.Error(), "EOF") || i != natoms-1 || line == "" { //This allows that an XYZ ends without a newline
+				return nil, nil, nil, CError{fmt.Sprintf("Ill formatted XYZ file: %s", err.Error()), []string{"bufio.Reader.ReadString", "xyzReadSnap"}}
 			}
 		}
-		fields := strings.Fields(line)
-		if len(fields) < 4 {
-			errs[0] = fmt.Errorf("Line number %d ill formed: %s", i, line)
-			break
-		}
+		var at *Atom
 		if ReadTopol {
-			molecule[i] = new(Atom)
-			molecule[i].Symbol = strings.Title(fields[0])
-			molecule[i].Mass = symbolMass[molecule[i].Symbol]
-			molecule[i].Molname = "UNK"
-			molecule[i].Name = molecule[i].Symbol
+			at = new(Atom)
+			molecule[i] = at
 		}
-		coords[i*3], errs[0] = strconv.ParseFloat(fields[1], 64)
-		coords[i*3+1], errs[1] = strconv.ParseFloat(fields[2], 64)
-		coords[i*3+2], errs[2] = strconv.ParseFloat(fields[3], 64)
-	}
-	//This could be done faster if done in the same loop where the coords are read
-	//Instead of having another loop just for them.
-	for _, i := range errs {
-		if i != nil {
-			//	fmt.Println("line", line, k)
-			return nil, nil, "", CError{i.Error(), []string{"strconv.ParseFloat", "xyzReadSnap"}}
+		if err := info.parseLine(strings.Fields(line), i, natoms, coords, at); err != nil {
+			return nil, nil, nil, CError{fmt.Sprintf("Line number %d ill formed: %s: %s", i, err.Error(), line), []string{"xyzReadSnap"}}
+		}
+		if ReadTopol {
+			at.Mass = symbolMass[at.Symbol]
+			at.Molname = "UNK"
+			at.Name = at.Symbol
 		}
 	}
 	//this should be fine even if I had a toplace matrix. Both toplace and mcoord should just point to the same data.
 	mcoords, err := v3.NewMatrix(coords)
-	return mcoords, molecule, data, errDecorate(err, "xyzReadSnap")
+	return mcoords, molecule, info, errDecorate(err, "xyzReadSnap")
 }
 
 //XYZWrite writes the mol Ref and the Coord coordinates in an XYZ file with name xyzname which will
 //be created fot that. If the file exist it will be overwritten.
 func XYZFileWrite(xyzname string, Coords *v3.Matrix, mol Atomer) error {
-	out, err := os.Create(xyzname)
+	out, err := CreateCompressed(xyzname)
 	if err != nil {
-		return CError{err.Error(), []string{"os.Create", "XYZFileWrite"}}
+		return errDecorate(err, "XYZFileWrite")
 	}
 	defer out.Close()
 	err = XYZWrite(out, Coords, mol)
@@ -728,14 +981,20 @@ func XYZStringWrite(Coords *v3.Matrix, mol Atomer) (string, error) {
 
 //XYZWrite writes the mol Ref and the Coord coordinates to a io.Writer.
 func XYZWrite(out io.Writer, Coords *v3.Matrix, mol Atomer) error {
+	return errDecorate(xyzWrite(out, Coords, mol, ""), "XYZWrite")
+}
+
+//xyzWrite writes the mol Ref and the Coord coordinates to a io.Writer, with
+//the given comment in the second line.
+func xyzWrite(out io.Writer, Coords *v3.Matrix, mol Atomer, comment string) error {
 	iowriterError := func(err error) error {
-		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Writer.Write", "XYZWrite"}}
+		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Writer.Write", "xyzWrite"}}
 	}
 	if mol.Len() != Coords.NVecs() {
-		return CError{"Ref and Coords dont have the same number of atoms", []string{"XYZWrite"}}
+		return CError{"Ref and Coords dont have the same number of atoms", []string{"xyzWrite"}}
 	}
 	c := make([]float64, 3, 3)
-	_, err := out.Write([]byte(fmt.Sprintf("%-4d\n\n", mol.Len())))
+	_, err := out.Write([]byte(fmt.Sprintf("%-4d\n%s\n", mol.Len(), comment)))
 	if err != nil {
 		return iowriterError(err)
 	}
@@ -754,35 +1013,46 @@ func XYZWrite(out io.Writer, Coords *v3.Matrix, mol Atomer) error {
 
 //GroFileRead reads a file in the Gromacs gro format, returning a molecule.
 func GroFileRead(groname string) (*Molecule, error) {
-	grofile, err := os.Open(groname)
+	grofile, err := OpenCompressed(groname)
 	if err != nil {
 		//fmt.Println("Unable to open file!!")
-		return nil, CError{err.Error(), []string{"os.Open", "GroFileRead"}}
+		return nil, errDecorate(err, "GroFileRead")
 	}
 	defer grofile.Close()
+	mol, err := GroRead(grofile)
+	if err != nil {
+		return nil, errDecorate(err, "GroFileRead")
+	}
+	return mol, nil
+}
+
+//GroRead reads data in the Gromacs gro format from an io.Reader, returning a molecule.
+func GroRead(r io.Reader) (*Molecule, error) {
+	var err error
 	snaps := 1
-	gro := bufio.NewReader(grofile)
+	gro := bufio.NewReader(r)
 	var top *Topology
 	var molecule []*Atom
 	Coords := make([]*v3.Matrix, 1, 1)
-
+	boxes := make([]*Box, 1, 1)
+	hasbox := false
 	for {
 		//When we read the first snapshot we collect also the topology data, later
 		//only coords are collected.
 		if snaps == 1 {
-			Coords[0], molecule, err = groReadSnap(gro, true)
+			Coords[0], molecule, boxes[0], err = groReadSnap(gro, true)
 			if err != nil {
-				return nil, errDecorate(err, "GroFileRead")
+				return nil, errDecorate(err, "GroRead")
 			}
 			top = NewTopology(0, 1, molecule)
 			if err != nil {
-				return nil, errDecorate(err, "GroFileRead")
+				return nil, errDecorate(err, "GroRead")
 			}
 			snaps++
 			continue
 		}
 		//fmt.Println("how manytimes?") /////////////////////
-		tmpcoords, _, err := groReadSnap(gro, false)
+		tmpcoords, _, tmpbox, err := groReadSnap(gro, false)
 		if err != nil {
 			break //We just ignore errors after the first snapshot, and simply read as many snapshots as we can.
 			/*
@@ -796,28 +1066,41 @@ func GroFileRead(groname string) (*Molecule, error) {
 			*/
 		}
 		Coords = append(Coords, tmpcoords)
+		boxes = append(boxes, tmpbox)
 	}
 	returned, err := NewMolecule(Coords, top, nil)
+	if err != nil {
+		return nil, errDecorate(err, "GroRead")
+	}
+	for _, v := range boxes {
+		if v != nil {
+			hasbox = true
+		}
+	}
+	if hasbox {
+		returned.Boxes = boxes
+	}
 	//	fmt.Println("2 return!", top.Atom(1), returned.Coords[0].VecView(2)) ///////////////////////
-	return returned, errDecorate(err, "GroRead")
+	return returned, nil
 }
 
-func groReadSnap(gro *bufio.Reader, ReadTopol bool) (*v3.Matrix, []*Atom, error) {
+func groReadSnap(gro *bufio.Reader, ReadTopol bool) (*v3.Matrix, []*Atom, *Box, error) {
 	nm2A := 10.0
 	chains := "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	line, err := gro.ReadString('\n') //we don't care about this line,but it has to be there
 	if err != nil {
-		return nil, nil, CError{fmt.Sprintf("Empty gro File: %s", err.Error()), []string{"bufio.Reader.ReadString", "groReadSnap"}}
+		return nil, nil, nil, CError{fmt.Sprintf("Empty gro File: %s", err.Error()), []string{"bufio.Reader.ReadString", "groReadSnap"}}
 	}
 	line, err = gro.ReadString('\n')
 	if err != nil {
-		return nil, nil, CError{fmt.Sprintf("Malformed gro File: %s", err.Error()), []string{"bufio.Reader.ReadString", "groReadSnap"}}
+		return nil, nil, nil, CError{fmt.Sprintf("Malformed gro File: %s", err.Error()), []string{"bufio.Reader.ReadString", "groReadSnap"}}
 	}
 
 	natoms, err := strconv.Atoi(strings.TrimSpace(line))
 	if err != nil {
-		return nil, nil, CError{fmt.Sprintf("Wrong header for a gro file %s", err.Error()), []string{"strconv.Atoi", "groReadSnap"}}
+		return nil, nil, nil, CError{fmt.Sprintf("Wrong header for a gro file %s", err.Error()), []string{"strconv.Atoi", "groReadSnap"}}
 	}
+	var boxline string
 	var molecule []*Atom
 	if ReadTopol {
 		molecule = make([]*Atom, 0, natoms)
@@ -828,16 +1111,17 @@ func groReadSnap(gro *bufio.Reader, ReadTopol bool) (*v3.Matrix, []*Atom, error)
 	for i := 0; i < natoms; i++ {
 		line, err = gro.ReadString('\n')
 		if err != nil {
-			return nil, nil, CError{fmt.Sprintf("Failure to read gro File: %s", err.Error()), []string{"bufio.Reader.ReadString", "groReadSnap"}}
+			return nil, nil, nil, CError{fmt.Sprintf("Failure to read gro File: %s", err.Error()), []string{"bufio.Reader.ReadString", "groReadSnap"}}
 		}
 		fields := strings.Fields(line)
 		if len(fields) < 4 {
+			boxline = line
 			break //meaning this line contains the unit cell vectors, and it is the last line of the snapshot
 		}
 		if ReadTopol {
 			atom, c, err := read_gro_line(line)
 			if err != nil {
-				return nil, nil, CError{fmt.Sprintf("Failure to read gro File: %s", err.Error()), []string{"bufio.Reader.ReadString", "groReadSnap"}}
+				return nil, nil, nil, CError{fmt.Sprintf("Failure to read gro File: %s", err.Error()), []string{"bufio.Reader.ReadString", "groReadSnap"}}
 			}
 			if atom.MolID < prevres {
 				chainindex++
@@ -857,16 +1141,79 @@ func groReadSnap(gro *bufio.Reader, ReadTopol bool) (*v3.Matrix, []*Atom, error)
 		for i := 0; i < 3; i++ {
 			c[i], err = strconv.ParseFloat(strings.TrimSpace(line[20+(i*8):28+(i*8)]), 64)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 			c[i] = c[i] * nm2A //gro uses nm, goChem uses A.
 		}
 		coords = append(coords, c...)
 
 	}
+	//The unit cell vectors are the last line of the snapshot. We tolerate its absence
+	//at the end of the file.
+	if boxline == "" {
+		boxline, err = gro.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, nil, CError{fmt.Sprintf("Failure to read gro File: %s", err.Error()), []string{"bufio.Reader.ReadString", "groReadSnap"}}
+		}
+	}
+	box, err := groParseBox(boxline)
+	if err != nil {
+		return nil, nil, nil, errDecorate(err, "groReadSnap")
+	}
 	mcoords, err := v3.NewMatrix(coords)
 	//	fmt.Println(molecule) //, mcoords) ////////////////////////
-	return mcoords, molecule, nil
+	return mcoords, molecule, box, nil
+}
+
+//groParseBox reads the unit cell vectors line of a gro snapshot and
+//returns the corresponding box, or nil if there is no box information.
+//The line has 3 values for rectangular boxes or 9 for triclinic ones, in nm, in the order
+//v1(x) v2(y) v3(z) v1(y) v1(z) v2(x) v2(z) v3(x) v3(y).
+func groParseBox(line string) (*Box, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if len(fields) != 3 && len(fields) != 9 {
+		return nil, CError{fmt.Sprintf("Malformed gro box line: %s", strings.TrimSpace(line)), []string{"groParseBox"}}
+	}
+	nm2A := 10.0
+	order := [][2]int{{0, 0}, {1, 1}, {2, 2}, {0, 1}, {0, 2}, {1, 0}, {1, 2}, {2, 0}, {2, 1}}
+	vecs := v3.Zeros(3)
+	empty := true
+	for i, v := range fields {
+		val, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, CError{fmt.Sprintf("Malformed gro box line: %s", err.Error()), []string{"strconv.ParseFloat", "groParseBox"}}
+		}
+		if val != 0 {
+			empty = false
+		}
+		vecs.Set(order[i][0], order[i][1], val*nm2A)
+	}
+	if empty {
+		return nil, nil //GROMACS and goChem write zeros if there is no box.
+	}
+	box, err := NewBox(vecs)
+	if err != nil {
+		return nil, errDecorate(err, "groParseBox")
+	}
+	return box, nil
+}
+
+//groBoxLine returns the unit cell vectors line for a gro snapshot with the
+//given box, which can be nil.
+func groBoxLine(box *Box) string {
+	if box == nil {
+		return fmt.Sprintf("%10.5f%10.5f%10.5f\n", 0.0, 0.0, 0.0)
+	}
+	A2nm := 0.1
+	b := box.vecs
+	if box.Orthorhombic() {
+		return fmt.Sprintf("%10.5f%10.5f%10.5f\n", b.At(0, 0)*A2nm, b.At(1, 1)*A2nm, b.At(2, 2)*A2nm)
+	}
+	return fmt.Sprintf("%10.5f%10.5f%10.5f%10.5f%10.5f%10.5f%10.5f%10.5f%10.5f\n", b.At(0, 0)*A2nm, b.At(1, 1)*A2nm, b.At(2, 2)*A2nm,
+		b.At(0, 1)*A2nm, b.At(0, 2)*A2nm, b.At(1, 0)*A2nm, b.At(1, 2)*A2nm, b.At(2, 0)*A2nm, b.At(2, 1)*A2nm)
 }
 
 //Parses a valid ATOM or HETATM line of a PDB file, returns an Atom
@@ -904,15 +1251,26 @@ func read_gro_line(line string) (*Atom, []float64, error) {
 //GoFileWrite writes the molecule described by mol and Coords into a file in the Gromacs
 //gro format. If Coords has more than one elements, it will write a multi-state file.
 func GroFileWrite(outname string, Coords []*v3.Matrix, mol Atomer) error {
-	out, err := os.Create(outname)
+	return errDecorate(GroFileBoxWrite(outname, Coords, nil, mol), "GroFileWrite")
+}
+
+//GroFileBoxWrite writes the molecule described by mol and Coords into a file in the Gromacs
+//gro format, with the periodic box for each snapshot taken from boxes. boxes can be nil, or shorter
+//than Coords, in which case the missing boxes are written as zeros.
+func GroFileBoxWrite(outname string, Coords []*v3.Matrix, boxes []*Box, mol Atomer) error {
+	out, err := CreateCompressed(outname)
 	if err != nil {
-		return CError{"Failed to write open file" + err.Error(), []string{"os.Create", "GroFileWrite"}}
+		return errDecorate(err, "GroFileBoxWrite")
 	}
 	defer out.Close()
-	for _, v := range Coords {
-		err := GroSnapWrite(v, mol, out)
+	for i, v := range Coords {
+		var box *Box
+		if i < len(boxes) {
+			box = boxes[i]
+		}
+		err := GroSnapBoxWrite(v, mol, box, out)
 		if err != nil {
-			return errDecorate(err, "GoFileWrite")
+			return errDecorate(err, "GroFileBoxWrite")
 		}
 	}
 	return nil
@@ -920,12 +1278,18 @@ func GroFileWrite(outname string, Coords []*v3.Matrix, mol Atomer) error {
 
 //GroSnapWrite writes a single snapshot of a molecule to an io.Writer
 func GroSnapWrite(coords *v3.Matrix, mol Atomer, out io.Writer) error {
+	return errDecorate(GroSnapBoxWrite(coords, mol, nil, out), "GroSnapWrite")
+}
+
+//GroSnapBoxWrite writes a single snapshot of a molecule, with the periodic
+//box box, to an io.Writer. If box is nil, the box is written as zeros.
+func GroSnapBoxWrite(coords *v3.Matrix, mol Atomer, box *Box, out io.Writer) error {
 	A2nm := 0.1
 	iowriterError := func(err error) error {
-		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Writer.Write", "GroSnapWrite"}}
+		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Writer.Write", "GroSnapBoxWrite"}}
 	}
 	if mol.Len() != coords.NVecs() {
-		return CError{"Ref and Coords dont have the same number of atoms", []string{"GroSnapWrite"}}
+		return CError{"Ref and Coords dont have the same number of atoms", []string{"GroSnapBoxWrite"}}
 	}
 	c := make([]float64, 3, 3)
 	_, err := out.Write([]byte(fmt.Sprintf("Written with goChem :-)\n%-4d\n", mol.Len())))
@@ -946,7 +1310,7 @@ func GroSnapWrite(coords *v3.Matrix, mol Atomer, out io.Writer) error {
 
 	}
 	//the box vectors at the end of the snappshot
-	_, err = out.Write([]byte("0.0 0.0 0.0\n"))
+	_, err = out.Write([]byte(groBoxLine(box)))
 	if err != nil {
 		return iowriterError(err)
 	}
@@ -0,0 +1,373 @@
+/*
+ * formats.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/***File format registry***/
+
+//FileFormat describes a file format, so ReadFile and OpenTraj can handle it. Packages that implement
+//new formats can make them available to those functions by calling RegisterFormat, usually in an init function.
+//The trajectory formats in goChem's subpackages (xtc, trr, dcd and amber) are registered when the corresponding
+//package is imported, so a program that only needs OpenTraj can import them with a blank identifier.
+type FileFormat struct {
+	Name       string                                      //A short name for the format, such as "pdb". It must be unique.
+	Extensions []string                                    //The file extensions for the format, without the dot, such as "pdb" and "ent".
+	Magic      func(header []byte) bool                    //Returns true if the beginning of a file is in this format. It can be nil.
+	Read       func(r io.Reader) (*Molecule, error)        //Reads a structure. It can be nil if the format is a trajectory format.
+	OpenTraj   func(name string, top Atomer) (Traj, error) //Opens a trajectory file. top can be nil. It can be nil if the format can only be read with Read.
+	Streams    bool                                        //OpenTraj reads compressed files by itself, so they don't need to be uncompressed to a temporary file.
+}
+
+//Compression describes a compression format for ReadFile, OpenTraj, OpenCompressed and CreateCompressed.
+//gzip, bzip2, xz and zstd are supported by default. All but bzip2 can also be written.
+type Compression struct {
+	Name       string                                    //A short name for the compression format, such as "gzip".
+	Extensions []string                                  //The file extensions for the format, without the dot, such as "gz".
+	Magic      []byte                                    //The bytes at the beginning of the compressed files.
+	NewReader  func(r io.Reader) (io.ReadCloser, error)  //Returns a reader for the uncompressed data in r.
+	NewWriter  func(w io.Writer) (io.WriteCloser, error) //Returns a writer that compresses to w. It can be nil if the format can't be written.
+}
+
+var registry struct {
+	sync.RWMutex
+	formats      []*FileFormat
+	compressions []*Compression
+}
+
+//RegisterFormat makes the format f available to ReadFile and OpenTraj. If an extension has been registered before for
+//another format, the last registration is used. It panics if the format has no name or no reader, or if there
+//is already a format with the same name.
+func RegisterFormat(f *FileFormat) {
+	if f == nil || f.Name == "" || (f.Read == nil && f.OpenTraj == nil) {
+		panic(PanicMsg("goChem: Formats must have a name and a reader"))
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	for _, v := range registry.formats {
+		if v.Name == f.Name {
+			panic(PanicMsg(fmt.Sprintf("goChem: Format %s registered twice", f.Name)))
+		}
+	}
+	registry.formats = append(registry.formats, f)
+}
+
+//RegisterCompression makes the compression format c available to ReadFile and OpenTraj. If a format with the same
+//name has been registered before, it is replaced. It panics if the format has no name, magic bytes or reader.
+func RegisterCompression(c *Compression) {
+	if c == nil || c.Name == "" || len(c.Magic) == 0 || c.NewReader == nil {
+		panic(PanicMsg("goChem: Compression formats must have a name, magic bytes and a reader"))
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	for i, v := range registry.compressions {
+		if v.Name == c.Name {
+			registry.compressions[i] = c
+			return
+		}
+	}
+	registry.compressions = append(registry.compressions, c)
+}
+
+//Formats returns the names of the registered formats.
+func Formats() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	names := make([]string, 0, len(registry.formats))
+	for _, v := range registry.formats {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+//headerSize is the number of bytes read from the beginning of a file to detect its format.
+const headerSize = 1024
+
+//detectFormat returns the format and the compression (nil if the file is not compressed) of the file name.
+//The compression is detected from the content of the file. The format is detected from the extension (ignoring
+//the extension of the compression format, if present) or, if there is no registered format for it, from the content.
+func detectFormat(name string) (*FileFormat, *Compression, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, CError{err.Error(), []string{"os.Open", "detectFormat"}}
+	}
+	defer f.Close()
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, nil, CError{err.Error(), []string{"io.ReadFull", "detectFormat"}}
+	}
+	header = header[:n]
+	registry.RLock()
+	defer registry.RUnlock()
+	base := strings.ToLower(filepath.Base(name))
+	comp := magicCompression(header)
+	if comp != nil {
+		for _, e := range comp.Extensions {
+			base = strings.TrimSuffix(base, "."+e)
+		}
+		//We need the beginning of the uncompressed data
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, CError{err.Error(), []string{"os.File.Seek", "detectFormat"}}
+		}
+		r, err := comp.NewReader(f)
+		if err != nil {
+			return nil, nil, CError{fmt.Sprintf("Can't read %s file: %s", comp.Name, err.Error()), []string{"Compression.NewReader", "detectFormat"}}
+		}
+		defer r.Close()
+		header = make([]byte, headerSize)
+		n, err = io.ReadFull(r, header)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, nil, CError{fmt.Sprintf("Can't read %s file: %s", comp.Name, err.Error()), []string{"io.ReadFull", "detectFormat"}}
+		}
+		header = header[:n]
+	}
+	if ext := strings.TrimPrefix(filepath.Ext(base), "."); ext != "" {
+		for i := len(registry.formats) - 1; i >= 0; i-- {
+			for _, e := range registry.formats[i].Extensions {
+				if e == ext {
+					return registry.formats[i], comp, nil
+				}
+			}
+		}
+	}
+	for _, v := range registry.formats {
+		if v.Magic != nil && v.Magic(header) {
+			return v, comp, nil
+		}
+	}
+	return nil, nil, CError{fmt.Sprintf("Unknown format for file %s", name), []string{"detectFormat"}}
+}
+
+//DetectFormat returns the name of the registered format of the file name, and the name of its
+//compression format, or an empty string if the file is not compressed.
+func DetectFormat(name string) (string, string, error) {
+	f, c, err := detectFormat(name)
+	if err != nil {
+		return "", "", errDecorate(err, "DetectFormat")
+	}
+	if c == nil {
+		return f.Name, "", nil
+	}
+	return f.Name, c.Name, nil
+}
+
+//ReadFile reads a structure from the file name, which can be in any registered format, and can be compressed.
+//The format is detected from the extension of the file or, if that fails, from its contents.
+func ReadFile(name string) (*Molecule, error) {
+	f, _, err := detectFormat(name)
+	if err != nil {
+		return nil, errDecorate(err, "ReadFile")
+	}
+	if f.Read == nil {
+		return nil, CError{fmt.Sprintf("Format %s can only be read as a trajectory", f.Name), []string{"ReadFile"}}
+	}
+	r, err := OpenCompressed(name)
+	if err != nil {
+		return nil, errDecorate(err, "ReadFile")
+	}
+	defer r.Close()
+	mol, err := f.Read(bufio.NewReader(r))
+	if err != nil {
+		return nil, errDecorate(err, "ReadFile")
+	}
+	return mol, nil
+}
+
+//OpenTraj opens the trajectory file name, which can be in any registered format, and can be compressed.
+//The format is detected as in ReadFile. Some formats need the topology top, for instance, to know the number of atoms,
+//otherwise, it can be nil. If top is not nil, its number of atoms is checked against the trajectory.
+//Files in formats without a trajectory reader are read completely, and returned as a *Molecule.
+//Compressed files in trajectory formats that can be read sequentially (xyz, AMBER mdcrd, XTC and TRR, for instance)
+//are uncompressed as they are read. For other formats, they are uncompressed to a temporary file, which is deleted
+//once the trajectory is open. On systems that don't allow deleting open files, the temporary file is left behind.
+func OpenTraj(name string, top Atomer) (Traj, error) {
+	f, comp, err := detectFormat(name)
+	if err != nil {
+		return nil, errDecorate(err, "OpenTraj")
+	}
+	var traj Traj
+	switch {
+	case f.OpenTraj == nil:
+		mol, err := ReadFile(name)
+		if err != nil {
+			return nil, errDecorate(err, "OpenTraj")
+		}
+		traj = mol
+	case comp == nil || f.Streams:
+		traj, err = f.OpenTraj(name, top)
+		if err != nil {
+			return nil, errDecorate(err, "OpenTraj")
+		}
+	default:
+		tmp, err := decompressTemp(name, comp)
+		if err != nil {
+			return nil, errDecorate(err, "OpenTraj")
+		}
+		traj, err = f.OpenTraj(tmp, top)
+		os.Remove(tmp)
+		if err != nil {
+			return nil, errDecorate(err, "OpenTraj")
+		}
+	}
+	if top != nil && top.Len() != traj.Len() {
+		return nil, CError{fmt.Sprintf("The trajectory has %d atoms, the topology %d", traj.Len(), top.Len()), []string{"OpenTraj"}}
+	}
+	return traj, nil
+}
+
+//decompressTemp uncompresses the file name to a temporary file, with the same extension
+//as name without the compression extension, and returns the name of the temporary file.
+func decompressTemp(name string, comp *Compression) (string, error) {
+	r, err := OpenCompressed(name)
+	if err != nil {
+		return "", errDecorate(err, "decompressTemp")
+	}
+	defer r.Close()
+	base := filepath.Base(name)
+	for _, e := range comp.Extensions {
+		if strings.HasSuffix(strings.ToLower(base), "."+e) {
+			base = base[:len(base)-len(e)-1]
+		}
+	}
+	tmp, err := ioutil.TempFile("", "gochem*"+filepath.Ext(base))
+	if err != nil {
+		return "", CError{err.Error(), []string{"ioutil.TempFile", "decompressTemp"}}
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", CError{fmt.Sprintf("Can't read %s file: %s", comp.Name, err.Error()), []string{"io.Copy", "decompressTemp"}}
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", CError{err.Error(), []string{"os.File.Close", "decompressTemp"}}
+	}
+	return tmp.Name(), nil
+}
+
+//headerLines returns the complete lines in header. The last one is dropped, as it could be incomplete,
+//unless header has only one line.
+func headerLines(header []byte) []string {
+	lines := strings.Split(strings.Replace(string(header), "\r\n", "\n", -1), "\n")
+	if len(lines) > 1 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func pdbMagic(header []byte) bool {
+	for _, l := range headerLines(header) {
+		for _, r := range []string{"HEADER", "ATOM  ", "HETATM", "CRYST1", "MODEL "} {
+			if strings.HasPrefix(l, r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func xyzMagic(header []byte) bool {
+	lines := headerLines(header)
+	if len(lines) < 3 {
+		return false
+	}
+	if _, err := strconv.Atoi(strings.TrimSpace(lines[0])); err != nil {
+		return false
+	}
+	f := strings.Fields(lines[2])
+	if len(f) < 4 {
+		return false
+	}
+	for _, v := range f[1:4] {
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func groMagic(header []byte) bool {
+	lines := headerLines(header)
+	if len(lines) < 3 {
+		return false
+	}
+	if _, err := strconv.Atoi(strings.TrimSpace(lines[1])); err != nil {
+		return false
+	}
+	//The atom lines have fixed columns, with the coordinates starting at column 21.
+	return len(lines[2]) >= 44 && len(strings.Fields(lines[2][20:])) >= 3
+}
+
+func cifMagic(header []byte) bool {
+	for _, l := range headerLines(header) {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "#") {
+			return strings.HasPrefix(l, "data_")
+		}
+	}
+	return false
+}
+
+func mol2Magic(header []byte) bool {
+	return bytes.Contains(header, []byte("@<TRIPOS>"))
+}
+
+func sdfMagic(header []byte) bool {
+	lines := headerLines(header)
+	return len(lines) >= 4 && (strings.Contains(lines[3], "V2000") || strings.Contains(lines[3], "V3000"))
+}
+
+func init() {
+	RegisterFormat(&FileFormat{Name: "pdb", Extensions: []string{"pdb", "ent"}, Magic: pdbMagic,
+		Read: func(r io.Reader) (*Molecule, error) { return PDBRead(r, true) }})
+	RegisterFormat(&FileFormat{Name: "cif", Extensions: []string{"cif", "mmcif"}, Magic: cifMagic, Read: CIFRead})
+	RegisterFormat(&FileFormat{Name: "mol2", Extensions: []string{"mol2"}, Magic: mol2Magic, Read: Mol2Read})
+	RegisterFormat(&FileFormat{Name: "sdf", Extensions: []string{"sdf", "sd", "mol"}, Magic: sdfMagic, Read: SDFRead})
+	RegisterFormat(&FileFormat{Name: "gro", Extensions: []string{"gro"}, Magic: groMagic, Read: GroRead})
+	RegisterFormat(&FileFormat{Name: "xyz", Extensions: []string{"xyz", "extxyz"}, Magic: xyzMagic, Read: XYZRead, Streams: true,
+		OpenTraj: func(name string, top Atomer) (Traj, error) {
+			_, traj, err := XYZFileAsTraj(name)
+			if err != nil {
+				return nil, err
+			}
+			return traj, nil
+		}})
+}
+
+/***End of file format registry***/
@@ -0,0 +1,148 @@
+/*
+ * formats_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+const testFormatXYZ = `2
+two frames
+He 0.0 0.0 0.0
+He 0.0 0.0 3.0
+2
+
+He 0.0 0.0 0.0
+He 0.0 0.0 3.5
+`
+
+const testFormatPDB = `CRYST1   20.000   20.000   20.000  90.00  90.00  90.00 P 1           1
+ATOM      1  O   HOH A   1       0.000   0.000   0.000  1.00  0.00           O
+ATOM      2  H1  HOH A   1       0.957   0.000   0.000  1.00  0.00           H
+END
+`
+
+func TestReadFile(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "gochem")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string, compress bool) string {
+		name = filepath.Join(dir, name)
+		data := []byte(content)
+		if compress {
+			var b bytes.Buffer
+			w := gzip.NewWriter(&b)
+			w.Write(data)
+			w.Close()
+			data = b.Bytes()
+		}
+		if err := ioutil.WriteFile(name, data, 0644); err != nil {
+			Te.Fatal(err)
+		}
+		return name
+	}
+	tests := []struct {
+		name, content string
+		compress      bool
+		format        string
+		natoms        int
+	}{
+		{"water.pdb", testFormatPDB, false, "pdb", 2},
+		{"water.pdb.gz", testFormatPDB, true, "pdb", 2},
+		{"water", testFormatPDB, true, "pdb", 2}, //detected from the content
+		{"he.xyz", testFormatXYZ, false, "xyz", 2},
+		{"he_noext", testFormatXYZ, false, "xyz", 2},
+	}
+	for _, t := range tests {
+		name := write(t.name, t.content, t.compress)
+		format, comp, err := DetectFormat(name)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		if format != t.format || (comp == "gzip") != t.compress {
+			Te.Errorf("%s detected as %s, compression %q", t.name, format, comp)
+		}
+		mol, err := ReadFile(name)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		if mol.Len() != t.natoms {
+			Te.Errorf("%s: read %d atoms, expected %d", t.name, mol.Len(), t.natoms)
+		}
+	}
+	//Trajectories, compressed or not.
+	for _, n := range []string{"traj.xyz", "traj.xyz.gz"} {
+		traj, err := OpenTraj(write(n, testFormatXYZ, strings.HasSuffix(n, ".gz")), nil)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		c := v3.Zeros(2)
+		frames := 0
+		for ; ; frames++ {
+			if err := traj.Next(c); err != nil {
+				if _, ok := err.(LastFrameError); !ok {
+					Te.Fatal(err)
+				}
+				break
+			}
+		}
+		if frames != 2 || c.At(1, 2) != 3.5 {
+			Te.Errorf("%s: read %d frames, last: %v", n, frames, c)
+		}
+	}
+	if _, err := OpenTraj(filepath.Join(dir, "he.xyz"), NewTopology(0, 1, []*Atom{{Symbol: "He"}})); err == nil {
+		Te.Errorf("A topology with the wrong number of atoms was accepted")
+	}
+	if _, err := ReadFile(write("unknown.foo", "nothing to see here\n", false)); err == nil {
+		Te.Errorf("A file with an unknown format was read")
+	}
+	if _, err := ReadFile(write("comp.xz", "\xfd7zXZ\x00 not really xz", false)); err == nil || !strings.Contains(err.Error(), "xz") {
+		Te.Errorf("Expected an error for a corrupt compressed file, got %v", err)
+	}
+	//A format registered by a third party.
+	RegisterFormat(&FileFormat{Name: "test-single-atom", Extensions: []string{"tsa"},
+		Read: func(r io.Reader) (*Molecule, error) {
+			top := NewTopology(0, 1, []*Atom{{Symbol: "Ar", Name: "AR"}})
+			return NewMolecule([]*v3.Matrix{v3.Zeros(1)}, top, nil)
+		}})
+	mol, err := ReadFile(write("argon.tsa", "", false))
+	if err != nil || mol.Atom(0).Symbol != "Ar" {
+		Te.Errorf("The registered format was not used: %v", err)
+	}
+}
@@ -0,0 +1,450 @@
+/*
+ * gmxtop.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/***GROMACS topology part***/
+
+//GmxTopOptions contains the options for reading GROMACS topologies.
+type GmxTopOptions struct {
+	IncludePath []string //Directories where included files are searched, after the directory of the including file.
+	Defines     []string //Symbols defined before reading, as with the "define" option of grompp (without the -D).
+}
+
+//The maximum depth of nested #include directives.
+const gmxMaxInclude = 32
+
+type gmxAtomType struct {
+	mass   float64
+	charge float64
+	z      int
+}
+
+type gmxMolType struct {
+	atoms []*Atom
+	bonds [][2]int
+}
+
+type gmxMolecules struct {
+	name string
+	n    int
+}
+
+//gmxCond is an #ifdef/#ifndef block. The block is being read if both parent and cond are true.
+type gmxCond struct {
+	parent bool
+	cond   bool
+}
+
+type gmxTopParser struct {
+	opts      *GmxTopOptions
+	defines   map[string]string
+	types     map[string]gmxAtomType
+	moltypes  map[string]*gmxMolType
+	order     []string //the moleculetypes, in the order they were defined.
+	molecules []gmxMolecules
+	cur       *gmxMolType
+	section   string
+	conds     []gmxCond
+	depth     int
+}
+
+//GmxTopFileRead reads a GROMACS topology (.top or .itp) file, and returns a Topology with one
+//atom for each atom in the system, in the order given by the [ molecules ] section, so it matches
+//the .gro or .xtc files for the same system. The atoms contain the charges, masses, atom types and
+//residue information from the topology, and are bonded as given by the [ bonds ] and [ settles ] sections
+//(the bonds have order 0 and distance 0, as the topology has no coordinates). #include directives are
+//resolved against the directory of the including file, the directories in opts.IncludePath, and
+//those in the GMXLIB environment variable, in that order. opts can be nil.
+//If the file has no [ molecules ] section, as with most .itp files, one copy of each moleculetype is returned.
+//The residue numbers of the first copy of each moleculetype are the ones in the topology, while for each
+//further copy they are shifted by the number of residues in the moleculetype.
+func GmxTopFileRead(name string, opts *GmxTopOptions) (*Topology, error) {
+	f, err := OpenCompressed(name)
+	if err != nil {
+		return nil, errDecorate(err, "GmxTopFileRead")
+	}
+	defer f.Close()
+	top, err := GmxTopRead(f, filepath.Dir(name), opts)
+	return top, errDecorate(err, "GmxTopFileRead")
+}
+
+//GmxTopRead reads a GROMACS topology from r. Relative #include directives are first searched in dir.
+//See GmxTopFileRead.
+func GmxTopRead(r io.Reader, dir string, opts *GmxTopOptions) (*Topology, error) {
+	if opts == nil {
+		opts = &GmxTopOptions{}
+	}
+	p := &gmxTopParser{opts: opts, defines: make(map[string]string), types: make(map[string]gmxAtomType), moltypes: make(map[string]*gmxMolType)}
+	for _, v := range opts.Defines {
+		name := strings.TrimPrefix(v, "-D")
+		value := ""
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+		p.defines[name] = value
+	}
+	if err := p.parse(r, "topology", dir); err != nil {
+		return nil, errDecorate(err, "GmxTopRead")
+	}
+	if len(p.conds) != 0 {
+		return nil, CError{"Unterminated #ifdef or #ifndef block", []string{"GmxTopRead"}}
+	}
+	top, err := p.topology()
+	if err != nil {
+		return nil, errDecorate(err, "GmxTopRead")
+	}
+	return top, nil
+}
+
+//active returns true if the lines read are not excluded by an #ifdef or #ifndef directive.
+func (p *gmxTopParser) active() bool {
+	if len(p.conds) == 0 {
+		return true
+	}
+	c := p.conds[len(p.conds)-1]
+	return c.parent && c.cond
+}
+
+//parse reads the topology from r, which comes from the file name in the directory dir.
+func (p *gmxTopParser) parse(r io.Reader, name, dir string) error {
+	in := bufio.NewReader(r)
+	lineno := 0
+	full := ""
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err == io.EOF {
+				break
+			}
+			return CError{err.Error(), []string{"bufio.Reader.ReadString", "gmxTopParser.parse"}}
+		}
+		lineno++
+		if i := strings.Index(line, ";"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, "\\") {
+			full += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		line = strings.TrimSpace(full + line)
+		full = ""
+		if line == "" {
+			continue
+		}
+		if err := p.parseLine(line, dir); err != nil {
+			return CError{fmt.Sprintf("%s, line %d: %s", name, lineno, err.Error()), []string{"gmxTopParser.parse"}}
+		}
+	}
+	return nil
+}
+
+func (p *gmxTopParser) parseLine(line, dir string) error {
+	if line[0] == '#' {
+		return p.directive(line, dir)
+	}
+	if !p.active() {
+		return nil
+	}
+	if line[0] == '[' {
+		p.section = strings.ToLower(strings.TrimSpace(strings.Trim(line, "[]")))
+		if p.section == "moleculetype" {
+			p.cur = nil
+		}
+		return nil
+	}
+	f := strings.Fields(line)
+	switch p.section {
+	case "atomtypes":
+		return p.atomType(f)
+	case "moleculetype":
+		key := strings.ToLower(f[0])
+		if _, ok := p.moltypes[key]; ok {
+			return fmt.Errorf("moleculetype %s defined twice", f[0])
+		}
+		p.cur = &gmxMolType{}
+		p.moltypes[key] = p.cur
+		p.order = append(p.order, key)
+	case "atoms":
+		return p.atom(f)
+	case "bonds", "settles":
+		if p.cur == nil {
+			return fmt.Errorf("[ %s ] outside of a moleculetype", p.section)
+		}
+		nat := len(p.cur.atoms)
+		i, err := strconv.Atoi(f[0])
+		if err != nil || i < 1 || i > nat {
+			return fmt.Errorf("Wrong atom in [ %s ]: %s", p.section, f[0])
+		}
+		if p.section == "settles" {
+			if i+2 > nat {
+				return fmt.Errorf("Wrong atom in [ settles ]: %s", f[0])
+			}
+			p.cur.bonds = append(p.cur.bonds, [2]int{i - 1, i}, [2]int{i - 1, i + 1})
+			return nil
+		}
+		if len(f) < 2 {
+			return fmt.Errorf("Wrong line in [ bonds ]: %s", line)
+		}
+		j, err := strconv.Atoi(f[1])
+		if err != nil || j < 1 || j > nat {
+			return fmt.Errorf("Wrong atom in [ bonds ]: %s", f[1])
+		}
+		p.cur.bonds = append(p.cur.bonds, [2]int{i - 1, j - 1})
+	case "molecules":
+		if len(f) < 2 {
+			return fmt.Errorf("Wrong line in [ molecules ]: %s", line)
+		}
+		n, err := strconv.Atoi(f[1])
+		if err != nil || n < 0 {
+			return fmt.Errorf("Wrong number of molecules: %s", f[1])
+		}
+		p.molecules = append(p.molecules, gmxMolecules{f[0], n})
+	}
+	return nil
+}
+
+//directive processes a preprocessor line.
+func (p *gmxTopParser) directive(line, dir string) error {
+	f := strings.Fields(strings.TrimSpace(line[1:]))
+	if len(f) == 0 {
+		return nil
+	}
+	switch f[0] {
+	case "ifdef", "ifndef":
+		if len(f) < 2 {
+			return fmt.Errorf("#%s without a symbol", f[0])
+		}
+		_, ok := p.defines[f[1]]
+		p.conds = append(p.conds, gmxCond{parent: p.active(), cond: ok == (f[0] == "ifdef")})
+		return nil
+	case "else":
+		if len(p.conds) == 0 {
+			return fmt.Errorf("#else without #ifdef")
+		}
+		p.conds[len(p.conds)-1].cond = !p.conds[len(p.conds)-1].cond
+		return nil
+	case "endif":
+		if len(p.conds) == 0 {
+			return fmt.Errorf("#endif without #ifdef")
+		}
+		p.conds = p.conds[:len(p.conds)-1]
+		return nil
+	}
+	if !p.active() {
+		return nil
+	}
+	switch f[0] {
+	case "define":
+		if len(f) < 2 {
+			return fmt.Errorf("#define without a symbol")
+		}
+		p.defines[f[1]] = strings.Join(f[2:], " ")
+	case "undef":
+		if len(f) > 1 {
+			delete(p.defines, f[1])
+		}
+	case "include":
+		if len(f) < 2 {
+			return fmt.Errorf("#include without a file")
+		}
+		return p.include(strings.Trim(f[1], "\"<>"), dir)
+	}
+	return nil
+}
+
+//include finds and reads an included file.
+func (p *gmxTopParser) include(name, dir string) error {
+	if p.depth >= gmxMaxInclude {
+		return fmt.Errorf("Too many nested #include directives")
+	}
+	var path string
+	if filepath.IsAbs(name) {
+		path = name
+	} else {
+		dirs := append([]string{dir}, p.opts.IncludePath...)
+		if gmxlib := os.Getenv("GMXLIB"); gmxlib != "" {
+			dirs = append(dirs, filepath.SplitList(gmxlib)...)
+		}
+		for _, d := range dirs {
+			if _, err := os.Stat(filepath.Join(d, name)); err == nil {
+				path = filepath.Join(d, name)
+				break
+			}
+		}
+	}
+	if path == "" {
+		return fmt.Errorf("Included file %s not found", name)
+	}
+	f, err := OpenCompressed(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	p.depth++
+	err = p.parse(f, path, filepath.Dir(path))
+	p.depth--
+	return err
+}
+
+//atomType reads a line of the [ atomtypes ] section. The line can contain, before the mass, a bonded type, an
+//atomic number, or both. The position of the particle type field, a single letter, tells which is the case.
+func (p *gmxTopParser) atomType(f []string) error {
+	pt := -1
+	for i := 3; i < len(f) && i < 6; i++ {
+		if len(f[i]) == 1 && strings.Contains("ASVD", f[i]) {
+			pt = i
+			break
+		}
+	}
+	if pt < 0 {
+		return fmt.Errorf("Can't find the particle type in [ atomtypes ]")
+	}
+	var t gmxAtomType
+	var err error
+	if t.mass, err = strconv.ParseFloat(f[pt-2], 64); err != nil {
+		return fmt.Errorf("Wrong mass in [ atomtypes ]: %s", f[pt-2])
+	}
+	if t.charge, err = strconv.ParseFloat(f[pt-1], 64); err != nil {
+		return fmt.Errorf("Wrong charge in [ atomtypes ]: %s", f[pt-1])
+	}
+	if pt > 3 {
+		t.z, _ = strconv.Atoi(f[pt-3]) //if it is not a number, it is a bonded type.
+	}
+	p.types[f[0]] = t
+	return nil
+}
+
+//atom reads a line of the [ atoms ] section: number, type, residue number, residue name, atom name, charge group
+//and, optionally, charge and mass. Missing charges and masses are taken from the atom type.
+func (p *gmxTopParser) atom(f []string) error {
+	if p.cur == nil {
+		return fmt.Errorf("[ atoms ] outside of a moleculetype")
+	}
+	if len(f) < 5 {
+		return fmt.Errorf("Wrong line in [ atoms ]: %s", strings.Join(f, " "))
+	}
+	nr, err := strconv.Atoi(f[0])
+	if err != nil || nr != len(p.cur.atoms)+1 {
+		return fmt.Errorf("Atoms in [ atoms ] must be numbered consecutively from 1: %s", f[0])
+	}
+	at := &Atom{Type: f[1], Molname: f[3], Name: f[4]}
+	if at.MolID, err = strconv.Atoi(strings.TrimRight(f[2], "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz")); err != nil {
+		return fmt.Errorf("Wrong residue number in [ atoms ]: %s", f[2])
+	}
+	at.Molname1 = three2OneLetter[at.Molname]
+	t, known := p.types[at.Type]
+	at.Charge, at.Mass = t.charge, t.mass
+	if len(f) > 6 {
+		if at.Charge, err = strconv.ParseFloat(f[6], 64); err != nil {
+			return fmt.Errorf("Wrong charge in [ atoms ]: %s", f[6])
+		}
+	}
+	if len(f) > 7 {
+		if at.Mass, err = strconv.ParseFloat(f[7], 64); err != nil {
+			return fmt.Errorf("Wrong mass in [ atoms ]: %s", f[7])
+		}
+	}
+	switch {
+	case t.z > 0 && t.z < len(zSymbol):
+		at.Symbol = zSymbol[t.z]
+	default:
+		at.Symbol, _ = symbolFromName(strings.ToUpper(at.Name))
+	}
+	if !known && len(f) < 8 {
+		at.Mass = symbolMass[at.Symbol]
+	}
+	p.cur.atoms = append(p.cur.atoms, at)
+	return nil
+}
+
+//topology builds the topology of the system from the moleculetypes and the [ molecules ] section.
+func (p *gmxTopParser) topology() (*Topology, error) {
+	molecules := p.molecules
+	if molecules == nil {
+		for _, v := range p.order {
+			molecules = append(molecules, gmxMolecules{v, 1})
+		}
+	}
+	var atoms []*Atom
+	nbonds := 0
+	var charge float64
+	for _, m := range molecules {
+		mt, ok := p.moltypes[strings.ToLower(m.name)]
+		if !ok {
+			return nil, CError{fmt.Sprintf("moleculetype %s not found", m.name), []string{"gmxTopParser.topology"}}
+		}
+		if len(mt.atoms) == 0 {
+			continue
+		}
+		minres, maxres := mt.atoms[0].MolID, mt.atoms[0].MolID
+		for _, at := range mt.atoms {
+			if at.MolID < minres {
+				minres = at.MolID
+			}
+			if at.MolID > maxres {
+				maxres = at.MolID
+			}
+		}
+		nres := maxres - minres + 1
+		for k := 0; k < m.n; k++ {
+			first := len(atoms)
+			for _, v := range mt.atoms {
+				at := new(Atom)
+				at.Copy(v)
+				at.ID = len(atoms) + 1
+				at.MolID += k * nres
+				atoms = append(atoms, at)
+				charge += at.Charge
+			}
+			for _, b := range mt.bonds {
+				at1, at2 := atoms[first+b[0]], atoms[first+b[1]]
+				bond := &Bond{Index: nbonds, At1: at1, At2: at2}
+				at1.Bonds = append(at1.Bonds, bond)
+				at2.Bonds = append(at2.Bonds, bond)
+				nbonds++
+			}
+		}
+	}
+	top := NewTopology(int(math.Round(charge)), 1, atoms)
+	top.FillIndexes()
+	return top, nil
+}
+
+/***End of GROMACS topology part***/
@@ -0,0 +1,166 @@
+/*
+ * gmxtop_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chem
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testGmxFiles = map[string]string{
+	"system/topol.top": `; A test system
+#include "forcefield.itp"
+#include "lig.itp"
+
+#ifdef FLEXIBLE
+#include "flexwater.itp"
+#else
+#include "water.itp"
+#endif
+
+[ system ]
+Ligand in water
+
+[ molecules ]
+; name  number
+LIG     1
+SOL     3
+`,
+	"system/lig.itp": `[ moleculetype ]
+; name  nrexcl
+LIG     3
+
+[ atoms ]
+;  nr  type  resnr  res  atom  cgnr  charge   mass
+    1  CT    1      LIG  C1    1     -0.180  12.011
+    2  HC    1      LIG  H11   1      0.060
+    3  HC    1      LIG  H12   1      0.060
+    4  HC    1      LIG  H13   1      0.060
+    5  CL    1      LIG  CL    2      0.000
+
+[ bonds ]
+    1  2  1
+    1  3  1
+    1  4  1
+    1  5  1 \
+           ; a continued line
+
+[ pairs ]
+    2  5  1
+`,
+	"lib/forcefield.itp": `[ defaults ]
+1  2  yes  0.5  0.8333
+
+[ atomtypes ]
+; name  at.num  mass     charge  ptype  sigma  epsilon
+CT      6       12.0110  0.000   A      0.339  0.457
+HC      1       1.0080   0.000   A      0.264  0.065
+CL      17      35.4500  0.000   A      0.347  1.109
+OW   OW 8       15.9994  0.000   A      0.315  0.636
+HW      HW      1.0080   0.000   A      0.000  0.000
+`,
+	"lib/water.itp": `[ moleculetype ]
+SOL  2
+
+[ atoms ]
+  1  OW  1  SOL  OW   1  -0.834
+  2  HW  1  SOL  HW1  1   0.417
+  3  HW  1  SOL  HW2  1   0.417
+
+#ifndef FLEXIBLE
+[ settles ]
+  1  1  0.09572  0.15139
+#endif
+
+[ exclusions ]
+1  2  3
+`,
+}
+
+func TestGmxTop(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "gochemtop")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range testGmxFiles {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			Te.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	topname := filepath.Join(dir, "system", "topol.top")
+	if _, err := GmxTopFileRead(topname, nil); err == nil {
+		Te.Errorf("The topology was read without the include path")
+	}
+	opts := &GmxTopOptions{IncludePath: []string{filepath.Join(dir, "lib")}}
+	top, err := GmxTopFileRead(topname, opts)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if top.Len() != 14 || top.Charge() != 0 {
+		Te.Fatalf("Read %d atoms with total charge %d, expected 14 and 0", top.Len(), top.Charge())
+	}
+	c1, h, cl := top.Atom(0), top.Atom(1), top.Atom(4)
+	if c1.Charge != -0.18 || c1.Mass != 12.011 || c1.Type != "CT" || c1.Symbol != "C" || len(c1.Bonds) != 4 {
+		Te.Errorf("Wrong atom read: %v", c1)
+	}
+	if h.Mass != 1.008 || h.Symbol != "H" || h.Bonds[0].Cross(h) != c1 {
+		Te.Errorf("Wrong mass or bonds taken from the atom type: %v", h)
+	}
+	if cl.Symbol != "Cl" || cl.Mass != 35.45 || cl.Molname != "LIG" {
+		Te.Errorf("Wrong element from the atomic number: %v", cl)
+	}
+	for i := 0; i < 3; i++ {
+		o := top.Atom(5 + 3*i)
+		if o.Name != "OW" || o.Symbol != "O" || o.Mass != 15.9994 || o.MolID != i+1 || o.ID != 6+3*i || len(o.Bonds) != 2 {
+			Te.Errorf("Wrong water oxygen %d: %v", i, o)
+		}
+		if hw := top.Atom(6 + 3*i); hw.Charge != 0.417 || hw.Bonds[0].Cross(hw) != o {
+			Te.Errorf("Wrong water hydrogen %d: %v", i, hw)
+		}
+	}
+	var mass float64
+	masses, _ := top.Masses()
+	for _, m := range masses {
+		mass += m
+	}
+	if math.Abs(mass-(50.485+3*18.0154)) > 1e-6 {
+		Te.Errorf("Wrong total mass: %f", mass)
+	}
+	//the flexible water is not there, but a defined symbol should select it.
+	if _, err := GmxTopFileRead(topname, &GmxTopOptions{IncludePath: opts.IncludePath, Defines: []string{"-DFLEXIBLE"}}); err == nil {
+		Te.Errorf("The #ifdef FLEXIBLE branch was not taken")
+	}
+	//An itp file alone.
+	lig, err := GmxTopFileRead(filepath.Join(dir, "system", "lig.itp"), nil)
+	if err != nil || lig.Len() != 5 || lig.Atom(4).Charge != 0 || lig.Atom(4).Mass != 35.45 {
+		Te.Errorf("Couldn't read an itp file alone: %v", err)
+	}
+}
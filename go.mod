@@ -3,7 +3,9 @@ module github.com/rmera/gochem
 go 1.12
 
 require (
+	github.com/klauspost/compress v1.11.13
 	github.com/skelterjohn/go.matrix v0.0.0-20130517144113-daa59528eefd
+	github.com/ulikunitz/xz v0.5.10
 	gonum.org/v1/gonum v0.7.0
 	gonum.org/v1/plot v0.7.0
 )
@@ -125,7 +125,7 @@ func Reduce(mol Atomer, coords *v3.Matrix, build int, report *os.File, executabl
 		}
 	}
 
-	mol2, err := pdbBufIORead(bufiopdb, false)
+	mol2, err := pdbBufIORead(bufiopdb, &PDBOptions{AltLoc: '*'})
 	if err != nil {
 		return nil, errDecorate(err, "Reduce")
 	}
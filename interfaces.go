@@ -62,6 +62,59 @@ type ConcTraj interface {
 	Len() int
 }
 
+//SeekableTraj is a trajectory that, in addition to being read sequentially,
+//allows random access to its frames, and knows the simulation time and step
+//of each frame.
+type SeekableTraj interface {
+	Traj
+
+	//NFrames returns the total number of frames in the trajectory
+	NFrames() int
+
+	//Seek sets the trajectory so the next call to Next reads the frame with the
+	//given index (starting from 0). Returns an error if the frame is out of range.
+	Seek(frame int) error
+
+	//CurrentFrame returns the index of the next frame to be read.
+	CurrentFrame() int
+
+	//Time returns the simulation time, in ps, of the last frame read.
+	Time() float64
+
+	//Step returns the simulation step of the last frame read.
+	Step() int
+}
+
+//BoxTraj is a trajectory that carries a periodic box for each frame.
+type BoxTraj interface {
+	Traj
+
+	//Box returns the periodic box of the last frame read, or nil if that frame
+	//has no box information.
+	Box() *Box
+}
+
+//TrajWriter is an interface for any trajectory that can be written
+//frame by frame.
+type TrajWriter interface {
+
+	//WNext writes coords as the next frame of the trajectory.
+	WNext(coords *v3.Matrix) error
+
+	//Close finishes the trajectory and closes the associated file. The
+	//trajectory can't be written to after closing.
+	Close() error
+}
+
+//BoxTrajWriter is a TrajWriter that can also write a periodic box for each frame.
+type BoxTrajWriter interface {
+	TrajWriter
+
+	//WNextBox writes coords as the next frame of the trajectory, with the
+	//periodic box box, which can be nil.
+	WNextBox(coords *v3.Matrix, box *Box) error
+}
+
 //Atomer is the basic interface for a topology.
 type Atomer interface {
 
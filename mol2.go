@@ -0,0 +1,358 @@
+/*
+ * mol2.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+/***Tripos MOL2 part***/
+
+//Mol2Reader reads the molecules of a MOL2 file one by one.
+type Mol2Reader struct {
+	r        *bufio.Reader
+	line     int
+	name     string
+	nextline string //a line read but not yet processed (the start of the next molecule).
+}
+
+//NewMol2Reader returns a Mol2Reader that reads from r.
+func NewMol2Reader(r io.Reader) *Mol2Reader {
+	return &Mol2Reader{r: bufio.NewReader(r)}
+}
+
+//Name returns the name of the last molecule read.
+func (M *Mol2Reader) Name() string {
+	return M.name
+}
+
+func (M *Mol2Reader) readLine() (string, error) {
+	if M.nextline != "" {
+		l := M.nextline
+		M.nextline = ""
+		return l, nil
+	}
+	line, err := M.r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	M.line++
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (M *Mol2Reader) errorf(format string, a ...interface{}) error {
+	return CError{fmt.Sprintf("line %d: ", M.line) + fmt.Sprintf(format, a...), []string{"Mol2Reader.Next"}}
+}
+
+//mol2BondOrders maps the MOL2 bond types to bond orders. Amide bonds are read as single bonds,
+//and dummy, unknown and "not connected" bonds have order 0.
+var mol2BondOrders = map[string]float64{"1": 1, "2": 2, "3": 3, "ar": 1.5, "am": 1}
+
+//Next reads the next molecule in the file, and returns it as a Molecule with one frame, where the atoms contain
+//the bonds, with their orders, and the partial charges. Aromatic bonds have order 1.5. It returns io.EOF when there
+//are no more molecules.
+func (M *Mol2Reader) Next() (*Molecule, error) {
+	var line string
+	var err error
+	for {
+		line, err = M.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, "@<TRIPOS>MOLECULE") {
+			break
+		}
+	}
+	if M.name, err = M.readLine(); err != nil {
+		return nil, M.errorf("Truncated MOLECULE section")
+	}
+	M.name = strings.TrimSpace(M.name)
+	counts, err := M.readLine()
+	if err != nil {
+		return nil, M.errorf("Truncated MOLECULE section")
+	}
+	f := strings.Fields(counts)
+	if len(f) == 0 {
+		return nil, M.errorf("Missing number of atoms")
+	}
+	natoms, err := strconv.Atoi(f[0])
+	if err != nil {
+		return nil, M.errorf("Can't read the number of atoms: %s", err.Error())
+	}
+	nbonds := 0
+	if len(f) > 1 {
+		nbonds, _ = strconv.Atoi(f[1])
+	}
+	var atoms []*Atom
+	var coords *v3.Matrix
+	ids := make(map[string]int, natoms)
+	bondsread := 0
+	section := ""
+	for {
+		line, err = M.readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, CError{err.Error(), []string{"bufio.Reader.ReadString", "Mol2Reader.Next"}}
+		}
+		if strings.HasPrefix(line, "@<TRIPOS>") {
+			section = strings.TrimSpace(line[9:])
+			if section == "MOLECULE" {
+				M.nextline = line
+				break
+			}
+			if section == "ATOM" {
+				atoms = make([]*Atom, 0, natoms)
+				coords = v3.Zeros(natoms)
+			}
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		switch section {
+		case "ATOM":
+			if len(f) < 6 || len(atoms) >= natoms {
+				return nil, M.errorf("Wrong atom line: %s", line)
+			}
+			i := len(atoms)
+			for j := 0; j < 3; j++ {
+				c, err := strconv.ParseFloat(f[j+2], 64)
+				if err != nil {
+					return nil, M.errorf("Can't read coordinates: %s", err.Error())
+				}
+				coords.Set(i, j, c)
+			}
+			ids[f[0]] = i
+			atoms = append(atoms, mol2Atom(f, i))
+		case "BOND":
+			if len(f) < 4 || coords == nil {
+				return nil, M.errorf("Wrong bond line: %s", line)
+			}
+			a, ok1 := ids[f[1]]
+			b, ok2 := ids[f[2]]
+			if !ok1 || !ok2 {
+				return nil, M.errorf("Wrong atom in bond: %s", line)
+			}
+			addBond(atoms, coords, a, b, bondsread, mol2BondOrders[f[3]])
+			bondsread++
+		}
+	}
+	if len(atoms) != natoms {
+		return nil, M.errorf("Read %d atoms, expected %d", len(atoms), natoms)
+	}
+	if bondsread != nbonds {
+		return nil, M.errorf("Read %d bonds, expected %d", bondsread, nbonds)
+	}
+	top := NewTopology(0, 1, atoms)
+	top.FillIndexes() //needed to walk the bonds.
+	mol, err := NewMolecule([]*v3.Matrix{coords}, top, nil)
+	if err != nil {
+		return nil, errDecorate(err, "Mol2Reader.Next")
+	}
+	return mol, nil
+}
+
+//mol2Atom builds an atom from the fields of a line in the ATOM section.
+func mol2Atom(f []string, i int) *Atom {
+	at := &Atom{Name: f[1], ID: i + 1, MolID: 1, Molname: "UNL"}
+	symbol := f[5]
+	if p := strings.Index(symbol, "."); p >= 0 {
+		symbol = symbol[:p]
+	}
+	at.Symbol = strings.Title(strings.ToLower(symbol))
+	if _, ok := symbolMass[at.Symbol]; !ok {
+		at.Symbol, _ = symbolFromName(strings.ToUpper(at.Name))
+	}
+	at.Mass = symbolMass[at.Symbol]
+	if len(f) > 6 {
+		at.MolID, _ = strconv.Atoi(f[6])
+	}
+	if len(f) > 7 {
+		//Substructure names are often the residue name followed by the residue number.
+		name := strings.TrimRight(f[7], "0123456789")
+		if name != "" && name != f[7] {
+			at.Molname = name
+			if id, err := strconv.Atoi(f[7][len(name):]); err == nil {
+				at.MolID = id
+			}
+		} else {
+			at.Molname = f[7]
+		}
+	}
+	at.Molname1 = three2OneLetter[at.Molname]
+	at.Het = at.Molname1 == 0
+	if len(f) > 8 {
+		at.Charge, _ = strconv.ParseFloat(f[8], 64)
+	}
+	return at
+}
+
+//Mol2Read reads the first molecule of a MOL2 file from r. See Mol2Reader.Next.
+func Mol2Read(r io.Reader) (*Molecule, error) {
+	mol, err := NewMol2Reader(r).Next()
+	if err == io.EOF {
+		return nil, CError{"No molecules found", []string{"Mol2Read"}}
+	}
+	return mol, errDecorate(err, "Mol2Read")
+}
+
+//Mol2FileRead reads the first molecule of a MOL2 file. See Mol2Reader.Next.
+func Mol2FileRead(name string) (*Molecule, error) {
+	f, err := OpenCompressed(name)
+	if err != nil {
+		return nil, errDecorate(err, "Mol2FileRead")
+	}
+	defer f.Close()
+	mol, err := Mol2Read(f)
+	return mol, errDecorate(err, "Mol2FileRead")
+}
+
+//mol2Type returns the Sybyl atom type for the atom, guessed from its element and bonds.
+func mol2Type(at *Atom) string {
+	var maxorder float64
+	for _, b := range at.Bonds {
+		if b.Order == 1.5 {
+			maxorder = 1.5
+			break
+		}
+		if b.Order > maxorder {
+			maxorder = b.Order
+		}
+	}
+	switch at.Symbol {
+	case "C":
+		switch maxorder {
+		case 1.5:
+			return "C.ar"
+		case 2:
+			return "C.2"
+		case 3:
+			return "C.1"
+		}
+		return "C.3"
+	case "N":
+		switch {
+		case maxorder == 1.5:
+			return "N.ar"
+		case maxorder == 2:
+			return "N.2"
+		case maxorder == 3:
+			return "N.1"
+		case len(at.Bonds) == 4:
+			return "N.4"
+		}
+		return "N.3"
+	case "O":
+		if maxorder == 2 {
+			return "O.2"
+		}
+		return "O.3"
+	case "S":
+		if maxorder == 2 && len(at.Bonds) == 1 {
+			return "S.2"
+		}
+		return "S.3"
+	case "P":
+		return "P.3"
+	}
+	return at.Symbol
+}
+
+//mol2BondType returns the MOL2 bond type for the order.
+func mol2BondType(order float64) string {
+	switch order {
+	case 1, 2, 3:
+		return strconv.Itoa(int(order))
+	case 1.5:
+		return "ar"
+	}
+	return "un"
+}
+
+//Mol2Write writes the atoms of mol, with their bonds and partial charges, and the coordinates coords
+//to out, as a molecule in MOL2 format with the given name. The Sybyl atom types are guessed from the
+//elements and bond orders.
+func Mol2Write(out io.Writer, coords *v3.Matrix, mol Atomer, name string) error {
+	if coords.NVecs() != mol.Len() {
+		return CError{fmt.Sprintf("%d coordinates for %d atoms", coords.NVecs(), mol.Len()), []string{"Mol2Write"}}
+	}
+	bonds, index := molBonds(mol)
+	charges := "NO_CHARGES"
+	for i := 0; i < mol.Len(); i++ {
+		if mol.Atom(i).Charge != 0 {
+			charges = "USER_CHARGES"
+			break
+		}
+	}
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "@<TRIPOS>MOLECULE\n%s\n%5d %5d %5d 0 0\nSMALL\n%s\n\n", name, mol.Len(), len(bonds), 1, charges)
+	w.WriteString("@<TRIPOS>ATOM\n")
+	for i := 0; i < mol.Len(); i++ {
+		at := mol.Atom(i)
+		atname := at.Name
+		if atname == "" {
+			atname = fmt.Sprintf("%s%d", at.Symbol, i+1)
+		}
+		resname := at.Molname
+		if resname == "" {
+			resname = "UNL"
+		}
+		fmt.Fprintf(w, "%7d %-8s %10.4f %10.4f %10.4f %-6s %4d %-8s %9.4f\n", i+1, atname, coords.At(i, 0), coords.At(i, 1), coords.At(i, 2),
+			mol2Type(at), at.MolID, fmt.Sprintf("%s%d", resname, at.MolID), at.Charge)
+	}
+	w.WriteString("@<TRIPOS>BOND\n")
+	for k, b := range bonds {
+		i, j := bondIndexes(b, index)
+		fmt.Fprintf(w, "%6d %5d %5d %s\n", k+1, i+1, j+1, mol2BondType(b.Order))
+	}
+	if err := w.Flush(); err != nil {
+		return CError{"Failed to write in io.Writer: " + err.Error(), []string{"bufio.Writer.Flush", "Mol2Write"}}
+	}
+	return nil
+}
+
+//Mol2FileWrite writes a MOL2 file with the atoms in mol and the coordinates coords. See Mol2Write.
+func Mol2FileWrite(name string, coords *v3.Matrix, mol Atomer) error {
+	out, err := CreateCompressed(name)
+	if err != nil {
+		return errDecorate(err, "Mol2FileWrite")
+	}
+	defer out.Close()
+	return errDecorate(Mol2Write(out, coords, mol, "gochem"), "Mol2FileWrite")
+}
+
+/***End of MOL2 part***/
@@ -0,0 +1,126 @@
+/*
+ * mol2_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chem
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+const testMol2 = `@<TRIPOS>MOLECULE
+benzene
+   12    12     1 0 0
+SMALL
+USER_CHARGES
+
+@<TRIPOS>ATOM
+      1 C1       1.3900     0.0000     0.0000 C.ar    1 BNZ1    -0.1150
+      2 C2       0.6950     1.2038     0.0000 C.ar    1 BNZ1    -0.1150
+      3 C3      -0.6950     1.2038     0.0000 C.ar    1 BNZ1    -0.1150
+      4 C4      -1.3900     0.0000     0.0000 C.ar    1 BNZ1    -0.1150
+      5 C5      -0.6950    -1.2038     0.0000 C.ar    1 BNZ1    -0.1150
+      6 C6       0.6950    -1.2038     0.0000 C.ar    1 BNZ1    -0.1150
+      7 H7       2.4700     0.0000     0.0000 H       1 BNZ1     0.1150
+      8 H8       1.2350     2.1391     0.0000 H       1 BNZ1     0.1150
+      9 H9      -1.2350     2.1391     0.0000 H       1 BNZ1     0.1150
+     10 H10     -2.4700     0.0000     0.0000 H       1 BNZ1     0.1150
+     11 H11     -1.2350    -2.1391     0.0000 H       1 BNZ1     0.1150
+     12 H12      1.2350    -2.1391     0.0000 H       1 BNZ1     0.1150
+@<TRIPOS>BOND
+     1     1     2 ar
+     2     2     3 ar
+     3     3     4 ar
+     4     4     5 ar
+     5     5     6 ar
+     6     6     1 ar
+     7     1     7 1
+     8     2     8 1
+     9     3     9 1
+    10     4    10 1
+    11     5    11 1
+    12     6    12 1
+@<TRIPOS>SUBSTRUCTURE
+     1 BNZ1        1 GROUP             0 ****  ****    0
+@<TRIPOS>MOLECULE
+water
+    3     2     1 0 0
+SMALL
+USER_CHARGES
+
+@<TRIPOS>ATOM
+      1 OW      0.0000  0.0000  0.0000 O.3     2 HOH2  -0.8340
+      2 HW1     0.9572  0.0000  0.0000 H       2 HOH2   0.4170
+      3 HW2    -0.2400  0.9266  0.0000 H       2 HOH2   0.4170
+@<TRIPOS>BOND
+     1     1     2 1
+     2     1     3 1
+`
+
+func TestMol2(Te *testing.T) {
+	r := NewMol2Reader(strings.NewReader(testMol2))
+	bz, err := r.Next()
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if r.Name() != "benzene" || bz.Len() != 12 || bz.Atom(0).Bonds[0].Order != 1.5 || bz.Atom(0).Charge != -0.115 {
+		Te.Fatalf("Wrong benzene read")
+	}
+	if at := bz.Atom(0); at.Symbol != "C" || at.Molname != "BNZ" || at.MolID != 1 {
+		Te.Errorf("Wrong atom read: %v", at)
+	}
+	if rings := FindRings(bz.Coords[0], bz); len(rings) != 1 {
+		Te.Errorf("Found %d rings in benzene, expected 1", len(rings))
+	}
+	w, err := r.Next()
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if w.Len() != 3 || w.Atom(0).Molname != "HOH" || w.Atom(0).MolID != 2 || len(w.Atom(0).Bonds) != 2 || w.Atom(1).Symbol != "H" {
+		Te.Errorf("Wrong water read: %v", w.Atom(0))
+	}
+	if _, err := r.Next(); err != io.EOF {
+		Te.Errorf("Expected io.EOF after the last molecule, got %v", err)
+	}
+	var out bytes.Buffer
+	if err := Mol2Write(&out, bz.Coords[0], bz, "benzene"); err != nil {
+		Te.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "C.ar") {
+		Te.Errorf("Aromatic carbons not typed as such:\n%s", out.String())
+	}
+	bz2, err := Mol2Read(&out)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if bz2.Len() != bz.Len() {
+		Te.Fatalf("Read back %d atoms, expected %d", bz2.Len(), bz.Len())
+	}
+	for i := 0; i < bz.Len(); i++ {
+		a, b := bz.Atom(i), bz2.Atom(i)
+		if a.Name != b.Name || a.Symbol != b.Symbol || a.Charge != b.Charge || a.Molname != b.Molname || len(a.Bonds) != len(b.Bonds) || a.Bonds[0].Order != b.Bonds[0].Order {
+			Te.Errorf("Atom %d changed in the round-trip: %v %v", i, a, b)
+		}
+	}
+}
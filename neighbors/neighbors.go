@@ -0,0 +1,410 @@
+/*
+ * neighbors.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+//Package neighbors implements a cell list for fast spatial neighbor searches over
+//the coordinates in a v3.Matrix, with optional periodic boundary conditions.
+//The package doesn't depend on goChem, only on the v3 package, so goChem itself can use it.
+package neighbors
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//Box is a periodic box. Vecs returns a 3x3 matrix with the cell vectors, one per row.
+//chem.Box implements this interface.
+type Box interface {
+	Vecs() *v3.Matrix
+}
+
+//Neighbor is a point found in a search, and its distance
+//to the query point.
+type Neighbor struct {
+	Index int
+	Dist  float64
+}
+
+//Pair is a pair of points in the grid, with I<J, and the distance between them.
+type Pair struct {
+	I, J int
+	Dist float64
+}
+
+//maxCellsPerPoint limits the number of cells in the grid, which could be huge
+//for sparse systems with a small cell size.
+const maxCellsPerPoint = 8
+
+//Grid is a cell list built from a set of points. The space is divided in cells,
+//and each point is assigned to one of them, so only points in nearby cells need
+//to be checked in a search. If a periodic box is given, the grid spans the box,
+//and the minimum image distances are used.
+type Grid struct {
+	points [][3]float64 //for periodic grids, the points are wrapped into the box.
+	cells  [][]int      //the indexes of the points in each cell.
+	n      [3]int       //the number of cells along each direction.
+	//Non-periodic grids
+	origin   [3]float64
+	cellsize float64
+	//Periodic grids
+	pbc     bool
+	ortho   bool
+	h, inv  [3][3]float64
+	heights [3]float64 //the distances between opposite faces of the box.
+}
+
+//New builds a Grid with the points in coords, with cells of at least cellsize A (typically, the largest
+//search radius that will be used). If box is not nil, periodic boundary conditions are used.
+func New(coords *v3.Matrix, cellsize float64, box Box) (*Grid, error) {
+	if coords == nil || coords.NVecs() == 0 {
+		return nil, Error{"No points given to build the grid", []string{"New"}, true}
+	}
+	if cellsize <= 0 {
+		return nil, Error{fmt.Sprintf("The cell size must be positive, got %5.3f", cellsize), []string{"New"}, true}
+	}
+	G := new(Grid)
+	G.points = make([][3]float64, coords.NVecs())
+	for i := range G.points {
+		G.points[i] = [3]float64{coords.At(i, 0), coords.At(i, 1), coords.At(i, 2)}
+	}
+	maxcells := maxCellsPerPoint * len(G.points)
+	if box != nil {
+		if err := G.setBox(box.Vecs()); err != nil {
+			return nil, errDecorate(err, "New")
+		}
+		for {
+			total := 1
+			for j := 0; j < 3; j++ {
+				G.n[j] = int(math.Max(1, math.Floor(G.heights[j]/cellsize)))
+				total *= G.n[j]
+			}
+			if total <= maxcells {
+				break
+			}
+			cellsize *= 1.5
+		}
+		for i, p := range G.points {
+			G.points[i] = G.wrap(p)
+		}
+	} else {
+		min := G.points[0]
+		max := G.points[0]
+		for _, p := range G.points {
+			for j := 0; j < 3; j++ {
+				min[j] = math.Min(min[j], p[j])
+				max[j] = math.Max(max[j], p[j])
+			}
+		}
+		G.origin = min
+		for {
+			total := 1
+			for j := 0; j < 3; j++ {
+				G.n[j] = int(math.Floor((max[j]-min[j])/cellsize)) + 1
+				total *= G.n[j]
+			}
+			if total <= maxcells {
+				break
+			}
+			cellsize *= 1.5
+		}
+		G.cellsize = cellsize
+	}
+	G.cells = make([][]int, G.n[0]*G.n[1]*G.n[2])
+	for i, p := range G.points {
+		c := G.cellOf(p)
+		k := G.linear(c)
+		G.cells[k] = append(G.cells[k], i)
+	}
+	return G, nil
+}
+
+//setBox sets the box vectors, their inverse and the heights of the box.
+func (G *Grid) setBox(vecs *v3.Matrix) error {
+	if vecs == nil || vecs.NVecs() != 3 {
+		return Error{"The box must have 3 vectors", []string{"setBox"}, true}
+	}
+	G.pbc = true
+	G.ortho = true
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			G.h[i][j] = vecs.At(i, j)
+			if i != j && G.h[i][j] != 0 {
+				G.ortho = false
+			}
+		}
+	}
+	h := G.h
+	det := h[0][0]*(h[1][1]*h[2][2]-h[1][2]*h[2][1]) - h[0][1]*(h[1][0]*h[2][2]-h[1][2]*h[2][0]) + h[0][2]*(h[1][0]*h[2][1]-h[1][1]*h[2][0])
+	if det <= 0 {
+		return Error{"Degenerate or left-handed box", []string{"setBox"}, true}
+	}
+	G.inv[0][0] = (h[1][1]*h[2][2] - h[1][2]*h[2][1]) / det
+	G.inv[0][1] = (h[0][2]*h[2][1] - h[0][1]*h[2][2]) / det
+	G.inv[0][2] = (h[0][1]*h[1][2] - h[0][2]*h[1][1]) / det
+	G.inv[1][0] = (h[1][2]*h[2][0] - h[1][0]*h[2][2]) / det
+	G.inv[1][1] = (h[0][0]*h[2][2] - h[0][2]*h[2][0]) / det
+	G.inv[1][2] = (h[0][2]*h[1][0] - h[0][0]*h[1][2]) / det
+	G.inv[2][0] = (h[1][0]*h[2][1] - h[1][1]*h[2][0]) / det
+	G.inv[2][1] = (h[0][1]*h[2][0] - h[0][0]*h[2][1]) / det
+	G.inv[2][2] = (h[0][0]*h[1][1] - h[0][1]*h[1][0]) / det
+	//The height along each direction is the inverse of the norm of the corresponding
+	//column of the inverse matrix.
+	for j := 0; j < 3; j++ {
+		G.heights[j] = 1 / math.Sqrt(G.inv[0][j]*G.inv[0][j]+G.inv[1][j]*G.inv[1][j]+G.inv[2][j]*G.inv[2][j])
+	}
+	return nil
+}
+
+//frac returns the fractional coordinates of p.
+func (G *Grid) frac(p [3]float64) [3]float64 {
+	var f [3]float64
+	for j := 0; j < 3; j++ {
+		f[j] = p[0]*G.inv[0][j] + p[1]*G.inv[1][j] + p[2]*G.inv[2][j]
+	}
+	return f
+}
+
+//wrap returns the image of p inside the box.
+func (G *Grid) wrap(p [3]float64) [3]float64 {
+	f := G.frac(p)
+	for j := 0; j < 3; j++ {
+		n := math.Floor(f[j])
+		for l := 0; l < 3; l++ {
+			p[l] -= n * G.h[j][l]
+		}
+	}
+	return p
+}
+
+//cellOf returns the cell to which the point p belongs. For
+//periodic grids, p must be inside the box.
+func (G *Grid) cellOf(p [3]float64) [3]int {
+	var c [3]int
+	if G.pbc {
+		f := G.frac(p)
+		for j := 0; j < 3; j++ {
+			c[j] = clamp(int(math.Floor(f[j]*float64(G.n[j]))), G.n[j])
+		}
+		return c
+	}
+	for j := 0; j < 3; j++ {
+		c[j] = clamp(int(math.Floor((p[j]-G.origin[j])/G.cellsize)), G.n[j])
+	}
+	return c
+}
+
+func clamp(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+func (G *Grid) linear(c [3]int) int {
+	return (c[0]*G.n[1]+c[1])*G.n[2] + c[2]
+}
+
+//dist2 returns the squared distance between the point p and the point with index i in the grid.
+//For periodic grids, the minimum image is used.
+func (G *Grid) dist2(p [3]float64, i int) float64 {
+	q := G.points[i]
+	d := [3]float64{q[0] - p[0], q[1] - p[1], q[2] - p[2]}
+	if !G.pbc {
+		return d[0]*d[0] + d[1]*d[1] + d[2]*d[2]
+	}
+	f := G.frac(d)
+	for j := 0; j < 3; j++ {
+		f[j] -= math.Round(f[j])
+	}
+	for j := 0; j < 3; j++ {
+		d[j] = f[0]*G.h[0][j] + f[1]*G.h[1][j] + f[2]*G.h[2][j]
+	}
+	best := d[0]*d[0] + d[1]*d[1] + d[2]*d[2]
+	if G.ortho {
+		return best
+	}
+	//In triclinic boxes, the reduced vector is not necessarily the shortest.
+	for a := -1.0; a <= 1; a++ {
+		for b := -1.0; b <= 1; b++ {
+			for c := -1.0; c <= 1; c++ {
+				var e [3]float64
+				for l := 0; l < 3; l++ {
+					e[l] = d[l] + a*G.h[0][l] + b*G.h[1][l] + c*G.h[2][l]
+				}
+				if d2 := e[0]*e[0] + e[1]*e[1] + e[2]*e[2]; d2 < best {
+					best = d2
+				}
+			}
+		}
+	}
+	return best
+}
+
+//cellRange returns the cells to be searched along the direction j, for a
+//query of radius r around a point in the cell c.
+func (G *Grid) cellRange(c [3]int, p [3]float64, r float64, j int) []int {
+	n := G.n[j]
+	ret := make([]int, 0, 3)
+	if G.pbc {
+		k := int(math.Ceil(r * float64(n) / G.heights[j]))
+		if 2*k+1 >= n {
+			for i := 0; i < n; i++ {
+				ret = append(ret, i)
+			}
+			return ret
+		}
+		for i := c[j] - k; i <= c[j]+k; i++ {
+			ret = append(ret, ((i%n)+n)%n)
+		}
+		return ret
+	}
+	lo := clamp(int(math.Floor((p[j]-r-G.origin[j])/G.cellsize)), n)
+	hi := clamp(int(math.Floor((p[j]+r-G.origin[j])/G.cellsize)), n)
+	for i := lo; i <= hi; i++ {
+		ret = append(ret, i)
+	}
+	return ret
+}
+
+//within calls f for each point of the grid closer than r to p, with the index of the
+//point and the squared distance.
+func (G *Grid) within(p [3]float64, r float64, f func(i int, d2 float64)) {
+	if G.pbc {
+		p = G.wrap(p)
+	}
+	c := G.cellOf(p)
+	r2 := r * r
+	xs, ys, zs := G.cellRange(c, p, r, 0), G.cellRange(c, p, r, 1), G.cellRange(c, p, r, 2)
+	for _, x := range xs {
+		for _, y := range ys {
+			for _, z := range zs {
+				for _, i := range G.cells[G.linear([3]int{x, y, z})] {
+					if d2 := G.dist2(p, i); d2 <= r2 {
+						f(i, d2)
+					}
+				}
+			}
+		}
+	}
+}
+
+//Within returns the points of the grid that are at a distance of r or less from the point
+//in the first row of point, sorted by distance.
+func (G *Grid) Within(point *v3.Matrix, r float64) []Neighbor {
+	ret := make([]Neighbor, 0, 10)
+	G.within([3]float64{point.At(0, 0), point.At(0, 1), point.At(0, 2)}, r, func(i int, d2 float64) {
+		ret = append(ret, Neighbor{Index: i, Dist: math.Sqrt(d2)})
+	})
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Dist < ret[j].Dist })
+	return ret
+}
+
+//Nearest returns the index of the point of the grid closest to the point in the first row of
+//point, and its distance, if they are at most r apart. Otherwise, it returns -1 and
+//the infinite distance.
+func (G *Grid) Nearest(point *v3.Matrix, r float64) (int, float64) {
+	best := -1
+	bestd2 := math.Inf(1)
+	G.within([3]float64{point.At(0, 0), point.At(0, 1), point.At(0, 2)}, r, func(i int, d2 float64) {
+		if d2 < bestd2 || (d2 == bestd2 && i < best) {
+			best, bestd2 = i, d2
+		}
+	})
+	return best, math.Sqrt(bestd2)
+}
+
+//Pairs returns all the pairs of points in the grid that are at a distance of r or less,
+//sorted by the first and then the second index.
+func (G *Grid) Pairs(r float64) []Pair {
+	ret := make([]Pair, 0, len(G.points))
+	for i, p := range G.points {
+		G.within(p, r, func(j int, d2 float64) {
+			if j > i {
+				ret = append(ret, Pair{I: i, J: j, Dist: math.Sqrt(d2)})
+			}
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].I != ret[j].I {
+			return ret[i].I < ret[j].I
+		}
+		return ret[i].J < ret[j].J
+	})
+	return ret
+}
+
+//Len returns the number of points in the grid.
+func (G *Grid) Len() int {
+	return len(G.points)
+}
+
+//Errors
+
+//the same as chem.Error but avoid circular import.
+type errorInt interface {
+	Error() string
+	Critical() bool
+	Decorate(string) []string
+}
+
+//Error is an error on the neighbors package. Compatible with goChem
+type Error struct {
+	message  string
+	deco     []string
+	critical bool
+}
+
+//Error returns a string with an error message.
+func (err Error) Error() string {
+	return fmt.Sprintf("neighbors: %s", err.message)
+}
+
+//Decorate will add the dec string to the decoration slice of strings of the error,
+//and return the resulting slice.
+func (err Error) Decorate(dec string) []string {
+	if dec != "" {
+		err.deco = append(err.deco, dec)
+	}
+	return err.deco
+}
+
+//Critical return whether the error is critical or it can be ifnored
+func (err Error) Critical() bool { return err.critical }
+
+//errDecorate is a helper function that asserts that the error is
+//implements chem.Error and decorates the error with the caller's name before returning it.
+//if used with a non-chem.Error error, it will cause a panic.
+func errDecorate(err error, caller string) error {
+	err2 := err.(errorInt)
+	err2.Decorate(caller)
+	return err2
+}
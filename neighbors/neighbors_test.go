@@ -0,0 +1,153 @@
+/*
+ * neighbors_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package neighbors
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+type testBox struct {
+	vecs *v3.Matrix
+}
+
+func (B testBox) Vecs() *v3.Matrix { return B.vecs }
+
+//bruteDist returns the distance between the points i and j of coords, using the
+//minimum image (checking many images) if vecs is not nil.
+func bruteDist(coords, vecs *v3.Matrix, i, j int) float64 {
+	n := 0.0
+	if vecs != nil {
+		n = 3
+	}
+	min := math.Inf(1)
+	for a := -n; a <= n; a++ {
+		for b := -n; b <= n; b++ {
+			for c := -n; c <= n; c++ {
+				d := 0.0
+				for l := 0; l < 3; l++ {
+					e := coords.At(j, l) - coords.At(i, l)
+					if vecs != nil {
+						e += a*vecs.At(0, l) + b*vecs.At(1, l) + c*vecs.At(2, l)
+					}
+					d += e * e
+				}
+				min = math.Min(min, math.Sqrt(d))
+			}
+		}
+	}
+	return min
+}
+
+func randomCoords(n int, size float64) *v3.Matrix {
+	coords := v3.Zeros(n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < 3; j++ {
+			coords.Set(i, j, rand.Float64()*size*1.2-0.1*size) //some points outside the box
+		}
+	}
+	return coords
+}
+
+func TestPairs(Te *testing.T) {
+	ortho, _ := v3.NewMatrix([]float64{20, 0, 0, 0, 22, 0, 0, 0, 25})
+	tric, _ := v3.NewMatrix([]float64{20, 0, 0, 5, 20, 0, -4, 6, 21})
+	for _, vecs := range []*v3.Matrix{nil, ortho, tric} {
+		coords := randomCoords(300, 20)
+		var box Box
+		if vecs != nil {
+			box = testBox{vecs}
+		}
+		for _, r := range []float64{1.5, 4, 9} {
+			G, err := New(coords, r, box)
+			if err != nil {
+				Te.Fatal(err)
+			}
+			pairs := G.Pairs(r)
+			k := 0
+			for i := 0; i < coords.NVecs(); i++ {
+				for j := i + 1; j < coords.NVecs(); j++ {
+					d := bruteDist(coords, vecs, i, j)
+					if d > r {
+						continue
+					}
+					if k >= len(pairs) || pairs[k].I != i || pairs[k].J != j {
+						Te.Fatalf("Pair %d %d (d=%5.3f, r=%3.1f) not found, box: %v", i, j, d, r, vecs)
+					}
+					if math.Abs(pairs[k].Dist-d) > 1e-8 {
+						Te.Fatalf("Pair %d %d, distance %f, expected %f", i, j, pairs[k].Dist, d)
+					}
+					k++
+				}
+			}
+			if k != len(pairs) {
+				Te.Errorf("Found %d pairs, expected %d", len(pairs), k)
+			}
+		}
+	}
+}
+
+func TestWithin(Te *testing.T) {
+	tric, _ := v3.NewMatrix([]float64{20, 0, 0, 5, 20, 0, -4, 6, 21})
+	for _, vecs := range []*v3.Matrix{nil, tric} {
+		coords := randomCoords(200, 20)
+		var box Box
+		if vecs != nil {
+			box = testBox{vecs}
+		}
+		G, err := New(coords.View(1, 0, 199, 3), 5, box)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		neigh := G.Within(coords.VecView(0), 5)
+		expected := 0
+		nearest, mind := -1, math.Inf(1)
+		for j := 1; j < coords.NVecs(); j++ {
+			d := bruteDist(coords, vecs, 0, j)
+			if d <= 5 {
+				expected++
+				if d < mind {
+					nearest, mind = j-1, d
+				}
+			}
+		}
+		if len(neigh) != expected {
+			Te.Fatalf("Found %d neighbors, expected %d", len(neigh), expected)
+		}
+		for i := 1; i < len(neigh); i++ {
+			if neigh[i].Dist < neigh[i-1].Dist {
+				Te.Errorf("Neighbors not sorted by distance: %v", neigh)
+			}
+		}
+		n, d := G.Nearest(coords.VecView(0), 5)
+		if n != nearest || (n >= 0 && math.Abs(d-mind) > 1e-8) {
+			Te.Errorf("Nearest point %d at %f, expected %d at %f", n, d, nearest, mind)
+		}
+	}
+	if _, err := New(v3.Zeros(3), 0, nil); err == nil {
+		Te.Errorf("A zero cell size should not be accepted")
+	}
+}
@@ -0,0 +1,412 @@
+/*
+ * pbc.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"fmt"
+	"math"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//Minimum image convention functions.
+
+//minImage returns the minimum image of the difference vector d.
+//For triclinic boxes, the vector is first reduced using fractional coordinates, and then
+//the neighboring images are checked, as the reduced vector is not necessarily the shortest.
+func (B *Box) minImage(d [3]float64) [3]float64 {
+	if B.ortho {
+		for k := 0; k < 3; k++ {
+			d[k] -= B.h[k][k] * math.Round(d[k]/B.h[k][k])
+		}
+		return d
+	}
+	var f [3]float64
+	for j := 0; j < 3; j++ {
+		f[j] = d[0]*B.inv[0][j] + d[1]*B.inv[1][j] + d[2]*B.inv[2][j]
+		f[j] -= math.Round(f[j])
+	}
+	for j := 0; j < 3; j++ {
+		d[j] = f[0]*B.h[0][j] + f[1]*B.h[1][j] + f[2]*B.h[2][j]
+	}
+	best := d
+	bestd2 := d[0]*d[0] + d[1]*d[1] + d[2]*d[2]
+	for i := -1.0; i <= 1; i++ {
+		for j := -1.0; j <= 1; j++ {
+			for k := -1.0; k <= 1; k++ {
+				var c [3]float64
+				for l := 0; l < 3; l++ {
+					c[l] = d[l] + i*B.h[0][l] + j*B.h[1][l] + k*B.h[2][l]
+				}
+				if d2 := c[0]*c[0] + c[1]*c[1] + c[2]*c[2]; d2 < bestd2 {
+					best, bestd2 = c, d2
+				}
+			}
+		}
+	}
+	return best
+}
+
+//MinImage puts in the receiver-like matrix dst the minimum image of each of the
+//difference vectors in vec. dst and vec must have the same number of vectors, and can
+//be the same matrix.
+func (B *Box) MinImage(dst, vec *v3.Matrix) {
+	if dst.NVecs() != vec.NVecs() {
+		panic(ErrInconsistentData)
+	}
+	for i := 0; i < vec.NVecs(); i++ {
+		d := B.minImage([3]float64{vec.At(i, 0), vec.At(i, 1), vec.At(i, 2)})
+		for j := 0; j < 3; j++ {
+			dst.Set(i, j, d[j])
+		}
+	}
+}
+
+//MinImageVec puts in dst (a 1x3 matrix) the minimum image of the vector
+//from point a to point b, i.e. b-a.
+func (B *Box) MinImageVec(dst, a, b *v3.Matrix) {
+	d := B.minImage([3]float64{b.At(0, 0) - a.At(0, 0), b.At(0, 1) - a.At(0, 1), b.At(0, 2) - a.At(0, 2)})
+	for j := 0; j < 3; j++ {
+		dst.Set(0, j, d[j])
+	}
+}
+
+//Dist returns the minimum image distance between the points a and b, which
+//are the first vectors of the respective matrices.
+func (B *Box) Dist(a, b *v3.Matrix) float64 {
+	return math.Sqrt(B.dist2(a.At(0, 0), a.At(0, 1), a.At(0, 2), b.At(0, 0), b.At(0, 1), b.At(0, 2)))
+}
+
+//dist2 returns the square of the minimum image distance between the given points.
+func (B *Box) dist2(ax, ay, az, bx, by, bz float64) float64 {
+	d := B.minImage([3]float64{bx - ax, by - ay, bz - az})
+	return d[0]*d[0] + d[1]*d[1] + d[2]*d[2]
+}
+
+//MaxCutoff returns the largest cutoff radius for which the minimum image is
+//guaranteed to include all the neighbors of a point, i.e. half of the shortest
+//distance between opposite faces of the box. Distance-based analyses, such as the
+//RDFs, should not go beyond this value.
+func (B *Box) MaxCutoff() float64 {
+	vol := B.Volume()
+	c := v3.Zeros(1)
+	min := math.Inf(1)
+	for _, v := range [][2]int{{1, 2}, {0, 2}, {0, 1}} {
+		c.Cross(B.vecs.VecView(v[0]), B.vecs.VecView(v[1]))
+		if h := vol / c.Norm(2); h < min {
+			min = h
+		}
+	}
+	return min / 2
+}
+
+//wholeMolecule puts in dst the coordinates in coords, so every point is placed at its minimum image
+//with respect to the first one. This makes whole a molecule broken by the periodic boundaries.
+func (B *Box) wholeMolecule(dst, coords *v3.Matrix) {
+	dst.Copy(coords)
+	x, y, z := coords.At(0, 0), coords.At(0, 1), coords.At(0, 2)
+	for i := 1; i < coords.NVecs(); i++ {
+		d := B.minImage([3]float64{coords.At(i, 0) - x, coords.At(i, 1) - y, coords.At(i, 2) - z})
+		dst.Set(i, 0, x+d[0])
+		dst.Set(i, 1, y+d[1])
+		dst.Set(i, 2, z+d[2])
+	}
+}
+
+//inBoxShift returns the lattice translation that puts the point p inside the box.
+func (B *Box) inBoxShift(p [3]float64) [3]float64 {
+	var s [3]float64
+	for j := 0; j < 3; j++ {
+		n := -math.Floor(p[0]*B.inv[0][j] + p[1]*B.inv[1][j] + p[2]*B.inv[2][j])
+		for l := 0; l < 3; l++ {
+			s[l] += n * B.h[j][l]
+		}
+	}
+	return s
+}
+
+//Center returns the center of the box.
+func (B *Box) Center() *v3.Matrix {
+	c := v3.Zeros(1)
+	for j := 0; j < 3; j++ {
+		c.Set(0, j, 0.5*(B.h[0][j]+B.h[1][j]+B.h[2][j]))
+	}
+	return c
+}
+
+//Wrap puts each of the points in coords inside the box. Molecules can be broken as a result.
+func (B *Box) Wrap(coords *v3.Matrix) {
+	for i := 0; i < coords.NVecs(); i++ {
+		s := B.inBoxShift([3]float64{coords.At(i, 0), coords.At(i, 1), coords.At(i, 2)})
+		for j := 0; j < 3; j++ {
+			coords.Set(i, j, coords.At(i, j)+s[j])
+		}
+	}
+}
+
+//fragment is a set of atoms connected by bonds. parents contains, for each
+//atom, the index of the atom from which it was reached when walking the bonds, or -1
+//for the first atom.
+type fragment struct {
+	atoms   []int
+	parents []int
+}
+
+//fragments obtains the sets of atoms in mol that are connected by bonds, by walking
+//the bonds breadth-first. Bonds to atoms not present in mol are ignored.
+func fragments(mol Atomer) []*fragment {
+	index := make(map[*Atom]int, mol.Len())
+	for i := 0; i < mol.Len(); i++ {
+		index[mol.Atom(i)] = i
+	}
+	visited := make([]bool, mol.Len())
+	frags := make([]*fragment, 0, 10)
+	for i := 0; i < mol.Len(); i++ {
+		if visited[i] {
+			continue
+		}
+		visited[i] = true
+		f := &fragment{atoms: []int{i}, parents: []int{-1}}
+		for k := 0; k < len(f.atoms); k++ {
+			at := mol.Atom(f.atoms[k])
+			for _, b := range at.Bonds {
+				other := b.At1
+				if other == at {
+					other = b.At2
+				}
+				j, ok := index[other]
+				if !ok || visited[j] {
+					continue
+				}
+				visited[j] = true
+				f.atoms = append(f.atoms, j)
+				f.parents = append(f.parents, f.atoms[k])
+			}
+		}
+		frags = append(frags, f)
+	}
+	return frags
+}
+
+//makeWhole places each atom of each fragment at the minimum image with respect
+//to the atom from which it was reached.
+func makeWhole(coords *v3.Matrix, frags []*fragment, box *Box) {
+	for _, f := range frags {
+		for k, i := range f.atoms {
+			p := f.parents[k]
+			if p < 0 {
+				continue
+			}
+			d := box.minImage([3]float64{coords.At(i, 0) - coords.At(p, 0), coords.At(i, 1) - coords.At(p, 1), coords.At(i, 2) - coords.At(p, 2)})
+			for j := 0; j < 3; j++ {
+				coords.Set(i, j, coords.At(p, j)+d[j])
+			}
+		}
+	}
+}
+
+//wrapFragments translates each fragment by a lattice vector so its centroid is in the box.
+func wrapFragments(coords *v3.Matrix, frags []*fragment, box *Box) {
+	for _, f := range frags {
+		var c [3]float64
+		for _, i := range f.atoms {
+			for j := 0; j < 3; j++ {
+				c[j] += coords.At(i, j)
+			}
+		}
+		for j := range c {
+			c[j] /= float64(len(f.atoms))
+		}
+		s := box.inBoxShift(c)
+		for _, i := range f.atoms {
+			for j := 0; j < 3; j++ {
+				coords.Set(i, j, coords.At(i, j)+s[j])
+			}
+		}
+	}
+}
+
+//MakeWhole puts together, in place, the molecules in coords that are broken by the periodic boundaries of box. The
+//molecules are determined from the bonds in mol, so bonds need to be assigned first (for instance, with AssignBondsPBC).
+//Atoms without bonds are left where they are.
+func MakeWhole(coords *v3.Matrix, mol Atomer, box *Box) {
+	if coords.NVecs() != mol.Len() {
+		panic(ErrInconsistentData)
+	}
+	makeWhole(coords, fragments(mol), box)
+}
+
+//WrapMolecules puts, in place, the centroid of each molecule in coords inside the box, by
+//translating whole molecules. The molecules are determined from the bonds in mol.
+//The molecules are expected to be whole (see MakeWhole).
+func WrapMolecules(coords *v3.Matrix, mol Atomer, box *Box) {
+	if coords.NVecs() != mol.Len() {
+		panic(ErrInconsistentData)
+	}
+	wrapFragments(coords, fragments(mol), box)
+}
+
+//CenterInBox translates all the coordinates in coords, in place, so the centroid of the
+//atoms with the given indexes is at the center of the box. If indexes is nil or empty, the
+//centroid of all the atoms is used.
+func CenterInBox(coords *v3.Matrix, box *Box, indexes []int) {
+	sel := coords
+	if len(indexes) > 0 {
+		sel = v3.Zeros(len(indexes))
+		sel.SomeVecs(coords, indexes)
+	}
+	c, err := CenterOfMass(sel)
+	if err != nil {
+		panic(err.Error()) //Can't really happen with no masses given.
+	}
+	c.Sub(box.Center(), c)
+	coords.AddVec(coords, c)
+}
+
+//Unwrapper removes the jumps of atoms across the periodic boundaries
+//in consecutive frames of a trajectory, so the atoms follow continuous
+//paths.
+type Unwrapper struct {
+	prevraw *v3.Matrix //The last frame, as given.
+	prev    *v3.Matrix //The last frame, unwrapped.
+}
+
+//NewUnwrapper returns a new Unwrapper. The first frame given to it
+//is used as the reference.
+func NewUnwrapper() *Unwrapper {
+	return new(Unwrapper)
+}
+
+//Unwrap replaces each point in coords with the image of it which is closest to the
+//point in the previous frame given to the Unwrapper.
+func (U *Unwrapper) Unwrap(coords *v3.Matrix, box *Box) {
+	if U.prev == nil || U.prev.NVecs() != coords.NVecs() {
+		U.prevraw = v3.Zeros(coords.NVecs())
+		U.prev = v3.Zeros(coords.NVecs())
+		U.prevraw.Copy(coords)
+		U.prev.Copy(coords)
+		return
+	}
+	for i := 0; i < coords.NVecs(); i++ {
+		d := box.minImage([3]float64{coords.At(i, 0) - U.prevraw.At(i, 0), coords.At(i, 1) - U.prevraw.At(i, 1), coords.At(i, 2) - U.prevraw.At(i, 2)})
+		for j := 0; j < 3; j++ {
+			U.prevraw.Set(i, j, coords.At(i, j))
+			coords.Set(i, j, U.prev.At(i, j)+d[j])
+		}
+	}
+	U.prev.Copy(coords)
+}
+
+//PBCOptions contains the treatments that a PBCTraj applies
+//to each frame. They are applied in the order in which they appear in the struct.
+type PBCOptions struct {
+	Whole  bool  //Make whole the molecules broken by the periodic boundaries.
+	Unwrap bool  //Remove the jumps of atoms across the boundaries between frames. Can't be used with Wrap.
+	Center []int //If not nil, the indexes of the atoms whose centroid is put at the center of the box.
+	Wrap   bool  //Put the centroid of each molecule in the box.
+}
+
+//PBCTraj is a trajectory that reads frames from another trajectory, and applies
+//to them periodic boundary conditions treatments, like making molecules whole, removing jumps, and
+//centering. It is similar to the -pbc and -center options of gmx trjconv.
+//The molecules are defined by the bonds of the topology given, at the moment the PBCTraj is created.
+type PBCTraj struct {
+	traj      BoxTraj
+	opts      PBCOptions
+	frags     []*fragment
+	unwrapper *Unwrapper
+	buffer    *v3.Matrix
+}
+
+//NewPBCTraj returns a PBCTraj that reads from traj, which must correspond to the topology mol, and applies to the frames
+//the treatments in opts.
+func NewPBCTraj(traj BoxTraj, mol Atomer, opts *PBCOptions) (*PBCTraj, error) {
+	if traj.Len() != mol.Len() {
+		return nil, CError{fmt.Sprintf("Trajectory (%d) and topology (%d) don't have the same number of atoms", traj.Len(), mol.Len()), []string{"NewPBCTraj"}}
+	}
+	if opts == nil {
+		opts = &PBCOptions{Whole: true}
+	}
+	if opts.Unwrap && opts.Wrap {
+		return nil, CError{"Can't both unwrap and wrap the trajectory", []string{"NewPBCTraj"}}
+	}
+	for _, v := range opts.Center {
+		if v < 0 || v >= mol.Len() {
+			return nil, CError{fmt.Sprintf("Index to center out of range: %d", v), []string{"NewPBCTraj"}}
+		}
+	}
+	P := &PBCTraj{traj: traj, opts: *opts, frags: fragments(mol), buffer: v3.Zeros(traj.Len())}
+	if opts.Unwrap {
+		P.unwrapper = NewUnwrapper()
+	}
+	return P, nil
+}
+
+//Readable returns true if the underlying trajectory is readable.
+func (P *PBCTraj) Readable() bool {
+	return P.traj.Readable()
+}
+
+//Len returns the number of atoms per frame.
+func (P *PBCTraj) Len() int {
+	return P.traj.Len()
+}
+
+//Box returns the box of the last frame read.
+func (P *PBCTraj) Box() *Box {
+	return P.traj.Box()
+}
+
+//Next reads the next frame of the underlying trajectory, applies the treatments to it, and
+//puts the result in coords. If coords is nil, the frame is still read and processed, as it may
+//be needed to unwrap the following ones. An error is returned if the frame has no box.
+func (P *PBCTraj) Next(coords *v3.Matrix) error {
+	if err := P.traj.Next(P.buffer); err != nil {
+		return errDecorate(err, "PBCTraj.Next")
+	}
+	box := P.traj.Box()
+	if box == nil {
+		return CError{"The frame read has no periodic box", []string{"PBCTraj.Next"}}
+	}
+	if P.opts.Whole {
+		makeWhole(P.buffer, P.frags, box)
+	}
+	if P.opts.Unwrap {
+		P.unwrapper.Unwrap(P.buffer, box)
+	}
+	if P.opts.Center != nil {
+		CenterInBox(P.buffer, box, P.opts.Center)
+	}
+	if P.opts.Wrap {
+		wrapFragments(P.buffer, P.frags, box)
+	}
+	if coords != nil {
+		coords.Copy(P.buffer)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+/*
+ * pbc_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chem
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//bruteMinDist obtains the minimum image distance by checking many images.
+func bruteMinDist(box *Box, a, b *v3.Matrix) float64 {
+	min := math.Inf(1)
+	for i := -6.0; i <= 6; i++ {
+		for j := -6.0; j <= 6; j++ {
+			for k := -6.0; k <= 6; k++ {
+				d := 0.0
+				for l := 0; l < 3; l++ {
+					c := b.At(0, l) - a.At(0, l) + i*box.At(0, l) + j*box.At(1, l) + k*box.At(2, l)
+					d += c * c
+				}
+				min = math.Min(min, math.Sqrt(d))
+			}
+		}
+	}
+	return min
+}
+
+func TestMinImage(Te *testing.T) {
+	ortho, _ := NewBoxFromParams(20, 25, 30, 90, 90, 90)
+	tric, _ := NewBoxFromParams(20, 25, 30, 70, 80, 55)
+	//a truncated octahedron-like cell, as generated by GROMACS
+	octa, _ := NewBoxFromParams(30, 30, 30, 70.53, 109.47, 70.53)
+	a, b := v3.Zeros(1), v3.Zeros(1)
+	for _, box := range []*Box{ortho, tric, octa} {
+		for n := 0; n < 500; n++ {
+			for j := 0; j < 3; j++ {
+				a.Set(0, j, rand.Float64()*40-20)
+				b.Set(0, j, rand.Float64()*40-20)
+			}
+			d, bd := box.Dist(a, b), bruteMinDist(box, a, b)
+			if math.Abs(d-bd) > 1e-8 {
+				Te.Fatalf("Wrong minimum image distance %f, expected %f, box:\n%v", d, bd, box)
+			}
+			vec := v3.Zeros(1)
+			box.MinImageVec(vec, a, b)
+			if math.Abs(vec.Norm(2)-bd) > 1e-8 {
+				Te.Fatalf("Wrong minimum image vector %v, expected norm %f", vec, bd)
+			}
+		}
+	}
+	if c := ortho.MaxCutoff(); math.Abs(c-10) > 1e-8 {
+		Te.Errorf("Wrong maximum cutoff: %f", c)
+	}
+}
+
+//TestPBCBonds checks bonds and distances for a water split by the box boundaries.
+func TestPBCBonds(Te *testing.T) {
+	box, _ := NewBoxFromParams(20, 20, 20, 90, 90, 90)
+	ats := []*Atom{{Symbol: "O", MolID: 1, Molname: "SOL"}, {Symbol: "H", MolID: 1, Molname: "SOL"}, {Symbol: "H", MolID: 1, Molname: "SOL"},
+		{Symbol: "N", MolID: 2, Molname: "NH4"}}
+	coords, _ := v3.NewMatrix([]float64{
+		0.1, 10, 0.5,
+		19.3, 10.5, 0.5,
+		0.4, 9.1, 0.5,
+		10, 10, 10,
+	})
+	if err := NewTopology(0, 1).AssignBonds(nil); err != nil {
+		Te.Errorf("Assigning bonds to an empty topology should do nothing, got %v", err)
+	}
+	top := NewTopology(0, 1, ats)
+	if err := top.AssignBonds(coords); err != nil {
+		Te.Fatal(err)
+	}
+	if len(top.Atom(0).Bonds) != 1 {
+		Te.Fatalf("Without PBC, the oxygen should have 1 bond, it has %d", len(top.Atom(0).Bonds))
+	}
+	top = NewTopology(0, 1, []*Atom{ats[0].copyNoBonds(), ats[1].copyNoBonds(), ats[2].copyNoBonds(), ats[3].copyNoBonds()})
+	if err := top.AssignBondsPBC(coords, box); err != nil {
+		Te.Fatal(err)
+	}
+	if len(top.Atom(0).Bonds) != 2 {
+		Te.Fatalf("With PBC, the oxygen should have 2 bonds, it has %d", len(top.Atom(0).Bonds))
+	}
+	//The N is 10 A away from the reference without PBC but 9.9 with PBC.
+	ref := v3.Zeros(1)
+	ref.Set(0, 0, 0.1)
+	ref.Set(0, 1, 10)
+	ref.Set(0, 2, 10)
+	test := v3.Zeros(1)
+	test.Set(0, 0, 10.2)
+	test.Set(0, 1, 10)
+	test.Set(0, 2, 10)
+	if d := MolShortestDistPBC(test, ref, box); math.Abs(d-9.9) > 1e-8 {
+		Te.Errorf("Wrong minimum image distance %f", d)
+	}
+	//DistRank of the water with respect to the N
+	mol, _ := NewMolecule([]*v3.Matrix{coords}, top, nil)
+	coords.Set(3, 0, 0.1)
+	coords.Set(3, 2, 17)
+	rank := DistRank(coords, mol, []int{3}, []string{"SOL"}, 8)
+	if rank.Len() != 0 {
+		Te.Errorf("Without PBC, no water should be found, found %d", rank.Len())
+	}
+	rank = DistRankPBC(coords, box, mol, []int{3}, []string{"SOL"}, 8)
+	if rank.Len() != 1 || math.Abs(rank.Distance(0)-3.5) > 1e-8 {
+		Te.Errorf("With PBC the water should be found at 3.5 A, got %v", rank)
+	}
+	//Nothing can be within a zero cutoff, or close to an empty reference.
+	if rank = DistRankPBC(coords, box, mol, []int{3}, []string{"SOL"}, 0); rank.Len() != 0 {
+		Te.Errorf("With a zero cutoff no water should be found, found %d", rank.Len())
+	}
+	if rank = DistRank(coords, mol, nil, []string{"SOL"}, 8); rank.Len() != 0 {
+		Te.Errorf("Without reference atoms no water should be found, found %d", rank.Len())
+	}
+}
+
+func (A *Atom) copyNoBonds() *Atom {
+	r := new(Atom)
+	r.Copy(A)
+	r.Bonds = nil
+	return r
+}
+
+//splitWaters returns a topology with bonds, and the coordinates, of 2 waters split by
+//the boundaries of a 20 A cubic box, which is also returned.
+func splitWaters(Te *testing.T) (*Topology, *v3.Matrix, *Box) {
+	box, _ := NewBoxFromParams(20, 20, 20, 90, 90, 90)
+	ats := make([]*Atom, 0, 6)
+	for i, s := range []string{"O", "H", "H", "O", "H", "H"} {
+		ats = append(ats, &Atom{Symbol: s, MolID: i/3 + 1, Molname: "SOL"})
+	}
+	coords, _ := v3.NewMatrix([]float64{
+		0.1, 10, 0.5,
+		19.3, 10.5, 0.5,
+		0.4, 9.1, 0.5,
+		5, 19.7, 19.8,
+		5.9, 0.2, 19.8,
+		5, 19.7, 0.7,
+	})
+	top := NewTopology(0, 1, ats)
+	if err := top.AssignBondsPBC(coords, box); err != nil {
+		Te.Fatal(err)
+	}
+	return top, coords, box
+}
+
+func isWhole(coords *v3.Matrix) bool {
+	for w := 0; w < 2; w++ {
+		for h := 1; h <= 2; h++ {
+			if dist(coords.VecView(w*3), coords.VecView(w*3+h), v3.Zeros(1)) > 1.5 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestMakeWhole(Te *testing.T) {
+	top, coords, box := splitWaters(Te)
+	if isWhole(coords) {
+		Te.Fatal("The waters should start broken")
+	}
+	MakeWhole(coords, top, box)
+	if !isWhole(coords) {
+		Te.Errorf("The waters were not made whole: %v", coords)
+	}
+	WrapMolecules(coords, top, box)
+	if !isWhole(coords) {
+		Te.Errorf("Wrapping the molecules broke them: %v", coords)
+	}
+	for w := 0; w < 2; w++ {
+		c, _ := CenterOfMass(coords.View(w*3, 0, 3, 3))
+		for j := 0; j < 3; j++ {
+			if c.At(0, j) < 0 || c.At(0, j) >= 20 {
+				Te.Errorf("Water %d is not in the box: %v", w, c)
+			}
+		}
+	}
+}
+
+func TestCenterInBox(Te *testing.T) {
+	box, _ := NewBoxFromParams(20, 20, 20, 90, 90, 90)
+	coords, _ := v3.NewMatrix([]float64{1, 1, 1, 3, 1, 1, 5, 4, 1})
+	CenterInBox(coords, box, []int{0, 1})
+	if coords.At(0, 0) != 9 || coords.At(0, 1) != 10 || coords.At(2, 2) != 10 {
+		Te.Errorf("Wrong centering on the selected atoms: %v", coords)
+	}
+	//An empty selection means all the atoms, as nil does.
+	for _, sel := range [][]int{{}, nil} {
+		CenterInBox(coords, box, sel)
+		c, _ := CenterOfMass(coords)
+		for j := 0; j < 3; j++ {
+			if math.Abs(c.At(0, j)-10) > 1e-10 {
+				Te.Errorf("Wrong centering on all the atoms: %v", c)
+			}
+		}
+	}
+}
+
+func TestUnwrap(Te *testing.T) {
+	box, _ := NewBoxFromParams(10, 10, 10, 90, 90, 80)
+	U := NewUnwrapper()
+	c := v3.Zeros(1)
+	var offset float64 //The first frame is the reference, and it might be moved by the wrapping.
+	for i := 0; i < 30; i++ {
+		x := float64(i) * 1.5
+		c.Set(0, 0, x)
+		c.Set(0, 1, 2)
+		c.Set(0, 2, 3)
+		box.Wrap(c)
+		U.Unwrap(c, box)
+		if i == 0 {
+			offset = c.At(0, 0)
+		}
+		if math.Abs(c.At(0, 0)-offset-x) > 1e-8 || math.Abs(c.At(0, 1)-2) > 1e-8 {
+			Te.Fatalf("Frame %d: unwrapped point %v, expected %f 2 3", i, c, x+offset)
+		}
+	}
+}
+
+func TestPBCTraj(Te *testing.T) {
+	top, coords, box := splitWaters(Te)
+	frames := []*v3.Matrix{coords, v3.Zeros(6)}
+	frames[1].Copy(coords)
+	mol, _ := NewMolecule(frames, top, nil)
+	mol.Boxes = []*Box{box, box}
+	traj, err := NewPBCTraj(mol, mol, &PBCOptions{Whole: true, Center: []int{0, 1, 2}, Wrap: true})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	read := v3.Zeros(6)
+	for i := 0; ; i++ {
+		if err := traj.Next(read); err != nil {
+			if _, ok := err.(LastFrameError); ok {
+				if i != 2 {
+					Te.Errorf("Read %d frames, expected 2", i)
+				}
+				break
+			}
+			Te.Fatal(err)
+		}
+		if !isWhole(read) {
+			Te.Errorf("The waters are not whole: %v", read)
+		}
+		c, _ := CenterOfMass(read.View(0, 0, 3, 3))
+		if c.Sub(c, box.Center()); c.Norm(2) > 1e-8 {
+			Te.Errorf("The first water is not centered: %v", c)
+		}
+	}
+	if _, err := NewPBCTraj(mol, mol, &PBCOptions{Unwrap: true, Wrap: true}); err == nil {
+		Te.Errorf("Unwrap and Wrap together should not be allowed")
+	}
+}
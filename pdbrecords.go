@@ -0,0 +1,355 @@
+/*
+ * pdbrecords.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//PDBOptions contains options for reading PDB files.
+type PDBOptions struct {
+	Additional bool //Read the element symbol and formal charge columns, if present.
+	//AltLoc determines which alternative locations are kept: 0 keeps the first location found for
+	//each atom, '*' keeps all of them, and any other value keeps only the location with that label.
+	//Atoms without alternative locations are always kept.
+	AltLoc byte
+}
+
+//PDBResidue identifies a residue in a PDB record.
+type PDBResidue struct {
+	Name    string
+	Chain   string
+	ID      int
+	InsCode byte //0 if there is no insertion code.
+}
+
+//SSBond is a disulfide bond, from a SSBOND record.
+type SSBond struct {
+	Res1, Res2 PDBResidue
+	Sym1, Sym2 string //Symmetry operators for each residue.
+	Length     float64
+}
+
+//Helix is a helix, from a HELIX record.
+type Helix struct {
+	ID        string
+	Init, End PDBResidue
+	Class     int //1 is right-handed alpha, 5 is 3-10, see the PDB format documentation for the others.
+	Comment   string
+	Length    int
+}
+
+//Strand is a strand in a sheet, from a SHEET record.
+type Strand struct {
+	Sheet        string //The ID of the sheet.
+	Strand       int
+	NStrands     int //the number of strands in the sheet.
+	Init, End    PDBResidue
+	Sense        int    //0 for the first strand, 1 for parallel and -1 for anti-parallel.
+	Registration string //The registration part of the record (columns 42-70), kept as is.
+}
+
+//Seqres is the sequence of residues of a chain, from SEQRES records.
+type Seqres struct {
+	Chain    string
+	Residues []string
+}
+
+//PDBRecords contains the information in PDB records other than atoms, coordinates,
+//connectivity and the unit cell.
+type PDBRecords struct {
+	Remarks []string //Everything after the "REMARK" in each REMARK line.
+	Seqres  []*Seqres
+	SSBonds []*SSBond
+	Helices []*Helix
+	Strands []*Strand
+}
+
+//Copy returns a copy of the records.
+func (P *PDBRecords) Copy() *PDBRecords {
+	r := &PDBRecords{Remarks: append([]string(nil), P.Remarks...)}
+	for _, v := range P.Seqres {
+		r.Seqres = append(r.Seqres, &Seqres{v.Chain, append([]string(nil), v.Residues...)})
+	}
+	for _, v := range P.SSBonds {
+		c := *v
+		r.SSBonds = append(r.SSBonds, &c)
+	}
+	for _, v := range P.Helices {
+		c := *v
+		r.Helices = append(r.Helices, &c)
+	}
+	for _, v := range P.Strands {
+		c := *v
+		r.Strands = append(r.Strands, &c)
+	}
+	return r
+}
+
+//pdbCol returns the columns from a to b (0-based, b excluded) of line, without
+//surrounding spaces. It returns the empty string for the columns beyond the end of the line.
+func pdbCol(line string, a, b int) string {
+	if a >= len(line) {
+		return ""
+	}
+	if b > len(line) {
+		b = len(line)
+	}
+	return strings.TrimSpace(line[a:b])
+}
+
+//pdbInt reads an integer from the columns a to b of line, or returns 0.
+func pdbInt(line string, a, b int) int {
+	i, _ := strconv.Atoi(pdbCol(line, a, b))
+	return i
+}
+
+//pdbByte returns the byte in the column a of line, or 0 if it's a space or beyond the end of the line.
+func pdbByte(line string, a int) byte {
+	if a >= len(line) || line[a] == ' ' {
+		return 0
+	}
+	return line[a]
+}
+
+func pdbResidue(line string, name, chain, id, icode int) PDBResidue {
+	return PDBResidue{Name: pdbCol(line, name, name+3), Chain: pdbCol(line, chain, chain+1), ID: pdbInt(line, id, id+4), InsCode: pdbByte(line, icode)}
+}
+
+//readRecord parses the line if it is a SEQRES, SSBOND, HELIX, SHEET or REMARK record. It
+//returns false if the line is none of those.
+func (P *PDBRecords) readRecord(line string) bool {
+	line = strings.TrimRight(line, "\r\n")
+	switch {
+	case strings.HasPrefix(line, "REMARK"):
+		if !strings.Contains(line, "WRITTEN WITH GOCHEM") {
+			P.Remarks = append(P.Remarks, line[6:])
+		}
+	case strings.HasPrefix(line, "SEQRES"):
+		chain := pdbCol(line, 11, 12)
+		var s *Seqres
+		if len(P.Seqres) > 0 && P.Seqres[len(P.Seqres)-1].Chain == chain {
+			s = P.Seqres[len(P.Seqres)-1]
+		} else {
+			s = &Seqres{Chain: chain}
+			P.Seqres = append(P.Seqres, s)
+		}
+		s.Residues = append(s.Residues, strings.Fields(pdbCol(line, 19, 80))...)
+	case strings.HasPrefix(line, "SSBOND"):
+		s := &SSBond{Res1: pdbResidue(line, 11, 15, 17, 21), Res2: pdbResidue(line, 25, 29, 31, 35)}
+		s.Sym1, s.Sym2 = pdbCol(line, 59, 65), pdbCol(line, 66, 72)
+		s.Length, _ = strconv.ParseFloat(pdbCol(line, 73, 78), 64)
+		P.SSBonds = append(P.SSBonds, s)
+	case strings.HasPrefix(line, "HELIX"):
+		h := &Helix{ID: pdbCol(line, 11, 14), Init: pdbResidue(line, 15, 19, 21, 25), End: pdbResidue(line, 27, 31, 33, 37)}
+		h.Class, h.Comment, h.Length = pdbInt(line, 38, 40), pdbCol(line, 40, 70), pdbInt(line, 71, 76)
+		P.Helices = append(P.Helices, h)
+	case strings.HasPrefix(line, "SHEET"):
+		s := &Strand{Strand: pdbInt(line, 7, 10), Sheet: pdbCol(line, 11, 14), NStrands: pdbInt(line, 14, 16)}
+		s.Init, s.End = pdbResidue(line, 17, 21, 22, 26), pdbResidue(line, 28, 32, 33, 37)
+		s.Sense = pdbInt(line, 38, 40)
+		if len(line) > 41 {
+			s.Registration = strings.TrimRight(line[41:minInt(len(line), 70)], " ")
+		}
+		P.Strands = append(P.Strands, s)
+	default:
+		return false
+	}
+	return true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func insCode(b byte) string {
+	if b == 0 {
+		return " "
+	}
+	return string(b)
+}
+
+//resString returns the residue name, chain, number and insertion code in the format used by HELIX
+//records, or by SHEET records if sheet is true.
+func (R PDBResidue) resString(sheet bool) string {
+	if sheet {
+		return fmt.Sprintf("%3s %1s%4d%s", R.Name, R.Chain, R.ID, insCode(R.InsCode))
+	}
+	return fmt.Sprintf("%3s %1s %4d%s", R.Name, R.Chain, R.ID, insCode(R.InsCode))
+}
+
+//write writes the records in PDB format, in the order required by the format.
+func (P *PDBRecords) write(out io.Writer) error {
+	lines := make([]string, 0, len(P.Remarks)+len(P.Helices)+len(P.Strands)+len(P.SSBonds))
+	for _, v := range P.Remarks {
+		lines = append(lines, "REMARK"+v)
+	}
+	for _, s := range P.Seqres {
+		for i := 0; i*13 < len(s.Residues); i++ {
+			res := s.Residues[i*13 : minInt(len(s.Residues), (i+1)*13)]
+			var b strings.Builder
+			for _, r := range res {
+				fmt.Fprintf(&b, "%3s ", r)
+			}
+			lines = append(lines, fmt.Sprintf("SEQRES %3d %1s %4d  %s", i+1, s.Chain, len(s.Residues), strings.TrimRight(b.String(), " ")))
+		}
+	}
+	for i, h := range P.Helices {
+		lines = append(lines, fmt.Sprintf("HELIX  %3d %3s %s %s%2d%-30s %5d", i+1, h.ID, h.Init.resString(false), h.End.resString(false), h.Class, h.Comment, h.Length))
+	}
+	for _, s := range P.Strands {
+		line := fmt.Sprintf("SHEET  %3d %3s%2d %s %s%2d", s.Strand, s.Sheet, s.NStrands, s.Init.resString(true), s.End.resString(true), s.Sense)
+		if s.Registration != "" {
+			line += " " + s.Registration
+		}
+		lines = append(lines, line)
+	}
+	for i, s := range P.SSBonds {
+		lines = append(lines, fmt.Sprintf("SSBOND %3d %3s %1s %4d%s   %3s %1s %4d%s                       %6s %6s %5.2f", i+1,
+			s.Res1.Name, s.Res1.Chain, s.Res1.ID, insCode(s.Res1.InsCode), s.Res2.Name, s.Res2.Chain, s.Res2.ID, insCode(s.Res2.InsCode),
+			s.Sym1, s.Sym2, s.Length))
+	}
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(out, l); err != nil {
+			return CError{"Failed to write in io.Writer: " + err.Error(), []string{"io.Writer.Write", "PDBRecords.write"}}
+		}
+	}
+	return nil
+}
+
+//readCONECT returns the pairs of atom serial numbers bonded according to a CONECT record.
+func readCONECT(line string) [][2]int {
+	ret := make([][2]int, 0, 4)
+	at, err := strconv.Atoi(pdbCol(line, 6, 11))
+	if err != nil {
+		return ret
+	}
+	for i := 11; i+5 <= 31 && i < len(line); i += 5 {
+		b, err := strconv.Atoi(pdbCol(line, i, i+5))
+		if err != nil {
+			continue
+		}
+		ret = append(ret, [2]int{at, b})
+	}
+	return ret
+}
+
+//conectBonds adds to the atoms the bonds between the pairs of serial numbers in pairs. Pairs
+//are usually given twice, once per atom. Serials not present in the molecule are ignored.
+func conectBonds(atoms []*Atom, coords *v3.Matrix, pairs [][2]int) {
+	if len(pairs) == 0 {
+		return
+	}
+	byID := make(map[int]int, len(atoms))
+	for i := len(atoms) - 1; i >= 0; i-- {
+		byID[atoms[i].ID] = i //we keep the first atom with each serial.
+		atoms[i].index = i
+	}
+	done := make(map[[2]int]bool, len(pairs))
+	index := 0
+	for _, p := range pairs {
+		i, ok1 := byID[p[0]]
+		j, ok2 := byID[p[1]]
+		if !ok1 || !ok2 || i == j {
+			continue
+		}
+		if i > j {
+			i, j = j, i
+		}
+		if done[[2]int{i, j}] {
+			continue
+		}
+		done[[2]int{i, j}] = true
+		b := &Bond{Index: index, At1: atoms[i], At2: atoms[j]}
+		if coords != nil {
+			t := v3.Zeros(1)
+			t.Sub(coords.VecView(i), coords.VecView(j))
+			b.Dist = t.Norm(2)
+		}
+		atoms[i].Bonds = append(atoms[i].Bonds, b)
+		atoms[j].Bonds = append(atoms[j].Bonds, b)
+		index++
+	}
+}
+
+//conectNeeded returns true if the bond should be written in a CONECT record. That is, bonds
+//that involve HETATMs, and bonds between different residues, except for the peptide and phosphodiester
+//bonds of polymers.
+func conectNeeded(b *Bond) bool {
+	a1, a2 := b.At1, b.At2
+	if a1.Het || a2.Het {
+		return true
+	}
+	if a1.MolID == a2.MolID && a1.Chain == a2.Chain && a1.InsCode == a2.InsCode {
+		return false
+	}
+	names := [2]string{a1.Name, a2.Name}
+	if names == [2]string{"C", "N"} || names == [2]string{"N", "C"} || names == [2]string{"O3'", "P"} || names == [2]string{"P", "O3'"} {
+		return false
+	}
+	return true
+}
+
+//writeCONECT writes CONECT records for the bonds in mol that need them (see conectNeeded), using
+//the atom serial numbers.
+func writeCONECT(out io.Writer, mol Atomer) error {
+	for i := 0; i < mol.Len(); i++ {
+		at := mol.Atom(i)
+		bonded := make([]int, 0, len(at.Bonds))
+		for _, b := range at.Bonds {
+			if !conectNeeded(b) {
+				continue
+			}
+			other := b.At1
+			if other == at {
+				other = b.At2
+			}
+			bonded = append(bonded, other.ID)
+		}
+		for j := 0; j < len(bonded); j += 4 {
+			var s strings.Builder
+			fmt.Fprintf(&s, "CONECT%5d", at.ID)
+			for _, v := range bonded[j:minInt(len(bonded), j+4)] {
+				fmt.Fprintf(&s, "%5d", v)
+			}
+			s.WriteString("\n")
+			if _, err := out.Write([]byte(s.String())); err != nil {
+				return CError{"Failed to write in io.Writer: " + err.Error(), []string{"io.Writer.Write", "writeCONECT"}}
+			}
+		}
+	}
+	return nil
+}
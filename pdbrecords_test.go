@@ -0,0 +1,126 @@
+/*
+ * pdbrecords_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chem
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPDBRecords = `REMARK   2 RESOLUTION.    1.80 ANGSTROMS.
+SEQRES   1 A   15  CYS ALA GLY CYS ALA GLY CYS ALA GLY CYS ALA GLY CYS
+SEQRES   2 A   15  ALA GLY
+HELIX    1   1 CYS A   10  ALA A   11  1                                   2
+SHEET    1   A 2 GLY A  12A CYS A  13   0
+SHEET    2   A 2 CYS A  10  ALA A  11 -1  N  CYS A  10   O  GLY A  12A
+SSBOND   1 CYS A   10    CYS A   13                          1555   1555  2.04
+CRYST1   40.000   40.000   40.000  90.00  90.00  90.00 P 1           1
+ATOM      1  N   CYS A  10       1.000   1.000   1.000  1.00 10.00           N
+ATOM      2  SG ACYS A  10       2.000   1.000   1.000  0.60 10.00           S
+ATOM      3  SG BCYS A  10       2.200   1.000   1.000  0.40 10.00           S
+ATOM      4  CA  ALA A  11       3.000   1.000   1.000  1.00 10.00           C
+ATOM      5  CA  GLY A  12A      4.000   1.000   1.000  1.00 10.00           C
+ATOM      6  SG  CYS A  13       4.000   1.000   3.000  1.00 10.00           S
+HETATM    7 ZN    ZN A 101       6.000   1.000   1.000  1.00 20.00          ZN2+
+HETATM    8  O1  SO4 A 102       7.000   1.000   1.000  1.00 20.00           O1-
+HETATM    9  S   SO4 A 102       8.000   1.000   1.000  1.00 20.00           S
+TER
+CONECT    2    6
+CONECT    6    2
+CONECT    8    9
+CONECT    9    8
+END
+`
+
+func TestPDBRecords(Te *testing.T) {
+	mol, err := PDBReadWithOptions(strings.NewReader(testPDBRecords), &PDBOptions{Additional: true})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if mol.Len() != 8 {
+		Te.Fatalf("Read %d atoms, expected 8 (one alternative location dropped)", mol.Len())
+	}
+	if sg := mol.Atom(1); sg.Char16 != 'A' || sg.Occupancy != 0.6 {
+		Te.Errorf("Wrong alternative location kept: %v", sg)
+	}
+	if gly := mol.Atom(3); gly.InsCode != 'A' || gly.MolID != 12 {
+		Te.Errorf("Wrong insertion code or residue number: %c %d", gly.InsCode, gly.MolID)
+	}
+	if zn, o := mol.Atom(5), mol.Atom(6); zn.Charge != 2 || zn.Symbol != "Zn" || o.Charge != -1 {
+		Te.Errorf("Wrong formal charges or symbols: %v %v", zn, o)
+	}
+	if len(mol.Atom(1).Bonds) != 1 || mol.Atom(1).Bonds[0].Cross(mol.Atom(1)) != mol.Atom(4) || len(mol.Atom(6).Bonds) != 1 {
+		Te.Errorf("Wrong bonds from CONECT records")
+	}
+	if mol.Boxes == nil {
+		Te.Errorf("The CRYST1 record was not read")
+	}
+	rec := mol.Records
+	if rec == nil {
+		Te.Fatal("No records read")
+	}
+	if len(rec.Remarks) != 1 || len(rec.Seqres) != 1 || len(rec.Seqres[0].Residues) != 15 || len(rec.Helices) != 1 || len(rec.Strands) != 2 || len(rec.SSBonds) != 1 {
+		Te.Fatalf("Wrong records read: %+v", rec)
+	}
+	if s := rec.Strands[0]; s.Init != (PDBResidue{"GLY", "A", 12, 'A'}) || s.End.ID != 13 || s.Sense != 0 {
+		Te.Errorf("Wrong strand read: %+v", s)
+	}
+	if s := rec.Strands[1]; s.Sense != -1 || s.Registration != " N  CYS A  10   O  GLY A  12A" {
+		Te.Errorf("Wrong strand registration read: %+v", s)
+	}
+	if s := rec.SSBonds[0]; s.Res2.ID != 13 || s.Length != 2.04 || s.Sym1 != "1555" {
+		Te.Errorf("Wrong SSBOND read: %+v", s)
+	}
+	//All the alternative locations, and only the B ones
+	all, err := PDBRead(strings.NewReader(testPDBRecords), true)
+	if err != nil || all.Len() != 9 {
+		Te.Errorf("All the alternative locations should be kept by PDBRead, got %d atoms, %v", all.Len(), err)
+	}
+	B, err := PDBReadWithOptions(strings.NewReader(testPDBRecords), &PDBOptions{AltLoc: 'B'})
+	if err != nil || B.Len() != 8 || B.Atom(1).Char16 != 'B' {
+		Te.Errorf("Only the B location should be kept: %v", err)
+	}
+	//Round-trip
+	var out bytes.Buffer
+	if err := PDBBoxWrite(&out, mol.Coords[0], mol, mol.Bfactors[0], mol.Boxes[0]); err != nil {
+		Te.Fatal(err)
+	}
+	mol2, err := PDBReadWithOptions(&out, &PDBOptions{Additional: true})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if !reflect.DeepEqual(mol.Records, mol2.Records) {
+		Te.Errorf("The records changed in the round-trip:\n%+v\n%+v", mol.Records, mol2.Records)
+	}
+	if mol2.Len() != mol.Len() || mol2.Boxes == nil {
+		Te.Fatalf("Read back %d atoms, expected %d", mol2.Len(), mol.Len())
+	}
+	for i := 0; i < mol.Len(); i++ {
+		a, b := mol.Atom(i), mol2.Atom(i)
+		if a.Name != b.Name || a.InsCode != b.InsCode || a.Char16 != b.Char16 || a.Charge != b.Charge || a.MolID != b.MolID || len(a.Bonds) != len(b.Bonds) {
+			Te.Errorf("Atom %d changed in the round trip: %v %v", i, a, b)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+/*
+ * prmtop.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/***AMBER prmtop part***/
+
+//The factor between the charges in a prmtop file and elementary charges.
+const amberCharge = 18.2223
+
+//Indexes of some of the values in the POINTERS section of a prmtop file.
+const (
+	prmNatom = 0
+	prmNres  = 11
+	prmIfbox = 27
+)
+
+var prmFormat = regexp.MustCompile(`(\d+)[aAiIeEfF](\d+)`)
+
+//PrmtopFileRead reads an AMBER parameter/topology (prmtop) file. See PrmtopRead.
+func PrmtopFileRead(name string) (*Topology, *Box, error) {
+	f, err := OpenCompressed(name)
+	if err != nil {
+		return nil, nil, errDecorate(err, "PrmtopFileRead")
+	}
+	defer f.Close()
+	top, box, err := PrmtopRead(f)
+	if err != nil {
+		return nil, nil, errDecorate(err, "PrmtopFileRead")
+	}
+	return top, box, nil
+}
+
+//PrmtopRead reads an AMBER parameter/topology file in the current (%FLAG) format from r, and returns a Topology
+//with the atom names and types, residues, charges (in elementary charges), masses and bonds (with order 0, as
+//the prmtop doesn't give bond orders). The elements are taken from the atomic numbers, if present, and guessed
+//from the names otherwise. If the system is periodic, the box in the file is also returned, otherwise the box is nil.
+func PrmtopRead(r io.Reader) (*Topology, *Box, error) {
+	flags, err := prmtopSections(r)
+	if err != nil {
+		return nil, nil, errDecorate(err, "PrmtopRead")
+	}
+	pointers, err := prmInts(flags, "POINTERS", prmIfbox+1)
+	if err != nil {
+		return nil, nil, errDecorate(err, "PrmtopRead")
+	}
+	natoms, nres := pointers[prmNatom], pointers[prmNres]
+	names, err := prmStrings(flags, "ATOM_NAME", natoms)
+	if err != nil {
+		return nil, nil, errDecorate(err, "PrmtopRead")
+	}
+	charges, err := prmFloats(flags, "CHARGE", natoms)
+	if err != nil {
+		return nil, nil, errDecorate(err, "PrmtopRead")
+	}
+	masses, err := prmFloats(flags, "MASS", natoms)
+	if err != nil {
+		return nil, nil, errDecorate(err, "PrmtopRead")
+	}
+	resnames, err := prmStrings(flags, "RESIDUE_LABEL", nres)
+	if err != nil {
+		return nil, nil, errDecorate(err, "PrmtopRead")
+	}
+	respointers, err := prmInts(flags, "RESIDUE_POINTER", nres)
+	if err != nil {
+		return nil, nil, errDecorate(err, "PrmtopRead")
+	}
+	//These are optional.
+	types, _ := prmStrings(flags, "AMBER_ATOM_TYPE", natoms)
+	zs, _ := prmInts(flags, "ATOMIC_NUMBER", natoms)
+	atoms := make([]*Atom, natoms)
+	var charge float64
+	res := -1
+	for i := range atoms {
+		for res+1 < nres && respointers[res+1]-1 <= i {
+			res++
+		}
+		if res < 0 {
+			return nil, nil, CError{"Atoms before the first residue", []string{"PrmtopRead"}}
+		}
+		at := &Atom{Name: names[i], ID: i + 1, MolID: res + 1, Molname: resnames[res], Mass: masses[i]}
+		at.Molname1 = three2OneLetter[at.Molname]
+		at.Charge = charges[i] / amberCharge
+		charge += at.Charge
+		if types != nil {
+			at.Type = types[i]
+		}
+		if zs != nil && zs[i] > 0 && zs[i] < len(zSymbol) {
+			at.Symbol = zSymbol[zs[i]]
+		} else {
+			at.Symbol, _ = symbolFromName(strings.ToUpper(at.Name))
+		}
+		atoms[i] = at
+	}
+	nbonds := 0
+	for _, section := range []string{"BONDS_INC_HYDROGEN", "BONDS_WITHOUT_HYDROGEN"} {
+		b, err := prmInts(flags, section, 0)
+		if err != nil {
+			return nil, nil, errDecorate(err, "PrmtopRead")
+		}
+		//Each bond is given by 3 numbers: the coordinate indexes (3 times the atom index) of both atoms, and the bond type.
+		for j := 0; j+2 < len(b); j += 3 {
+			i1, i2 := b[j]/3, b[j+1]/3
+			if i1 < 0 || i2 < 0 || i1 >= natoms || i2 >= natoms {
+				return nil, nil, CError{fmt.Sprintf("Wrong atom in %s section", section), []string{"PrmtopRead"}}
+			}
+			bond := &Bond{Index: nbonds, At1: atoms[i1], At2: atoms[i2]}
+			atoms[i1].Bonds = append(atoms[i1].Bonds, bond)
+			atoms[i2].Bonds = append(atoms[i2].Bonds, bond)
+			nbonds++
+		}
+	}
+	top := NewTopology(int(math.Round(charge)), 1, atoms)
+	top.FillIndexes()
+	if pointers[prmIfbox] == 0 {
+		return top, nil, nil
+	}
+	//The box section has the beta angle and the 3 lengths. The other angles are 90,
+	//except for truncated octahedra (IFBOX=2) where all the angles are the same.
+	bd, err := prmFloats(flags, "BOX_DIMENSIONS", 4)
+	if err != nil {
+		return nil, nil, errDecorate(err, "PrmtopRead")
+	}
+	alpha, gamma := 90.0, 90.0
+	if pointers[prmIfbox] == 2 {
+		alpha, gamma = bd[0], bd[0]
+	}
+	box, err := NewBoxFromParams(bd[1], bd[2], bd[3], alpha, bd[0], gamma)
+	if err != nil {
+		return nil, nil, errDecorate(err, "PrmtopRead")
+	}
+	return top, box, nil
+}
+
+//prmtopSections reads a prmtop file and returns the values, as strings, in each %FLAG section.
+func prmtopSections(r io.Reader) (map[string][]string, error) {
+	in := bufio.NewScanner(r)
+	in.Buffer(make([]byte, 0, 4096), 1<<20)
+	flags := make(map[string][]string)
+	flag := ""
+	count, width := 0, 0
+	for in.Scan() {
+		line := strings.TrimRight(in.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, "%FLAG"):
+			flag = strings.TrimSpace(line[5:])
+			flags[flag] = []string{}
+			width = 0
+		case strings.HasPrefix(line, "%FORMAT"):
+			m := prmFormat.FindStringSubmatch(line)
+			if m == nil {
+				return nil, CError{"Unknown format: " + line, []string{"prmtopSections"}}
+			}
+			count, _ = strconv.Atoi(m[1])
+			width, _ = strconv.Atoi(m[2])
+		case strings.HasPrefix(line, "%"):
+			continue //%VERSION and %COMMENT
+		default:
+			if flag == "" || width == 0 {
+				continue
+			}
+			for i := 0; i < count && i*width < len(line); i++ {
+				end := (i + 1) * width
+				if end > len(line) {
+					end = len(line)
+				}
+				flags[flag] = append(flags[flag], strings.TrimSpace(line[i*width:end]))
+			}
+		}
+	}
+	if err := in.Err(); err != nil {
+		return nil, CError{err.Error(), []string{"bufio.Scanner.Scan", "prmtopSections"}}
+	}
+	if _, ok := flags["POINTERS"]; !ok {
+		return nil, CError{"No POINTERS section found. Only the current prmtop format is supported", []string{"prmtopSections"}}
+	}
+	return flags, nil
+}
+
+//prmStrings returns the values in a section, checking that there are at least n of them.
+func prmStrings(flags map[string][]string, section string, n int) ([]string, error) {
+	s, ok := flags[section]
+	if !ok {
+		return nil, CError{fmt.Sprintf("Section %s not found", section), []string{"prmStrings"}}
+	}
+	if len(s) < n {
+		return nil, CError{fmt.Sprintf("Section %s has %d values, expected %d", section, len(s), n), []string{"prmStrings"}}
+	}
+	return s, nil
+}
+
+func prmInts(flags map[string][]string, section string, n int) ([]int, error) {
+	s, err := prmStrings(flags, section, n)
+	if err != nil {
+		return nil, errDecorate(err, "prmInts")
+	}
+	ret := make([]int, len(s))
+	for i, v := range s {
+		if ret[i], err = strconv.Atoi(v); err != nil {
+			return nil, CError{fmt.Sprintf("Wrong value in section %s: %s", section, v), []string{"strconv.Atoi", "prmInts"}}
+		}
+	}
+	return ret, nil
+}
+
+func prmFloats(flags map[string][]string, section string, n int) ([]float64, error) {
+	s, err := prmStrings(flags, section, n)
+	if err != nil {
+		return nil, errDecorate(err, "prmFloats")
+	}
+	ret := make([]float64, len(s))
+	for i, v := range s {
+		if ret[i], err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, CError{fmt.Sprintf("Wrong value in section %s: %s", section, v), []string{"strconv.ParseFloat", "prmFloats"}}
+		}
+	}
+	return ret, nil
+}
+
+/***End of AMBER prmtop part***/
@@ -0,0 +1,106 @@
+/*
+ * prmtop_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chem
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const testPrmtop = `%VERSION  VERSION_STAMP = V0001.000  DATE = 01/01/21  00:00:00
+%FLAG TITLE
+%FORMAT(20a4)
+TEST
+%FLAG POINTERS
+%FORMAT(10I8)
+       7       0       4       0       0       0       0       0       0       0
+       0       3       0       0       0       0       0       0       0       0
+       0       0       0       0       0       0       0       1       0       0
+       0
+%FLAG ATOM_NAME
+%FORMAT(20a4)
+O   H1  H2  O   H1  H2  Na+ 
+%FLAG CHARGE
+%FORMAT(5E16.8)
+ -1.51973982E+01  7.59869910E+00  7.59869910E+00 -1.51973982E+01  7.59869910E+00
+  7.59869910E+00  1.82223000E+01
+%FLAG ATOMIC_NUMBER
+%FORMAT(10I8)
+       8       1       1       8       1       1      11
+%FLAG MASS
+%FORMAT(5E16.8)
+  1.60000000E+01  1.00800000E+00  1.00800000E+00  1.60000000E+01  1.00800000E+00
+  1.00800000E+00  2.29900000E+01
+%FLAG RESIDUE_LABEL
+%FORMAT(20a4)
+WAT WAT Na+ 
+%FLAG RESIDUE_POINTER
+%FORMAT(10I8)
+       1       4       7
+%FLAG AMBER_ATOM_TYPE
+%FORMAT(20a4)
+OW  HW  HW  OW  HW  HW  Na+ 
+%FLAG BONDS_INC_HYDROGEN
+%FORMAT(10I8)
+       0       3       1       0       6       1       9      12       1       9
+      15       1
+%FLAG BONDS_WITHOUT_HYDROGEN
+%FORMAT(10I8)
+
+%FLAG BOX_DIMENSIONS
+%FORMAT(5E16.8)
+  9.00000000E+01  2.00000000E+01  2.10000000E+01  2.20000000E+01
+`
+
+func TestPrmtop(Te *testing.T) {
+	top, box, err := PrmtopRead(strings.NewReader(testPrmtop))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if top.Len() != 7 || top.Charge() != 1 {
+		Te.Fatalf("Read %d atoms with charge %d, expected 7 and 1", top.Len(), top.Charge())
+	}
+	o, h, na := top.Atom(3), top.Atom(4), top.Atom(6)
+	if o.Name != "O" || o.Symbol != "O" || o.Type != "OW" || o.MolID != 2 || o.Molname != "WAT" || len(o.Bonds) != 2 || math.Abs(o.Charge+0.834) > 1e-6 {
+		Te.Errorf("Wrong oxygen read: %v", o)
+	}
+	if h.Mass != 1.008 || h.Bonds[0].Cross(h) != o {
+		Te.Errorf("Wrong hydrogen read: %v", h)
+	}
+	if na.Symbol != "Na" || na.Molname != "Na+" || na.MolID != 3 || len(na.Bonds) != 0 {
+		Te.Errorf("Wrong ion read: %v", na)
+	}
+	if box == nil {
+		Te.Fatal("The box was not read")
+	}
+	a, b, c, alpha, beta, gamma := box.Params()
+	if math.Abs(a-20) > 1e-6 || math.Abs(b-21) > 1e-6 || math.Abs(c-22) > 1e-6 || math.Abs(alpha-90) > 1e-6 || math.Abs(beta-90) > 1e-6 || math.Abs(gamma-90) > 1e-6 {
+		Te.Errorf("Wrong box read: %v", box)
+	}
+	//Without a box
+	nobox := strings.Replace(testPrmtop, "       0       0       0       0       0       0       0       1       0       0", "       0       0       0       0       0       0       0       0       0       0", 1)
+	if _, box, err := PrmtopRead(strings.NewReader(nobox)); err != nil || box != nil {
+		Te.Errorf("Wrong non-periodic system: %v %v", box, err)
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * psf.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/***CHARMM/NAMD PSF part***/
+
+//PSFFileRead reads a CHARMM/NAMD PSF file. See PSFRead.
+func PSFFileRead(name string) (*Topology, error) {
+	f, err := OpenCompressed(name)
+	if err != nil {
+		return nil, errDecorate(err, "PSFFileRead")
+	}
+	defer f.Close()
+	top, err := PSFRead(f)
+	return top, errDecorate(err, "PSFFileRead")
+}
+
+//PSFRead reads a CHARMM or NAMD (X-PLOR) protein structure file, in the standard or EXT formats, including
+//the CHEQ and DRUDE variants, from r. It returns a Topology with the segment IDs (in the Segment field of the atoms),
+//residues, atom types, charges, masses and bonds (with order 0, as the PSF doesn't give bond orders). The atoms
+//are in the same order as in the DCD trajectories for the system. Drude particles and lone pairs are read as atoms.
+//The elements are guessed from the atom names and masses.
+func PSFRead(r io.Reader) (*Topology, error) {
+	in := bufio.NewScanner(r)
+	in.Buffer(make([]byte, 0, 4096), 1<<20)
+	lineno := 0
+	next := func() (string, bool) {
+		lineno++
+		if !in.Scan() {
+			return "", false
+		}
+		return in.Text(), true
+	}
+	header, ok := next()
+	if !ok || !strings.HasPrefix(strings.TrimSpace(header), "PSF") {
+		return nil, CError{"Not a PSF file", []string{"PSFRead"}}
+	}
+	var atoms []*Atom
+	var charge float64
+	for {
+		line, ok := next()
+		if !ok {
+			break
+		}
+		n, section := psfSection(line)
+		switch section {
+		case "NTITLE":
+			for i := 0; i < n; i++ {
+				next()
+			}
+		case "NATOM":
+			atoms = make([]*Atom, n)
+			for i := range atoms {
+				l, ok := next()
+				if !ok {
+					return nil, CError{fmt.Sprintf("Read %d atoms, expected %d", i, n), []string{"PSFRead"}}
+				}
+				at, err := psfAtom(l)
+				if err != nil {
+					return nil, CError{fmt.Sprintf("line %d: %s", lineno, err.Error()), []string{"psfAtom", "PSFRead"}}
+				}
+				if at.ID != i+1 {
+					return nil, CError{fmt.Sprintf("line %d: Atoms must be numbered consecutively from 1", lineno), []string{"PSFRead"}}
+				}
+				charge += at.Charge
+				atoms[i] = at
+			}
+		case "NBOND":
+			bonds := make([]int, 0, 2*n)
+			for len(bonds) < 2*n {
+				l, ok := next()
+				if !ok {
+					return nil, CError{fmt.Sprintf("Read %d bonds, expected %d", len(bonds)/2, n), []string{"PSFRead"}}
+				}
+				for _, v := range strings.Fields(l) {
+					b, err := strconv.Atoi(v)
+					if err != nil || b < 1 || b > len(atoms) {
+						return nil, CError{fmt.Sprintf("line %d: Wrong atom in bond: %s", lineno, v), []string{"PSFRead"}}
+					}
+					bonds = append(bonds, b-1)
+				}
+			}
+			for i := 0; i+1 < len(bonds); i += 2 {
+				at1, at2 := atoms[bonds[i]], atoms[bonds[i+1]]
+				b := &Bond{Index: i / 2, At1: at1, At2: at2}
+				at1.Bonds = append(at1.Bonds, b)
+				at2.Bonds = append(at2.Bonds, b)
+			}
+		}
+		if section == "NBOND" {
+			break //We don't need anything after the bonds.
+		}
+	}
+	if err := in.Err(); err != nil {
+		return nil, CError{err.Error(), []string{"bufio.Scanner.Scan", "PSFRead"}}
+	}
+	if atoms == nil {
+		return nil, CError{"No atoms found", []string{"PSFRead"}}
+	}
+	top := NewTopology(int(math.Round(charge)), 1, atoms)
+	top.FillIndexes()
+	return top, nil
+}
+
+//psfSection returns the number and the name of the section started by line,
+//(something like "      2 !NTITLE") or 0 and an empty string if the line doesn't start a section.
+func psfSection(line string) (int, string) {
+	i := strings.Index(line, "!")
+	if i < 0 {
+		return 0, ""
+	}
+	f := strings.Fields(line[:i])
+	if len(f) == 0 {
+		return 0, ""
+	}
+	n, err := strconv.Atoi(f[0])
+	if err != nil {
+		return 0, ""
+	}
+	section := strings.Fields(line[i+1:])
+	if len(section) == 0 {
+		return 0, ""
+	}
+	return n, strings.TrimSuffix(section[0], ":")
+}
+
+//psfAtom reads an atom line: number, segment, residue number, residue name, atom name, type, charge and mass, followed
+//by fields we don't use (the IMOVE flag, and the CHEQ or DRUDE parameters).
+func psfAtom(line string) (*Atom, error) {
+	f := strings.Fields(line)
+	if len(f) < 8 {
+		return nil, fmt.Errorf("Wrong atom line: %s", line)
+	}
+	at := &Atom{Segment: f[1], Molname: f[3], Name: f[4], Type: f[5]}
+	var err error
+	if at.ID, err = strconv.Atoi(f[0]); err != nil {
+		return nil, fmt.Errorf("Wrong atom number: %s", f[0])
+	}
+	resid := f[2]
+	if l := resid[len(resid)-1]; l < '0' || l > '9' {
+		at.InsCode = l
+		resid = resid[:len(resid)-1]
+	}
+	if at.MolID, err = strconv.Atoi(resid); err != nil {
+		return nil, fmt.Errorf("Wrong residue number: %s", f[2])
+	}
+	if at.Charge, err = strconv.ParseFloat(f[6], 64); err != nil {
+		return nil, fmt.Errorf("Wrong charge: %s", f[6])
+	}
+	if at.Mass, err = strconv.ParseFloat(f[7], 64); err != nil {
+		return nil, fmt.Errorf("Wrong mass: %s", f[7])
+	}
+	at.Molname1 = three2OneLetter[at.Molname]
+	if len(at.Segment) == 1 {
+		at.Chain = at.Segment
+	}
+	at.Symbol = symbolFromNameMass(at.Name, at.Mass)
+	return at, nil
+}
+
+//symbolFromNameMass guesses the element of an atom from its name, and, if the mass of the element guessed
+//doesn't match the given mass, from the mass. This helps with CHARMM ion names, such as SOD or CLA.
+func symbolFromNameMass(name string, mass float64) string {
+	symbol, _ := symbolFromName(strings.ToUpper(name))
+	if m, ok := symbolMass[symbol]; ok && math.Abs(m-mass) < 1 {
+		return symbol
+	}
+	for s, m := range symbolMass {
+		if math.Abs(m-mass) < 0.5 {
+			return s
+		}
+	}
+	return symbol
+}
+
+/***End of CHARMM/NAMD PSF part***/
@@ -0,0 +1,94 @@
+/*
+ * psf_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chem
+
+import (
+	"strings"
+	"testing"
+)
+
+const testPSF = `PSF CMAP CHEQ
+
+       2 !NTITLE
+* A TEST SYSTEM
+*  DATE:     1/ 1/21      0: 0: 0      CREATED BY USER: test
+
+       8 !NATOM
+       1 PROA     1        GLY      N        NH3     -0.300000       14.0070           0   0.00000     -0.301140E-02
+       2 PROA     1        GLY      HT1      HC       0.330000       1.00800           0   0.00000     -0.301140E-02
+       3 PROA     1        GLY      CA       CT2     -0.020000       12.0110           0   0.00000     -0.301140E-02
+       4 PROA     2A       ALA      N        NH1     -0.470000       14.0070           0   0.00000     -0.301140E-02
+       5 MEMB     1        POPC     P        PL       1.500000       30.9740           0   0.00000     -0.301140E-02
+       6 WAT1     1        TIP3     OH2      OT      -0.834000       15.9994           0   0.00000     -0.301140E-02
+       7 SOD      1        SOD      SOD      SOD      1.000000       22.9898           0   0.00000     -0.301140E-02
+       8 CLA      1        CLA      CLA      CLA     -1.000000       35.4500           0   0.00000     -0.301140E-02
+
+       3 !NBOND: bonds
+       1       2       1       3       3       4
+
+       1 !NTHETA: angles
+       2       1       3
+`
+
+func TestPSF(Te *testing.T) {
+	top, err := PSFRead(strings.NewReader(testPSF))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if top.Len() != 8 || top.Charge() != 0 {
+		Te.Fatalf("Read %d atoms with total charge %d, expected 8 and 0", top.Len(), top.Charge())
+	}
+	n, ala := top.Atom(0), top.Atom(3)
+	if n.Segment != "PROA" || n.Type != "NH3" || n.Charge != -0.3 || n.Mass != 14.007 || n.Symbol != "N" || len(n.Bonds) != 2 || n.Molname1 != 'G' {
+		Te.Errorf("Wrong atom read: %v", n)
+	}
+	if ala.MolID != 2 || ala.InsCode != 'A' || len(ala.Bonds) != 1 || ala.Bonds[0].Cross(ala) != top.Atom(2) {
+		Te.Errorf("Wrong residue or bonds: %v", ala)
+	}
+	if sod, cla := top.Atom(6), top.Atom(7); sod.Symbol != "Na" || cla.Symbol != "Cl" {
+		Te.Errorf("Wrong elements for the ions: %s %s", sod.Symbol, cla.Symbol)
+	}
+	if sel, err := Select(top, nil, "segid PROA and not type NH1"); err != nil || len(sel) != 3 {
+		Te.Errorf("Wrong selection of segment and types: %v %v", sel, err)
+	}
+	//The EXT, X-PLOR, format written by NAMD's psfgen
+	ext := `PSF EXT
+
+         1 !NTITLE
+ REMARKS original generated structure x-plor psf file
+
+         2 !NATOM
+         1 MEMBRANE 101      POPC     N        NTL     -0.600000       14.0070           0
+         2 MEMBRANE 101      POPC     C12      CTL2    -0.100000       12.0110           0
+
+         1 !NBOND: bonds
+         1         2
+`
+	top, err = PSFRead(strings.NewReader(ext))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if top.Len() != 2 || top.Atom(1).Segment != "MEMBRANE" || top.Atom(1).MolID != 101 || top.Atom(1).Symbol != "C" || len(top.Atom(0).Bonds) != 1 {
+		Te.Errorf("Wrong EXT PSF read: %v", top.Atom(1))
+	}
+}
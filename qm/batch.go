@@ -0,0 +1,244 @@
+/*
+ * batch.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//BatchJob is one calculation to be run by a Batch. Each job needs its own handle,
+//as the handle keeps the name and working directory of the calculation.
+type BatchJob struct {
+	Name   string //Used as the input name and as the name of the job's working directory. If empty, "jobN" is used, with N the index of the job.
+	Handle Handle //Must also implement SetWorkDir(string).
+	Coords *v3.Matrix
+	Mol    chem.AtomMultiCharger
+	Calc   *Calc
+}
+
+//BatchResult contains the outcome of a BatchJob.
+type BatchResult struct {
+	Index    int    //The index of the job in the slice given to Batch.Run
+	Name     string //The name of the job
+	WorkDir  string //The working directory for the job
+	Energy   float64
+	Geometry *v3.Matrix //The optimized geometry, only for optimizations.
+	Calc     *Calc      //The Calc used in the last attempt
+	Attempts int        //The number of times the calculation was run
+	Time     time.Duration
+	//Err is nil if the job succeeded. Otherwise, it is the error for the last attempt. If the job timed out or
+	//was cancelled, Err is context.DeadlineExceeded or context.Canceled, respectively. A non-critical
+	//ErrProbableProblem error means that Energy (and Geometry) were obtained, but the calculation didn't end properly.
+	Err error
+}
+
+//Batch runs a set of QM calculations with a bounded number of them running at the same time,
+//each in its own working directory.
+type Batch struct {
+	Workers int           //Maximum number of calculations running at the same time.
+	WorkDir string        //The working directory of each job is created under this one.
+	Timeout time.Duration //Maximum time for each attempt at a job. Zero means no limit. Only for handles that implement ContextRunner.
+	Retries int           //Number of times a failed job is re-run.
+	//Retry returns the Calc to be used for the attempt-th retry (starting from 1) of a job, given the Calc
+	//used in the previous attempt, which should not be modified. If it returns nil, the job is not retried.
+	//By default, the SCFConvHelp of the Calc is increased, up to 2.
+	Retry func(attempt int, Q *Calc) *Calc
+	//Done, if not nil, is called with the result of each job as soon as it finishes. The calls are
+	//never concurrent.
+	Done func(r *BatchResult)
+}
+
+//NewBatch returns a Batch that runs up to workers calculations at the same time, under the
+//working directory wrkdir, retrying each failed calculation once. If workers is less than 1,
+//runtime.NumCPU() is used.
+func NewBatch(workers int, wrkdir string) *Batch {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	return &Batch{Workers: workers, WorkDir: wrkdir, Retries: 1}
+}
+
+//SCFRetry increases the SCFConvHelp of a copy of Q, up to 2, the highest level. It returns nil if
+//SCFConvHelp is already 2. It is the default Retry function for a Batch.
+func SCFRetry(attempt int, Q *Calc) *Calc {
+	if Q.SCFConvHelp >= 2 {
+		return nil
+	}
+	ret := *Q
+	ret.SCFConvHelp++
+	return &ret
+}
+
+//Run runs the jobs, and returns their results in the same order. The handles of
+//the programs that implement ContextRunner are killed if the job times out or ctx is
+//cancelled. Other handles are run with Run(true), and can't be used with a Timeout.
+//If ctx is cancelled, their running calculations are allowed to finish, and the
+//jobs that haven't started are not run.
+func (B *Batch) Run(ctx context.Context, jobs []*BatchJob) []*BatchResult {
+	workers := B.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	results := make([]*BatchResult, len(jobs))
+	indexes := make(chan int)
+	out := make(chan *BatchResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range indexes {
+				out <- B.runJob(ctx, j, jobs[j])
+			}
+		}()
+	}
+	go func() {
+		for i := range jobs {
+			indexes <- i
+		}
+		close(indexes)
+		wg.Wait()
+		close(out)
+	}()
+	for r := range out {
+		results[r.Index] = r
+		if B.Done != nil {
+			B.Done(r)
+		}
+	}
+	return results
+}
+
+//runJob runs a job, retrying if needed, and returns its result.
+func (B *Batch) runJob(ctx context.Context, index int, job *BatchJob) *BatchResult {
+	start := time.Now()
+	r := &BatchResult{Index: index, Name: job.Name, Calc: copyCalc(job.Calc)}
+	defer func() { r.Time = time.Since(start) }()
+	if r.Name == "" {
+		r.Name = fmt.Sprintf("job%d", index)
+	}
+	r.WorkDir = filepath.Join(B.WorkDir, r.Name)
+	wd, ok := job.Handle.(interface{ SetWorkDir(string) })
+	if !ok {
+		r.Err = Error{ErrNotRunning, "", r.Name, "The handle can't set a working directory", []string{"runJob", "Batch.Run"}, true}
+		return r
+	}
+	if _, ok := job.Handle.(ContextRunner); !ok && B.Timeout > 0 {
+		r.Err = Error{ErrNotRunning, "", r.Name, "The handle doesn't implement ContextRunner, so it can't be run with a timeout", []string{"runJob", "Batch.Run"}, true}
+		return r
+	}
+	retry := B.Retry
+	if retry == nil {
+		retry = SCFRetry
+	}
+	for attempt := 0; attempt <= B.Retries; attempt++ {
+		if err := ctx.Err(); err != nil {
+			r.Err = err
+			return r
+		}
+		if attempt > 0 {
+			Q := retry(attempt, copyCalc(r.Calc))
+			if Q == nil {
+				return r
+			}
+			r.Calc = Q
+		}
+		r.Attempts++
+		if err := os.MkdirAll(r.WorkDir, 0755); err != nil {
+			r.Err = Error{ErrNotRunning, "", r.Name, err.Error(), []string{"os.MkdirAll", "runJob", "Batch.Run"}, true}
+			return r
+		}
+		job.Handle.SetName(r.Name)
+		wd.SetWorkDir(r.WorkDir)
+		//BuildInput sets the handle's defaults in the Calc, so it gets a copy, and r.Calc is kept as given.
+		if err := job.Handle.BuildInput(job.Coords, job.Mol, copyCalc(r.Calc)); err != nil {
+			//Retrying wouldn't help here.
+			r.Err = err
+			return r
+		}
+		r.Err = B.runAttempt(ctx, job.Handle)
+		if r.Err != nil {
+			if ctx.Err() != nil {
+				r.Err = ctx.Err()
+				return r
+			}
+			continue
+		}
+		r.Energy, r.Err = job.Handle.Energy()
+		if r.Err == nil && r.Calc.Job.Opti {
+			r.Geometry, r.Err = job.Handle.OptimizedGeometry(job.Mol)
+		}
+		if r.Err == nil {
+			return r
+		}
+	}
+	return r
+}
+
+//runAttempt runs the calculation set in H, with the timeout for the batch, if any. If the
+//calculation times out, it returns context.DeadlineExceeded.
+func (B *Batch) runAttempt(ctx context.Context, H Handle) error {
+	if B.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, B.Timeout)
+		defer cancel()
+	}
+	var err error
+	if C, ok := H.(ContextRunner); ok {
+		err = C.RunContext(ctx)
+	} else {
+		//The program can't be stopped, so we wait for it, or the number of running
+		//calculations could go over B.Workers.
+		err = H.Run(true)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+//copyCalc returns a copy of Q that doesn't share any slice with it.
+func copyCalc(Q *Calc) *Calc {
+	if Q == nil {
+		return nil
+	}
+	ret := *Q
+	ret.HBAtoms = append([]int(nil), Q.HBAtoms...)
+	ret.LBAtoms = append([]int(nil), Q.LBAtoms...)
+	ret.HBElements = append([]string(nil), Q.HBElements...)
+	ret.LBElements = append([]string(nil), Q.LBElements...)
+	ret.CConstraints = append([]int(nil), Q.CConstraints...)
+	ret.IConstraints = append([]*IConstraint(nil), Q.IConstraints...)
+	ret.ECPElements = append([]string(nil), Q.ECPElements...)
+	return &ret
+}
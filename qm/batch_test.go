@@ -0,0 +1,239 @@
+/*
+ * batch_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+var _ ContextRunner = (*OrcaHandle)(nil)
+var _ ContextRunner = (*XTBHandle)(nil)
+var _ ContextRunner = (*MopacHandle)(nil)
+var _ ContextRunner = (*NWChemHandle)(nil)
+var _ ContextRunner = (*Psi4Handle)(nil)
+var _ ContextRunner = (*CP2KHandle)(nil)
+var _ ContextRunner = (*GaussianHandle)(nil)
+var _ ContextRunner = (*TMHandle)(nil)
+
+//fakeHandle pretends to run a calculation, which takes delay and only converges
+//if the SCFConvHelp of the Calc is at least conv.
+type fakeHandle struct {
+	name, wrkdir string
+	delay        time.Duration
+	conv         int
+	converged    bool
+	running      *runCounter
+}
+
+type runCounter struct {
+	sync.Mutex
+	current, max int
+}
+
+func (F *fakeHandle) SetName(name string)   { F.name = name }
+func (F *fakeHandle) SetWorkDir(dir string) { F.wrkdir = dir }
+
+func (F *fakeHandle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger, Q *Calc) error {
+	F.converged = Q.SCFConvHelp >= F.conv
+	//The real handles set their defaults in the Calc.
+	Q.Method = "default"
+	Q.SCFConvHelp = 0
+	return ioutil.WriteFile(filepath.Join(F.wrkdir, F.name+".inp"), []byte(Q.Method), 0644)
+}
+
+func (F *fakeHandle) Run(wait bool) error {
+	return F.RunContext(context.Background())
+}
+
+func (F *fakeHandle) RunContext(ctx context.Context) error {
+	F.running.Lock()
+	F.running.current++
+	if F.running.current > F.running.max {
+		F.running.max = F.running.current
+	}
+	F.running.Unlock()
+	defer func() {
+		F.running.Lock()
+		F.running.current--
+		F.running.Unlock()
+	}()
+	select {
+	case <-time.After(F.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (F *fakeHandle) Energy() (float64, error) {
+	if !F.converged {
+		return 0, Error{ErrNoEnergy, "fake", F.name, "", []string{"Energy"}, true}
+	}
+	return -1, nil
+}
+
+func (F *fakeHandle) OptimizedGeometry(atoms chem.Atomer) (*v3.Matrix, error) {
+	return v3.NewMatrix([]float64{0, 0, 0})
+}
+
+//plainHandle is a fakeHandle without RunContext.
+type plainHandle struct {
+	F *fakeHandle
+}
+
+func (P *plainHandle) SetName(name string)   { P.F.SetName(name) }
+func (P *plainHandle) SetWorkDir(dir string) { P.F.SetWorkDir(dir) }
+func (P *plainHandle) Run(wait bool) error   { return P.F.Run(wait) }
+func (P *plainHandle) Energy() (float64, error) {
+	return P.F.Energy()
+}
+func (P *plainHandle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger, Q *Calc) error {
+	return P.F.BuildInput(coords, atoms, Q)
+}
+func (P *plainHandle) OptimizedGeometry(atoms chem.Atomer) (*v3.Matrix, error) {
+	return P.F.OptimizedGeometry(atoms)
+}
+
+func TestBatch(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "gochemqm")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	counter := &runCounter{}
+	calc := &Calc{Method: "fake"}
+	calc.Job.Opti = true
+	var jobs []*BatchJob
+	for i := 0; i < 10; i++ {
+		F := &fakeHandle{delay: 20 * time.Millisecond, conv: i % 3, running: counter}
+		jobs = append(jobs, &BatchJob{Handle: F, Calc: calc})
+	}
+	jobs[9].Handle.(*fakeHandle).conv = 3 //never converges
+	jobs[9].Name = "hard"
+	var done int
+	B := NewBatch(3, dir)
+	B.Retries = 2
+	B.Done = func(r *BatchResult) { done++ }
+	results := B.Run(context.Background(), jobs)
+	if done != len(jobs) || len(results) != len(jobs) {
+		Te.Fatalf("%d jobs, %d results reported, %d returned", len(jobs), done, len(results))
+	}
+	if counter.max > 3 || counter.max < 2 {
+		Te.Errorf("Up to 3 jobs should have run at the same time, but %d did", counter.max)
+	}
+	for i, r := range results[:9] {
+		if r.Err != nil || r.Index != i || r.Energy != -1 || r.Geometry == nil {
+			Te.Errorf("Wrong result for job %d: %+v", i, r)
+		}
+		if r.Attempts != i%3+1 || r.Calc.SCFConvHelp != i%3 {
+			Te.Errorf("Job %d took %d attempts with SCFConvHelp %d", i, r.Attempts, r.Calc.SCFConvHelp)
+		}
+		if _, err := os.Stat(filepath.Join(dir, r.Name, r.Name+".inp")); err != nil {
+			Te.Errorf("No input for job %d: %v", i, err)
+		}
+	}
+	if r := results[9]; r.Err == nil || r.Attempts != 3 || r.WorkDir != filepath.Join(dir, "hard") {
+		Te.Errorf("Wrong result for a failing job: %+v", r)
+	}
+	if calc.SCFConvHelp != 0 || calc.Method != "fake" {
+		Te.Error("The original Calc was modified")
+	}
+
+	//Handles that can't be killed can't be given a timeout.
+	B = NewBatch(2, dir)
+	B.Timeout = time.Second
+	results = B.Run(context.Background(), []*BatchJob{{Handle: &plainHandle{jobs[0].Handle.(*fakeHandle)}, Calc: calc}})
+	if results[0].Err == nil || results[0].Attempts != 0 {
+		Te.Errorf("A timeout for a handle without RunContext should give an error: %+v", results[0])
+	}
+	B.Timeout = 0
+	results = B.Run(context.Background(), []*BatchJob{{Handle: &plainHandle{&fakeHandle{delay: time.Millisecond, running: counter}}, Calc: calc}})
+	if results[0].Err != nil || results[0].Energy != -1 {
+		Te.Errorf("Wrong result for a handle without RunContext: %+v", results[0])
+	}
+
+	//Timeouts and cancellation.
+	jobs = jobs[:0]
+	for i := 0; i < 4; i++ {
+		jobs = append(jobs, &BatchJob{Handle: &fakeHandle{delay: time.Hour, running: counter}, Calc: calc})
+	}
+	B = NewBatch(2, dir)
+	B.Timeout = 10 * time.Millisecond
+	results = B.Run(context.Background(), jobs)
+	for i, r := range results {
+		if r.Err != context.DeadlineExceeded || r.Attempts != 2 {
+			Te.Errorf("Job %d should have timed out twice: %+v", i, r)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	B.Timeout = 0
+	start := time.Now()
+	results = B.Run(ctx, jobs)
+	if time.Since(start) > time.Second {
+		Te.Error("Cancelled batch took too long")
+	}
+	for i, r := range results {
+		if r.Err != context.DeadlineExceeded {
+			Te.Errorf("Job %d should have been cancelled: %+v", i, r)
+		}
+	}
+}
+
+func TestRunGroup(Te *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		Te.Skip("No sh available")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	//The children of the shell keep the output pipe open, so Wait would not return
+	//until they end if they were not killed too.
+	command := exec.Command("sh", "-c", "sleep 30 | sleep 30; sleep 30")
+	command.Stdout = new(bytes.Buffer)
+	start := time.Now()
+	if err := runGroup(ctx, command); err != context.DeadlineExceeded {
+		Te.Errorf("Expected a timeout, got %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		Te.Error("The process group was not killed")
+	}
+	if err := runGroup(context.Background(), exec.Command("sh", "-c", "exit 3")); err == nil {
+		Te.Error("A failing command should give an error")
+	}
+}
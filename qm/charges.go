@@ -0,0 +1,72 @@
+/*
+ * charges.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"fmt"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//Charge schemes, to be used with the SchemeCharges method of the handles.
+const (
+	Mulliken  = "Mulliken"
+	Lowdin    = "Lowdin"
+	Hirshfeld = "Hirshfeld"
+	CM5       = "CM5"
+	ESP       = "ESP"
+	RESP      = "RESP"
+)
+
+//au2Debye converts a dipole moment in atomic units (e*bohr) to Debye.
+const au2Debye = 2.541746
+
+//chooseCharges returns the charges for scheme in all. If scheme is an empty string, it returns
+//the charges for the first of defaults present in all. It returns an error if the charges are not found.
+func chooseCharges(all map[string][]float64, scheme string, defaults ...string) ([]float64, error) {
+	if scheme != "" {
+		defaults = []string{scheme}
+	}
+	for _, v := range defaults {
+		if c, ok := all[v]; ok && len(c) > 0 {
+			return c, nil
+		}
+	}
+	if scheme == "" {
+		return nil, fmt.Errorf("No charges found")
+	}
+	return nil, fmt.Errorf("No %s charges found", scheme)
+}
+
+//dipoleMatrix returns a 1x3 matrix with the dipole moment in d, multiplied by factor.
+func dipoleMatrix(d []float64, factor float64) (*v3.Matrix, error) {
+	if len(d) != 3 {
+		return nil, fmt.Errorf("No dipole moment found")
+	}
+	dipole, err := v3.NewMatrix([]float64{d[0] * factor, d[1] * factor, d[2] * factor})
+	if err != nil {
+		return nil, err
+	}
+	return dipole, nil
+}
@@ -0,0 +1,255 @@
+/*
+ * charges_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+var _ Charger = (*OrcaHandle)(nil)
+var _ Charger = (*TMHandle)(nil)
+var _ Charger = (*NWChemHandle)(nil)
+var _ Charger = (*XTBHandle)(nil)
+var _ Charger = (*MopacHandle)(nil)
+var _ Charger = (*GaussianHandle)(nil)
+var _ Charger = (*Psi4Handle)(nil)
+var _ Charger = (*CP2KHandle)(nil)
+var _ Dipoler = (*OrcaHandle)(nil)
+var _ Dipoler = (*TMHandle)(nil)
+var _ Dipoler = (*NWChemHandle)(nil)
+var _ Dipoler = (*XTBHandle)(nil)
+var _ Dipoler = (*MopacHandle)(nil)
+var _ Dipoler = (*GaussianHandle)(nil)
+var _ Dipoler = (*Psi4Handle)(nil)
+var _ Dipoler = (*CP2KHandle)(nil)
+
+//The following are excerpts of outputs for water.
+
+const testOrcaChargesOut = `-----------------------
+MULLIKEN ATOMIC CHARGES
+-----------------------
+   0 O :   -0.337470
+   1 H :    0.168735
+   2 H :    0.168735
+Sum of atomic charges:   -0.0000000
+
+----------------------
+LOEWDIN ATOMIC CHARGES
+----------------------
+   0 O :   -0.181556
+   1 H :    0.090778
+   2 H :    0.090778
+
+------------------
+HIRSHFELD ANALYSIS
+------------------
+
+Total integrated alpha density =      4.999999992
+Total integrated beta density  =      4.999999992
+
+  ATOM     CHARGE      SPIN    
+   0 O   -0.306543    0.000000
+   1 H    0.153272    0.000000
+   2 H    0.153272    0.000000
+
+  TOTAL  -0.000000    0.000000
+
+--------------------------------
+CHELPG Charges            
+--------------------------------
+  0   O   :      -0.790116
+  1   H   :       0.395058
+  2   H   :       0.395058
+--------------------------------
+Total charge:    -0.000000
+--------------------------------
+
+Total Dipole Moment    :      0.000000       0.000000      -0.812345
+                        -----------------------------------------
+Magnitude (a.u.)       :      0.812345
+Magnitude (Debye)      :      2.064817
+`
+
+const testXTBChargesOut = `
+ Mulliken/CM5 charges         n(s)   n(p)   n(d)
+     1O  -0.56520 -0.40915   1.835  4.730  0.000
+     2H   0.28260  0.20457   0.717  0.000  0.000
+     3H   0.28260  0.20457   0.717  0.000  0.000
+
+molecular dipole:
+                 x           y           z       tot (Debye)
+ q only:        0.000       0.000      -0.602
+   full:        0.000       0.000      -0.890       2.262
+molecular quadrupole (traceless):
+                xx          xy          yy          xz          yz          zz
+ q only:        1.336       0.000      -1.523       0.000       0.000       0.187
+   full:        1.749       0.000      -2.063       0.000       0.000       0.314
+`
+
+const testTMChargesOut = `
+ ==============================================================================
+                           Mulliken Population Analysis
+ ==============================================================================
+
+    atomic populations from total density:
+
+   atom      charge    n(s)      n(p)      n(d)
+     1o      -0.44356   3.81346   4.63010
+     2h       0.22178   0.77822
+     3h       0.22178   0.77822
+
+ dipole moment
+                         nuc           elec       total
+   x     0.00000000000002    -0.00000000000002   -0.00000000000000
+   y     0.00000000000000     0.00000000000000    0.00000000000000
+   z     3.78011549734018    -3.00373812281010    0.77637737453008
+
+   | dipole moment | =     0.7764 a.u. =     1.9733 debye
+`
+
+const testMopacChargesOut = `
+          NET ATOMIC CHARGES AND DIPOLE CONTRIBUTIONS
+
+  ATOM NO.   TYPE          CHARGE      No. of ELECS.   s-Pop       p-Pop
+    1          O          -0.617335        6.6173     1.85416     4.76318
+    2          H           0.308668        0.6913     0.69133
+    3          H           0.308668        0.6913     0.69133
+ DIPOLE           X         Y         Z       TOTAL
+ POINT-CHG.    -0.000     0.000     1.554     1.554
+ HYBRID         0.000     0.000     0.000     0.000
+ SUM           -0.000     0.000     1.554     1.554
+
+                ELECTROSTATIC POTENTIAL CHARGES
+
+          ATOM NO.    TYPE    CHARGE
+            1          O     -0.6543
+            2          H      0.3271
+            3          H      0.3271
+
+`
+
+const testNWChemChargesOut = `
+  Mulliken analysis of the total density
+  --------------------------------------
+
+    Atom       Charge   Shell Charges
+ -----------   ------   -------------------------------------------------------
+    1 O    8     8.69   1.99  0.93  2.96  0.92  1.89
+    2 H    1     0.66   0.55  0.11
+    3 H    1     0.66   0.55  0.11
+
+     Multipole analysis of the density
+     ---------------------------------
+
+     L   x y z        total         alpha         beta         nuclear
+     -   - - -        -----         -----         ----         -------
+     0   0 0 0     -0.000000     -5.000000     -5.000000     10.000000
+
+     1   1 0 0      0.000000      0.000000      0.000000      0.000000
+     1   0 1 0     -0.000000     -0.000000     -0.000000      0.000000
+     1   0 0 1     -0.812345     -0.400000     -0.400000     -0.012345
+
+     2   2 0 0     -4.000000     -2.000000     -2.000000      0.000000
+
+    Atom              Coordinates                           Charge
+
+                                                  ESP         RESP        RESP2
+
+    1 O     0.00000000    0.00000000    0.11786656   -0.801591   -0.757232   -0.757232
+    2 H     0.00000000    0.75545210   -0.47146625    0.400796    0.378616    0.378616
+    3 H     0.00000000   -0.75545210   -0.47146625    0.400796    0.378616    0.378616
+                                            ------------------------------------
+                                                  0.000000    0.000000    0.000000
+`
+
+func TestChargesDipoleParsers(Te *testing.T) {
+	check := func(name string, got []float64, err error, expected ...float64) {
+		if err != nil {
+			Te.Errorf("%s: %v", name, err)
+			return
+		}
+		if len(got) != len(expected) {
+			Te.Errorf("%s: got %v, expected %v", name, got, expected)
+			return
+		}
+		for i, v := range expected {
+			if math.Abs(got[i]-v) > 1e-6 {
+				Te.Errorf("%s: got %v, expected %v", name, got, expected)
+				return
+			}
+		}
+	}
+	all, err := orcaCharges(strings.NewReader(testOrcaChargesOut))
+	check("ORCA Mulliken", all[Mulliken], err, -0.337470, 0.168735, 0.168735)
+	check("ORCA Lowdin", all[Lowdin], err, -0.181556, 0.090778, 0.090778)
+	check("ORCA Hirshfeld", all[Hirshfeld], err, -0.306543, 0.153272, 0.153272)
+	check("ORCA ESP", all[ESP], err, -0.790116, 0.395058, 0.395058)
+	d, err := orcaDipole(strings.NewReader(testOrcaChargesOut))
+	check("ORCA dipole", d, err, 0, 0, -0.812345)
+
+	all, err = xtbCharges(strings.NewReader(testXTBChargesOut))
+	check("xtb Mulliken", all[Mulliken], err, -0.56520, 0.28260, 0.28260)
+	check("xtb CM5", all[CM5], err, -0.40915, 0.20457, 0.20457)
+	d, err = xtbDipole(strings.NewReader(testXTBChargesOut))
+	check("xtb dipole", d, err, 0, 0, -0.890)
+
+	all, err = tmCharges(strings.NewReader(testTMChargesOut))
+	check("Turbomole Mulliken", all[Mulliken], err, -0.44356, 0.22178, 0.22178)
+	d, err = tmDipole(strings.NewReader(testTMChargesOut))
+	check("Turbomole dipole", d, err, 0, 0, 0.77637737453008)
+
+	all, err = mopacCharges(strings.NewReader(testMopacChargesOut))
+	check("MOPAC Mulliken", all[Mulliken], err, -0.617335, 0.308668, 0.308668)
+	check("MOPAC ESP", all[ESP], err, -0.6543, 0.3271, 0.3271)
+	d, err = mopacDipole(strings.NewReader(testMopacChargesOut))
+	check("MOPAC dipole", d, err, 0, 0, 1.554)
+
+	all, err = nwchemCharges(strings.NewReader(testNWChemChargesOut))
+	check("NWChem Mulliken", all[Mulliken], err, -0.69, 0.34, 0.34)
+	check("NWChem ESP", all[ESP], err, -0.801591, 0.400796, 0.400796)
+	check("NWChem RESP", all[RESP], err, -0.757232, 0.378616, 0.378616)
+	d, err = nwchemDipole(strings.NewReader(testNWChemChargesOut))
+	check("NWChem dipole", d, err, 0, 0, -0.812345)
+
+	d, err = gaussianLogDipole(strings.NewReader(" Dipole moment (field-independent basis, Debye):\n    X=              0.0000    Y=              0.0000    Z=             -2.0975  Tot=              2.0975\n"))
+	check("Gaussian dipole", d, err, 0, 0, -2.0975)
+	d, err = psi4Dipole(strings.NewReader("  Dipole Moment: [D]\n     X:     0.0000      Y:     0.0000      Z:     2.0135     Total:     2.0135\n"))
+	check("Psi4 dipole", d, err, 0, 0, 2.0135)
+	d, err = psi4Dipole(strings.NewReader(" Dipole X            :          0.0000000            0.0000000            0.0000000\n Dipole Y            :          0.0000000            0.0000000            0.0000000\n Dipole Z            :         -0.1234000            0.9000000            0.7766000\n Magnitude           :                                                    0.7766000\n"))
+	check("Psi4 1.4 dipole", d, err, 0, 0, 0.7766*au2Debye)
+	d, err = cp2kDipole(strings.NewReader(" Dipole moment [Debye]\n    X=   -0.00000011 Y=    0.00000000 Z=   -1.89856743     Total=      1.89856743\n"))
+	check("CP2K dipole", d, err, -0.00000011, 0, -1.89856743)
+
+	if _, err := chooseCharges(all, CM5); err == nil {
+		Te.Errorf("Missing charges not detected")
+	}
+	c, err := chooseCharges(all, "", CM5, RESP, ESP)
+	check("Default charges", c, err, -0.757232, 0.378616, 0.378616)
+}
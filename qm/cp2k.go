@@ -0,0 +1,580 @@
+/*
+ * cp2k.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//CP2KHandle represents a CP2K (Quickstep) calculation.
+//A Forces job runs only a gradient (ENERGY_FORCE) calculation, no Hessian is computed.
+//Note that the default methods and basis vary with each program, and even
+//for a given program they are NOT considered part of the API, so they can always change.
+type CP2KHandle struct {
+	defmethod string
+	defbasis  string
+	command   string
+	inputname string
+	wrkdir    string
+	nCPU      int
+	box       *chem.Box
+}
+
+//NewCP2KHandle initializes and returns a new CP2KHandle.
+func NewCP2KHandle() *CP2KHandle {
+	run := new(CP2KHandle)
+	run.SetDefaults()
+	return run
+}
+
+//CP2KHandle methods
+
+//SetnCPU sets the number of MPI processes to be used.
+func (O *CP2KHandle) SetnCPU(cpu int) {
+	O.nCPU = cpu
+}
+
+//SetName sets the name of the job, which will reflect in the
+//name of the input (name.inp) and output (name.out, name-pos-1.xyz, etc.) files.
+func (O *CP2KHandle) SetName(name string) {
+	O.inputname = name
+}
+
+//SetCommand sets the name and path of the CP2K excecutable.
+func (O *CP2KHandle) SetCommand(name string) {
+	O.command = name
+}
+
+//SetWorkDir sets the name of the working directory for the calculation.
+func (O *CP2KHandle) SetWorkDir(d string) {
+	O.wrkdir = d
+}
+
+//SetBox sets the periodic box for the calculation. If box is nil, which is
+//the default, the system is not periodic, and a cubic box large enough to contain it is used.
+func (O *CP2KHandle) SetBox(box *chem.Box) {
+	O.box = box
+}
+
+//SetDefaults sets defaults for the CP2K calculation. The default is
+//currently a single-point at PBE/DZVP-MOLOPT-SR-GTH, with half the logical CPUs available
+//as MPI processes.
+//The default is _not_ part of the API, it can change as new methods appear.
+func (O *CP2KHandle) SetDefaults() {
+	O.defmethod = "PBE"
+	O.defbasis = "DZVP-MOLOPT-SR-GTH"
+	O.command = "cp2k.psmp"
+	O.nCPU = runtime.NumCPU() / 2
+}
+
+//name returns the path of the file for the job with the suffix suf.
+func (O *CP2KHandle) name(suf string) string {
+	return filepath.Join(O.wrkdir, O.inputname+suf)
+}
+
+//BuildInput builds a CP2K input (name.inp) based on the data in atoms, coords and Q.
+//GTH pseudopotentials for the functional used are employed for all elements, so the ECP options are ignored.
+//For MD, Q.MDTime is taken in ps, and Q.MDPressure, if not zero, in bar.
+//A Forces job runs only a gradient (ENERGY_FORCE), printing the forces, not a frequency calculation.
+//returns only error.
+func (O *CP2KHandle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger, Q *Calc) error {
+	if O.inputname == "" {
+		O.inputname = "gochem"
+	}
+	if atoms == nil || coords == nil {
+		return Error{ErrMissingCharges, CP2K, O.inputname, "", []string{"BuildInput"}, true}
+	}
+	if Q.Method == "" {
+		log.Printf("no method assigned for CP2K calculation, will use the default %s, \n", O.defmethod)
+		Q.Method = O.defmethod
+	}
+	if Q.Basis == "" {
+		log.Printf("no basis set assigned for CP2K calculation, will use the default %s, \n", O.defbasis)
+		Q.Basis = O.defbasis
+	}
+	if Q.HBAtoms != nil || Q.LBAtoms != nil {
+		log.Printf("per-atom basis sets are not supported for CP2K calculations, will be ignored")
+	}
+	method := strings.ToUpper(Q.Method)
+	runtype := "ENERGY"
+	motion := ""
+	printforces := false
+	charges := false
+	jc := jobChoose{}
+	jc.opti = func() {
+		runtype = "GEO_OPT"
+		motion = "  &GEO_OPT\n    OPTIMIZER BFGS\n    MAX_ITER 200\n  &END GEO_OPT\n"
+	}
+	jc.forces = func() {
+		runtype = "ENERGY_FORCE"
+		printforces = true
+	}
+	jc.md = func() {
+		runtype = "MD"
+		ensemble := "NVT"
+		barostat := ""
+		if Q.MDPressure != 0 {
+			ensemble = "NPT_I"
+			barostat = fmt.Sprintf("    &BAROSTAT\n      PRESSURE [bar] %d\n      TIMECON 1000\n    &END BAROSTAT\n", Q.MDPressure)
+		}
+		motion = fmt.Sprintf("  &MD\n    ENSEMBLE %s\n    STEPS %d\n    TIMESTEP 0.5\n    TEMPERATURE %.2f\n    &THERMOSTAT\n      TYPE CSVR\n      &CSVR\n        TIMECON 100\n      &END CSVR\n    &END THERMOSTAT\n%s  &END MD\n",
+			ensemble, Q.MDTime*2000, Q.MDTemp, barostat) //2000 steps of 0.5 fs per ps.
+	}
+	jc.charges = func() {
+		charges = true
+	}
+	Q.Job.Do(jc)
+	constraints, colvars, err := O.buildConstraints(Q)
+	if err != nil {
+		return errDecorate(err, "BuildInput")
+	}
+	if runtype != "GEO_OPT" && runtype != "MD" {
+		constraints = ""
+		colvars = ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "&GLOBAL\n  PROJECT %s\n  RUN_TYPE %s\n  PRINT_LEVEL LOW\n&END GLOBAL\n\n", O.inputname, runtype)
+	b.WriteString("&FORCE_EVAL\n  METHOD Quickstep\n")
+	if printforces {
+		b.WriteString("  &PRINT\n    &FORCES ON\n    &END FORCES\n  &END PRINT\n")
+	}
+	b.WriteString("  &DFT\n    BASIS_SET_FILE_NAME BASIS_MOLOPT\n    POTENTIAL_FILE_NAME GTH_POTENTIALS\n")
+	fmt.Fprintf(&b, "    CHARGE %d\n    MULTIPLICITY %d\n", atoms.Charge(), atoms.Multi())
+	if atoms.Multi() != 1 {
+		b.WriteString("    UKS\n")
+	}
+	cutoff, ok := cp2kGrid[Q.Grid]
+	if !ok {
+		cutoff = cp2kGrid[0]
+	}
+	fmt.Fprintf(&b, "    &MGRID\n      CUTOFF %d\n      REL_CUTOFF 50\n    &END MGRID\n", cutoff)
+	fmt.Fprintf(&b, "    &SCF\n      EPS_SCF %s\n%s", cp2kSCFTight[Q.SCFTightness], cp2kSCFConv[Q.SCFConvHelp])
+	if Q.Guess != "" {
+		fmt.Fprintf(&b, "      SCF_GUESS %s\n", Q.Guess)
+	}
+	b.WriteString("      &OT\n        PRECONDITIONER FULL_SINGLE_INVERSE\n        MINIMIZER DIIS\n      &END OT\n    &END SCF\n")
+	fmt.Fprintf(&b, "    &XC\n      &XC_FUNCTIONAL %s\n      &END XC_FUNCTIONAL\n", method)
+	if Q.Dispersion != "" {
+		disp, ok := cp2kDisp[Q.Dispersion]
+		if !ok {
+			return Error{"goChem/QM: Dispersion correction not supported", CP2K, O.inputname, Q.Dispersion, []string{"BuildInput"}, true}
+		}
+		if disp != "" {
+			fmt.Fprintf(&b, "      &VDW_POTENTIAL\n        POTENTIAL_TYPE PAIR_POTENTIAL\n        &PAIR_POTENTIAL\n          TYPE %s\n", disp)
+			fmt.Fprintf(&b, "          PARAMETER_FILE_NAME dftd3.dat\n          REFERENCE_FUNCTIONAL %s\n        &END PAIR_POTENTIAL\n      &END VDW_POTENTIAL\n", method)
+		}
+	}
+	b.WriteString("    &END XC\n")
+	if O.box == nil {
+		b.WriteString("    &POISSON\n      PERIODIC NONE\n      POISSON_SOLVER MT\n    &END POISSON\n")
+	}
+	if Q.Dielectric > 0 {
+		fmt.Fprintf(&b, "    &SCCS\n      DIELECTRIC_CONSTANT %.4f\n    &END SCCS\n", Q.Dielectric)
+	}
+	if charges {
+		b.WriteString("    &PRINT\n      &MULLIKEN ON\n      &END MULLIKEN\n      &HIRSHFELD ON\n      &END HIRSHFELD\n      &MOMENTS ON\n      &END MOMENTS\n    &END PRINT\n")
+	}
+	b.WriteString("  &END DFT\n  &SUBSYS\n")
+	b.WriteString(O.buildCell(coords))
+	b.WriteString("    &COORD\n")
+	elements := make([]string, 0, 5)
+	for i := 0; i < atoms.Len(); i++ {
+		symbol := atoms.Atom(i).Symbol
+		if !isInString(elements, symbol) {
+			elements = append(elements, symbol)
+		}
+		fmt.Fprintf(&b, "      %-2s  %12.6f%12.6f%12.6f\n", symbol, coords.At(i, 0), coords.At(i, 1), coords.At(i, 2))
+	}
+	b.WriteString("    &END COORD\n")
+	if O.box == nil {
+		b.WriteString("    &TOPOLOGY\n      &CENTER_COORDINATES\n      &END CENTER_COORDINATES\n    &END TOPOLOGY\n")
+	}
+	b.WriteString(colvars)
+	for _, v := range elements {
+		basis := Q.Basis
+		if isInString(Q.HBElements, v) {
+			basis = Q.HighBasis
+		} else if isInString(Q.LBElements, v) {
+			basis = Q.LowBasis
+		}
+		fmt.Fprintf(&b, "    &KIND %s\n      BASIS_SET %s\n      POTENTIAL GTH-%s\n    &END KIND\n", v, basis, method)
+	}
+	b.WriteString("  &END SUBSYS\n&END FORCE_EVAL\n")
+	if motion != "" || constraints != "" {
+		fmt.Fprintf(&b, "\n&MOTION\n%s%s&END MOTION\n", motion, constraints)
+	}
+	if Q.Others != "" {
+		b.WriteString(Q.Others + "\n")
+	}
+	err = ioutil.WriteFile(O.name(".inp"), []byte(b.String()), 0644)
+	if err != nil {
+		return Error{ErrCantInput, CP2K, O.inputname, err.Error(), []string{"ioutil.WriteFile", "BuildInput"}, true}
+	}
+	return nil
+}
+
+//buildCell returns the CELL section, with the periodic box or, if there is none, a cubic box twice as large as the
+//system, (at least 10 A larger) as needed by the Poisson solver for non-periodic systems.
+func (O *CP2KHandle) buildCell(coords *v3.Matrix) string {
+	if O.box != nil {
+		var b strings.Builder
+		b.WriteString("    &CELL\n")
+		for i, v := range []string{"A", "B", "C"} {
+			fmt.Fprintf(&b, "      %s %12.6f%12.6f%12.6f\n", v, O.box.At(i, 0), O.box.At(i, 1), O.box.At(i, 2))
+		}
+		b.WriteString("      PERIODIC XYZ\n    &END CELL\n")
+		return b.String()
+	}
+	extent := 0.0
+	for j := 0; j < 3; j++ {
+		min, max := math.Inf(1), math.Inf(-1)
+		for i := 0; i < coords.NVecs(); i++ {
+			min = math.Min(min, coords.At(i, j))
+			max = math.Max(max, coords.At(i, j))
+		}
+		extent = math.Max(extent, max-min)
+	}
+	side := math.Max(2*extent, extent+10)
+	return fmt.Sprintf("    &CELL\n      ABC %.3f %.3f %.3f\n      PERIODIC NONE\n    &END CELL\n", side, side, side)
+}
+
+//buildConstraints returns the CONSTRAINT section of MOTION with the constraints in Q, and the
+//collective variables needed for the internal constraints.
+func (O *CP2KHandle) buildConstraints(Q *Calc) (string, string, error) {
+	if Q.CConstraints == nil && Q.IConstraints == nil {
+		return "", "", nil
+	}
+	var cons, colvars strings.Builder
+	cons.WriteString("  &CONSTRAINT\n")
+	if Q.CConstraints != nil {
+		fixed := make([]string, 0, len(Q.CConstraints))
+		for _, v := range Q.CConstraints {
+			fixed = append(fixed, strconv.Itoa(v+1)) //1-based indexes for CP2K
+		}
+		fmt.Fprintf(&cons, "    &FIXED_ATOMS\n      LIST %s\n    &END FIXED_ATOMS\n", strings.Join(fixed, " "))
+	}
+	names := map[byte]string{'B': "DISTANCE", 'A': "ANGLE", 'D': "TORSION"}
+	units := map[byte]string{'B': "[angstrom]", 'A': "[deg]", 'D': "[deg]"}
+	for i, v := range Q.IConstraints {
+		if iConstraintOrder[v.Class] != len(v.CAtoms) {
+			return "", "", Error{"Internal constraint ill-formated", CP2K, O.inputname, "", []string{"buildConstraints"}, true}
+		}
+		atoms := make([]string, 0, 4)
+		for _, w := range v.CAtoms {
+			atoms = append(atoms, strconv.Itoa(w+1))
+		}
+		fmt.Fprintf(&colvars, "    &COLVAR\n      &%s\n        ATOMS %s\n      &END %s\n    &END COLVAR\n", names[v.Class], strings.Join(atoms, " "), names[v.Class])
+		target := ""
+		if v.UseVal { //otherwise, the value in the starting structure is kept.
+			target = fmt.Sprintf("      TARGET %s %.3f\n", units[v.Class], v.Val)
+		}
+		fmt.Fprintf(&cons, "    &COLLECTIVE\n      COLVAR %d\n%s      INTERMOLECULAR FALSE\n    &END COLLECTIVE\n", i+1, target)
+	}
+	cons.WriteString("  &END CONSTRAINT\n")
+	return cons.String(), colvars.String(), nil
+}
+
+//Run runs the command given by the string O.command, with mpirun if more than one CPU is to be used.
+//It waits or not for the result depending on wait.
+//Not waiting for results works
+//only for unix-compatible systems, as it uses sh and nohup.
+func (O *CP2KHandle) Run(wait bool) (err error) {
+	if wait {
+		return O.RunContext(context.Background())
+	}
+	command := exec.Command("sh", "-c", "nohup "+strings.Join(O.runArgs(), " ")+" > /dev/null 2>&1 &")
+	command.Dir = O.wrkdir
+	err = command.Start()
+	if err != nil {
+		err = Error{ErrNotRunning, CP2K, O.inputname, err.Error(), []string{"exec.Start", "Run"}, true}
+	}
+	return err
+}
+
+//RunContext runs the command given by the string O.command, with mpirun if more than one CPU
+//is to be used, and waits for it to finish. The program is killed if ctx is done before that.
+func (O *CP2KHandle) RunContext(ctx context.Context) error {
+	args := O.runArgs()
+	command := exec.CommandContext(ctx, args[0], args[1:]...)
+	command.Dir = O.wrkdir
+	if err := command.Run(); err != nil {
+		return Error{ErrNotRunning, CP2K, O.inputname, err.Error(), []string{"exec.Run", "RunContext"}, true}
+	}
+	return nil
+}
+
+//runArgs returns the full command line for CP2K, including mpirun if needed.
+func (O *CP2KHandle) runArgs() []string {
+	args := []string{O.command, "-i", O.inputname + ".inp", "-o", O.inputname + ".out"}
+	if O.nCPU > 1 {
+		args = append([]string{"mpirun", "-np", strconv.Itoa(O.nCPU)}, args...)
+	}
+	return args
+}
+
+//Energy returns the energy of a previous CP2K calculation, in kcal/mol.
+//Returns error if problem, and also if the energy returned that is product of an
+//abnormally-terminated CP2K calculation. (in this case error is "Probable problem
+//in calculation")
+func (O *CP2KHandle) Energy() (float64, error) {
+	f, err := os.Open(O.name(".out"))
+	if err != nil {
+		return 0, Error{ErrNoEnergy, CP2K, O.inputname, err.Error(), []string{"os.Open", "Energy"}, true}
+	}
+	defer f.Close()
+	energy, normal, err := cp2kEnergy(f)
+	if err != nil {
+		return 0, Error{ErrNoEnergy, CP2K, O.inputname, err.Error(), []string{"cp2kEnergy", "Energy"}, true}
+	}
+	if !normal {
+		return energy * chem.H2Kcal, Error{ErrProbableProblem, CP2K, O.inputname, "", []string{"Energy"}, false}
+	}
+	return energy * chem.H2Kcal, nil
+}
+
+//cp2kEnergy returns the last total energy in a CP2K output, in Hartree, and whether the
+//calculation terminated normally.
+func cp2kEnergy(r io.Reader) (float64, bool, error) {
+	energy := 0.0
+	found := false
+	normal := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if strings.Contains(line, "PROGRAM ENDED AT") {
+			normal = true
+			continue
+		}
+		if !strings.Contains(line, "ENERGY| Total FORCE_EVAL") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var err error
+		energy, err = strconv.ParseFloat(fields[len(fields)-1], 64)
+		if err != nil {
+			return 0, false, err
+		}
+		found = true
+	}
+	if err := s.Err(); err != nil {
+		return 0, false, err
+	}
+	if !found {
+		return 0, false, fmt.Errorf("No energy found")
+	}
+	return energy, normal, nil
+}
+
+//OptimizedGeometry reads the last geometry of a CP2K optimization or MD from the
+//trajectory it writes (name-pos-1.xyz). Returns the
+//geometry or error. Returns the geometry AND error if the geometry read
+//is not the product of a correctly ended CP2K calculation. In this case
+//the error is "probable problem in calculation".
+func (O *CP2KHandle) OptimizedGeometry(atoms chem.Atomer) (*v3.Matrix, error) {
+	mol, err := chem.XYZFileRead(O.name("-pos-1.xyz"))
+	if err != nil {
+		return nil, Error{ErrNoGeometry, CP2K, O.inputname, err.Error(), []string{"chem.XYZFileRead", "OptimizedGeometry"}, true}
+	}
+	coords := mol.Coords[len(mol.Coords)-1]
+	if atoms != nil && atoms.Len() != coords.NVecs() {
+		return nil, Error{ErrNoGeometry, CP2K, O.inputname, "Wrong number of atoms", []string{"OptimizedGeometry"}, true}
+	}
+	f, err := os.Open(O.name(".out"))
+	if err != nil {
+		return coords, Error{ErrProbableProblem, CP2K, O.inputname, err.Error(), []string{"os.Open", "OptimizedGeometry"}, false}
+	}
+	defer f.Close()
+	if _, normal, err := cp2kEnergy(f); err != nil || !normal {
+		return coords, Error{ErrProbableProblem, CP2K, O.inputname, "", []string{"OptimizedGeometry"}, false}
+	}
+	return coords, nil
+}
+
+//Charges returns the Mulliken charges from a previous CP2K calculation where the
+//Charges job was requested.
+func (O *CP2KHandle) Charges() ([]float64, error) {
+	return O.schemeCharges("Charges", "", Mulliken)
+}
+
+//SchemeCharges returns the partial charges of the given scheme (Mulliken or Hirshfeld) from a previous CP2K
+//calculation where the Charges job was requested.
+func (O *CP2KHandle) SchemeCharges(scheme string) ([]float64, error) {
+	return O.schemeCharges("SchemeCharges", scheme)
+}
+
+func (O *CP2KHandle) schemeCharges(caller, scheme string, defaults ...string) ([]float64, error) {
+	f, err := os.Open(O.name(".out"))
+	if err != nil {
+		return nil, Error{ErrNoCharges, CP2K, O.inputname, err.Error(), []string{"os.Open", caller}, true}
+	}
+	defer f.Close()
+	all, err := cp2kCharges(f)
+	if err != nil {
+		return nil, Error{ErrNoCharges, CP2K, O.inputname, err.Error(), []string{"cp2kCharges", caller}, true}
+	}
+	charges, err := chooseCharges(all, scheme, defaults...)
+	if err != nil {
+		return nil, Error{ErrNoCharges, CP2K, O.inputname, err.Error(), []string{"chooseCharges", caller}, true}
+	}
+	return charges, nil
+}
+
+//Dipole returns the dipole moment from a previous CP2K calculation, in Debye.
+func (O *CP2KHandle) Dipole() (*v3.Matrix, error) {
+	f, err := os.Open(O.name(".out"))
+	if err != nil {
+		return nil, Error{ErrNoDipole, CP2K, O.inputname, err.Error(), []string{"os.Open", "Dipole"}, true}
+	}
+	defer f.Close()
+	d, err := cp2kDipole(f)
+	if err == nil {
+		var dipole *v3.Matrix
+		if dipole, err = dipoleMatrix(d, 1); err == nil {
+			return dipole, nil
+		}
+	}
+	return nil, Error{ErrNoDipole, CP2K, O.inputname, err.Error(), []string{"cp2kDipole", "Dipole"}, true}
+}
+
+//cp2kCharges returns the last set of each type of charges ("Mulliken" and "Hirshfeld") in a CP2K output.
+func cp2kCharges(r io.Reader) (map[string][]float64, error) {
+	ret := make(map[string][]float64)
+	var current string
+	var charges []float64
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		fields := strings.Fields(line)
+		if current == "" {
+			if strings.Contains(line, "Mulliken Population Analysis") {
+				current = "Mulliken"
+			} else if strings.Contains(line, "Hirshfeld Charges") {
+				current = "Hirshfeld"
+			} else {
+				continue
+			}
+			charges = make([]float64, 0, 10)
+			continue
+		}
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#A") || fields[0] == "#" && len(fields) > 1 && fields[1] == "Atom" {
+			continue //the blank line and the titles
+		}
+		if fields[0] == "#" || len(fields) < 5 {
+			//the "# Total charge" or "Total Charge" line
+			ret[current] = charges
+			current = ""
+			continue
+		}
+		//The net charge is the last value, except for Mulliken charges in open-shell
+		//calculations, where the spin moment comes after it.
+		col := len(fields) - 1
+		if current == "Mulliken" && len(fields) == 7 {
+			col = 5
+		}
+		c, err := strconv.ParseFloat(fields[col], 64)
+		if err != nil {
+			return nil, err
+		}
+		charges = append(charges, c)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+var cp2kDisp = map[string]string{
+	"nodisp": "",
+	"D2":     "DFTD2",
+	"D3":     "DFTD3",
+	"D3ZERO": "DFTD3",
+	"D3Zero": "DFTD3",
+	"D3zero": "DFTD3",
+	"D3BJ":   "DFTD3(BJ)",
+	"D3bj":   "DFTD3(BJ)",
+}
+
+var cp2kSCFTight = map[int]string{
+	0: "1.0E-6",
+	1: "1.0E-7",
+	2: "1.0E-8",
+}
+
+var cp2kSCFConv = map[int]string{
+	0: "      MAX_SCF 50\n",
+	1: "      MAX_SCF 100\n",
+	2: "      MAX_SCF 50\n      &OUTER_SCF\n        MAX_SCF 20\n      &END OUTER_SCF\n",
+}
+
+//cp2kGrid contains the plane-wave cutoffs, in Ry, for each grid level.
+var cp2kGrid = map[int]int{
+	0: 400,
+	1: 300,
+	2: 400,
+	3: 500,
+	4: 600,
+}
+
+//cp2kDipole returns the last dipole moment, in Debye, in a CP2K output.
+func cp2kDipole(r io.Reader) ([]float64, error) {
+	var dipole []float64
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if strings.TrimSpace(s.Text()) != "Dipole moment [Debye]" || !s.Scan() {
+			continue
+		}
+		//X=   -0.00000011 Y=    0.00000000 Z=   -1.89856743     Total=      1.89856743
+		fields := strings.Fields(strings.Replace(s.Text(), "=", "= ", -1))
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("Malformed dipole line: %s", s.Text())
+		}
+		dipole = make([]float64, 3)
+		for i := range dipole {
+			d, err := strconv.ParseFloat(fields[2*i+1], 64)
+			if err != nil {
+				return nil, err
+			}
+			dipole[i] = d
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return dipole, nil
+}
@@ -0,0 +1,139 @@
+/*
+ * cp2k_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//testCP2KOut is an excerpt of the output of a CP2K 7.1 single point of water, with charges.
+const testCP2KOut = `                     Mulliken Population Analysis
+
+ #  Atom  Element  Kind  Atomic population                           Net charge
+       1     O        1          6.257116                           -0.257116
+       2     H        2          0.871442                            0.128558
+       3     H        2          0.871442                            0.128558
+ # Total charge                              8.000000                 -0.000000
+
+                           Hirshfeld Charges
+
+  #Atom  Element  Kind  Ref Charge     Population                    Net charge
+      1       O      1       6.000          6.311                        -0.311
+      2       H      2       1.000          0.844                         0.156
+      3       H      2       1.000          0.844                         0.156
+
+  Total Charge                                                           -0.000
+
+ ENERGY| Total FORCE_EVAL ( QS ) energy [a.u.]:              -17.146760848648233
+
+  **** **** ******  **  PROGRAM ENDED AT                 2021-03-01 12:00:00.000
+`
+
+//testCP2KPos is the trajectory of a short CP2K optimization.
+const testCP2KPos = `       3
+ i =        0, E =       -17.1467608486
+  O         0.0000000000        0.0000000000        0.1200000000
+  H         0.0000000000        0.7600000000       -0.4800000000
+  H         0.0000000000       -0.7600000000       -0.4800000000
+       3
+ i =        5, E =       -17.1483240512
+  O         0.0000000000        0.0000000000        0.1156230000
+  H         0.0000000000        0.7681240000       -0.4752310000
+  H         0.0000000000       -0.7681240000       -0.4752310000
+`
+
+func TestCP2K(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "goqm")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mol := chem.NewTopology(0, 1, []*chem.Atom{{Symbol: "O"}, {Symbol: "H"}, {Symbol: "H"}})
+	coords, _ := v3.NewMatrix([]float64{0, 0, 0.12, 0, 0.76, -0.48, 0, -0.76, -0.48})
+	calc := &Calc{Method: "pbe", Dispersion: "D3", CConstraints: []int{0}, Job: Job{Opti: true},
+		IConstraints: []*IConstraint{{CAtoms: []int{0, 1}, Val: 0.97, Class: 'B', UseVal: true}}}
+	c := NewCP2KHandle()
+	c.SetWorkDir(dir)
+	c.SetName("water")
+	if err := c.BuildInput(coords, mol, calc); err != nil {
+		Te.Fatal(err)
+	}
+	input, err := ioutil.ReadFile(filepath.Join(dir, "water.inp"))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for _, v := range []string{"  RUN_TYPE GEO_OPT\n", "      &XC_FUNCTIONAL PBE\n", "          TYPE DFTD3\n", "      PERIODIC NONE\n",
+		"      LIST 1\n", "      &DISTANCE\n        ATOMS 1 2\n", "      TARGET [angstrom] 0.970\n", "    &KIND H\n      BASIS_SET DZVP-MOLOPT-SR-GTH\n      POTENTIAL GTH-PBE\n"} {
+		if !strings.Contains(string(input), v) {
+			Te.Errorf("The input doesn't contain %q:\n%s", v, input)
+		}
+	}
+	//A periodic MD.
+	box, _ := chem.NewBoxFromParams(10, 10, 10, 90, 90, 90)
+	c.SetBox(box)
+	calc = &Calc{Job: Job{MD: true}, MDTime: 1, MDTemp: 300}
+	if err := c.BuildInput(coords, mol, calc); err != nil {
+		Te.Fatal(err)
+	}
+	input, _ = ioutil.ReadFile(filepath.Join(dir, "water.inp"))
+	for _, v := range []string{"  RUN_TYPE MD\n", "    STEPS 2000\n", "      PERIODIC XYZ\n", "      A    10.000000    0.000000    0.000000\n"} {
+		if !strings.Contains(string(input), v) {
+			Te.Errorf("The input doesn't contain %q:\n%s", v, input)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "water.out"), []byte(testCP2KOut), 0644); err != nil {
+		Te.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "water-pos-1.xyz"), []byte(testCP2KPos), 0644); err != nil {
+		Te.Fatal(err)
+	}
+	energy, err := c.Energy()
+	if err != nil || math.Abs(energy-(-17.146760848648233*chem.H2Kcal)) > 1e-6 {
+		Te.Errorf("Wrong energy read: %f %v", energy, err)
+	}
+	geo, err := c.OptimizedGeometry(mol)
+	if err != nil || geo.At(1, 1) != 0.768124 {
+		Te.Errorf("Wrong geometry read: %v %v", geo, err)
+	}
+	charges, err := c.Charges()
+	if err != nil || len(charges) != 3 || charges[1] != 0.128558 {
+		Te.Errorf("Wrong charges read: %v %v", charges, err)
+	}
+	all, err := cp2kCharges(strings.NewReader(testCP2KOut))
+	if err != nil || len(all["Hirshfeld"]) != 3 || all["Hirshfeld"][0] != -0.311 {
+		Te.Errorf("Wrong Hirshfeld charges read: %v %v", all, err)
+	}
+}
@@ -0,0 +1,872 @@
+/*
+ * gaussian.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+	"gonum.org/v1/gonum/mat"
+)
+
+//GaussianHandle represents a Gaussian calculation.
+//Note that the default methods and basis vary with each program, and even
+//for a given program they are NOT considered part of the API, so they can always change.
+type GaussianHandle struct {
+	defmethod  string
+	defbasis   string
+	previousMO string
+	command    string
+	formchk    string
+	inputname  string
+	wrkdir     string
+	nCPU       int
+}
+
+//NewGaussianHandle initializes and returns a new GaussianHandle.
+func NewGaussianHandle() *GaussianHandle {
+	run := new(GaussianHandle)
+	run.SetDefaults()
+	return run
+}
+
+//GaussianHandle methods
+
+//SetnCPU sets the number of CPU to be used.
+func (O *GaussianHandle) SetnCPU(cpu int) {
+	O.nCPU = cpu
+}
+
+//SetName sets the name of the job, which will reflect in the
+//name of the input (name.gjf) and output (name.log, name.chk and name.fchk) files.
+func (O *GaussianHandle) SetName(name string) {
+	O.inputname = name
+}
+
+//SetCommand sets the name and path of the Gaussian excecutable.
+func (O *GaussianHandle) SetCommand(name string) {
+	O.command = name
+}
+
+//SetFormchk sets the name and path of the formchk utility, which is run after the calculation
+//to produce the formatted checkpoint file. An empty string means that formchk is not run.
+func (O *GaussianHandle) SetFormchk(name string) {
+	O.formchk = name
+}
+
+//SetMOName sets the name of a checkpoint file from which the initial guess
+//is read, if the OldMO field of the Calc is true.
+func (O *GaussianHandle) SetMOName(name string) {
+	O.previousMO = name
+}
+
+//SetWorkDir sets the name of the working directory for the calculation.
+func (O *GaussianHandle) SetWorkDir(d string) {
+	O.wrkdir = d
+}
+
+//SetDefaults sets defaults for the Gaussian calculation. The default is
+//currently a single-point at B3LYP/def2-SVP, with half the logical CPUs available.
+//The command is g16, and formchk is run after the calculation.
+//The default is _not_ part of the API, it can change as new methods appear.
+func (O *GaussianHandle) SetDefaults() {
+	O.defmethod = "B3LYP"
+	O.defbasis = "def2SVP"
+	O.command = "g16"
+	O.formchk = "formchk"
+	O.nCPU = runtime.NumCPU() / 2
+}
+
+//name returns the path of the file for the job with the extension ext.
+func (O *GaussianHandle) name(ext string) string {
+	return filepath.Join(O.wrkdir, O.inputname+ext)
+}
+
+//BuildInput builds a Gaussian input (name.gjf) based on the data in atoms, coords and Q.
+//returns only error.
+func (O *GaussianHandle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger, Q *Calc) error {
+	if O.inputname == "" {
+		O.inputname = "gochem"
+	}
+	if atoms == nil || coords == nil {
+		return Error{ErrMissingCharges, Gaussian, O.inputname, "", []string{"BuildInput"}, true}
+	}
+	if Q.Method == "" {
+		log.Printf("no method assigned for Gaussian calculation, will use the default %s, \n", O.defmethod)
+		Q.Method = O.defmethod
+	}
+	if Q.Basis == "" {
+		log.Printf("no basis set assigned for Gaussian calculation, will use the default %s, \n", O.defbasis)
+		Q.Basis = O.defbasis
+	}
+	method := Q.Method
+	if m, ok := gaussianMethods[strings.ToLower(method)]; ok {
+		method = m
+	}
+	//Per-element or per-atom basis sets, or ECPs, need the basis to be given in the input.
+	basis := gaussianBasis(Q.Basis)
+	gen := Q.HBElements != nil || Q.LBElements != nil || Q.HBAtoms != nil || Q.LBAtoms != nil
+	if Q.ECPElements != nil {
+		basis = "GenECP"
+	} else if gen {
+		basis = "Gen"
+	}
+	route := []string{"#P", method + "/" + basis, "NoSymm"}
+	if Q.Dispersion != "" {
+		disp, ok := gaussianDisp[Q.Dispersion]
+		if !ok {
+			return Error{"goChem/QM: Dispersion correction not supported", Gaussian, O.inputname, Q.Dispersion, []string{"BuildInput"}, true}
+		}
+		if disp != "" {
+			route = append(route, "EmpiricalDispersion="+disp)
+		}
+	}
+	scf := []string{}
+	if t := gaussianSCFTight[Q.SCFTightness]; t != "" {
+		scf = append(scf, t)
+	}
+	if c := gaussianSCFConv[Q.SCFConvHelp]; c != "" {
+		scf = append(scf, c)
+	}
+	if len(scf) > 0 {
+		route = append(route, fmt.Sprintf("SCF=(%s)", strings.Join(scf, ",")))
+	}
+	if g, ok := gaussianGrid[Q.Grid]; ok {
+		route = append(route, "Int=(Grid="+g+")")
+	}
+	if Q.Dielectric > 0 {
+		route = append(route, "SCRF=(PCM,Solvent=Generic,Read)")
+	}
+	oldchk := ""
+	if Q.OldMO {
+		route = append(route, "Guess=Read")
+		if O.previousMO != "" {
+			oldchk = fmt.Sprintf("%%OldChk=%s\n", O.previousMO)
+		}
+	} else if Q.Guess != "" {
+		route = append(route, "Guess="+Q.Guess)
+	}
+	modred := Q.CConstraints != nil || Q.IConstraints != nil
+	jc := jobChoose{}
+	jc.opti = func() {
+		opts := []string{}
+		if modred {
+			opts = append(opts, "ModRedundant")
+		}
+		if Q.CartesianOpt {
+			opts = append(opts, "Cartesian")
+		}
+		if len(opts) == 0 {
+			route = append(route, "Opt")
+		} else {
+			route = append(route, fmt.Sprintf("Opt=(%s)", strings.Join(opts, ",")))
+		}
+	}
+	jc.forces = func() {
+		route = append(route, "Freq") //Gaussian also computes the gradient in frequency calculations.
+	}
+	jc.charges = func() {
+		route = append(route, "Pop=MK")
+	}
+	Q.Job.Do(jc)
+	if Q.Others != "" {
+		route = append(route, Q.Others)
+	}
+	var modredundant string
+	if modred && Q.Job.Opti {
+		var err error
+		modredundant, err = O.buildModRedundant(Q)
+		if err != nil {
+			return errDecorate(err, "BuildInput")
+		}
+	}
+	//Now lets write the thing
+	file, err := os.Create(O.name(".gjf"))
+	if err != nil {
+		return Error{ErrCantInput, Gaussian, O.inputname, err.Error(), []string{"os.Create", "BuildInput"}, true}
+	}
+	defer file.Close()
+	out := bufio.NewWriter(file)
+	if O.nCPU > 1 {
+		fmt.Fprintf(out, "%%NProcShared=%d\n", O.nCPU)
+	}
+	if Q.Memory != 0 {
+		fmt.Fprintf(out, "%%Mem=%dMB\n", Q.Memory)
+	}
+	fmt.Fprint(out, oldchk)
+	fmt.Fprintf(out, "%%Chk=%s.chk\n", O.inputname)
+	fmt.Fprintf(out, "%s\n\n%s\n\n", strings.Join(route, " "), "Input written by goChem")
+	fmt.Fprintf(out, "%d %d\n", atoms.Charge(), atoms.Multi())
+	for i := 0; i < atoms.Len(); i++ {
+		fmt.Fprintf(out, "%-2s  %12.6f%12.6f%12.6f\n", atoms.Atom(i).Symbol, coords.At(i, 0), coords.At(i, 1), coords.At(i, 2))
+	}
+	fmt.Fprint(out, "\n")
+	//The additional sections go in this order after the geometry.
+	fmt.Fprint(out, modredundant)
+	if Q.ECPElements != nil || gen {
+		fmt.Fprint(out, O.buildBasis(atoms, Q))
+	}
+	if Q.Dielectric > 0 {
+		fmt.Fprintf(out, "Eps=%.4f\nEpsInf=1.7800\n\n", Q.Dielectric)
+	}
+	if err := out.Flush(); err != nil {
+		return Error{ErrCantInput, Gaussian, O.inputname, err.Error(), []string{"bufio.Writer.Flush", "BuildInput"}, true}
+	}
+	return nil
+}
+
+//buildModRedundant returns the ModRedundant section of the input, with the
+//cartesian constraints and the internal constraints in Q.
+func (O *GaussianHandle) buildModRedundant(Q *Calc) (string, error) {
+	var b strings.Builder
+	for _, v := range Q.CConstraints {
+		fmt.Fprintf(&b, "X %d F\n", v+1) //1-based indexes for Gaussian
+	}
+	for _, v := range Q.IConstraints {
+		if iConstraintOrder[v.Class] != len(v.CAtoms) {
+			return "", Error{"Internal constraint ill-formated", Gaussian, O.inputname, "", []string{"buildModRedundant"}, true}
+		}
+		b.WriteByte(v.Class)
+		for _, w := range v.CAtoms {
+			fmt.Fprintf(&b, " %d", w+1)
+		}
+		if v.UseVal {
+			fmt.Fprintf(&b, " %.3f", v.Val)
+		}
+		b.WriteString(" F\n")
+	}
+	b.WriteString("\n")
+	return b.String(), nil
+}
+
+//buildBasis returns the basis set section for a Gen or GenECP calculation, with the basis
+//for each element or atom, and the ECP section, if needed.
+func (O *GaussianHandle) buildBasis(atoms chem.AtomMultiCharger, Q *Calc) string {
+	basisnames := make([]string, 0, 3)
+	centers := make(map[string][]string)
+	add := func(basis, center string) {
+		if _, ok := centers[basis]; !ok {
+			basisnames = append(basisnames, basis)
+		}
+		if !isInString(centers[basis], center) {
+			centers[basis] = append(centers[basis], center)
+		}
+	}
+	elements := make([]string, 0, 5)
+	for i := 0; i < atoms.Len(); i++ {
+		symbol := atoms.Atom(i).Symbol
+		if !isInString(elements, symbol) {
+			elements = append(elements, symbol)
+		}
+		switch {
+		case isInInt(Q.HBAtoms, i):
+			add(Q.HighBasis, strconv.Itoa(i+1))
+		case isInInt(Q.LBAtoms, i):
+			add(Q.LowBasis, strconv.Itoa(i+1))
+		case isInString(Q.HBElements, symbol):
+			add(Q.HighBasis, symbol)
+		case isInString(Q.LBElements, symbol):
+			add(Q.LowBasis, symbol)
+		default:
+			add(Q.Basis, symbol)
+		}
+	}
+	var b strings.Builder
+	for _, v := range basisnames {
+		fmt.Fprintf(&b, "%s 0\n%s\n****\n", strings.Join(centers[v], " "), gaussianBasis(v))
+	}
+	b.WriteString("\n")
+	if Q.ECPElements == nil {
+		return b.String()
+	}
+	ecp := Q.ECP
+	if ecp == "" {
+		ecp = Q.Basis //The Karlsruhe basis sets have their own ECPs.
+	}
+	ecpelements := make([]string, 0, len(Q.ECPElements))
+	for _, v := range Q.ECPElements {
+		if isInString(elements, v) {
+			ecpelements = append(ecpelements, v)
+		}
+	}
+	if len(ecpelements) > 0 {
+		fmt.Fprintf(&b, "%s 0\n%s\n\n", strings.Join(ecpelements, " "), gaussianBasis(ecp))
+	}
+	return b.String()
+}
+
+//gaussianBasis returns the Gaussian name for the basis set basis. Only the Karlsruhe basis sets
+//need to be changed.
+func gaussianBasis(basis string) string {
+	if strings.HasPrefix(strings.ToLower(basis), "def2-") {
+		return "def2" + basis[len("def2-"):]
+	}
+	return basis
+}
+
+//Run runs the command given by the string O.command, followed by formchk, unless it
+//has been set to an empty string. It waits or not for the result depending on wait.
+//Not waiting for results works only for unix-compatible systems, as it uses sh and nohup.
+func (O *GaussianHandle) Run(wait bool) (err error) {
+	if wait {
+		return O.RunContext(context.Background())
+	}
+	com := fmt.Sprintf("%s %s.gjf", O.command, O.inputname)
+	if O.formchk != "" {
+		com += fmt.Sprintf(" && %s %s.chk %s.fchk", O.formchk, O.inputname, O.inputname)
+	}
+	command := exec.Command("sh", "-c", fmt.Sprintf("nohup sh -c '%s' > /dev/null 2>&1 &", com))
+	command.Dir = O.wrkdir
+	err = command.Start()
+	if err != nil {
+		err = Error{ErrNotRunning, Gaussian, O.inputname, err.Error(), []string{"exec.Start", "Run"}, true}
+	}
+	return err
+}
+
+//RunContext runs the command given by the string O.command, followed by formchk, unless it
+//has been set to an empty string, and waits for them to finish. The programs are killed if
+//ctx is done before that.
+func (O *GaussianHandle) RunContext(ctx context.Context) error {
+	steps := [][]string{append(strings.Fields(O.command), O.inputname+".gjf")}
+	if O.formchk != "" {
+		steps = append(steps, append(strings.Fields(O.formchk), O.inputname+".chk", O.inputname+".fchk"))
+	}
+	for _, args := range steps {
+		command := exec.Command(args[0], args[1:]...)
+		command.Dir = O.wrkdir
+		if err := runGroup(ctx, command); err != nil {
+			return Error{ErrNotRunning, Gaussian, O.inputname, err.Error(), []string{"exec.Run", "RunContext"}, true}
+		}
+	}
+	return nil
+}
+
+//output opens the Gaussian log file for the job or, if there is none, the formatted checkpoint file.
+//It returns the file, and whether it is a formatted checkpoint.
+func (O *GaussianHandle) output(caller, errmsg string) (*os.File, bool, error) {
+	f, err := os.Open(O.name(".log"))
+	if err == nil {
+		return f, false, nil
+	}
+	f, err2 := os.Open(O.name(".fchk"))
+	if err2 != nil {
+		return nil, false, Error{errmsg, Gaussian, O.inputname, err.Error(), []string{"os.Open", caller}, true}
+	}
+	return f, true, nil
+}
+
+//Energy returns the energy of a previous Gaussian calculation, in kcal/mol, from the log file or, if
+//it is not present, from the formatted checkpoint file.
+//Returns error if problem, and also if the energy returned that is product of an
+//abnormally-terminated Gaussian calculation. (in this case error is "Probable problem
+//in calculation")
+func (O *GaussianHandle) Energy() (float64, error) {
+	f, fchk, err := O.output("Energy", ErrNoEnergy)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if fchk {
+		data, err := gaussianReadFchk(f)
+		if err != nil || len(data["Total Energy"]) == 0 {
+			return 0, Error{ErrNoEnergy, Gaussian, O.inputname, "", []string{"Energy"}, true}
+		}
+		return data["Total Energy"][0] * chem.H2Kcal, nil
+	}
+	energy, normal, err := gaussianLogEnergy(f)
+	if err != nil {
+		return 0, Error{ErrNoEnergy, Gaussian, O.inputname, err.Error(), []string{"gaussianLogEnergy", "Energy"}, true}
+	}
+	if !normal {
+		return energy * chem.H2Kcal, Error{ErrProbableProblem, Gaussian, O.inputname, "", []string{"Energy"}, false}
+	}
+	return energy * chem.H2Kcal, nil
+}
+
+//OptimizedGeometry reads the latest geometry from a Gaussian calculation, from the log file or, if
+//it is not present, from the formatted checkpoint file. Returns the
+//geometry or error. Returns the geometry AND error if the geometry read
+//is not the product of a correctly ended Gaussian calculation. In this case
+//the error is "probable problem in calculation".
+func (O *GaussianHandle) OptimizedGeometry(atoms chem.Atomer) (*v3.Matrix, error) {
+	f, fchk, err := O.output("OptimizedGeometry", ErrNoGeometry)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if fchk {
+		data, err := gaussianReadFchk(f)
+		c := data["Current cartesian coordinates"]
+		if err != nil || len(c) == 0 || len(c)%3 != 0 {
+			return nil, Error{ErrNoGeometry, Gaussian, O.inputname, "", []string{"OptimizedGeometry"}, true}
+		}
+		coords, _ := v3.NewMatrix(c)
+		coords.Scale(chem.Bohr2A, coords)
+		return coords, nil
+	}
+	coords, normal, err := gaussianLogGeometry(f)
+	if err != nil {
+		return nil, Error{ErrNoGeometry, Gaussian, O.inputname, err.Error(), []string{"gaussianLogGeometry", "OptimizedGeometry"}, true}
+	}
+	if atoms != nil && atoms.Len() != coords.NVecs() {
+		return nil, Error{ErrNoGeometry, Gaussian, O.inputname, "Wrong number of atoms", []string{"OptimizedGeometry"}, true}
+	}
+	if !normal {
+		return coords, Error{ErrProbableProblem, Gaussian, O.inputname, "", []string{"OptimizedGeometry"}, false}
+	}
+	return coords, nil
+}
+
+//Charges returns the partial charges from a previous Gaussian calculation. The ESP charges are
+//returned if present (i.e. if the Charges job was requested), otherwise, the Mulliken charges.
+func (O *GaussianHandle) Charges() ([]float64, error) {
+	return O.schemeCharges("Charges", "", ESP, Mulliken)
+}
+
+//SchemeCharges returns the partial charges of the given scheme (Mulliken, ESP, Hirshfeld or CM5) from
+//a previous Gaussian calculation. Hirshfeld and CM5 charges are only printed if requested (Pop=Hirshfeld in
+//the Others field of Calc).
+func (O *GaussianHandle) SchemeCharges(scheme string) ([]float64, error) {
+	return O.schemeCharges("SchemeCharges", scheme)
+}
+
+func (O *GaussianHandle) schemeCharges(caller, scheme string, defaults ...string) ([]float64, error) {
+	f, fchk, err := O.output(caller, ErrNoCharges)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	all := make(map[string][]float64)
+	if fchk {
+		data, err := gaussianReadFchk(f)
+		if err != nil {
+			return nil, Error{ErrNoCharges, Gaussian, O.inputname, err.Error(), []string{"gaussianReadFchk", caller}, true}
+		}
+		for _, v := range []string{Mulliken, ESP, Hirshfeld, CM5} {
+			all[v] = data[v+" Charges"]
+		}
+	} else {
+		all, err = gaussianLogCharges(f)
+		if err != nil {
+			return nil, Error{ErrNoCharges, Gaussian, O.inputname, err.Error(), []string{"gaussianLogCharges", caller}, true}
+		}
+	}
+	charges, err := chooseCharges(all, scheme, defaults...)
+	if err != nil {
+		return nil, Error{ErrNoCharges, Gaussian, O.inputname, err.Error(), []string{"chooseCharges", caller}, true}
+	}
+	return charges, nil
+}
+
+//Dipole returns the dipole moment from a previous Gaussian calculation, in Debye.
+func (O *GaussianHandle) Dipole() (*v3.Matrix, error) {
+	f, fchk, err := O.output("Dipole", ErrNoDipole)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var d []float64
+	factor := 1.0
+	if fchk {
+		data, err := gaussianReadFchk(f)
+		if err != nil {
+			return nil, Error{ErrNoDipole, Gaussian, O.inputname, err.Error(), []string{"gaussianReadFchk", "Dipole"}, true}
+		}
+		d = data["Dipole Moment"]
+		factor = au2Debye
+	} else {
+		d, err = gaussianLogDipole(f)
+		if err != nil {
+			return nil, Error{ErrNoDipole, Gaussian, O.inputname, err.Error(), []string{"gaussianLogDipole", "Dipole"}, true}
+		}
+	}
+	dipole, err := dipoleMatrix(d, factor)
+	if err != nil {
+		return nil, Error{ErrNoDipole, Gaussian, O.inputname, err.Error(), []string{"dipoleMatrix", "Dipole"}, true}
+	}
+	return dipole, nil
+}
+
+//gaussianLogDipole returns the last dipole moment, in Debye, in a Gaussian log.
+func gaussianLogDipole(r io.Reader) ([]float64, error) {
+	var dipole []float64
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if !strings.Contains(s.Text(), "Dipole moment (field-independent basis, Debye):") {
+			continue
+		}
+		if !s.Scan() {
+			break
+		}
+		//X=  0.0000  Y=  0.0000  Z=  -2.0975  Tot=  2.0975
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("Malformed dipole line: %s", s.Text())
+		}
+		dipole = make([]float64, 3)
+		for i := range dipole {
+			d, err := strconv.ParseFloat(fields[2*i+1], 64)
+			if err != nil {
+				return nil, err
+			}
+			dipole[i] = d
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return dipole, nil
+}
+
+//Gradient returns the gradient from a previous Gaussian calculation, in kcal/(mol*A),
+//read from the formatted checkpoint file.
+func (O *GaussianHandle) Gradient() (*v3.Matrix, error) {
+	data, err := O.fchk("Gradient", ErrNoGradient)
+	if err != nil {
+		return nil, err
+	}
+	g := data["Cartesian Gradient"]
+	if len(g) == 0 || len(g)%3 != 0 {
+		return nil, Error{ErrNoGradient, Gaussian, O.inputname, "", []string{"Gradient"}, true}
+	}
+	grad, _ := v3.NewMatrix(g)
+	grad.Scale(hartreeBohr2KcalA, grad)
+	return grad, nil
+}
+
+//Hessian returns the Cartesian Hessian from a previous Gaussian Forces (frequency) calculation,
+//in kcal/(mol*A^2), read from the formatted checkpoint file.
+func (O *GaussianHandle) Hessian() (*mat.Dense, error) {
+	data, err := O.fchk("Hessian", ErrNoHessian)
+	if err != nil {
+		return nil, err
+	}
+	hess, err := packedLowerSym(data["Cartesian Force Constants"])
+	if err != nil {
+		return nil, Error{ErrNoHessian, Gaussian, O.inputname, err.Error(), []string{"packedLowerSym", "Hessian"}, true}
+	}
+	hess.Scale(hartreeBohr22KcalA, hess)
+	return hess, nil
+}
+
+//fchk reads the formatted checkpoint file for the job.
+func (O *GaussianHandle) fchk(caller, errmsg string) (map[string][]float64, error) {
+	f, err := os.Open(O.name(".fchk"))
+	if err != nil {
+		return nil, Error{errmsg, Gaussian, O.inputname, err.Error(), []string{"os.Open", caller}, true}
+	}
+	defer f.Close()
+	data, err := gaussianReadFchk(f)
+	if err != nil {
+		return nil, Error{errmsg, Gaussian, O.inputname, err.Error(), []string{"gaussianReadFchk", caller}, true}
+	}
+	return data, nil
+}
+
+//gaussianLogEnergy returns the last SCF energy in a Gaussian log, in Hartree, and whether the
+//calculation terminated normally.
+func gaussianLogEnergy(r io.Reader) (float64, bool, error) {
+	energy := 0.0
+	found := false
+	normal := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if strings.Contains(line, "Normal termination of Gaussian") {
+			normal = true
+			continue
+		}
+		if !strings.Contains(line, "SCF Done:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			return 0, false, fmt.Errorf("Malformed energy line: %s", line)
+		}
+		var err error
+		energy, err = strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return 0, false, err
+		}
+		found = true
+		normal = false //there could be more steps after this.
+	}
+	if err := s.Err(); err != nil {
+		return 0, false, err
+	}
+	if !found {
+		return 0, false, fmt.Errorf("No energy found")
+	}
+	return energy, normal, nil
+}
+
+//gaussianLogGeometry returns the last geometry in a Gaussian log, and whether the calculation
+//terminated normally. The input orientation is preferred to the standard orientation, so the
+//geometry is in the same reference frame as the input one.
+func gaussianLogGeometry(r io.Reader) (*v3.Matrix, bool, error) {
+	var input, standard []float64
+	var current *[]float64
+	normal := false
+	dashes := 0
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if current == nil {
+			switch {
+			case strings.Contains(line, "Normal termination of Gaussian"):
+				normal = true
+			case strings.Contains(line, "Input orientation:"):
+				current = &input
+			case strings.Contains(line, "Standard orientation:"):
+				current = &standard
+			}
+			if current != nil {
+				*current = (*current)[:0]
+				dashes = 0
+				normal = false
+			}
+			continue
+		}
+		//The coordinates are between the second and the third line of dashes after the title.
+		if strings.HasPrefix(strings.TrimSpace(line), "-----") {
+			dashes++
+			if dashes == 3 {
+				current = nil
+			}
+			continue
+		}
+		if dashes < 2 {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 6 {
+			return nil, false, fmt.Errorf("Malformed geometry line: %s", line)
+		}
+		for _, v := range fields[3:] {
+			c, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, false, err
+			}
+			*current = append(*current, c)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(input) == 0 {
+		input = standard
+	}
+	if len(input) == 0 {
+		return nil, false, fmt.Errorf("No geometry found")
+	}
+	coords, err := v3.NewMatrix(input)
+	return coords, normal, err
+}
+
+//gaussianChargesHeader matches the lines before the charges in a Gaussian log, such as
+//"Mulliken charges:" or "Mulliken charges and spin densities:", but not the charges with
+//hydrogens summed into heavy atoms.
+var gaussianChargesHeader = regexp.MustCompile(`^(\w+) (atomic )?charges( and spin densities)?:$`)
+
+//gaussianLogCharges returns the last set of each type of charges in a Gaussian log, in a map
+//with the name of the scheme ("Mulliken", "ESP", "Hirshfeld", "CM5", etc.) as key.
+func gaussianLogCharges(r io.Reader) (map[string][]float64, error) {
+	ret := make(map[string][]float64)
+	var current string
+	var charges, cm5 []float64
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		fields := strings.Fields(line)
+		if current == "" {
+			if m := gaussianChargesHeader.FindStringSubmatch(line); m != nil {
+				current = m[1]
+			} else if strings.HasPrefix(line, "Hirshfeld charges, spin densities, dipoles, and CM5 charges") {
+				current = "Hirshfeld"
+			} else {
+				continue
+			}
+			charges = make([]float64, 0, 10)
+			cm5 = make([]float64, 0, 10)
+			//The next line contains only the column numbers or names.
+			if !s.Scan() {
+				break
+			}
+			continue
+		}
+		//The charges end with the "Sum of ... charges" or the "Tot" line.
+		if len(fields) < 3 || fields[0] == "Sum" || fields[0] == "Tot" {
+			ret[current] = charges
+			if current == "Hirshfeld" {
+				ret["CM5"] = cm5
+			}
+			current = ""
+			continue
+		}
+		c, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, err
+		}
+		charges = append(charges, c)
+		if current == "Hirshfeld" {
+			c, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+			if err != nil {
+				return nil, err
+			}
+			cm5 = append(cm5, c)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+//gaussianReadFchk reads the real and integer values in a formatted checkpoint file, into a map with the
+//label of each value or array as key. Character and logical arrays are skipped.
+func gaussianReadFchk(r io.Reader) (map[string][]float64, error) {
+	ret := make(map[string][]float64)
+	s := bufio.NewScanner(r)
+	label := ""
+	n := 0    //the elements left to read in the current array.
+	skip := 0 //the lines left to skip in the current character or logical array.
+	lineno := 0
+	for s.Scan() {
+		line := s.Text()
+		lineno++
+		switch {
+		case lineno <= 2: //title and job type lines
+			continue
+		case skip > 0:
+			skip--
+			continue
+		case n > 0:
+			for _, v := range strings.Fields(line) {
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return nil, err
+				}
+				ret[label] = append(ret[label], f)
+				n--
+			}
+			continue
+		case strings.TrimSpace(line) == "":
+			continue
+		}
+		//The labels take 40 columns, followed by the type, and, for arrays, N= and the number of elements.
+		if len(line) < 45 {
+			return nil, fmt.Errorf("Malformed fchk line: %s", line)
+		}
+		label = strings.TrimSpace(line[:40])
+		kind := line[43]
+		fields := strings.Fields(line[44:])
+		if len(fields) == 2 && fields[0] == "N=" {
+			var err error
+			n, err = strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			switch kind {
+			case 'R', 'I':
+				ret[label] = make([]float64, 0, n)
+			case 'C':
+				skip, n = (n+4)/5, 0 //5 strings of 12 characters per line
+			case 'L':
+				skip, n = (n+71)/72, 0
+			default:
+				return nil, fmt.Errorf("Unknown fchk type in line: %s", line)
+			}
+			continue
+		}
+		if kind != 'R' && kind != 'I' {
+			continue
+		}
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("Malformed fchk line: %s", line)
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		ret[label] = []float64{v}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+var gaussianMethods = map[string]string{
+	"pbe":    "PBEPBE",
+	"pbe0":   "PBE1PBE",
+	"tpss":   "TPSSTPSS",
+	"revpbe": "revPBEPBE",
+	"bp86":   "BP86",
+	"b-p":    "BP86",
+	"blyp":   "BLYP",
+	"b3-lyp": "B3LYP",
+}
+
+var gaussianDisp = map[string]string{
+	"nodisp": "",
+	"D2":     "GD2",
+	"D3":     "GD3",
+	"D3ZERO": "GD3",
+	"D3Zero": "GD3",
+	"D3zero": "GD3",
+	"D3BJ":   "GD3BJ",
+	"D3bj":   "GD3BJ",
+}
+
+var gaussianSCFTight = map[int]string{
+	0: "",
+	1: "Tight",
+	2: "VeryTight",
+}
+
+var gaussianSCFConv = map[int]string{
+	0: "",
+	1: "XQC",
+	2: "QC",
+}
+
+var gaussianGrid = map[int]string{
+	1: "SG1",
+	2: "Fine",
+	3: "UltraFine",
+	4: "SuperFine",
+}
@@ -0,0 +1,171 @@
+/*
+ * gaussian_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//testGaussianLog is an excerpt of the log of a Gaussian 16 optimization of water.
+const testGaussianLog = ` Entering Gaussian System, Link 0=g16
+ #P B3LYP/def2SVP NoSymm Opt
+                          Input orientation:
+ ---------------------------------------------------------------------
+ Center     Atomic      Atomic             Coordinates (Angstroms)
+ Number     Number       Type             X           Y           Z
+ ---------------------------------------------------------------------
+      1          8           0        0.000000    0.000000    0.119262
+      2          1           0        0.000000    0.763239   -0.477047
+      3          1           0        0.000000   -0.763239   -0.477047
+ ---------------------------------------------------------------------
+ SCF Done:  E(RB3LYP) =  -76.3203481264     A.U. after   10 cycles
+                          Input orientation:
+ ---------------------------------------------------------------------
+ Center     Atomic      Atomic             Coordinates (Angstroms)
+ Number     Number       Type             X           Y           Z
+ ---------------------------------------------------------------------
+      1          8           0        0.000000    0.000000    0.115623
+      2          1           0        0.000000    0.768124   -0.475231
+      3          1           0        0.000000   -0.768124   -0.475231
+ ---------------------------------------------------------------------
+ SCF Done:  E(RB3LYP) =  -76.3207755321     A.U. after    8 cycles
+ Optimization completed.
+ Mulliken charges:
+               1
+     1  O   -0.361478
+     2  H    0.180739
+     3  H    0.180739
+ Sum of Mulliken charges =   0.00000
+ Mulliken charges with hydrogens summed into heavy atoms:
+               1
+     1  O    0.000000
+ Sum of Mulliken charges with hydrogens summed into heavy atoms =   0.00000
+ Hirshfeld charges, spin densities, dipoles, and CM5 charges using IRadAn=      4:
+              Q-H        S-H        Dx         Dy         Dz        Q-CM5
+     1  O   -0.327712   0.000000   0.000000   0.000000  -0.100958  -0.646291
+     2  H    0.163856   0.000000   0.000000   0.104321  -0.081456   0.323146
+     3  H    0.163856   0.000000   0.000000  -0.104321  -0.081456   0.323146
+       Tot   0.000000   0.000000   0.000000   0.000000  -0.263870   0.000000
+ Normal termination of Gaussian 16 at Mon Mar  1 12:00:00 2021.
+`
+
+//testGaussianFchk is an excerpt of the formatted checkpoint file of the same calculation.
+const testGaussianFchk = `water
+FOpt      RB3LYP                                                      def2SVP
+Number of atoms                            I                3
+Atomic numbers                             I   N=           3
+           8           1           1
+Current cartesian coordinates              R   N=           9
+  0.00000000E+00  0.00000000E+00  2.18494040E-01  0.00000000E+00  1.45155000E+00
+ -8.98058650E-01  0.00000000E+00 -1.45155000E+00 -8.98058650E-01
+Route                                      C   N=           4
+#P B3LYP/def2SVP NoSymm Opt
+Total Energy                               R     -7.632077553210000E+01
+Mulliken Charges                           R   N=           3
+ -3.61478000E-01  1.80739000E-01  1.80739000E-01
+`
+
+func TestGaussian(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "goqm")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mol := chem.NewTopology(0, 1, []*chem.Atom{{Symbol: "O"}, {Symbol: "H"}, {Symbol: "H"}})
+	coords, _ := v3.NewMatrix([]float64{0, 0, 0.12, 0, 0.76, -0.48, 0, -0.76, -0.48})
+	calc := &Calc{Method: "PBE0", Basis: "def2-TZVP", Dispersion: "D3BJ", Dielectric: 4, SCFTightness: 1, Memory: 1000,
+		ECPElements: []string{"O"}, CConstraints: []int{0}, Job: Job{Opti: true},
+		IConstraints: []*IConstraint{{CAtoms: []int{0, 1}, Val: 0.97, Class: 'B', UseVal: true}, {CAtoms: []int{1, 0, 2}, Class: 'A'}}}
+	g := NewGaussianHandle()
+	g.SetWorkDir(dir)
+	g.SetName("water")
+	g.SetnCPU(4)
+	if err := g.BuildInput(coords, mol, calc); err != nil {
+		Te.Fatal(err)
+	}
+	input, err := ioutil.ReadFile(filepath.Join(dir, "water.gjf"))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for _, v := range []string{"%NProcShared=4\n", "%Mem=1000MB\n", "#P PBE1PBE/GenECP NoSymm EmpiricalDispersion=GD3BJ SCF=(Tight) SCRF=(PCM,Solvent=Generic,Read) Opt=(ModRedundant)\n",
+		"\n0 1\nO       0.000000", "\n\nX 1 F\nB 1 2 0.970 F\nA 2 1 3 F\n\n", "O H 0\ndef2TZVP\n****\n\nO 0\ndef2TZVP\n\n", "Eps=4.0000\n"} {
+		if !strings.Contains(string(input), v) {
+			Te.Errorf("The input doesn't contain %q:\n%s", v, input)
+		}
+	}
+	//The output is read from the log, if present
+	if err := ioutil.WriteFile(filepath.Join(dir, "water.log"), []byte(testGaussianLog), 0644); err != nil {
+		Te.Fatal(err)
+	}
+	energy, err := g.Energy()
+	if err != nil || math.Abs(energy-(-76.3207755321*chem.H2Kcal)) > 1e-6 {
+		Te.Errorf("Wrong energy read: %f %v", energy, err)
+	}
+	geo, err := g.OptimizedGeometry(mol)
+	if err != nil || geo.At(1, 1) != 0.768124 {
+		Te.Errorf("Wrong geometry read: %v %v", geo, err)
+	}
+	charges, err := g.Charges()
+	if err != nil || len(charges) != 3 || charges[0] != -0.361478 {
+		Te.Errorf("Wrong charges read: %v %v", charges, err)
+	}
+	all, err := gaussianLogCharges(strings.NewReader(testGaussianLog))
+	if err != nil || len(all["CM5"]) != 3 || all["CM5"][0] != -0.646291 || all["Hirshfeld"][2] != 0.163856 {
+		Te.Errorf("Wrong Hirshfeld or CM5 charges read: %v %v", all, err)
+	}
+	//An unfinished calculation gives the results, with a non-critical error
+	unfinished := testGaussianLog[:strings.Index(testGaussianLog, " Normal termination")]
+	if _, normal, err := gaussianLogEnergy(strings.NewReader(unfinished)); err != nil || normal {
+		Te.Errorf("An unfinished calculation was not detected: %v", err)
+	}
+	//Now from the formatted checkpoint.
+	os.Remove(filepath.Join(dir, "water.log"))
+	if err := ioutil.WriteFile(filepath.Join(dir, "water.fchk"), []byte(testGaussianFchk), 0644); err != nil {
+		Te.Fatal(err)
+	}
+	energy, err = g.Energy()
+	if err != nil || math.Abs(energy-(-76.3207755321*chem.H2Kcal)) > 1e-6 {
+		Te.Errorf("Wrong energy read from the fchk file: %f %v", energy, err)
+	}
+	geo, err = g.OptimizedGeometry(mol)
+	if err != nil || math.Abs(geo.At(1, 1)-0.768124) > 1e-5 {
+		Te.Errorf("Wrong geometry read from the fchk file: %v %v", geo, err)
+	}
+	charges, err = g.Charges()
+	if err != nil || len(charges) != 3 || charges[1] != 0.180739 {
+		Te.Errorf("Wrong charges read from the fchk file: %v %v", charges, err)
+	}
+}
@@ -25,7 +25,9 @@ package qm
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
@@ -34,6 +36,7 @@ import (
 
 	chem "github.com/rmera/gochem"
 	v3 "github.com/rmera/gochem/v3"
+	"gonum.org/v1/gonum/mat"
 )
 
 //MopacHandle represents a MOPAC calculations
@@ -103,6 +106,12 @@ func (O *MopacHandle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger,
 		opt = "1SCF"
 	}
 	jc.opti = func() {}
+	jc.forces = func() {
+		opt = "FORCE"
+	}
+	jc.charges = func() {
+		opt = "1SCF ESP"
+	}
 	Q.Job.Do(jc)
 	//If this flag is set we'll look for a suitable MO file.
 	//If not found, we'll just use the default ORCA guess
@@ -169,20 +178,28 @@ var mopacMultiplicity = map[int]string{
 //only for unix-compatible systems, as it uses bash and nohup.
 func (O *MopacHandle) Run(wait bool) (err error) {
 	if wait == true {
-		command := exec.Command(O.command, fmt.Sprintf("%s.mop", O.inputname))
-		command.Dir = O.wrkdir
-		err = command.Run()
-	} else {
-		command := exec.Command("sh", "-c", "nohup "+O.command+fmt.Sprintf(" %s.mop &", O.inputname))
-		command.Dir = O.wrkdir
-		err = command.Start()
+		return O.RunContext(context.Background())
 	}
+	command := exec.Command("sh", "-c", "nohup "+O.command+fmt.Sprintf(" %s.mop &", O.inputname))
+	command.Dir = O.wrkdir
+	err = command.Start()
 	if err != nil {
-		err = Error{ErrNotRunning, Mopac, O.inputname, err.Error(), []string{"exec.Start/Run", "Run"}, true}
+		err = Error{ErrNotRunning, Mopac, O.inputname, err.Error(), []string{"exec.Start", "Run"}, true}
 	}
 	return
 }
 
+//RunContext runs the command given by the string O.command and waits for it to finish.
+//The program is killed if ctx is done before that.
+func (O *MopacHandle) RunContext(ctx context.Context) error {
+	command := exec.CommandContext(ctx, O.command, fmt.Sprintf("%s.mop", O.inputname))
+	command.Dir = O.wrkdir
+	if err := command.Run(); err != nil {
+		return Error{ErrNotRunning, Mopac, O.inputname, err.Error(), []string{"exec.Run", "RunContext"}, true}
+	}
+	return nil
+}
+
 //Energy gets the last energy for a MOPAC2009/2012 calculation by
 //parsing the mopac output file. Return error if fail. Also returns
 //Error ("Probable problem in calculation")
@@ -304,6 +321,216 @@ func (O *MopacHandle) OptimizedGeometry(atoms chem.Atomer) (*v3.Matrix, error) {
 	return mcoords, err
 }
 
+//Gradient returns the last gradient from a previous MOPAC calculation, in kcal/(mol*A), read from the
+//AUX file. MOPAC computes the gradient for optimizations and Forces calculations, and for single points
+//if the GRADIENTS keyword is given (in the Others field of Calc).
+func (O *MopacHandle) Gradient() (*v3.Matrix, error) {
+	inp := O.wrkdir + O.inputname
+	f, err := os.Open(fmt.Sprintf("%s.aux", inp))
+	if err != nil {
+		return nil, Error{ErrNoGradient, Mopac, inp, err.Error(), []string{"os.Open", "Gradient"}, true}
+	}
+	defer f.Close()
+	grad, err := mopacAuxArray(f, "GRADIENTS:")
+	if err != nil {
+		return nil, Error{ErrNoGradient, Mopac, inp, err.Error(), []string{"mopacAuxArray", "Gradient"}, true}
+	}
+	if len(grad)%3 != 0 {
+		return nil, Error{ErrNoGradient, Mopac, inp, "Gradient not given in Cartesian coordinates", []string{"Gradient"}, true}
+	}
+	return v3.NewMatrix(grad)
+}
+
+//Hessian returns the Cartesian Hessian from a previous MOPAC Forces calculation, in kcal/(mol*A^2),
+//read from the AUX file.
+func (O *MopacHandle) Hessian() (*mat.Dense, error) {
+	inp := O.wrkdir + O.inputname
+	f, err := os.Open(fmt.Sprintf("%s.aux", inp))
+	if err != nil {
+		return nil, Error{ErrNoHessian, Mopac, inp, err.Error(), []string{"os.Open", "Hessian"}, true}
+	}
+	defer f.Close()
+	packed, err := mopacAuxArray(f, "HESSIAN_MATRIX:")
+	if err != nil {
+		return nil, Error{ErrNoHessian, Mopac, inp, err.Error(), []string{"mopacAuxArray", "Hessian"}, true}
+	}
+	hess, err := packedLowerSym(packed) //MOPAC gives the lower triangle of the Hessian
+	if err != nil {
+		return nil, Error{ErrNoHessian, Mopac, inp, err.Error(), []string{"packedLowerSym", "Hessian"}, true}
+	}
+	hess.Scale(mdyneA2KcalA, hess)
+	return hess, nil
+}
+
+//mopacAuxArray returns the values for the last occurrence of the array with the given key
+//in a MOPAC AUX file. In the file, the key is followed by the number of elements in brackets
+//and an equal sign, and the values are given in the following lines.
+func mopacAuxArray(r io.Reader, key string) ([]float64, error) {
+	var ret, current []float64
+	n := 0
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, key) {
+			ini, end := strings.Index(line, "["), strings.Index(line, "]")
+			if ini < 0 || end < ini {
+				return nil, fmt.Errorf("Malformed AUX line: %s", line)
+			}
+			var err error
+			n, err = strconv.Atoi(line[ini+1 : end])
+			if err != nil {
+				return nil, err
+			}
+			current = make([]float64, 0, n)
+			line = line[strings.Index(line, "=")+1:]
+		}
+		if len(current) >= n {
+			continue
+		}
+		for _, v := range strings.Fields(line) {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, err
+			}
+			current = append(current, f)
+		}
+		if len(current) >= n {
+			ret = current
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("%s not found", key)
+	}
+	return ret, nil
+}
+
+//Charges returns the partial charges from a previous MOPAC calculation. The ESP charges are
+//returned if present (i.e. if the Charges job was requested), otherwise, the Mulliken charges.
+func (O *MopacHandle) Charges() ([]float64, error) {
+	return O.schemeCharges("Charges", "", ESP, Mulliken)
+}
+
+//SchemeCharges returns the partial charges of the given scheme (Mulliken or ESP) from a previous
+//MOPAC calculation. Note that, as the semiempirical methods in MOPAC neglect the overlap, its Mulliken
+//charges are equivalent to the Coulson charges. ESP charges are only printed for the Charges job.
+func (O *MopacHandle) SchemeCharges(scheme string) ([]float64, error) {
+	return O.schemeCharges("SchemeCharges", scheme)
+}
+
+func (O *MopacHandle) schemeCharges(caller, scheme string, defaults ...string) ([]float64, error) {
+	inp := O.wrkdir + O.inputname
+	f, err := os.Open(fmt.Sprintf("%s.out", inp))
+	if err != nil {
+		return nil, Error{ErrNoCharges, Mopac, inp, err.Error(), []string{"os.Open", caller}, true}
+	}
+	defer f.Close()
+	all, err := mopacCharges(f)
+	if err != nil {
+		return nil, Error{ErrNoCharges, Mopac, inp, err.Error(), []string{"mopacCharges", caller}, true}
+	}
+	charges, err := chooseCharges(all, scheme, defaults...)
+	if err != nil {
+		return nil, Error{ErrNoCharges, Mopac, inp, err.Error(), []string{"chooseCharges", caller}, true}
+	}
+	return charges, nil
+}
+
+//Dipole returns the dipole moment from a previous MOPAC calculation, in Debye.
+func (O *MopacHandle) Dipole() (*v3.Matrix, error) {
+	inp := O.wrkdir + O.inputname
+	f, err := os.Open(fmt.Sprintf("%s.out", inp))
+	if err != nil {
+		return nil, Error{ErrNoDipole, Mopac, inp, err.Error(), []string{"os.Open", "Dipole"}, true}
+	}
+	defer f.Close()
+	d, err := mopacDipole(f)
+	if err == nil {
+		var dipole *v3.Matrix
+		if dipole, err = dipoleMatrix(d, 1); err == nil {
+			return dipole, nil
+		}
+	}
+	return nil, Error{ErrNoDipole, Mopac, inp, err.Error(), []string{"mopacDipole", "Dipole"}, true}
+}
+
+//mopacCharges returns the last set of Mulliken and ESP charges in a MOPAC output.
+func mopacCharges(r io.Reader) (map[string][]float64, error) {
+	ret := make(map[string][]float64)
+	var current string
+	var charges []float64
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if current == "" {
+			if strings.HasPrefix(line, "NET ATOMIC CHARGES") {
+				current = Mulliken
+			} else if strings.HasPrefix(line, "ELECTROSTATIC POTENTIAL CHARGES") {
+				current = ESP
+			} else {
+				continue
+			}
+			charges = make([]float64, 0, 10)
+			continue
+		}
+		//1          O          -0.617335        6.6173     1.85416     4.76318
+		fields := strings.Fields(line)
+		if len(fields) >= 3 {
+			if _, err := strconv.Atoi(fields[0]); err == nil {
+				c, err := strconv.ParseFloat(fields[2], 64)
+				if err != nil {
+					return nil, err
+				}
+				charges = append(charges, c)
+				continue
+			}
+		}
+		//Until the charges start, there are a header and some blank lines.
+		if len(charges) > 0 {
+			ret[current] = charges
+			current = ""
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+//mopacDipole returns the last dipole moment, in Debye, in a MOPAC output.
+func mopacDipole(r io.Reader) ([]float64, error) {
+	var dipole []float64
+	reading := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		fields := strings.Fields(line)
+		if len(fields) == 5 && fields[0] == "DIPOLE" && fields[4] == "TOTAL" {
+			reading = true
+			continue
+		}
+		if !reading || len(fields) < 5 || fields[0] != "SUM" {
+			continue
+		}
+		reading = false
+		//SUM           -0.000     0.000     1.554     1.554
+		dipole = make([]float64, 3)
+		for i := range dipole {
+			d, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil {
+				return nil, err
+			}
+			dipole[i] = d
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return dipole, nil
+}
+
 //Support function, gets a slice of errors and returns the first
 //non-nil error found, or nil if all errors are nil.
 func parseErrorSlice(errorsl []error) error {
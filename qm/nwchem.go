@@ -25,7 +25,9 @@ package qm
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
@@ -35,6 +37,7 @@ import (
 
 	chem "github.com/rmera/gochem"
 	v3 "github.com/rmera/gochem/v3"
+	"gonum.org/v1/gonum/mat"
 )
 
 //NWChemHandle represents an NWChem calculation.
@@ -242,6 +245,9 @@ func (O *NWChemHandle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger
 		esp = "esp\n restrain\nend\n"
 		task = task + "\ntask esp"
 	}
+	jc.forces = func() {
+		task = "dft gradient\ntask dft freq"
+	}
 	jc.opti = func() {
 		eprec := "" //The available presition is set to default except if tighter SCF convergene criteria are being used.
 		if Q.SCFTightness > 0 {
@@ -381,34 +387,39 @@ func (O *NWChemHandle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger
 //only for unix-compatible systems, as it uses bash and nohup.
 func (O *NWChemHandle) Run(wait bool) (err error) {
 	if wait == true {
-		out, err := os.Create(fmt.Sprintf("%s.out", O.wrkdir+O.inputname))
-		if err != nil {
-			return Error{ErrNotRunning, NWChem, O.wrkdir + O.inputname, err.Error(), []string{"os.Create", "Run"}, true}
-
-		}
-		defer out.Close()
-		command := exec.Command(O.command, fmt.Sprintf("%s.nw", O.inputname))
-		if O.nCPU > 1 {
-			//	fmt.Println("mpirun", "-np", fmt.Sprintf("%d", O.nCPU), O.command, fmt.Sprintf("%s.nw", O.inputname)) ////////////////////////////
-			command = exec.Command("mpirun", "-np", fmt.Sprintf("%d", O.nCPU), O.command, fmt.Sprintf("%s.nw", O.inputname))
-		}
-		command.Dir = O.wrkdir
-		command.Stdout = out
-		command.Stderr = out
-		err = command.Run()
-
-	} else {
-		//This will not work in windows.
-		command := exec.Command("sh", "-c", "nohup "+O.command+fmt.Sprintf(" %s.nw > %s.out &", O.inputname, O.inputname))
-		command.Dir = O.wrkdir
-		err = command.Start()
+		return O.RunContext(context.Background())
 	}
+	//This will not work in windows.
+	command := exec.Command("sh", "-c", "nohup "+O.command+fmt.Sprintf(" %s.nw > %s.out &", O.inputname, O.inputname))
+	command.Dir = O.wrkdir
+	err = command.Start()
 	if err != nil {
-		err = Error{ErrNotRunning, Mopac, O.inputname, err.Error(), []string{"exec.command.Start/Run", "Run"}, true}
+		err = Error{ErrNotRunning, NWChem, O.inputname, err.Error(), []string{"exec.command.Start", "Run"}, true}
 	}
 	return err
 }
 
+//RunContext runs the command given by the string O.command, with mpirun if more than one
+//CPU is to be used, and waits for it to finish. The program is killed if ctx is done before that.
+func (O *NWChemHandle) RunContext(ctx context.Context) error {
+	out, err := os.Create(fmt.Sprintf("%s.out", O.wrkdir+O.inputname))
+	if err != nil {
+		return Error{ErrNotRunning, NWChem, O.wrkdir + O.inputname, err.Error(), []string{"os.Create", "RunContext"}, true}
+	}
+	defer out.Close()
+	command := exec.CommandContext(ctx, O.command, fmt.Sprintf("%s.nw", O.inputname))
+	if O.nCPU > 1 {
+		command = exec.CommandContext(ctx, "mpirun", "-np", fmt.Sprintf("%d", O.nCPU), O.command, fmt.Sprintf("%s.nw", O.inputname))
+	}
+	command.Dir = O.wrkdir
+	command.Stdout = out
+	command.Stderr = out
+	if err = command.Run(); err != nil {
+		return Error{ErrNotRunning, NWChem, O.inputname, err.Error(), []string{"exec.command.Run", "RunContext"}, true}
+	}
+	return nil
+}
+
 func getOldMO(prevMO string) string {
 	dir, _ := os.Open("./")     //This should always work, hence ignoring the error
 	files, _ := dir.Readdir(-1) //Get all the files.
@@ -554,65 +565,264 @@ func (O *NWChemHandle) Energy() (float64, error) {
 	return energy * chem.H2Kcal, err
 }
 
-func (O *NWChemHandle) move2lines(fin *bufio.Reader) error {
-	_, err := fin.ReadString('\n')
+//Charges returns the RESP charges from a previous NWChem calculation.
+func (O *NWChemHandle) Charges() ([]float64, error) {
+	return O.schemeCharges("Charges", "", RESP)
+}
+
+//SchemeCharges returns the partial charges of the given scheme (Mulliken, ESP or RESP) from a previous
+//NWChem calculation. ESP and RESP charges are only obtained for the Charges job. Note that NWChem prints the
+//Mulliken populations with only two decimals.
+func (O *NWChemHandle) SchemeCharges(scheme string) ([]float64, error) {
+	return O.schemeCharges("SchemeCharges", scheme)
+}
+
+func (O *NWChemHandle) schemeCharges(caller, scheme string, defaults ...string) ([]float64, error) {
+	f, err := os.Open(fmt.Sprintf("%s.out", O.wrkdir+O.inputname))
+	if err != nil {
+		return nil, Error{ErrNoCharges, NWChem, O.inputname, err.Error(), []string{"os.Open", caller}, true}
+	}
+	defer f.Close()
+	all, err := nwchemCharges(f)
 	if err != nil {
-		return Error{ErrNoEnergy, NWChem, O.inputname, err.Error(), []string{"os.Open", "Charges"}, true}
+		return nil, Error{ErrNoCharges, NWChem, O.inputname, err.Error(), []string{"nwchemCharges", caller}, true}
 	}
-	_, err = fin.ReadString('\n')
+	charges, err := chooseCharges(all, scheme, defaults...)
 	if err != nil {
-		return Error{ErrNoEnergy, NWChem, O.inputname, err.Error(), []string{"os.Open", "Charges"}, true}
+		return nil, Error{ErrNoCharges, NWChem, O.inputname, err.Error(), []string{"chooseCharges", caller}, true}
 	}
-	return nil
+	return charges, nil
 }
 
-//Charges returns the RESP charges from a previous NWChem calculation.
-func (O *NWChemHandle) Charges() ([]float64, error) {
-	f, err1 := os.Open(fmt.Sprintf("%s.out", O.wrkdir+O.inputname))
-	if err1 != nil {
-		return nil, Error{ErrNoCharges, NWChem, O.inputname, err1.Error(), []string{"os.Open", "Charges"}, true}
+//Dipole returns the dipole moment from a previous NWChem calculation, in Debye.
+func (O *NWChemHandle) Dipole() (*v3.Matrix, error) {
+	f, err := os.Open(fmt.Sprintf("%s.out", O.wrkdir+O.inputname))
+	if err != nil {
+		return nil, Error{ErrNoDipole, NWChem, O.inputname, err.Error(), []string{"os.Open", "Dipole"}, true}
 	}
-	charges := make([]float64, 0, 30)
-	var reading bool = false
 	defer f.Close()
-	fin := bufio.NewReader(f)
-	for {
+	d, err := nwchemDipole(f)
+	if err == nil {
+		var dipole *v3.Matrix
+		if dipole, err = dipoleMatrix(d, au2Debye); err == nil {
+			return dipole, nil
+		}
+	}
+	return nil, Error{ErrNoDipole, NWChem, O.inputname, err.Error(), []string{"nwchemDipole", "Dipole"}, true}
+}
 
-		line, err := fin.ReadString('\n')
-		if err != nil {
-			if strings.Contains(err.Error(), "EOF") { //This allows that an XYZ ends without a newline
-				break
-			} else {
-				return nil, Error{ErrNoCharges, NWChem, O.inputname, err.Error(), []string{"os.Open", "Charges"}, true}
-			}
+//nwchemCharges returns the last set of Mulliken, ESP and RESP charges in an NWChem output.
+func nwchemCharges(r io.Reader) (map[string][]float64, error) {
+	ret := make(map[string][]float64)
+	var mulliken, esp, resp []float64
+	var current string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if strings.Contains(line, "Mulliken analysis of the total density") {
+			current = Mulliken
+			mulliken = make([]float64, 0, 10)
+			continue
 		}
 		if strings.Contains(line, "ESP         RESP        RESP2") {
-			reading = true
-			err := O.move2lines(fin)
-			if err != nil {
+			current = ESP
+			esp = make([]float64, 0, 10)
+			resp = make([]float64, 0, 10)
+			continue
+		}
+		if current == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		_, err := strconv.Atoi(fields0(fields))
+		switch {
+		case current == Mulliken && len(fields) >= 4 && err == nil:
+			//1 O    8     8.69   1.99  0.93  2.96  0.92  1.89
+			//The charge is the atomic number minus the population.
+			z, err1 := strconv.ParseFloat(fields[2], 64)
+			pop, err2 := strconv.ParseFloat(fields[3], 64)
+			if err := parseErrorSlice([]error{err1, err2}); err != nil {
+				return nil, err
+			}
+			mulliken = append(mulliken, z-pop)
+		case current == ESP && len(fields) >= 7 && err == nil:
+			//1 O     0.00000000    0.00000000    0.11786656   -0.801591   -0.757232   -0.757232
+			e, err1 := strconv.ParseFloat(fields[len(fields)-3], 64)
+			r, err2 := strconv.ParseFloat(fields[len(fields)-2], 64)
+			if err := parseErrorSlice([]error{err1, err2}); err != nil {
 				return nil, err
 			}
+			esp = append(esp, e)
+			resp = append(resp, r)
+		case current == Mulliken && len(mulliken) > 0:
+			ret[Mulliken] = mulliken
+			current = ""
+		case current == ESP && strings.Contains(line, "------------------------------------"):
+			ret[ESP] = esp
+			ret[RESP] = resp
+			current = ""
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+//fields0 returns the first element of fields, or an empty string if there is none.
+func fields0(fields []string) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+//nwchemDipole returns the last dipole moment, in a.u., in an NWChem output.
+func nwchemDipole(r io.Reader) ([]float64, error) {
+	var dipole []float64
+	reading := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if strings.Contains(line, "Multipole analysis of the density") {
+			reading = true
+			dipole = make([]float64, 3)
 			continue
 		}
 		if !reading {
 			continue
 		}
-		if reading && strings.Contains(line, "------------------------------------") {
-			break
-		}
+		//L   x y z        total         alpha         beta         nuclear
+		//1   1 0 0      0.000000      0.000000      0.000000      0.000000
 		fields := strings.Fields(line)
-		if len(fields) < 7 {
-			return nil, Error{ErrNoCharges, NWChem, O.inputname, "", []string{"os.Open", "Charges"}, true}
+		if len(fields) != 8 || fields[0] != "1" {
+			if fields0(fields) == "2" {
+				reading = false
+			}
+			continue
 		}
-		charge, err := strconv.ParseFloat(fields[len(fields)-2], 64)
+		i := strings.Index(strings.Join(fields[1:4], ""), "1")
+		if i < 0 {
+			continue
+		}
+		d, err := strconv.ParseFloat(fields[4], 64)
 		if err != nil {
-			return nil, Error{ErrNoCharges, NWChem, O.inputname, err.Error(), []string{"os.Open", "Charges"}, true}
+			return nil, err
 		}
-		charges = append(charges, charge)
+		dipole[i] = d
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return dipole, nil
+}
 
+//Gradient returns the last gradient printed in the output of a previous NWChem calculation, in kcal/(mol*A).
+//Returns the gradient AND error ("Probable problem in calculation") if the calculation didn't end normally.
+func (O *NWChemHandle) Gradient() (*v3.Matrix, error) {
+	f, err := os.Open(fmt.Sprintf("%s.out", O.wrkdir+O.inputname))
+	if err != nil {
+		return nil, Error{ErrNoGradient, NWChem, O.inputname, err.Error(), []string{"os.Open", "Gradient"}, true}
 	}
+	defer f.Close()
+	grad, err := nwchemGradient(f)
+	if err != nil {
+		return nil, Error{ErrNoGradient, NWChem, O.inputname, err.Error(), []string{"nwchemGradient", "Gradient"}, true}
+	}
+	grad.Scale(hartreeBohr2KcalA, grad)
+	if !O.nwchemNormalTermination() {
+		return grad, Error{ErrProbableProblem, NWChem, O.inputname, "", []string{"Gradient"}, false}
+	}
+	return grad, nil
+}
 
-	return charges, nil
+//Hessian returns the Cartesian Hessian from a previous NWChem Forces calculation, in kcal/(mol*A^2),
+//read from the .hess file.
+//Returns the Hessian AND error ("Probable problem in calculation") if the calculation didn't end normally.
+func (O *NWChemHandle) Hessian() (*mat.Dense, error) {
+	f, err := os.Open(fmt.Sprintf("%s.hess", O.wrkdir+O.inputname))
+	if err != nil {
+		return nil, Error{ErrNoHessian, NWChem, O.inputname, err.Error(), []string{"os.Open", "Hessian"}, true}
+	}
+	defer f.Close()
+	hess, err := nwchemHessian(f)
+	if err != nil {
+		return nil, Error{ErrNoHessian, NWChem, O.inputname, err.Error(), []string{"nwchemHessian", "Hessian"}, true}
+	}
+	hess.Scale(hartreeBohr22KcalA, hess)
+	if !O.nwchemNormalTermination() {
+		return hess, Error{ErrProbableProblem, NWChem, O.inputname, "", []string{"Hessian"}, false}
+	}
+	return hess, nil
+}
+
+//nwchemGradient reads the last "ENERGY GRADIENTS" block of an NWChem output, and returns the gradient
+//in atomic units.
+func nwchemGradient(r io.Reader) (*v3.Matrix, error) {
+	var grad, last []float64
+	reading := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if strings.Contains(line, "ENERGY GRADIENTS") {
+			reading = true
+			grad = make([]float64, 0, 30)
+			continue
+		}
+		if !reading {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 8 {
+			if len(grad) > 0 { //the end of the block
+				reading = false
+				last = grad
+			}
+			continue
+		}
+		if _, err := strconv.Atoi(fields[0]); err != nil {
+			continue
+		}
+		for _, v := range fields[5:] {
+			g, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, err
+			}
+			grad = append(grad, g)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if reading && len(grad) > 0 {
+		last = grad
+	}
+	if len(last) == 0 {
+		return nil, fmt.Errorf("No gradient found")
+	}
+	return v3.NewMatrix(last)
+}
+
+//nwchemHessian reads an NWChem .hess file, which contains the lower triangle of the Hessian,
+//one element per line, and returns the Hessian in atomic units.
+func nwchemHessian(r io.Reader) (*mat.Dense, error) {
+	packed := make([]float64, 0, 100)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		h, err := strconv.ParseFloat(strings.Replace(line, "D", "E", 1), 64)
+		if err != nil {
+			return nil, err
+		}
+		packed = append(packed, h)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return packedLowerSym(packed)
 }
 
 //This checks that an NWChem calculation has terminated normally
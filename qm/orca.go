@@ -24,7 +24,10 @@
 package qm
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
@@ -34,6 +37,7 @@ import (
 
 	chem "github.com/rmera/gochem"
 	v3 "github.com/rmera/gochem/v3"
+	"gonum.org/v1/gonum/mat"
 )
 
 //OrcaHandle represents an Orca calculation.
@@ -166,6 +170,14 @@ func (O *OrcaHandle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger,
 		opt = "Opt"
 		trustradius = "%geom trust 0.3\nend\n\n" //Orca uses a fixed trust radius by default. This goChem makes an input that activates variable trust radius.
 	}
+	jc.forces = func() {
+		opt = "EnGrad Freq"
+	}
+	population := ""
+	jc.charges = func() {
+		opt = "CHELPG"
+		population = "%output\n   Print[ P_Hirshfeld ] 1\nend\n\n"
+	}
 	Q.Job.Do(jc)
 	//If this flag is set we'll look for a suitable MO file.
 	//If not found, we'll just use the default ORCA guess
@@ -295,6 +307,7 @@ func (O *OrcaHandle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger,
 	fmt.Fprint(file, constraints)
 	fmt.Fprint(file, iconstraints)
 	fmt.Fprint(file, trustradius)
+	fmt.Fprint(file, population)
 	fmt.Fprint(file, ElementBasis)
 	fmt.Fprint(file, cosmo)
 	fmt.Fprint(file, "\n")
@@ -322,27 +335,35 @@ func (O *OrcaHandle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger,
 //only for unix-compatible systems, as it uses bash and nohup.
 func (O *OrcaHandle) Run(wait bool) (err error) {
 	if wait == true {
-		out, err := os.Create(fmt.Sprintf("%s.out", O.inputname))
-		if err != nil {
-			return Error{ErrNotRunning, Orca, O.inputname, "", []string{"Run"}, true}
-		}
-		defer out.Close()
-		command := exec.Command(O.command, fmt.Sprintf("%s.inp", O.inputname))
-		command.Stdout = out
-		command.Dir = O.wrkdir
-		err = command.Run()
-
-	} else {
-		command := exec.Command("sh", "-c", "nohup "+O.command+fmt.Sprintf(" %s.inp > %s.out &", O.inputname, O.inputname))
-		command.Dir = O.wrkdir
-		err = command.Start()
+		return O.RunContext(context.Background())
 	}
+	command := exec.Command("sh", "-c", "nohup "+O.command+fmt.Sprintf(" %s.inp > %s.out &", O.inputname, O.inputname))
+	command.Dir = O.wrkdir
+	err = command.Start()
 	if err != nil {
 		err = Error{ErrNotRunning, Orca, O.inputname, err.Error(), []string{"exec.Start", "Run"}, true}
 	}
 	return err
 }
 
+//RunContext runs the command given by the string O.command and waits for it to finish.
+//The program is killed if ctx is done before that.
+func (O *OrcaHandle) RunContext(ctx context.Context) error {
+	out, err := os.Create(fmt.Sprintf("%s.out", O.wrkdir+O.inputname))
+	if err != nil {
+		return Error{ErrNotRunning, Orca, O.inputname, "", []string{"RunContext"}, true}
+	}
+	defer out.Close()
+	command := exec.CommandContext(ctx, O.command, fmt.Sprintf("%s.inp", O.inputname))
+	command.Stdout = out
+	command.Dir = O.wrkdir
+	err = command.Run()
+	if err != nil {
+		return Error{ErrNotRunning, Orca, O.inputname, err.Error(), []string{"exec.Run", "RunContext"}, true}
+	}
+	return nil
+}
+
 //buildIConstraints transforms the list of cartesian constrains in the QMCalc structre
 //into a string with ORCA-formatted internal constraints.
 func (O *OrcaHandle) buildIConstraints(C []*IConstraint) (string, error) {
@@ -512,6 +533,278 @@ func (O *OrcaHandle) Energy() (float64, error) {
 	return energy * chem.H2Kcal, err
 }
 
+//Gradient returns the gradient from a previous ORCA calculation, in kcal/(mol*A), read from
+//the .engrad file, which ORCA writes for optimizations and gradient (and Forces) calculations.
+//Returns the gradient AND error ("Probable problem in calculation") if the calculation didn't end normally.
+func (O *OrcaHandle) Gradient() (*v3.Matrix, error) {
+	f, err := os.Open(fmt.Sprintf("%s.engrad", O.wrkdir+O.inputname))
+	if err != nil {
+		return nil, Error{ErrNoGradient, Orca, O.inputname, err.Error(), []string{"os.Open", "Gradient"}, true}
+	}
+	defer f.Close()
+	grad, err := orcaEngrad(f)
+	if err != nil {
+		return nil, Error{ErrNoGradient, Orca, O.inputname, err.Error(), []string{"orcaEngrad", "Gradient"}, true}
+	}
+	grad.Scale(hartreeBohr2KcalA, grad)
+	if !O.orcaNormalTermination() {
+		return grad, Error{ErrProbableProblem, Orca, O.inputname, "", []string{"Gradient"}, false}
+	}
+	return grad, nil
+}
+
+//Hessian returns the Cartesian Hessian from a previous ORCA frequency (or Forces) calculation,
+//in kcal/(mol*A^2), read from the .hess file.
+//Returns the Hessian AND error ("Probable problem in calculation") if the calculation didn't end normally.
+func (O *OrcaHandle) Hessian() (*mat.Dense, error) {
+	f, err := os.Open(fmt.Sprintf("%s.hess", O.wrkdir+O.inputname))
+	if err != nil {
+		return nil, Error{ErrNoHessian, Orca, O.inputname, err.Error(), []string{"os.Open", "Hessian"}, true}
+	}
+	defer f.Close()
+	hess, err := orcaHess(f)
+	if err != nil {
+		return nil, Error{ErrNoHessian, Orca, O.inputname, err.Error(), []string{"orcaHess", "Hessian"}, true}
+	}
+	hess.Scale(hartreeBohr22KcalA, hess)
+	if !O.orcaNormalTermination() {
+		return hess, Error{ErrProbableProblem, Orca, O.inputname, "", []string{"Hessian"}, false}
+	}
+	return hess, nil
+}
+
+//orcaEngrad reads an ORCA .engrad file and returns the gradient, in atomic units.
+func orcaEngrad(r io.Reader) (*v3.Matrix, error) {
+	values := make([]float64, 0, 20)
+	natoms := -1
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if natoms < 0 {
+			n, err := strconv.Atoi(line)
+			if err != nil {
+				return nil, err
+			}
+			natoms = n
+			continue
+		}
+		v, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		if len(values) == 3*natoms+1 { //the energy comes first
+			break
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if natoms <= 0 || len(values) != 3*natoms+1 {
+		return nil, fmt.Errorf("Incomplete engrad file")
+	}
+	return v3.NewMatrix(values[1:])
+}
+
+//orcaHess reads the $hessian block of an ORCA .hess file, and returns the Hessian in atomic units.
+//The block is written in groups of columns, each preceded by a line with the column indexes.
+func orcaHess(r io.Reader) (*mat.Dense, error) {
+	var hess *mat.Dense
+	var cols []int
+	read := 0
+	s := bufio.NewScanner(r)
+	reading := false
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if !reading {
+			reading = line == "$hessian"
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "$") {
+			break
+		}
+		fields := strings.Fields(line)
+		if hess == nil {
+			n, err := strconv.Atoi(line)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("Wrong Hessian dimension: %s", line)
+			}
+			hess = mat.NewDense(n, n, nil)
+			continue
+		}
+		if !strings.Contains(line, ".") { //the column indexes
+			cols = cols[:0]
+			for _, v := range fields {
+				c, err := strconv.Atoi(v)
+				if err != nil {
+					return nil, err
+				}
+				cols = append(cols, c)
+			}
+			continue
+		}
+		row, err := strconv.Atoi(fields[0])
+		if err != nil || len(fields) != len(cols)+1 {
+			return nil, fmt.Errorf("Malformed Hessian line: %s", line)
+		}
+		for i, c := range cols {
+			v, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil {
+				return nil, err
+			}
+			hess.Set(row, c, v)
+			read++
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if hess == nil {
+		return nil, fmt.Errorf("No Hessian found")
+	}
+	if n, _ := hess.Dims(); read != n*n {
+		return nil, fmt.Errorf("Incomplete Hessian: %d elements read for a %dx%d matrix", read, n, n)
+	}
+	return hess, nil
+}
+
+//Charges returns the partial charges from a previous ORCA calculation. The CHELPG (ESP) charges are returned
+//if present (i.e. if the Charges job was requested), otherwise, the Mulliken charges.
+func (O *OrcaHandle) Charges() ([]float64, error) {
+	return O.schemeCharges("Charges", "", ESP, Mulliken)
+}
+
+//SchemeCharges returns the partial charges of the given scheme (Mulliken, Lowdin, Hirshfeld or ESP) from a previous
+//ORCA calculation. Mulliken and Lowdin charges are always printed, Hirshfeld and ESP (CHELPG) charges, only when the
+//Charges job is requested.
+func (O *OrcaHandle) SchemeCharges(scheme string) ([]float64, error) {
+	return O.schemeCharges("SchemeCharges", scheme)
+}
+
+func (O *OrcaHandle) schemeCharges(caller, scheme string, defaults ...string) ([]float64, error) {
+	f, err := os.Open(fmt.Sprintf("%s.out", O.wrkdir+O.inputname))
+	if err != nil {
+		return nil, Error{ErrNoCharges, Orca, O.inputname, err.Error(), []string{"os.Open", caller}, true}
+	}
+	defer f.Close()
+	all, err := orcaCharges(f)
+	if err != nil {
+		return nil, Error{ErrNoCharges, Orca, O.inputname, err.Error(), []string{"orcaCharges", caller}, true}
+	}
+	charges, err := chooseCharges(all, scheme, defaults...)
+	if err != nil {
+		return nil, Error{ErrNoCharges, Orca, O.inputname, err.Error(), []string{"chooseCharges", caller}, true}
+	}
+	return charges, nil
+}
+
+//Dipole returns the dipole moment from a previous ORCA calculation, in Debye.
+func (O *OrcaHandle) Dipole() (*v3.Matrix, error) {
+	f, err := os.Open(fmt.Sprintf("%s.out", O.wrkdir+O.inputname))
+	if err != nil {
+		return nil, Error{ErrNoDipole, Orca, O.inputname, err.Error(), []string{"os.Open", "Dipole"}, true}
+	}
+	defer f.Close()
+	d, err := orcaDipole(f)
+	if err == nil {
+		var dipole *v3.Matrix
+		if dipole, err = dipoleMatrix(d, au2Debye); err == nil {
+			return dipole, nil
+		}
+	}
+	return nil, Error{ErrNoDipole, Orca, O.inputname, err.Error(), []string{"orcaDipole", "Dipole"}, true}
+}
+
+//orcaChargeHeaders maps the titles of the population analyses in ORCA outputs to the charge schemes.
+var orcaChargeHeaders = map[string]string{
+	"MULLIKEN ATOMIC CHARGES": Mulliken,
+	"LOEWDIN ATOMIC CHARGES":  Lowdin,
+	"HIRSHFELD ANALYSIS":      Hirshfeld,
+	"CHELPG Charges":          ESP,
+}
+
+//orcaCharges returns the last set of each type of charges in an ORCA output.
+func orcaCharges(r io.Reader) (map[string][]float64, error) {
+	ret := make(map[string][]float64)
+	var current string
+	var charges []float64
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if current == "" {
+			for k, v := range orcaChargeHeaders {
+				if strings.HasPrefix(line, k) {
+					current = v
+					charges = make([]float64, 0, 10)
+				}
+			}
+			continue
+		}
+		//The charges are given as "0 O : charge" or, for Hirshfeld, as "0 O charge spin"
+		fields := strings.Fields(line)
+		var value string
+		if len(fields) >= 3 {
+			if _, err := strconv.Atoi(fields[0]); err == nil {
+				value = fields[2]
+				if i := strings.Index(line, ":"); i >= 0 {
+					value = strings.Fields(line[i+1:] + " ?")[0]
+				}
+			}
+		}
+		if value == "" {
+			//Until the charges start, there can be some lines that we don't need.
+			if len(charges) > 0 {
+				ret[current] = charges
+				current = ""
+			}
+			continue
+		}
+		c, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		charges = append(charges, c)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+//orcaDipole returns the last total dipole moment, in a.u., in an ORCA output.
+func orcaDipole(r io.Reader) ([]float64, error) {
+	var dipole []float64
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if !strings.Contains(line, "Total Dipole Moment") {
+			continue
+		}
+		fields := strings.Fields(line[strings.Index(line, ":")+1:])
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Malformed dipole line: %s", line)
+		}
+		dipole = make([]float64, 3)
+		for i, v := range fields {
+			d, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, err
+			}
+			dipole[i] = d
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return dipole, nil
+}
+
 //Gets previous line of the file f
 func getTailLine(f *os.File) (line string, err error) {
 	var i int64 = 1
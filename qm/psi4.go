@@ -0,0 +1,686 @@
+/*
+ * psi4.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+	"gonum.org/v1/gonum/mat"
+)
+
+//Psi4Handle represents a Psi4 calculation.
+//Note that the default methods and basis vary with each program, and even
+//for a given program they are NOT considered part of the API, so they can always change.
+type Psi4Handle struct {
+	defmethod string
+	defbasis  string
+	command   string
+	inputname string
+	wrkdir    string
+	nCPU      int
+}
+
+//NewPsi4Handle initializes and returns a new Psi4Handle.
+func NewPsi4Handle() *Psi4Handle {
+	run := new(Psi4Handle)
+	run.SetDefaults()
+	return run
+}
+
+//Psi4Handle methods
+
+//SetnCPU sets the number of threads to be used.
+func (O *Psi4Handle) SetnCPU(cpu int) {
+	O.nCPU = cpu
+}
+
+//SetName sets the name of the job, which will reflect in the
+//name of the input (name.in) and output (name.out) files.
+func (O *Psi4Handle) SetName(name string) {
+	O.inputname = name
+}
+
+//SetCommand sets the name and path of the Psi4 excecutable.
+func (O *Psi4Handle) SetCommand(name string) {
+	O.command = name
+}
+
+//SetWorkDir sets the name of the working directory for the calculation.
+func (O *Psi4Handle) SetWorkDir(d string) {
+	O.wrkdir = d
+}
+
+//SetDefaults sets defaults for the Psi4 calculation. The default is
+//currently a single-point at B3LYP/def2-SVP with density fitting, and half the logical CPUs available.
+//The default is _not_ part of the API, it can change as new methods appear.
+func (O *Psi4Handle) SetDefaults() {
+	O.defmethod = "b3lyp"
+	O.defbasis = "def2-svp"
+	O.command = "psi4"
+	O.nCPU = runtime.NumCPU() / 2
+}
+
+//name returns the path of the file for the job with the extension ext.
+func (O *Psi4Handle) name(ext string) string {
+	return filepath.Join(O.wrkdir, O.inputname+ext)
+}
+
+//BuildInput builds a Psi4 input (name.in) based on the data in atoms, coords and Q.
+//Per-atom basis sets are not supported. The ECPs of the Karlsruhe basis sets are used automatically by Psi4.
+//A Forces job runs a gradient and a frequency calculation, and saves the gradient and Hessian
+//(to name.grad and name.hess) for the Gradient and Hessian methods.
+//returns only error.
+func (O *Psi4Handle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger, Q *Calc) error {
+	if O.inputname == "" {
+		O.inputname = "gochem"
+	}
+	if atoms == nil || coords == nil {
+		return Error{ErrMissingCharges, Psi4, O.inputname, "", []string{"BuildInput"}, true}
+	}
+	if Q.Method == "" {
+		log.Printf("no method assigned for Psi4 calculation, will use the default %s, \n", O.defmethod)
+		Q.Method = O.defmethod
+	}
+	if Q.Basis == "" {
+		log.Printf("no basis set assigned for Psi4 calculation, will use the default %s, \n", O.defbasis)
+		Q.Basis = O.defbasis
+	}
+	if Q.HBAtoms != nil || Q.LBAtoms != nil {
+		log.Printf("per-atom basis sets are not supported for Psi4 calculations, will be ignored")
+	}
+	method := strings.ToLower(Q.Method)
+	if Q.Dispersion != "" {
+		disp, ok := psi4Disp[Q.Dispersion]
+		if !ok {
+			return Error{"goChem/QM: Dispersion correction not supported", Psi4, O.inputname, Q.Dispersion, []string{"BuildInput"}, true}
+		}
+		method += disp
+	}
+	var b strings.Builder
+	if Q.Memory != 0 {
+		fmt.Fprintf(&b, "memory %d mb\n\n", Q.Memory)
+	}
+	fmt.Fprintf(&b, "molecule mol {\n%d %d\n", atoms.Charge(), atoms.Multi())
+	for i := 0; i < atoms.Len(); i++ {
+		fmt.Fprintf(&b, "%-2s  %12.6f%12.6f%12.6f\n", atoms.Atom(i).Symbol, coords.At(i, 0), coords.At(i, 1), coords.At(i, 2))
+	}
+	//We keep the input reference frame, so the geometries read are comparable with the input one.
+	b.WriteString("symmetry c1\nno_reorient\nno_com\n}\n\n")
+	if Q.HBElements != nil || Q.LBElements != nil {
+		fmt.Fprintf(&b, "basis {\n   assign %s\n", Q.Basis)
+		for _, v := range Q.HBElements {
+			fmt.Fprintf(&b, "   assign %s %s\n", v, Q.HighBasis)
+		}
+		for _, v := range Q.LBElements {
+			fmt.Fprintf(&b, "   assign %s %s\n", v, Q.LowBasis)
+		}
+		b.WriteString("}\n\n")
+	}
+	b.WriteString("set {\n")
+	if Q.HBElements == nil && Q.LBElements == nil {
+		fmt.Fprintf(&b, "   basis %s\n", Q.Basis)
+	}
+	if atoms.Multi() != 1 && isDFT(method) {
+		b.WriteString("   reference uks\n")
+	} else if atoms.Multi() != 1 {
+		b.WriteString("   reference uhf\n")
+	}
+	if !Q.RI && !Q.RIJ {
+		b.WriteString("   scf_type pk\n")
+	}
+	if conv, ok := psi4SCFTight[Q.SCFTightness]; ok {
+		b.WriteString(conv)
+	}
+	b.WriteString(psi4SCFConv[Q.SCFConvHelp])
+	if g, ok := psi4Grid[Q.Grid]; ok {
+		b.WriteString(g)
+	}
+	if Q.Guess != "" {
+		fmt.Fprintf(&b, "   guess %s\n", Q.Guess)
+	}
+	if Q.Dielectric > 0 {
+		b.WriteString("   pcm true\n   pcm_scf_type total\n")
+	}
+	b.WriteString("}\n\n")
+	if Q.Dielectric > 0 {
+		fmt.Fprintf(&b, "pcm = {\n   Units = Angstrom\n   Medium {\n   SolverType = IEFPCM\n   Solvent = Explicit\n   ProbeRadius = 1.385\n")
+		fmt.Fprintf(&b, "   Green<inside> {\n      Type = Vacuum\n   }\n   Green<outside> {\n      Type = UniformDielectric\n      Der = Numerical\n      Eps = %.4f\n      EpsDyn = 1.7800\n   }\n   }\n", Q.Dielectric)
+		b.WriteString("   Cavity {\n   RadiiSet = UFF\n   Type = GePol\n   Scaling = False\n   Area = 0.3\n   Mode = Implicit\n   }\n}\n\n")
+	}
+	if Q.Job.Opti {
+		constraints, err := O.buildConstraints(Q)
+		if err != nil {
+			return errDecorate(err, "BuildInput")
+		}
+		b.WriteString(constraints)
+	}
+	call := "energy"
+	charges := false
+	jc := jobChoose{}
+	jc.opti = func() {
+		call = "optimize"
+	}
+	jc.forces = func() {
+		call = "frequency"
+	}
+	jc.md = func() {
+		log.Printf("MD is not supported for Psi4 calculations, will run a single point")
+	}
+	jc.charges = func() {
+		charges = true
+	}
+	Q.Job.Do(jc)
+	if Q.Others != "" {
+		b.WriteString(Q.Others + "\n")
+	}
+	if call == "frequency" {
+		//The gradient and Hessian are saved, in atomic units, for the Gradient and Hessian methods.
+		b.WriteString("import numpy as np\n")
+		fmt.Fprintf(&b, "G, wfn = gradient('%s', return_wfn=True)\nG.print_out()\n", method)
+		fmt.Fprintf(&b, "np.savetxt('%s.grad', G.np)\n", O.inputname)
+		fmt.Fprintf(&b, "E, wfn = frequency('%s', ref_gradient=G, return_wfn=True)\n", method)
+		fmt.Fprintf(&b, "np.savetxt('%s.hess', wfn.hessian().np)\n", O.inputname)
+	} else {
+		fmt.Fprintf(&b, "E, wfn = %s('%s', return_wfn=True)\n", call, method)
+	}
+	if charges {
+		b.WriteString("oeprop(wfn, 'MULLIKEN_CHARGES', 'LOWDIN_CHARGES', 'DIPOLE')\n")
+	}
+	err := ioutil.WriteFile(O.name(".in"), []byte(b.String()), 0644)
+	if err != nil {
+		return Error{ErrCantInput, Psi4, O.inputname, err.Error(), []string{"ioutil.WriteFile", "BuildInput"}, true}
+	}
+	return nil
+}
+
+//buildConstraints returns the optking section with the constraints in Q.
+func (O *Psi4Handle) buildConstraints(Q *Calc) (string, error) {
+	if Q.CConstraints == nil && Q.IConstraints == nil {
+		return "", nil
+	}
+	frozen := map[byte][]string{}
+	fixed := map[byte][]string{}
+	for _, v := range Q.IConstraints {
+		if iConstraintOrder[v.Class] != len(v.CAtoms) {
+			return "", Error{"Internal constraint ill-formated", Psi4, O.inputname, "", []string{"buildConstraints"}, true}
+		}
+		atoms := make([]string, 0, 4)
+		for _, w := range v.CAtoms {
+			atoms = append(atoms, strconv.Itoa(w+1)) //1-based indexes for Psi4
+		}
+		if v.UseVal {
+			fixed[v.Class] = append(fixed[v.Class], fmt.Sprintf("%s %.3f", strings.Join(atoms, " "), v.Val))
+		} else {
+			frozen[v.Class] = append(frozen[v.Class], strings.Join(atoms, " "))
+		}
+	}
+	names := map[byte]string{'B': "distance", 'A': "bend", 'D': "dihedral"}
+	var b strings.Builder
+	b.WriteString("set optking {\n")
+	if Q.CConstraints != nil {
+		cart := make([]string, 0, len(Q.CConstraints))
+		for _, v := range Q.CConstraints {
+			cart = append(cart, fmt.Sprintf("%d xyz", v+1))
+		}
+		fmt.Fprintf(&b, "   frozen_cartesian = (\"%s\")\n", strings.Join(cart, " "))
+	}
+	for _, c := range []byte{'B', 'A', 'D'} {
+		if frozen[c] != nil {
+			fmt.Fprintf(&b, "   frozen_%s = (\"%s\")\n", names[c], strings.Join(frozen[c], " "))
+		}
+		if fixed[c] != nil {
+			fmt.Fprintf(&b, "   fixed_%s = (\"%s\")\n", names[c], strings.Join(fixed[c], " "))
+		}
+	}
+	b.WriteString("}\n\n")
+	return b.String(), nil
+}
+
+//isDFT returns true if the method, in lowercase, is not one of the common wavefunction methods.
+func isDFT(method string) bool {
+	for _, v := range []string{"hf", "mp2", "mp3", "mp2.5", "ccsd", "ccsd(t)", "sapt0", "omp2", "cisd", "casscf"} {
+		if method == v || strings.HasPrefix(method, v+"-") || strings.HasPrefix(method, "scs-") {
+			return false
+		}
+	}
+	return true
+}
+
+//Run runs the command given by the string O.command
+//it waits or not for the result depending on wait.
+//Not waiting for results works
+//only for unix-compatible systems, as it uses sh and nohup.
+func (O *Psi4Handle) Run(wait bool) (err error) {
+	if wait {
+		return O.RunContext(context.Background())
+	}
+	command := exec.Command("sh", "-c", "nohup "+O.command+" "+strings.Join(O.runArgs(), " ")+" > /dev/null 2>&1 &")
+	command.Dir = O.wrkdir
+	err = command.Start()
+	if err != nil {
+		err = Error{ErrNotRunning, Psi4, O.inputname, err.Error(), []string{"exec.Start", "Run"}, true}
+	}
+	return err
+}
+
+//RunContext runs the command given by the string O.command and waits for it to finish.
+//The program is killed if ctx is done before that.
+func (O *Psi4Handle) RunContext(ctx context.Context) error {
+	command := exec.CommandContext(ctx, O.command, O.runArgs()...)
+	command.Dir = O.wrkdir
+	if err := command.Run(); err != nil {
+		return Error{ErrNotRunning, Psi4, O.inputname, err.Error(), []string{"exec.Run", "RunContext"}, true}
+	}
+	return nil
+}
+
+//runArgs returns the command line arguments for Psi4.
+func (O *Psi4Handle) runArgs() []string {
+	args := []string{"-n", strconv.Itoa(O.nCPU), O.inputname + ".in", O.inputname + ".out"}
+	if O.nCPU < 1 {
+		args = args[2:]
+	}
+	return args
+}
+
+//Energy returns the energy of a previous Psi4 calculation, in kcal/mol.
+//Returns error if problem, and also if the energy returned that is product of an
+//abnormally-terminated Psi4 calculation. (in this case error is "Probable problem
+//in calculation")
+func (O *Psi4Handle) Energy() (float64, error) {
+	f, err := os.Open(O.name(".out"))
+	if err != nil {
+		return 0, Error{ErrNoEnergy, Psi4, O.inputname, err.Error(), []string{"os.Open", "Energy"}, true}
+	}
+	defer f.Close()
+	energy, normal, err := psi4Energy(f)
+	if err != nil {
+		return 0, Error{ErrNoEnergy, Psi4, O.inputname, err.Error(), []string{"psi4Energy", "Energy"}, true}
+	}
+	if !normal {
+		return energy * chem.H2Kcal, Error{ErrProbableProblem, Psi4, O.inputname, "", []string{"Energy"}, false}
+	}
+	return energy * chem.H2Kcal, nil
+}
+
+//OptimizedGeometry reads the optimized geometry from a Psi4 optimization. Returns the
+//geometry or error. Returns the geometry AND error if the geometry read
+//is not the product of a correctly ended Psi4 calculation. In this case
+//the error is "probable problem in calculation".
+func (O *Psi4Handle) OptimizedGeometry(atoms chem.Atomer) (*v3.Matrix, error) {
+	f, err := os.Open(O.name(".out"))
+	if err != nil {
+		return nil, Error{ErrNoGeometry, Psi4, O.inputname, err.Error(), []string{"os.Open", "OptimizedGeometry"}, true}
+	}
+	defer f.Close()
+	coords, normal, err := psi4Geometry(f)
+	if err != nil {
+		return nil, Error{ErrNoGeometry, Psi4, O.inputname, err.Error(), []string{"psi4Geometry", "OptimizedGeometry"}, true}
+	}
+	if atoms != nil && atoms.Len() != coords.NVecs() {
+		return nil, Error{ErrNoGeometry, Psi4, O.inputname, "Wrong number of atoms", []string{"OptimizedGeometry"}, true}
+	}
+	if !normal {
+		return coords, Error{ErrProbableProblem, Psi4, O.inputname, "", []string{"OptimizedGeometry"}, false}
+	}
+	return coords, nil
+}
+
+//psi4EnergyRe matches the lines with total energies in Psi4 outputs. The last one
+//is the energy for the method requested, except for the spin-component-scaled energies.
+var psi4EnergyRe = regexp.MustCompile(`(?i)(total energy|final energy is)\s*=?\s*(-?\d+\.\d+)`)
+
+//psi4Energy returns the last total energy in a Psi4 output, in Hartree, and whether the
+//calculation terminated normally.
+func psi4Energy(r io.Reader) (float64, bool, error) {
+	energy := 0.0
+	found := false
+	normal := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if strings.Contains(line, "Psi4 exiting successfully") {
+			normal = true
+			continue
+		}
+		m := psi4EnergyRe.FindStringSubmatch(line)
+		if m == nil || strings.Contains(line, "SCS") {
+			continue
+		}
+		var err error
+		energy, err = strconv.ParseFloat(m[2], 64)
+		if err != nil {
+			return 0, false, err
+		}
+		found = true
+	}
+	if err := s.Err(); err != nil {
+		return 0, false, err
+	}
+	if !found {
+		return 0, false, fmt.Errorf("No energy found")
+	}
+	return energy, normal, nil
+}
+
+//psi4Geometry returns the final geometry of a Psi4 optimization, and whether the
+//calculation terminated normally.
+func psi4Geometry(r io.Reader) (*v3.Matrix, bool, error) {
+	var coords []float64
+	final := false
+	reading := false
+	normal := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		fields := strings.Fields(line)
+		switch {
+		case strings.Contains(line, "Psi4 exiting successfully"):
+			normal = true
+		case strings.Contains(line, "Final optimized geometry and variables"):
+			final = true
+			coords = coords[:0]
+		case final && strings.Contains(line, "Geometry (in Angstrom)"):
+			reading = true
+		case reading && len(fields) == 0 && len(coords) == 0:
+			//The blank line after the title
+		case reading && len(fields) == 4:
+			for _, v := range fields[1:] {
+				c, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return nil, false, err
+				}
+				coords = append(coords, c)
+			}
+		case reading:
+			reading = false
+			final = false
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(coords) == 0 {
+		return nil, false, fmt.Errorf("No optimized geometry found")
+	}
+	ret, err := v3.NewMatrix(coords)
+	return ret, normal, err
+}
+
+//Charges returns the Mulliken charges from a previous Psi4 calculation where the
+//Charges job was requested.
+func (O *Psi4Handle) Charges() ([]float64, error) {
+	return O.schemeCharges("Charges", "", Mulliken)
+}
+
+//SchemeCharges returns the partial charges of the given scheme (Mulliken or Lowdin) from a previous Psi4
+//calculation where the Charges job was requested.
+func (O *Psi4Handle) SchemeCharges(scheme string) ([]float64, error) {
+	return O.schemeCharges("SchemeCharges", scheme)
+}
+
+func (O *Psi4Handle) schemeCharges(caller, scheme string, defaults ...string) ([]float64, error) {
+	f, err := os.Open(O.name(".out"))
+	if err != nil {
+		return nil, Error{ErrNoCharges, Psi4, O.inputname, err.Error(), []string{"os.Open", caller}, true}
+	}
+	defer f.Close()
+	all, err := psi4Charges(f)
+	if err != nil {
+		return nil, Error{ErrNoCharges, Psi4, O.inputname, err.Error(), []string{"psi4Charges", caller}, true}
+	}
+	charges, err := chooseCharges(all, scheme, defaults...)
+	if err != nil {
+		return nil, Error{ErrNoCharges, Psi4, O.inputname, err.Error(), []string{"chooseCharges", caller}, true}
+	}
+	return charges, nil
+}
+
+//Dipole returns the dipole moment from a previous Psi4 calculation, in Debye.
+func (O *Psi4Handle) Dipole() (*v3.Matrix, error) {
+	f, err := os.Open(O.name(".out"))
+	if err != nil {
+		return nil, Error{ErrNoDipole, Psi4, O.inputname, err.Error(), []string{"os.Open", "Dipole"}, true}
+	}
+	defer f.Close()
+	d, err := psi4Dipole(f)
+	if err == nil {
+		var dipole *v3.Matrix
+		if dipole, err = dipoleMatrix(d, 1); err == nil {
+			return dipole, nil
+		}
+	}
+	return nil, Error{ErrNoDipole, Psi4, O.inputname, err.Error(), []string{"psi4Dipole", "Dipole"}, true}
+}
+
+//Gradient returns the gradient from a previous Psi4 calculation where the Forces job
+//was requested, in kcal/(mol*A).
+func (O *Psi4Handle) Gradient() (*v3.Matrix, error) {
+	f, err := os.Open(O.name(".grad"))
+	if err != nil {
+		return nil, Error{ErrNoGradient, Psi4, O.inputname, err.Error(), []string{"os.Open", "Gradient"}, true}
+	}
+	defer f.Close()
+	g, cols, err := psi4Matrix(f)
+	if err == nil && cols != 3 {
+		err = fmt.Errorf("Gradient with %d columns", cols)
+	}
+	if err != nil {
+		return nil, Error{ErrNoGradient, Psi4, O.inputname, err.Error(), []string{"psi4Matrix", "Gradient"}, true}
+	}
+	grad, err := v3.NewMatrix(g)
+	if err != nil {
+		return nil, Error{ErrNoGradient, Psi4, O.inputname, err.Error(), []string{"v3.NewMatrix", "Gradient"}, true}
+	}
+	grad.Scale(hartreeBohr2KcalA, grad)
+	return grad, O.probableProblem("Gradient")
+}
+
+//Hessian returns the Cartesian Hessian from a previous Psi4 calculation where the Forces job
+//was requested, in kcal/(mol*A^2).
+func (O *Psi4Handle) Hessian() (*mat.Dense, error) {
+	f, err := os.Open(O.name(".hess"))
+	if err != nil {
+		return nil, Error{ErrNoHessian, Psi4, O.inputname, err.Error(), []string{"os.Open", "Hessian"}, true}
+	}
+	defer f.Close()
+	h, cols, err := psi4Matrix(f)
+	if err == nil && (cols == 0 || len(h) != cols*cols) {
+		err = fmt.Errorf("Hessian is not square: %d elements, %d columns", len(h), cols)
+	}
+	if err != nil {
+		return nil, Error{ErrNoHessian, Psi4, O.inputname, err.Error(), []string{"psi4Matrix", "Hessian"}, true}
+	}
+	hess := mat.NewDense(cols, cols, h)
+	hess.Scale(hartreeBohr22KcalA, hess)
+	return hess, O.probableProblem("Hessian")
+}
+
+//probableProblem returns a non-critical ErrProbableProblem if the output of the calculation
+//doesn't show a normal termination, nil otherwise.
+func (O *Psi4Handle) probableProblem(caller string) error {
+	out, err := ioutil.ReadFile(O.name(".out"))
+	if err != nil || !strings.Contains(string(out), "Psi4 exiting successfully") {
+		return Error{ErrProbableProblem, Psi4, O.inputname, "", []string{caller}, false}
+	}
+	return nil
+}
+
+//psi4Matrix reads a matrix written by numpy.savetxt, and returns its elements in
+//row-major order and its number of columns.
+func psi4Matrix(r io.Reader) ([]float64, int, error) {
+	var ret []float64
+	cols := 0
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024) //Hessian rows can be long.
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if cols == 0 {
+			cols = len(fields)
+		} else if len(fields) != cols {
+			return nil, 0, fmt.Errorf("Rows with %d and %d columns", cols, len(fields))
+		}
+		for _, v := range fields {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, 0, err
+			}
+			ret = append(ret, f)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(ret) == 0 {
+		return nil, 0, fmt.Errorf("No data found")
+	}
+	return ret, cols, nil
+}
+
+//psi4Charges returns the last set of each type of charges ("Mulliken" and "Lowdin") in a Psi4 output.
+func psi4Charges(r io.Reader) (map[string][]float64, error) {
+	ret := make(map[string][]float64)
+	var current string
+	var charges []float64
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if current == "" {
+			if len(fields) == 3 && fields[1] == "Charges:" && fields[2] == "(a.u.)" {
+				current = fields[0]
+				charges = make([]float64, 0, 10)
+				s.Scan() //the column titles
+			}
+			continue
+		}
+		if len(fields) < 6 {
+			ret[current] = charges
+			current = ""
+			continue
+		}
+		c, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+		if err != nil {
+			return nil, err
+		}
+		charges = append(charges, c)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+var psi4Disp = map[string]string{
+	"nodisp": "",
+	"D2":     "-d2",
+	"D3":     "-d3",
+	"D3ZERO": "-d3",
+	"D3Zero": "-d3",
+	"D3zero": "-d3",
+	"D3BJ":   "-d3bj",
+	"D3bj":   "-d3bj",
+}
+
+var psi4SCFTight = map[int]string{
+	1: "   e_convergence 8\n   d_convergence 8\n",
+	2: "   e_convergence 10\n   d_convergence 10\n",
+}
+
+var psi4SCFConv = map[int]string{
+	0: "",
+	1: "   damping_percentage 20\n",
+	2: "   damping_percentage 40\n   soscf true\n",
+}
+
+var psi4Grid = map[int]string{
+	1: "   dft_spherical_points 302\n   dft_radial_points 75\n",
+	2: "   dft_spherical_points 434\n   dft_radial_points 75\n",
+	3: "   dft_spherical_points 590\n   dft_radial_points 99\n",
+	4: "   dft_spherical_points 974\n   dft_radial_points 175\n",
+}
+
+//psi4Dipole returns the last dipole moment, in Debye, in a Psi4 output. Both the
+//format of Psi4 1.4 and later (in a.u.) and that of the previous versions (in Debye)
+//are supported.
+func psi4Dipole(r io.Reader) ([]float64, error) {
+	var dipole []float64
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		fields := strings.Fields(line)
+		//Dipole X            :          0.0000000            0.0000000            0.0000000
+		if len(fields) > 3 && fields[0] == "Dipole" && fields[2] == ":" {
+			i := strings.Index("XYZ", fields[1])
+			if i < 0 || len(fields[1]) != 1 {
+				continue
+			}
+			if i == 0 || len(dipole) != 3 {
+				dipole = make([]float64, 3)
+			}
+			d, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+			if err != nil {
+				return nil, err
+			}
+			dipole[i] = d * au2Debye
+			continue
+		}
+		if line != "Dipole Moment: [D]" || !s.Scan() {
+			continue
+		}
+		//X:     0.0000      Y:     0.0000      Z:     2.0135     Total:     2.0135
+		fields = strings.Fields(s.Text())
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("Malformed dipole line: %s", s.Text())
+		}
+		dipole = make([]float64, 3)
+		for i := range dipole {
+			d, err := strconv.ParseFloat(fields[2*i+1], 64)
+			if err != nil {
+				return nil, err
+			}
+			dipole[i] = d
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return dipole, nil
+}
@@ -0,0 +1,198 @@
+/*
+ * psi4_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//testPsi4Out is an excerpt of the output of a Psi4 1.4 optimization of water.
+const testPsi4Out = `  ==> Iterations <==
+   @DF-RKS iter   9:   -76.32072411705024   -1.25e-09   1.04e-07 DIIS
+  Energy and wave function converged.
+
+  @DF-RKS Final Energy:   -76.32072411705024
+
+   => Energetics <=
+
+    Nuclear Repulsion Energy =              9.1683847587069504
+    Total Energy =                        -76.3207241170502422
+
+	Final optimized geometry and variables:
+	Molecular point group: c1
+	Full point group: C2v
+
+	Geometry (in Angstrom), charge = 0, multiplicity = 1:
+
+	   O            0.000000000000     0.000000000000     0.115623000000
+	   H            0.000000000000     0.768124000000    -0.475231000000
+	   H            0.000000000000    -0.768124000000    -0.475231000000
+
+  Mulliken Charges: (a.u.)
+   Center  Symbol  Alpha    Beta     Spin     Total
+       1     O     4.18074  4.18074  0.00000 -0.36148
+       2     H     0.40963  0.40963  0.00000  0.18074
+       3     H     0.40963  0.40963  0.00000  0.18074
+
+   Total alpha =  5.00000, Total beta =  5.00000, Total charge = -0.00000
+
+  Lowdin Charges: (a.u.)
+   Center  Symbol  Alpha    Beta     Spin     Total
+       1     O     4.12500  4.12500  0.00000 -0.25000
+       2     H     0.43750  0.43750  0.00000  0.12500
+       3     H     0.43750  0.43750  0.00000  0.12500
+
+   Total alpha =  5.00000, Total beta =  5.00000, Total charge = -0.00000
+
+*** Psi4 exiting successfully. Buy a developer a beer!
+`
+
+func TestPsi4(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "goqm")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mol := chem.NewTopology(0, 1, []*chem.Atom{{Symbol: "O"}, {Symbol: "H"}, {Symbol: "H"}})
+	coords, _ := v3.NewMatrix([]float64{0, 0, 0.12, 0, 0.76, -0.48, 0, -0.76, -0.48})
+	calc := &Calc{Method: "B3LYP", Basis: "def2-TZVP", Dispersion: "D3BJ", Memory: 2000, RI: true, SCFTightness: 2,
+		CConstraints: []int{0}, Job: Job{Opti: true, Charges: true},
+		IConstraints: []*IConstraint{{CAtoms: []int{0, 1}, Val: 0.97, Class: 'B', UseVal: true}, {CAtoms: []int{1, 0, 2}, Class: 'A'}}}
+	p := NewPsi4Handle()
+	p.SetWorkDir(dir)
+	p.SetName("water")
+	if err := p.BuildInput(coords, mol, calc); err != nil {
+		Te.Fatal(err)
+	}
+	input, err := ioutil.ReadFile(filepath.Join(dir, "water.in"))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for _, v := range []string{"memory 2000 mb\n", "molecule mol {\n0 1\nO ", "   basis def2-TZVP\n", "   e_convergence 10\n",
+		"   frozen_cartesian = (\"1 xyz\")\n", "   frozen_bend = (\"2 1 3\")\n", "   fixed_distance = (\"1 2 0.970\")\n",
+		"E, wfn = optimize('b3lyp-d3bj', return_wfn=True)\n"} {
+		if !strings.Contains(string(input), v) {
+			Te.Errorf("The input doesn't contain %q:\n%s", v, input)
+		}
+	}
+	if strings.Contains(string(input), "scf_type pk") {
+		Te.Errorf("Density fitting was not used")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "water.out"), []byte(testPsi4Out), 0644); err != nil {
+		Te.Fatal(err)
+	}
+	energy, err := p.Energy()
+	if err != nil || math.Abs(energy-(-76.3207241170502422*chem.H2Kcal)) > 1e-6 {
+		Te.Errorf("Wrong energy read: %f %v", energy, err)
+	}
+	geo, err := p.OptimizedGeometry(mol)
+	if err != nil || geo.At(2, 1) != -0.768124 {
+		Te.Errorf("Wrong geometry read: %v %v", geo, err)
+	}
+	charges, err := p.Charges()
+	if err != nil || len(charges) != 3 || charges[0] != -0.36148 {
+		Te.Errorf("Wrong charges read: %v %v", charges, err)
+	}
+	all, err := psi4Charges(strings.NewReader(testPsi4Out))
+	if err != nil || len(all["Lowdin"]) != 3 || all["Lowdin"][1] != 0.125 {
+		Te.Errorf("Wrong Lowdin charges read: %v %v", all, err)
+	}
+	unfinished := testPsi4Out[:strings.Index(testPsi4Out, "*** Psi4 exiting")]
+	if _, normal, err := psi4Energy(strings.NewReader(unfinished)); err != nil || normal {
+		Te.Errorf("An unfinished calculation was not detected: %v", err)
+	}
+}
+
+func TestPsi4Forces(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "goqm")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mol := chem.NewTopology(0, 1, []*chem.Atom{{Symbol: "H"}, {Symbol: "H"}})
+	coords, _ := v3.NewMatrix([]float64{0, 0, 0, 0, 0, 0.74})
+	p := NewPsi4Handle()
+	p.SetWorkDir(dir)
+	p.SetName("h2")
+	if err := p.BuildInput(coords, mol, &Calc{Method: "HF", Basis: "def2-SVP", Job: Job{Forces: true}}); err != nil {
+		Te.Fatal(err)
+	}
+	input, err := ioutil.ReadFile(filepath.Join(dir, "h2.in"))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for _, v := range []string{"G, wfn = gradient('hf', return_wfn=True)\n", "np.savetxt('h2.grad', G.np)\n",
+		"E, wfn = frequency('hf', ref_gradient=G, return_wfn=True)\n", "np.savetxt('h2.hess', wfn.hessian().np)\n"} {
+		if !strings.Contains(string(input), v) {
+			Te.Errorf("The input doesn't contain %q:\n%s", v, input)
+		}
+	}
+	grad := "0.000000000000000000e+00 0.000000000000000000e+00 -1.000000000000000000e-02\n" +
+		"0.000000000000000000e+00 0.000000000000000000e+00 1.000000000000000000e-02\n"
+	hess := ""
+	for i := 0; i < 6; i++ {
+		row := make([]string, 6)
+		for j := range row {
+			row[j] = "0.0"
+			if i == j {
+				row[j] = "5.000000000000000000e-01"
+			}
+		}
+		hess += strings.Join(row, " ") + "\n"
+	}
+	for name, data := range map[string]string{"h2.grad": grad, "h2.hess": hess, "h2.out": testPsi4Out} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	g, err := p.Gradient()
+	if err != nil || g.NVecs() != 2 || math.Abs(g.At(1, 2)-0.01*hartreeBohr2KcalA) > 1e-9 {
+		Te.Errorf("Wrong gradient read: %v %v", g, err)
+	}
+	h, err := p.Hessian()
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if r, c := h.Dims(); r != 6 || c != 6 || math.Abs(h.At(3, 3)-0.5*hartreeBohr22KcalA) > 1e-9 || h.At(3, 4) != 0 {
+		Te.Errorf("Wrong Hessian read: %v", h)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "h2.hess"), []byte("1 2 3\n4 5 6\n"), 0644); err != nil {
+		Te.Fatal(err)
+	}
+	if _, err := p.Hessian(); err == nil {
+		Te.Errorf("A non-square Hessian was accepted")
+	}
+}
@@ -25,10 +25,12 @@
 package qm
 
 import (
+	"context"
 	"fmt"
 
 	chem "github.com/rmera/gochem"
 	v3 "github.com/rmera/gochem/v3"
+	"gonum.org/v1/gonum/mat"
 )
 
 //builds an input for a QM calculation
@@ -50,6 +52,13 @@ type Runner interface {
 	Run(wait bool) (err error)
 }
 
+//ContextRunner runs a QM calculation that can be cancelled.
+type ContextRunner interface {
+	//RunContext runs the QM program for a calculation previously set,
+	//waits for it to finish, and kills it if ctx is done before that.
+	RunContext(ctx context.Context) error
+}
+
 //Builds inputs and runs a QM calculations
 type BuilderRunner interface {
 	InputBuilder
@@ -72,6 +81,44 @@ type EnergyGeo interface {
 	OptimizedGeometry(atoms chem.Atomer) (*v3.Matrix, error)
 }
 
+//Gradienter allows to recover the gradient of the energy from a QM calculation.
+//It is implemented by the handles of the programs that support it.
+type Gradienter interface {
+	//Gradient returns the last gradient of the energy with respect to the
+	//Cartesian coordinates, in kcal/(mol*A), as a matrix with one row per atom.
+	//As with Energy, it returns the gradient AND an Error ("Probable problem in calculation")
+	//if the calculation didn't end properly.
+	Gradient() (*v3.Matrix, error)
+}
+
+//Charger allows to recover partial charges from a QM calculation.
+//It is implemented by the handles of the programs that support it.
+type Charger interface {
+	//Charges returns the partial charges from a calculation, using the
+	//default scheme for the program (usually, the one requested by the Charges job).
+	Charges() ([]float64, error)
+
+	//SchemeCharges returns the partial charges obtained with a given scheme
+	//(Mulliken, Lowdin, Hirshfeld, CM5, ESP or RESP), or an error if the program
+	//didn't print them.
+	SchemeCharges(scheme string) ([]float64, error)
+}
+
+//Dipoler allows to recover the dipole moment from a QM calculation.
+type Dipoler interface {
+	//Dipole returns the dipole moment vector, in Debye, as a 1x3 matrix.
+	Dipole() (*v3.Matrix, error)
+}
+
+//Hessianer allows to recover the Hessian from a QM calculation.
+//It is implemented by the handles of the programs that support it.
+type Hessianer interface {
+	//Hessian returns the 3Nx3N matrix of second derivatives of the energy
+	//with respect to the Cartesian coordinates, in kcal/(mol*A^2), not mass-weighted.
+	//The result can be given to NormalModes to obtain frequencies and normal modes.
+	Hessian() (*mat.Dense, error)
+}
+
 //Handle is an interface for a mostly-full functionality QM program
 //where "functionality" reflects it's degree of support in goChem
 type Handle interface {
@@ -86,7 +133,10 @@ const (
 	ErrNoEnergy        = "goChem/QM: No energy in output"
 	ErrNoFreeEnergy    = "goChem/QM: No free energy in output. Forces calculation might have not been performed"
 	ErrNoCharges       = "goChem/QM: Unable to read charges from  output"
+	ErrNoDipole        = "goChem/QM: Unable to read dipole moment from output"
 	ErrNoGeometry      = "gochem/QM: Unable to read geometry from output"
+	ErrNoGradient      = "goChem/QM: Unable to read gradient from output"
+	ErrNoHessian       = "goChem/QM: Unable to read Hessian from output"
 	ErrNotRunning      = "gochem/QM: Couldn't run calculation"
 	ErrCantInput       = "goChem/QM: Can't build input file"
 )
@@ -98,6 +148,9 @@ const (
 	NWChem    = "NWChem"
 	Fermions  = "Fermions++"
 	XTB       = "XTB" //this may go away if Orca starts supporting XTB.
+	Gaussian  = "Gaussian"
+	Psi4      = "Psi4"
+	CP2K      = "CP2K"
 )
 
 //errors
@@ -161,7 +214,7 @@ type jobChoose struct {
 //only the function handling forces will be called).
 type Job struct {
 	Opti    bool
-	Forces  bool
+	Forces  bool //gradient and, where the program supports it, Hessian (frequencies). CP2K runs only the gradient.
 	SP      bool
 	MD      bool
 	Charges bool
@@ -0,0 +1,235 @@
+/*
+ * thermo.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"fmt"
+	"math"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+	"gonum.org/v1/gonum/mat"
+)
+
+//Quasi-harmonic treatments for the low-frequency vibrations, to be used in ThermoSettings.
+const (
+	QHGrimme  = "Grimme"  //Interpolation between harmonic oscillator and free rotor entropies (Chem. Eur. J. 2012, 18, 9955)
+	QHTruhlar = "Truhlar" //Frequencies below the cutoff are raised to the cutoff for the entropy (J. Phys. Chem. B 2011, 115, 14556)
+)
+
+//Physical constants in SI units, used for the thermochemistry.
+const (
+	planck    = 6.62607015e-34    //J s
+	boltzmann = 1.380649e-23      //J/K
+	avogadro  = 6.02214076e23     //1/mol
+	lightC    = 2.99792458e10     //cm/s
+	amu2Kg    = 1.66053906660e-27 //kg
+	atm2Pa    = 101325.0
+	j2Kcal    = 1.0 / 4184.0
+)
+
+//ThermoSettings contains the conditions and options for a thermochemistry calculation.
+//The zero value of each field means that the default is to be used.
+type ThermoSettings struct {
+	Temperature   float64 //In K, default 298.15
+	Pressure      float64 //In atm, default 1
+	Symmetry      int     //Rotational symmetry number, default 1
+	Multiplicity  int     //Electronic multiplicity. By default it is taken from the molecule, if it can give it, or set to 1
+	QuasiHarmonic string  //Treatment for low frequencies: "" (plain RRHO), QHGrimme or QHTruhlar
+	Cutoff        float64 //Frequency, in 1/cm, under which the quasi-harmonic treatment is applied. Default 100
+}
+
+//Thermo contains the results of a thermochemistry calculation. Energies are in kcal/mol and
+//entropies in kcal/(mol K). U, H and G are thermal corrections, including the ZPE, that should
+//be added to the electronic energy.
+type Thermo struct {
+	Temperature float64
+	Pressure    float64
+	ZPE         float64
+	U           float64
+	H           float64
+	G           float64
+	S           float64
+	STrans      float64
+	SRot        float64
+	SVib        float64
+	SElec       float64
+}
+
+//String returns a summary of the thermochemistry results.
+func (T *Thermo) String() string {
+	return fmt.Sprintf("T: %.2f K P: %.4f atm ZPE: %.4f U: %.4f H: %.4f G: %.4f kcal/mol S: %.4f (trans: %.4f rot: %.4f vib: %.4f elec: %.4f) cal/(mol K)",
+		T.Temperature, T.Pressure, T.ZPE, T.U, T.H, T.G, T.S*1000, T.STrans*1000, T.SRot*1000, T.SVib*1000, T.SElec*1000)
+}
+
+//Thermochemistry returns the thermochemical properties of the molecule with coordinates coords,
+//in the ideal gas, rigid-rotor, harmonic-oscillator approximation, optionally with a quasi-harmonic
+//treatment of the low frequencies. The masses are obtained from mol, and the moments of inertia from
+//the moment tensor of the molecule. freqs are the vibrational frequencies, in 1/cm, as returned by
+//NormalModes. Imaginary frequencies (given as negative numbers) are ignored. If settings is nil, the
+//defaults are used.
+func Thermochemistry(mol chem.Masser, coords *v3.Matrix, freqs []float64, settings *ThermoSettings) (*Thermo, error) {
+	s := ThermoSettings{}
+	if settings != nil {
+		s = *settings
+	}
+	if s.Temperature == 0 {
+		s.Temperature = 298.15
+	}
+	if s.Pressure == 0 {
+		s.Pressure = 1
+	}
+	if s.Symmetry == 0 {
+		s.Symmetry = 1
+	}
+	if s.Cutoff == 0 {
+		s.Cutoff = 100
+	}
+	if s.Multiplicity == 0 {
+		s.Multiplicity = 1
+		if m, ok := mol.(interface{ Multi() int }); ok && m.Multi() > 0 {
+			s.Multiplicity = m.Multi()
+		}
+	}
+	if s.Temperature < 0 || s.Pressure < 0 || s.Symmetry < 0 || s.Cutoff < 0 {
+		return nil, Error{ErrCantValue, "", "", "Negative temperature, pressure, symmetry number or cutoff", []string{"Thermochemistry"}, true}
+	}
+	if s.QuasiHarmonic != "" && s.QuasiHarmonic != QHGrimme && s.QuasiHarmonic != QHTruhlar {
+		return nil, Error{ErrCantValue, "", "", "Unknown quasi-harmonic treatment: " + s.QuasiHarmonic, []string{"Thermochemistry"}, true}
+	}
+	masses, err := mol.Masses()
+	if err != nil {
+		return nil, errDecorate(err, "Thermochemistry")
+	}
+	if len(masses) != coords.NVecs() {
+		return nil, Error{ErrCantValue, "", "", fmt.Sprintf("%d masses for %d atoms", len(masses), coords.NVecs()), []string{"Thermochemistry"}, true}
+	}
+	var totalmass float64
+	for _, m := range masses {
+		totalmass += m
+	}
+	T := s.Temperature
+	kT := boltzmann * T
+	R := boltzmann * avogadro * j2Kcal //kcal/(mol K)
+	ret := &Thermo{Temperature: T, Pressure: s.Pressure}
+
+	//Translation
+	m := totalmass * amu2Kg
+	qtrans := math.Pow(2*math.Pi*m*kT/(planck*planck), 1.5) * kT / (s.Pressure * atm2Pa)
+	ret.STrans = R * (math.Log(qtrans) + 2.5)
+	ret.U = 1.5 * R * T
+
+	//Rotation
+	moments, err := principalMoments(coords, masses)
+	if err != nil {
+		return nil, errDecorate(err, "Thermochemistry")
+	}
+	sigma := float64(s.Symmetry)
+	switch {
+	case moments[2] == 0:
+		//A single atom, nothing to do.
+	case moments[0]/moments[2] < 1e-4:
+		//Linear molecule
+		theta := planck * planck / (8 * math.Pi * math.Pi * moments[2] * boltzmann)
+		ret.SRot = R * (math.Log(T/(sigma*theta)) + 1)
+		ret.U += R * T
+	default:
+		prod := 1.0
+		for _, v := range moments {
+			prod *= planck * planck / (8 * math.Pi * math.Pi * v * boltzmann)
+		}
+		ret.SRot = R * (math.Log(math.Sqrt(math.Pi*T*T*T/prod)/sigma) + 1.5)
+		ret.U += 1.5 * R * T
+	}
+
+	//Vibration
+	//Average moment of inertia used by Grimme to limit the free-rotor moments, in kg m^2.
+	const bav = 1e-44
+	for _, f := range freqs {
+		if f <= 0 {
+			continue
+		}
+		ret.ZPE += 0.5 * planck * lightC * f * avogadro * j2Kcal
+		x := planck * lightC * f / kT
+		ret.U += R * T * x / math.Expm1(x)
+		switch {
+		case s.QuasiHarmonic == QHTruhlar && f < s.Cutoff:
+			ret.SVib += hoEntropy(planck*lightC*s.Cutoff/kT, R)
+		case s.QuasiHarmonic == QHGrimme:
+			mu := planck / (8 * math.Pi * math.Pi * lightC * f)
+			mu = mu * bav / (mu + bav)
+			srot := R * (0.5 + math.Log(math.Sqrt(8*math.Pi*math.Pi*math.Pi*mu*kT/(planck*planck))))
+			w := 1 / (1 + math.Pow(s.Cutoff/f, 4))
+			ret.SVib += w*hoEntropy(x, R) + (1-w)*srot
+		default:
+			ret.SVib += hoEntropy(x, R)
+		}
+	}
+	ret.U += ret.ZPE
+	ret.SElec = R * math.Log(float64(s.Multiplicity))
+	ret.S = ret.STrans + ret.SRot + ret.SVib + ret.SElec
+	ret.H = ret.U + R*T
+	ret.G = ret.H - T*ret.S
+	return ret, nil
+}
+
+//hoEntropy returns the entropy of a harmonic oscillator with x=h*nu/kT, in the units of R.
+func hoEntropy(x, R float64) float64 {
+	return R * (x/math.Expm1(x) - math.Log(-math.Expm1(-x)))
+}
+
+//principalMoments returns the principal moments of inertia, in kg m^2, sorted in increasing order,
+//for the coordinates (in A) and masses (in amu) given.
+func principalMoments(coords *v3.Matrix, masses []float64) ([]float64, error) {
+	if coords.NVecs() == 1 {
+		return []float64{0, 0, 0}, nil
+	}
+	moment, err := chem.MomentTensor(coords, masses)
+	if err != nil {
+		return nil, err
+	}
+	//The inertia tensor is trace(M)*1-M, where M is the moment tensor.
+	tr := moment.At(0, 0) + moment.At(1, 1) + moment.At(2, 2)
+	inertia := mat.NewSymDense(3, nil)
+	for i := 0; i < 3; i++ {
+		for j := i; j < 3; j++ {
+			v := -moment.At(i, j)
+			if i == j {
+				v += tr
+			}
+			inertia.SetSym(i, j, v*amu2Kg*1e-20)
+		}
+	}
+	var eig mat.EigenSym
+	if ok := eig.Factorize(inertia, false); !ok {
+		return nil, Error{ErrCantValue, "", "", "Diagonalization of the inertia tensor failed", []string{"mat.EigenSym.Factorize", "principalMoments"}, true}
+	}
+	vals := eig.Values(nil)
+	for i, v := range vals {
+		if v < 0 {
+			vals[i] = 0
+		}
+	}
+	return vals, nil
+}
@@ -0,0 +1,114 @@
+/*
+ * thermo_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"math"
+	"testing"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+type testMasser []float64
+
+func (t testMasser) Masses() ([]float64, error) { return []float64(t), nil }
+
+type testMultiMasser struct {
+	testMasser
+	multi int
+}
+
+func (t testMultiMasser) Multi() int { return t.multi }
+
+func TestThermochemistry(Te *testing.T) {
+	near := func(name string, got, want, tol float64) {
+		if math.Abs(got-want) > tol {
+			Te.Errorf("%s: got %.5f want %.5f", name, got, want)
+		}
+	}
+	//A single atom of argon: only translation. Standard entropy at 1 atm is 36.98 cal/(mol K).
+	ar, _ := v3.NewMatrix([]float64{0, 0, 0})
+	th, err := Thermochemistry(testMasser{39.962383}, ar, nil, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	near("Ar S", th.S*1000, 36.98, 0.01)
+	near("Ar SRot", th.SRot, 0, 1e-12)
+	near("Ar H", th.H, 2.5*0.0019872*298.15, 1e-3)
+	//H2, linear, rotational temperature 87.55 K with r=0.7414 A.
+	h2, _ := v3.NewMatrix([]float64{0, 0, 0, 0, 0, 0.7414})
+	th, err = Thermochemistry(testMultiMasser{testMasser{1.007825, 1.007825}, 1}, h2, []float64{4400}, &ThermoSettings{Symmetry: 2})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	near("H2 SRot", th.SRot*1000, 1.98720*(math.Log(298.15/(2*87.55))+1), 0.005)
+	near("H2 ZPE", th.ZPE, 2200*2.859144e-3, 1e-4)
+	near("H2 SVib", th.SVib, 0, 1e-7)
+	//Water. Gaussian reports a translational entropy of 34.608 cal/(mol K).
+	water, _ := v3.NewMatrix([]float64{0, 0, 0.1173, 0, 0.7572, -0.4692, 0, -0.7572, -0.4692})
+	wmasses := testMasser{15.994915, 1.007825, 1.007825}
+	freqs := []float64{-50, 1600, 3700, 3800}
+	th, err = Thermochemistry(wmasses, water, freqs, &ThermoSettings{Symmetry: 2})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	near("water STrans", th.STrans*1000, 34.608, 0.002)
+	near("water ZPE", th.ZPE, 0.5*9100*2.859144e-3, 1e-3)
+	near("water G", th.G, th.H-298.15*th.S, 1e-9)
+	if th.SRot <= 0 || th.SVib <= 0 || th.SElec != 0 {
+		Te.Errorf("Wrong water entropies: %s", th)
+	}
+	//Doublet: the electronic entropy is R ln 2.
+	th2, err := Thermochemistry(testMultiMasser{wmasses, 2}, water, freqs, &ThermoSettings{Symmetry: 2})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	near("doublet SElec", th2.SElec*1000, 1.98720*math.Log(2), 1e-4)
+	//Quasi-harmonic corrections only matter for low frequencies.
+	low := []float64{20, 1600, 3700, 3800}
+	rrho, _ := Thermochemistry(wmasses, water, low, nil)
+	grimme, _ := Thermochemistry(wmasses, water, low, &ThermoSettings{QuasiHarmonic: QHGrimme})
+	truhlar, err := Thermochemistry(wmasses, water, low, &ThermoSettings{QuasiHarmonic: QHTruhlar})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	ref, _ := Thermochemistry(wmasses, water, []float64{100, 1600, 3700, 3800}, nil)
+	near("Truhlar SVib", truhlar.SVib, ref.SVib, 1e-9)
+	if !(grimme.SVib < rrho.SVib && truhlar.SVib < rrho.SVib) {
+		Te.Errorf("Quasi-harmonic entropies should be smaller than the RRHO ones: %v %v %v", rrho.SVib, grimme.SVib, truhlar.SVib)
+	}
+	high, _ := Thermochemistry(wmasses, water, freqs, &ThermoSettings{QuasiHarmonic: QHGrimme})
+	near("Grimme high frequencies", high.SVib, th.SVib, 1e-5)
+	near("Grimme ZPE", grimme.ZPE, rrho.ZPE, 1e-12)
+	if _, err := Thermochemistry(wmasses, water, freqs, &ThermoSettings{QuasiHarmonic: "Foo"}); err == nil {
+		Te.Error("Unknown quasi-harmonic treatment should give an error")
+	}
+	if _, err := Thermochemistry(testMasser{1}, water, freqs, nil); err == nil {
+		Te.Error("Wrong number of masses should give an error")
+	}
+}
@@ -34,16 +34,20 @@ package qm
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	chem "github.com/rmera/gochem"
 	v3 "github.com/rmera/gochem/v3"
+	"gonum.org/v1/gonum/mat"
 )
 
 //TMHandle is the representation of a Turbomole (TM) calculation
@@ -395,6 +399,10 @@ func (O *TMHandle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger, Q
 			O.command = O.command + " -ri"
 		}
 	}
+	pop := false
+	jc.charges = func() {
+		pop = true
+	}
 	Q.Job.Do(jc)
 
 	//Now modify control
@@ -408,6 +416,9 @@ func (O *TMHandle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger, Q
 		O.command = "mpshift"
 		args = append(args, "$gimic")
 	}
+	if pop {
+		args = append(args, "$pop") //Mulliken population analysis
+	}
 	if err := O.addToControl(args, Q); err != nil {
 		return errDecorate(err, "BuildInput")
 	}
@@ -453,22 +464,37 @@ var tMDisp = map[string]string{
 //it waits or not for the result depending on wait.
 //This is a Unix-only function.
 func (O *TMHandle) Run(wait bool) error {
+	if wait == true {
+		return O.RunContext(context.Background())
+	}
 	os.Chdir(O.inputname)
 	defer os.Chdir("..")
-	var err error
 	filename := strings.Fields(O.command)
 	//fmt.Println("nohup " + O.command + " > " + filename[0] + ".out")
 	command := exec.Command("sh", "-c", "nohup "+O.command+" >"+filename[0]+".out")
-	if wait == true {
-		err = command.Run()
-	} else {
-		err = command.Start()
+	if err := command.Start(); err != nil {
+		return Error{ErrNotRunning, Turbomole, O.inputname, err.Error(), []string{"exec.Start", "Run"}, true}
 	}
-	if err != nil {
-		err = Error{ErrNotRunning, Turbomole, O.inputname, err.Error(), []string{"exec.Run/Start", "Run"}, true}
+	return nil
+}
 
+//RunContext runs the command given by the string O.command and waits for it to finish.
+//The program, and all the Turbomole programs it calls (as jobex or NumForce do), are killed
+//if ctx is done before that.
+func (O *TMHandle) RunContext(ctx context.Context) error {
+	args := strings.Fields(O.command)
+	out, err := os.Create(filepath.Join(O.inputname, args[0]+".out"))
+	if err != nil {
+		return Error{ErrNotRunning, Turbomole, O.inputname, err.Error(), []string{"os.Create", "RunContext"}, true}
+	}
+	defer out.Close()
+	command := exec.Command(args[0], args[1:]...)
+	command.Dir = O.inputname
+	command.Stdout = out
+	if err := runGroup(ctx, command); err != nil {
+		return Error{ErrNotRunning, Turbomole, O.inputname, err.Error(), []string{"exec.Run", "RunContext"}, true}
 	}
-	return err
+	return nil
 }
 
 //Energy returns the energy from the corresponding calculation, in kcal/mol.
@@ -515,6 +541,273 @@ func (O *TMHandle) OptimizedGeometry(atoms chem.Atomer) (*v3.Matrix, error) {
 
 }
 
+//Gradient returns the last gradient from the gradient file of the calculation (written
+//by ridft/rdgrad, jobex, or NumForce), in kcal/(mol*A).
+func (O *TMHandle) Gradient() (*v3.Matrix, error) {
+	f, err := os.Open(filepath.Join(O.inputname, "gradient"))
+	if err != nil {
+		return nil, Error{ErrNoGradient, Turbomole, O.inputname, err.Error(), []string{"os.Open", "Gradient"}, true}
+	}
+	defer f.Close()
+	grad, err := tmGradient(f)
+	if err != nil {
+		return nil, Error{ErrNoGradient, Turbomole, O.inputname, err.Error(), []string{"tmGradient", "Gradient"}, true}
+	}
+	grad.Scale(hartreeBohr2KcalA, grad)
+	return grad, nil
+}
+
+//Hessian returns the Cartesian Hessian from a NumForce or aoforce calculation, in kcal/(mol*A^2).
+//The Hessian is read from the hessian file or, if there isn't one, from the control file. The non-projected
+//Hessian is preferred, if present.
+func (O *TMHandle) Hessian() (*mat.Dense, error) {
+	f, err := os.Open(filepath.Join(O.inputname, "hessian"))
+	if err != nil {
+		f, err = os.Open(filepath.Join(O.inputname, "control"))
+		if err != nil {
+			return nil, Error{ErrNoHessian, Turbomole, O.inputname, err.Error(), []string{"os.Open", "Hessian"}, true}
+		}
+	}
+	defer f.Close()
+	hess, err := tmHessian(f)
+	if err != nil {
+		return nil, Error{ErrNoHessian, Turbomole, O.inputname, err.Error(), []string{"tmHessian", "Hessian"}, true}
+	}
+	hess.Scale(hartreeBohr22KcalA, hess)
+	return hess, nil
+}
+
+//tmFloat parses a float in Turbomole format, where the exponent can be marked with a D.
+func tmFloat(s string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(strings.Replace(s, "D", "E", 1), "d", "e", 1), 64)
+}
+
+//tmGradient reads the last cycle of a $grad data group, in the Turbomole format
+//(also used by xtb), and returns the gradient in atomic units.
+func tmGradient(r io.Reader) (*v3.Matrix, error) {
+	var grad []float64
+	natoms := 0
+	reading := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text()) //xtb indents the data group names.
+		if strings.HasPrefix(line, "$grad") {
+			reading = true
+			continue
+		}
+		if !reading {
+			continue
+		}
+		if strings.HasPrefix(line, "$") {
+			break
+		}
+		if strings.Contains(line, "cycle =") {
+			grad = grad[:0]
+			natoms = 0
+			continue
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 4: //coordinates and element
+			natoms++
+		case 3:
+			for _, v := range fields {
+				g, err := tmFloat(v)
+				if err != nil {
+					return nil, err
+				}
+				grad = append(grad, g)
+			}
+		default:
+			return nil, fmt.Errorf("Malformed gradient line: %s", line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if natoms == 0 || len(grad) != 3*natoms {
+		return nil, fmt.Errorf("No complete gradient found")
+	}
+	return v3.NewMatrix(grad)
+}
+
+//tmHessian reads a $hessian data group, in the Turbomole format (also used by xtb), and
+//returns the Hessian in atomic units. The non-projected Hessian is returned if present, otherwise,
+//the projected one. In the Turbomole format each line starts with the row index and
+//the line number in the row, which xtb omits.
+func tmHessian(r io.Reader) (*mat.Dense, error) {
+	var values, projected []float64
+	var current *[]float64
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "$") {
+			current = nil
+			if strings.HasPrefix(line, "$hessian") {
+				current = &values
+				if strings.Contains(line, "projected") {
+					current = &projected
+				}
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 && !strings.Contains(fields[0], ".") && !strings.Contains(fields[1], ".") {
+			fields = fields[2:]
+		}
+		for _, v := range fields {
+			h, err := tmFloat(v)
+			if err != nil {
+				return nil, err
+			}
+			*current = append(*current, h)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		values = projected
+	}
+	n := int(math.Round(math.Sqrt(float64(len(values)))))
+	if n == 0 || n*n != len(values) {
+		return nil, fmt.Errorf("No complete Hessian found: %d elements read", len(values))
+	}
+	return mat.NewDense(n, n, values), nil
+}
+
+//output returns the name of the output file with the results of ridft/dscf for the calculation.
+func (O *TMHandle) output() string {
+	prog := "ridft"
+	if f := strings.Fields(O.command); len(f) > 0 {
+		prog = f[0]
+	}
+	if prog == "jobex" {
+		return filepath.Join(O.inputname, "job.last")
+	}
+	return filepath.Join(O.inputname, prog+".out")
+}
+
+//Charges returns the Mulliken charges from a previous calculation where the Charges job was requested.
+func (O *TMHandle) Charges() ([]float64, error) {
+	return O.SchemeCharges(Mulliken)
+}
+
+//SchemeCharges returns the partial charges of the given scheme from a previous calculation. Only
+//Mulliken charges, which are printed if the Charges job was requested, are supported.
+func (O *TMHandle) SchemeCharges(scheme string) ([]float64, error) {
+	f, err := os.Open(O.output())
+	if err != nil {
+		return nil, Error{ErrNoCharges, Turbomole, O.inputname, err.Error(), []string{"os.Open", "SchemeCharges"}, true}
+	}
+	defer f.Close()
+	all, err := tmCharges(f)
+	if err != nil {
+		return nil, Error{ErrNoCharges, Turbomole, O.inputname, err.Error(), []string{"tmCharges", "SchemeCharges"}, true}
+	}
+	charges, err := chooseCharges(all, scheme)
+	if err != nil {
+		return nil, Error{ErrNoCharges, Turbomole, O.inputname, err.Error(), []string{"chooseCharges", "SchemeCharges"}, true}
+	}
+	return charges, nil
+}
+
+//Dipole returns the dipole moment from a previous calculation, in Debye.
+func (O *TMHandle) Dipole() (*v3.Matrix, error) {
+	f, err := os.Open(O.output())
+	if err != nil {
+		return nil, Error{ErrNoDipole, Turbomole, O.inputname, err.Error(), []string{"os.Open", "Dipole"}, true}
+	}
+	defer f.Close()
+	d, err := tmDipole(f)
+	if err == nil {
+		var dipole *v3.Matrix
+		if dipole, err = dipoleMatrix(d, au2Debye); err == nil {
+			return dipole, nil
+		}
+	}
+	return nil, Error{ErrNoDipole, Turbomole, O.inputname, err.Error(), []string{"tmDipole", "Dipole"}, true}
+}
+
+//tmCharges returns the last set of Mulliken charges in a ridft/dscf output.
+func tmCharges(r io.Reader) (map[string][]float64, error) {
+	ret := make(map[string][]float64)
+	var charges []float64
+	reading := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "atomic populations from total density") {
+			reading = true
+			charges = make([]float64, 0, 10)
+			continue
+		}
+		if !reading {
+			continue
+		}
+		//1o      -0.44356   3.81346   4.63010
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(line, "atom") {
+			if len(charges) > 0 {
+				ret[Mulliken] = charges
+				reading = false
+			}
+			continue
+		}
+		c, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		charges = append(charges, c)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+//tmDipole returns the last dipole moment, in a.u., in a ridft/dscf output.
+func tmDipole(r io.Reader) ([]float64, error) {
+	var dipole []float64
+	reading := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "dipole moment" {
+			reading = true
+			dipole = make([]float64, 3)
+			continue
+		}
+		if !reading {
+			continue
+		}
+		//x     0.00000000000002    -0.00000000000002   -0.00000000000000
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			continue
+		}
+		i := strings.Index("xyz", fields[0])
+		if i < 0 || len(fields[0]) != 1 {
+			continue
+		}
+		d, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return nil, err
+		}
+		dipole[i] = d
+		if i == 2 {
+			reading = false
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return dipole, nil
+}
+
 //Gets the second to last line in a turbomole energy file given as a bufio.Reader.
 //expensive on the CPU but rather easy on the memory, as the file is read line by line.
 func getSecondToLastLine(f *bufio.Reader) (string, error) {
@@ -0,0 +1,209 @@
+/*
+ * vibrations.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	v3 "github.com/rmera/gochem/v3"
+	"gonum.org/v1/gonum/mat"
+)
+
+//Unit conversions for gradients and Hessians.
+const (
+	hartreeBohr2KcalA  = 627.509 * 1.889725989               //Eh/bohr to kcal/(mol*A)
+	hartreeBohr22KcalA = 627.509 * 1.889725989 * 1.889725989 //Eh/bohr^2 to kcal/(mol*A^2)
+	mdyneA2KcalA       = 143.9325                            //mdyne/A to kcal/(mol*A^2)
+)
+
+//kcalAmu2Wavenumber converts the square root of an eigenvalue of a mass-weighted Hessian,
+//in kcal/(mol*A^2*amu), to a wave number in 1/cm.
+//The eigenvalue is first taken to s^-2 (1 kcal/mol = 4184 J/NA, 1 amu = 1e-3 kg/NA, 1 A = 1e-10 m)
+//and the angular frequency is then divided by 2*pi*c, with c in cm/s.
+var kcalAmu2Wavenumber = math.Sqrt(4184/(1e-3*1e-20)) / (2 * math.Pi * 2.99792458e10)
+
+//NormalModes performs a harmonic normal-mode analysis from a Cartesian Hessian, in kcal/(mol*A^2)
+//as returned by the Hessian method of the handles, the coordinates (in A) at which the Hessian was
+//obtained and the atomic masses (in amu). The Hessian is mass-weighted and the translations and rotations
+//are projected out of it before diagonalization, so only the 3N-6 (3N-5 for linear molecules) vibrations
+//are returned.
+//NormalModes returns the frequencies, in 1/cm, sorted in increasing order, with imaginary frequencies
+//given as negative numbers, and a matrix where each column is the normal mode for the corresponding
+//frequency, as a normalized Cartesian displacement (i.e. not mass-weighted).
+func NormalModes(coords *v3.Matrix, masses []float64, hessian *mat.Dense) ([]float64, *mat.Dense, error) {
+	natoms := coords.NVecs()
+	n := 3 * natoms
+	if r, c := hessian.Dims(); r != n || c != n {
+		return nil, nil, Error{ErrCantValue, "", "", fmt.Sprintf("Hessian of size %dx%d for %d atoms", r, c, natoms), []string{"NormalModes"}, true}
+	}
+	if len(masses) != natoms {
+		return nil, nil, Error{ErrCantValue, "", "", fmt.Sprintf("%d masses for %d atoms", len(masses), natoms), []string{"NormalModes"}, true}
+	}
+	sqrtm := make([]float64, n)
+	var com [3]float64
+	var totalmass float64
+	for i, m := range masses {
+		if m <= 0 {
+			return nil, nil, Error{ErrCantValue, "", "", fmt.Sprintf("Non-positive mass for atom %d", i), []string{"NormalModes"}, true}
+		}
+		for j := 0; j < 3; j++ {
+			sqrtm[3*i+j] = math.Sqrt(m)
+			com[j] += m * coords.At(i, j)
+		}
+		totalmass += m
+	}
+	for j := range com {
+		com[j] /= totalmass
+	}
+	trot := trRotVectors(coords, com, sqrtm)
+	//The projector to the space orthogonal to translations and rotations.
+	P := mat.NewDense(n, n, nil)
+	for i := 0; i < n; i++ {
+		P.Set(i, i, 1)
+	}
+	for _, v := range trot {
+		vv := mat.NewDense(n, n, nil)
+		vv.Outer(1, v, v)
+		P.Sub(P, vv)
+	}
+	mw := mat.NewDense(n, n, nil)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			mw.Set(i, j, hessian.At(i, j)/(sqrtm[i]*sqrtm[j]))
+		}
+	}
+	proj := mat.NewDense(n, n, nil)
+	proj.Product(P, mw, P)
+	sym := mat.NewSymDense(n, nil)
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			sym.SetSym(i, j, (proj.At(i, j)+proj.At(j, i))/2)
+		}
+	}
+	var eig mat.EigenSym
+	if ok := eig.Factorize(sym, true); !ok {
+		return nil, nil, Error{ErrCantValue, "", "", "Diagonalization of the Hessian failed", []string{"mat.EigenSym.Factorize", "NormalModes"}, true}
+	}
+	values := eig.Values(nil)
+	vectors := mat.NewDense(n, n, nil)
+	eig.VectorsTo(vectors)
+	//The eigenvectors with the largest overlap with the translations and rotations are discarded.
+	type mode struct {
+		index   int
+		overlap float64
+	}
+	modes := make([]mode, n)
+	for i := range modes {
+		modes[i].index = i
+		col := vectors.ColView(i)
+		for _, v := range trot {
+			d := mat.Dot(col, v)
+			modes[i].overlap += d * d
+		}
+	}
+	sort.SliceStable(modes, func(i, j int) bool { return modes[i].overlap < modes[j].overlap })
+	modes = modes[:n-len(trot)]
+	sort.SliceStable(modes, func(i, j int) bool { return values[modes[i].index] < values[modes[j].index] })
+	freqs := make([]float64, len(modes))
+	cart := mat.NewDense(n, len(modes), nil)
+	for i, v := range modes {
+		l := values[v.index]
+		freqs[i] = math.Copysign(math.Sqrt(math.Abs(l))*kcalAmu2Wavenumber, l)
+		norm := 0.0
+		for j := 0; j < n; j++ {
+			d := vectors.At(j, v.index) / sqrtm[j]
+			cart.Set(j, i, d)
+			norm += d * d
+		}
+		norm = math.Sqrt(norm)
+		for j := 0; j < n; j++ {
+			cart.Set(j, i, cart.At(j, i)/norm)
+		}
+	}
+	return freqs, cart, nil
+}
+
+//trRotVectors returns an orthonormal basis for the translations and rotations of the
+//system in mass-weighted coordinates. The basis has 6 vectors except for linear systems (5) and
+//single atoms (3).
+func trRotVectors(coords *v3.Matrix, com [3]float64, sqrtm []float64) []*mat.VecDense {
+	n := len(sqrtm)
+	candidates := make([]*mat.VecDense, 0, 6)
+	for k := 0; k < 3; k++ {
+		t := mat.NewVecDense(n, nil)
+		for i := 0; i < n/3; i++ {
+			t.SetVec(3*i+k, sqrtm[3*i])
+		}
+		candidates = append(candidates, t)
+	}
+	for k := 0; k < 3; k++ {
+		//the rotation around the axis k is the cross product of the unit vector k with the position.
+		r := mat.NewVecDense(n, nil)
+		for i := 0; i < n/3; i++ {
+			var pos [3]float64
+			for j := range pos {
+				pos[j] = coords.At(i, j) - com[j]
+			}
+			a, b := (k+1)%3, (k+2)%3
+			r.SetVec(3*i+b, pos[a]*sqrtm[3*i])
+			r.SetVec(3*i+a, -pos[b]*sqrtm[3*i])
+		}
+		candidates = append(candidates, r)
+	}
+	//Gram-Schmidt, discarding the vectors that are linearly dependent on the previous ones.
+	basis := make([]*mat.VecDense, 0, 6)
+	for _, v := range candidates {
+		for _, b := range basis {
+			v.AddScaledVec(v, -mat.Dot(v, b), b)
+		}
+		norm := mat.Norm(v, 2)
+		if norm < 1e-4 {
+			continue
+		}
+		v.ScaleVec(1/norm, v)
+		basis = append(basis, v)
+	}
+	return basis
+}
+
+//packedLowerSym returns the symmetric matrix whose lower triangle is given, row by row, in packed.
+func packedLowerSym(packed []float64) (*mat.Dense, error) {
+	//len(packed)=n(n+1)/2
+	n := int(math.Round((math.Sqrt(float64(8*len(packed)+1)) - 1) / 2))
+	if n*(n+1)/2 != len(packed) || n == 0 {
+		return nil, fmt.Errorf("%d elements can't form a lower triangular matrix", len(packed))
+	}
+	ret := mat.NewDense(n, n, nil)
+	k := 0
+	for i := 0; i < n; i++ {
+		for j := 0; j <= i; j++ {
+			ret.Set(i, j, packed[k])
+			ret.Set(j, i, packed[k])
+			k++
+		}
+	}
+	return ret, nil
+}
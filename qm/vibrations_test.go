@@ -0,0 +1,386 @@
+/*
+ * vibrations_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package qm
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	v3 "github.com/rmera/gochem/v3"
+	"gonum.org/v1/gonum/mat"
+)
+
+var _ Gradienter = (*OrcaHandle)(nil)
+var _ Gradienter = (*TMHandle)(nil)
+var _ Gradienter = (*NWChemHandle)(nil)
+var _ Gradienter = (*XTBHandle)(nil)
+var _ Gradienter = (*MopacHandle)(nil)
+var _ Gradienter = (*GaussianHandle)(nil)
+var _ Gradienter = (*Psi4Handle)(nil)
+var _ Hessianer = (*OrcaHandle)(nil)
+var _ Hessianer = (*TMHandle)(nil)
+var _ Hessianer = (*NWChemHandle)(nil)
+var _ Hessianer = (*XTBHandle)(nil)
+var _ Hessianer = (*MopacHandle)(nil)
+var _ Hessianer = (*GaussianHandle)(nil)
+var _ Hessianer = (*Psi4Handle)(nil)
+
+//waterFF is a simple harmonic force field for water, in kcal/mol, A and rad.
+func waterFF(c []float64) float64 {
+	bond := func(i, j int) float64 {
+		return math.Sqrt(math.Pow(c[3*i]-c[3*j], 2) + math.Pow(c[3*i+1]-c[3*j+1], 2) + math.Pow(c[3*i+2]-c[3*j+2], 2))
+	}
+	r1, r2 := bond(0, 1), bond(0, 2)
+	dot := 0.0
+	for k := 0; k < 3; k++ {
+		dot += (c[3+k] - c[k]) * (c[6+k] - c[k])
+	}
+	theta := math.Acos(dot / (r1 * r2))
+	return 0.5*1100*(math.Pow(r1-0.96, 2)+math.Pow(r2-0.96, 2)) + 0.5*100*math.Pow(theta-104.5*math.Pi/180, 2)
+}
+
+//numHessian obtains the Hessian of f at c by finite differences.
+func numHessian(f func([]float64) float64, c []float64) *mat.Dense {
+	const d = 1e-4
+	n := len(c)
+	hess := mat.NewDense(n, n, nil)
+	x := make([]float64, n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			e := 0.0
+			for _, s := range [][3]float64{{1, 1, 1}, {1, -1, -1}, {-1, 1, -1}, {-1, -1, 1}} {
+				copy(x, c)
+				x[i] += s[0] * d
+				x[j] += s[1] * d
+				e += s[2] * f(x)
+			}
+			hess.Set(i, j, e/(4*d*d))
+		}
+	}
+	return hess
+}
+
+func TestNormalModes(Te *testing.T) {
+	if math.Abs(kcalAmu2Wavenumber-108.59) > 0.01 {
+		Te.Errorf("Wrong conversion factor: %f", kcalAmu2Wavenumber)
+	}
+	//H2 along a tilted axis, with a bond force constant of 827 kcal/(mol A^2)
+	k := 827.0
+	u := []float64{1 / math.Sqrt(3), 1 / math.Sqrt(3), 1 / math.Sqrt(3)}
+	h2 := mat.NewDense(6, 6, nil)
+	for i := 0; i < 6; i++ {
+		for j := 0; j < 6; j++ {
+			sign := 1.0
+			if i/3 != j/3 {
+				sign = -1
+			}
+			h2.Set(i, j, sign*k*u[i%3]*u[j%3])
+		}
+	}
+	coords, _ := v3.NewMatrix([]float64{0, 0, 0, 0.43, 0.43, 0.43})
+	freqs, modes, err := NormalModes(coords, []float64{1.008, 1.008}, h2)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	expected := math.Sqrt(k/(1.008/2)) * kcalAmu2Wavenumber
+	if len(freqs) != 1 || math.Abs(freqs[0]-expected) > 1e-6 {
+		Te.Fatalf("Wrong H2 frequencies: %v, expected %f", freqs, expected)
+	}
+	for i := 0; i < 3; i++ {
+		if math.Abs(math.Abs(modes.At(i, 0))-1/math.Sqrt(6)) > 1e-6 || math.Abs(modes.At(i, 0)+modes.At(i+3, 0)) > 1e-6 {
+			Te.Errorf("Wrong H2 normal mode: %v", mat.Formatted(modes.T()))
+		}
+	}
+	//Water with a valence force field
+	th := 104.5 * math.Pi / 360
+	c := []float64{0, 0, 0, 0.96 * math.Sin(th), 0, 0.96 * math.Cos(th), -0.96 * math.Sin(th), 0, 0.96 * math.Cos(th)}
+	coords, _ = v3.NewMatrix(c)
+	freqs, modes, err = NormalModes(coords, []float64{15.999, 1.008, 1.008}, numHessian(waterFF, c))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if rows, _ := modes.Dims(); len(freqs) != 3 || rows != 9 {
+		Te.Fatalf("Wrong number of water normal modes: %v", freqs)
+	}
+	for i, v := range freqs {
+		if v < 1000 || v > 5000 || (i > 0 && v < freqs[i-1]) {
+			Te.Errorf("Wrong water frequencies: %v", freqs)
+		}
+	}
+	//The bending mode keeps the symmetry plane: no displacement along y.
+	for i := 0; i < 3; i++ {
+		if math.Abs(modes.At(3*i+1, 0)) > 1e-5 {
+			Te.Errorf("Wrong water bending mode: %v", mat.Formatted(modes.T()))
+		}
+	}
+	//A negative force constant gives an imaginary frequency
+	h2.Scale(-1, h2)
+	freqs, _, err = NormalModes(v3.Dense2Matrix(mat.NewDense(2, 3, []float64{0, 0, 0, 0.43, 0.43, 0.43})), []float64{1.008, 1.008}, h2)
+	if err != nil || math.Abs(freqs[0]+expected) > 1e-6 {
+		Te.Errorf("Wrong imaginary frequency: %v %v", freqs, err)
+	}
+	if _, _, err = NormalModes(coords, []float64{1, 1}, h2); err == nil {
+		Te.Errorf("Wrong sizes not detected")
+	}
+}
+
+//The following are excerpts of outputs for H2, with the Hessian testHessian, and the
+//gradient (0.01, 0.01, 0.01, -0.01, -0.01, -0.01), both in atomic units (except for MOPAC).
+
+const testOrcaEngrad = `#
+# Number of atoms
+#
+ 2
+#
+# The current total energy in Eh
+#
+     -1.166123456789
+#
+# The current gradient in Eh/bohr
+#
+       0.010000000000
+       0.010000000000
+       0.010000000000
+      -0.010000000000
+      -0.010000000000
+      -0.010000000000
+#
+# The atomic numbers and current coordinates in Bohr
+#
+   1     0.0000000    0.0000000    0.0000000
+   1     0.8125800    0.8125800    0.8125800
+`
+
+const testOrcaHess = `
+$orca_hessian_file
+
+$act_atom
+  0
+
+$hessian
+6
+                         0                  1                  2                  3                  4
+      0        1.0100000000E+00   2.0000000000E-02   3.0000000000E-02   4.0000000000E-02   5.0000000000E-02
+      1        2.0000000000E-02   1.0400000000E+00   6.0000000000E-02   8.0000000000E-02   1.0000000000E-01
+      2        3.0000000000E-02   6.0000000000E-02   1.0900000000E+00   1.2000000000E-01   1.5000000000E-01
+      3        4.0000000000E-02   8.0000000000E-02   1.2000000000E-01   1.1600000000E+00   2.0000000000E-01
+      4        5.0000000000E-02   1.0000000000E-01   1.5000000000E-01   2.0000000000E-01   1.2500000000E+00
+      5        6.0000000000E-02   1.2000000000E-01   1.8000000000E-01   2.4000000000E-01   3.0000000000E-01
+                         5
+      0        6.0000000000E-02
+      1        1.2000000000E-01
+      2        1.8000000000E-01
+      3        2.4000000000E-01
+      4        3.0000000000E-01
+      5        1.3600000000E+00
+
+$vibrational_frequencies
+6
+    0        0.000000
+`
+
+const testTMGradient = `$grad          cartesian gradients
+  cycle =      1    SCF energy =       -1.1661000000   |dE/dxyz| =  0.069282
+    0.00000000000000      0.00000000000000      0.00000000000000      h
+    0.81258000000000      0.81258000000000      0.81258000000000      h
+   0.40000000000000D-01  0.40000000000000D-01  0.40000000000000D-01
+  -0.40000000000000D-01 -0.40000000000000D-01 -0.40000000000000D-01
+  cycle =      2    SCF energy =       -1.1661234568   |dE/dxyz| =  0.024495
+    0.00000000000000      0.00000000000000      0.00000000000000      h
+    0.81258000000000      0.81258000000000      0.81258000000000      h
+   0.10000000000000D-01  0.10000000000000D-01  0.10000000000000D-01
+  -0.10000000000000D-01 -0.10000000000000D-01 -0.10000000000000D-01
+$end
+`
+
+const testTMHessian = `$hessian (projected)
+  1  1   0.0000000000   0.0000000000   0.0000000000   0.0000000000   0.0000000000
+  1  2   0.0000000000
+  2  1   0.0000000000   0.0000000000   0.0000000000   0.0000000000   0.0000000000
+  2  2   0.0000000000
+  3  1   0.0000000000   0.0000000000   0.0000000000   0.0000000000   0.0000000000
+  3  2   0.0000000000
+  4  1   0.0000000000   0.0000000000   0.0000000000   0.0000000000   0.0000000000
+  4  2   0.0000000000
+  5  1   0.0000000000   0.0000000000   0.0000000000   0.0000000000   0.0000000000
+  5  2   0.0000000000
+  6  1   0.0000000000   0.0000000000   0.0000000000   0.0000000000   0.0000000000
+  6  2   0.0000000000
+$hessian
+  1  1   1.0100000000   0.0200000000   0.0300000000   0.0400000000   0.0500000000
+  1  2   0.0600000000
+  2  1   0.0200000000   1.0400000000   0.0600000000   0.0800000000   0.1000000000
+  2  2   0.1200000000
+  3  1   0.0300000000   0.0600000000   1.0900000000   0.1200000000   0.1500000000
+  3  2   0.1800000000
+  4  1   0.0400000000   0.0800000000   0.1200000000   1.1600000000   0.2000000000
+  4  2   0.2400000000
+  5  1   0.0500000000   0.1000000000   0.1500000000   0.2000000000   1.2500000000
+  5  2   0.3000000000
+  6  1   0.0600000000   0.1200000000   0.1800000000   0.2400000000   0.3000000000
+  6  2   1.3600000000
+$end
+`
+
+const testXTBHessian = ` $hessian
+   1.0100000000   0.0200000000   0.0300000000   0.0400000000   0.0500000000
+   0.0600000000
+   0.0200000000   1.0400000000   0.0600000000   0.0800000000   0.1000000000
+   0.1200000000
+   0.0300000000   0.0600000000   1.0900000000   0.1200000000   0.1500000000
+   0.1800000000
+   0.0400000000   0.0800000000   0.1200000000   1.1600000000   0.2000000000
+   0.2400000000
+   0.0500000000   0.1000000000   0.1500000000   0.2000000000   1.2500000000
+   0.3000000000
+   0.0600000000   0.1200000000   0.1800000000   0.2400000000   0.3000000000
+   1.3600000000
+`
+
+const testNWChemOut = `                         DFT ENERGY GRADIENTS
+
+    atom               coordinates                        gradient
+                 x          y          z           x          y          z
+    1 H       0.000000   0.000000   0.000000    0.040000   0.040000   0.040000
+    2 H       0.812580   0.812580   0.812580   -0.040000  -0.040000  -0.040000
+
+                         DFT ENERGY GRADIENTS
+
+    atom               coordinates                        gradient
+                 x          y          z           x          y          z
+    1 H       0.000000   0.000000   0.000000    0.010000   0.010000   0.010000
+    2 H       0.812580   0.812580   0.812580   -0.010000  -0.010000  -0.010000
+
+`
+
+const testNWChemHess = ` 1.01000000000000D+00
+ 2.00000000000000D-02
+ 1.04000000000000D+00
+ 3.00000000000000D-02
+ 6.00000000000000D-02
+ 1.09000000000000D+00
+ 4.00000000000000D-02
+ 8.00000000000000D-02
+ 1.20000000000000D-01
+ 1.16000000000000D+00
+ 5.00000000000000D-02
+ 1.00000000000000D-01
+ 1.50000000000000D-01
+ 2.00000000000000D-01
+ 1.25000000000000D+00
+ 6.00000000000000D-02
+ 1.20000000000000D-01
+ 1.80000000000000D-01
+ 2.40000000000000D-01
+ 3.00000000000000D-01
+ 1.36000000000000D+00
+`
+
+const testMopacAux = ` GRADIENTS:KCAL/MOL/ANGSTROM[0006]=
+     4.00000     4.00000     4.00000    -4.00000    -4.00000    -4.00000
+ GRADIENTS:KCAL/MOL/ANGSTROM[0006]=
+     0.01000     0.01000     0.01000    -0.01000    -0.01000    -0.01000
+ HESSIAN_MATRIX:MILLIDYNES/ANGSTROM[0021]=
+    1.0100    0.0200    1.0400    0.0300    0.0600    1.0900    0.0400    0.0800    0.1200    1.1600
+    0.0500    0.1000    0.1500    0.2000    1.2500    0.0600    0.1200    0.1800    0.2400    0.3000
+    1.3600
+ VIB._FREQ:CM(-1)[0006]=
+`
+
+const testGaussianFchkHess = `H2
+Freq                                                        B3LYP                                                       def2SVP
+Cartesian Gradient                         R   N=           6
+  1.00000000E-02  1.00000000E-02  1.00000000E-02 -1.00000000E-02 -1.00000000E-02
+ -1.00000000E-02
+Cartesian Force Constants                  R   N=          21
+  1.01000000E+00  2.00000000E-02  1.04000000E+00  3.00000000E-02  6.00000000E-02
+  1.09000000E+00  4.00000000E-02  8.00000000E-02  1.20000000E-01  1.16000000E+00
+  5.00000000E-02  1.00000000E-01  1.50000000E-01  2.00000000E-01  1.25000000E+00
+  6.00000000E-02  1.20000000E-01  1.80000000E-01  2.40000000E-01  3.00000000E-01
+  1.36000000E+00
+`
+
+func TestGradientHessianParsers(Te *testing.T) {
+	testHessian := mat.NewDense(6, 6, nil)
+	for i := 0; i < 6; i++ {
+		for j := 0; j < 6; j++ {
+			testHessian.Set(i, j, float64((i+1)*(j+1))/100+float64(v3.KronekerDelta(float64(i), float64(j), 0.1)))
+		}
+	}
+	testGradient, _ := v3.NewMatrix([]float64{0.01, 0.01, 0.01, -0.01, -0.01, -0.01})
+	checkG := func(name string, g *v3.Matrix, err error) {
+		if err != nil {
+			Te.Errorf("%s: %v", name, err)
+			return
+		}
+		if !mat.EqualApprox(g, testGradient, 1e-8) {
+			Te.Errorf("%s: wrong gradient read: %v", name, g)
+		}
+	}
+	checkH := func(name string, h *mat.Dense, err error) {
+		if err != nil {
+			Te.Errorf("%s: %v", name, err)
+			return
+		}
+		if !mat.EqualApprox(h, testHessian, 1e-8) {
+			Te.Errorf("%s: wrong Hessian read:\n%v", name, mat.Formatted(h))
+		}
+	}
+	g, err := orcaEngrad(strings.NewReader(testOrcaEngrad))
+	checkG("ORCA", g, err)
+	h, err := orcaHess(strings.NewReader(testOrcaHess))
+	checkH("ORCA", h, err)
+	g, err = tmGradient(strings.NewReader(testTMGradient))
+	checkG("Turbomole", g, err)
+	h, err = tmHessian(strings.NewReader(testTMHessian))
+	checkH("Turbomole", h, err)
+	h, err = tmHessian(strings.NewReader(testXTBHessian))
+	checkH("xtb", h, err)
+	g, err = nwchemGradient(strings.NewReader(testNWChemOut))
+	checkG("NWChem", g, err)
+	h, err = nwchemHessian(strings.NewReader(testNWChemHess))
+	checkH("NWChem", h, err)
+	gs, err := mopacAuxArray(strings.NewReader(testMopacAux), "GRADIENTS:")
+	g, _ = v3.NewMatrix(gs)
+	checkG("MOPAC", g, err)
+	hs, err := mopacAuxArray(strings.NewReader(testMopacAux), "HESSIAN_MATRIX:")
+	h, _ = packedLowerSym(hs)
+	checkH("MOPAC", h, err)
+	data, err := gaussianReadFchk(strings.NewReader(testGaussianFchkHess))
+	g, _ = v3.NewMatrix(data["Cartesian Gradient"])
+	checkG("Gaussian", g, err)
+	h, err = packedLowerSym(data["Cartesian Force Constants"])
+	checkH("Gaussian", h, err)
+	if _, err := orcaHess(strings.NewReader(testOrcaHess[:strings.Index(testOrcaHess, "                         5")])); err == nil {
+		Te.Errorf("An incomplete ORCA Hessian was not detected")
+	}
+	if _, err := packedLowerSym(make([]float64, 20)); err == nil {
+		Te.Errorf("A wrong number of elements for a triangular matrix was not detected")
+	}
+}
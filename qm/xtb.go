@@ -27,9 +27,11 @@
 package qm
 
 import (
+	"context"
 	//	"bufio"
 	"bufio"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"os/exec"
@@ -39,6 +41,7 @@ import (
 
 	chem "github.com/rmera/gochem"
 	v3 "github.com/rmera/gochem/v3"
+	"gonum.org/v1/gonum/mat"
 )
 
 //XTBHandle represents an xtb calculation
@@ -262,34 +265,42 @@ func (O *XTBHandle) BuildInput(coords *v3.Matrix, atoms chem.AtomMultiCharger, Q
 //Not waiting for results works
 //only for unix-compatible systems, as it uses bash and nohup.
 func (O *XTBHandle) Run(wait bool) (err error) {
-	var com string
-	if O.gfnff {
-		com = fmt.Sprintf(" --gfnff %s.xyz  --input %s.inp  %s > %s.out  2>&1", O.inputname, O.inputname, strings.Join(O.options[2:], " "), O.inputname)
-	} else {
-
-		com = fmt.Sprintf(" %s.xyz  --input %s.inp  %s > %s.out  2>&1", O.inputname, O.inputname, strings.Join(O.options[2:], " "), O.inputname)
-	}
 	if wait == true {
-		//log.Printf(com) //this is stderr, I suppose
-		command := exec.Command("sh", "-c", O.command+com)
-		command.Dir = O.wrkdir
-		err = command.Run()
-
-	} else {
-		command := exec.Command("sh", "-c", "nohup "+O.command+com)
-		command.Dir = O.wrkdir
-		err = command.Start()
+		return O.RunContext(context.Background())
 	}
+	command := exec.Command("sh", "-c", "nohup "+O.command+O.runArgs())
+	command.Dir = O.wrkdir
+	err = command.Start()
 	if err != nil {
-		err = Error{ErrNotRunning, XTB, O.inputname, err.Error(), []string{"exec.Start", "Run"}, true}
+		return Error{ErrNotRunning, XTB, O.inputname, err.Error(), []string{"exec.Start", "Run"}, true}
 	}
+	os.Remove("xtbrestart")
+	return nil
+}
+
+//RunContext runs the command given by the string O.command and waits for it to finish.
+//The program is killed if ctx is done before that. It works only for unix-compatible
+//systems, as it uses sh.
+func (O *XTBHandle) RunContext(ctx context.Context) error {
+	//exec replaces the shell with xtb, so the latter is the process killed when ctx is done.
+	command := exec.CommandContext(ctx, "sh", "-c", "exec "+O.command+O.runArgs())
+	command.Dir = O.wrkdir
+	err := command.Run()
 	if err != nil {
-		return err
+		return Error{ErrNotRunning, XTB, O.inputname, err.Error(), []string{"exec.Run", "RunContext"}, true}
 	}
 	os.Remove("xtbrestart")
 	return nil
 }
 
+//runArgs returns the arguments and redirections for the xtb command line.
+func (O *XTBHandle) runArgs() string {
+	if O.gfnff {
+		return fmt.Sprintf(" --gfnff %s.xyz  --input %s.inp  %s > %s.out  2>&1", O.inputname, O.inputname, strings.Join(O.options[2:], " "), O.inputname)
+	}
+	return fmt.Sprintf(" %s.xyz  --input %s.inp  %s > %s.out  2>&1", O.inputname, O.inputname, strings.Join(O.options[2:], " "), O.inputname)
+}
+
 //OptimizedGeometry returns the latest geometry from an XTB optimization. It doesn't actually need the chem.Atomer
 //but requires it so XTBHandle fits with the QM interface.
 func (O *XTBHandle) OptimizedGeometry(atoms chem.Atomer) (*v3.Matrix, error) {
@@ -382,6 +393,199 @@ func (O *XTBHandle) Energy() (float64, error) {
 	return energy * chem.H2Kcal, err //dummy thin
 }
 
+//Gradient returns the gradient from a previous xtb gradient calculation (i.e. one with the --grad flag
+//which can be given in the Others field of Calc), in kcal/(mol*A).
+//Returns the gradient AND error ("Probable problem in calculation") if the calculation didn't end normally.
+func (O *XTBHandle) Gradient() (*v3.Matrix, error) {
+	inp := O.wrkdir + O.inputname
+	f, err := os.Open(O.wrkdir + "gradient") //As with the optimized geometry, the name of the file is always the same.
+	if err != nil {
+		return nil, Error{ErrNoGradient, XTB, inp, err.Error(), []string{"os.Open", "Gradient"}, true}
+	}
+	defer f.Close()
+	grad, err := tmGradient(f)
+	if err != nil {
+		return nil, Error{ErrNoGradient, XTB, inp, err.Error(), []string{"tmGradient", "Gradient"}, true}
+	}
+	grad.Scale(hartreeBohr2KcalA, grad)
+	if !O.normalTermination() {
+		return grad, Error{ErrProbableProblem, XTB, inp, "", []string{"Gradient"}, false}
+	}
+	return grad, nil
+}
+
+//Hessian returns the Cartesian Hessian from a previous xtb Forces calculation, in kcal/(mol*A^2).
+//Returns the Hessian AND error ("Probable problem in calculation") if the calculation didn't end normally.
+//Note that in a Forces calculation xtb first optimizes the structure, so the Hessian corresponds to the
+//optimized geometry.
+func (O *XTBHandle) Hessian() (*mat.Dense, error) {
+	inp := O.wrkdir + O.inputname
+	f, err := os.Open(O.wrkdir + "hessian")
+	if err != nil {
+		return nil, Error{ErrNoHessian, XTB, inp, err.Error(), []string{"os.Open", "Hessian"}, true}
+	}
+	defer f.Close()
+	hess, err := tmHessian(f)
+	if err != nil {
+		return nil, Error{ErrNoHessian, XTB, inp, err.Error(), []string{"tmHessian", "Hessian"}, true}
+	}
+	hess.Scale(hartreeBohr22KcalA, hess)
+	if !O.normalTermination() {
+		return hess, Error{ErrProbableProblem, XTB, inp, "", []string{"Hessian"}, false}
+	}
+	return hess, nil
+}
+
+//Charges returns the atomic charges from a previous xtb calculation, read from the charges file
+//written by xtb. These are Mulliken charges for the GFN1 and GFN2 methods, and EEQ charges for GFN-FF.
+func (O *XTBHandle) Charges() ([]float64, error) {
+	inp := O.wrkdir + O.inputname
+	f, err := os.Open(O.wrkdir + "charges") //As with the optimized geometry, the name of the file is always the same.
+	if err != nil {
+		return nil, Error{ErrNoCharges, XTB, inp, err.Error(), []string{"os.Open", "Charges"}, true}
+	}
+	defer f.Close()
+	charges := make([]float64, 0, 10)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		c, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return nil, Error{ErrNoCharges, XTB, inp, err.Error(), []string{"strconv.ParseFloat", "Charges"}, true}
+		}
+		charges = append(charges, c)
+	}
+	if err := s.Err(); err != nil {
+		return nil, Error{ErrNoCharges, XTB, inp, err.Error(), []string{"bufio.Scanner.Scan", "Charges"}, true}
+	}
+	if len(charges) == 0 {
+		return nil, Error{ErrNoCharges, XTB, inp, "", []string{"Charges"}, true}
+	}
+	return charges, nil
+}
+
+//SchemeCharges returns the partial charges of the given scheme (Mulliken or, for GFN1, CM5) from
+//a previous xtb calculation.
+func (O *XTBHandle) SchemeCharges(scheme string) ([]float64, error) {
+	inp := O.wrkdir + O.inputname
+	f, err := os.Open(fmt.Sprintf("%s.out", inp))
+	if err != nil {
+		return nil, Error{ErrNoCharges, XTB, inp, err.Error(), []string{"os.Open", "SchemeCharges"}, true}
+	}
+	defer f.Close()
+	all, err := xtbCharges(f)
+	if err != nil {
+		return nil, Error{ErrNoCharges, XTB, inp, err.Error(), []string{"xtbCharges", "SchemeCharges"}, true}
+	}
+	if all[Mulliken] == nil && scheme == Mulliken && !O.gfnff {
+		//GFN2 doesn't print the charges in the output, only in the charges file.
+		return O.Charges()
+	}
+	charges, err := chooseCharges(all, scheme)
+	if err != nil {
+		return nil, Error{ErrNoCharges, XTB, inp, err.Error(), []string{"chooseCharges", "SchemeCharges"}, true}
+	}
+	return charges, nil
+}
+
+//Dipole returns the dipole moment from a previous xtb calculation, in Debye.
+func (O *XTBHandle) Dipole() (*v3.Matrix, error) {
+	inp := O.wrkdir + O.inputname
+	f, err := os.Open(fmt.Sprintf("%s.out", inp))
+	if err != nil {
+		return nil, Error{ErrNoDipole, XTB, inp, err.Error(), []string{"os.Open", "Dipole"}, true}
+	}
+	defer f.Close()
+	d, err := xtbDipole(f)
+	if err == nil {
+		var dipole *v3.Matrix
+		if dipole, err = dipoleMatrix(d, au2Debye); err == nil {
+			return dipole, nil
+		}
+	}
+	return nil, Error{ErrNoDipole, XTB, inp, err.Error(), []string{"xtbDipole", "Dipole"}, true}
+}
+
+//xtbCharges returns the last set of Mulliken and CM5 charges in an xtb output. These are only printed for GFN1.
+func xtbCharges(r io.Reader) (map[string][]float64, error) {
+	ret := make(map[string][]float64)
+	var mulliken, cm5 []float64
+	reading := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "Mulliken/CM5 charges") {
+			reading = true
+			mulliken = make([]float64, 0, 10)
+			cm5 = make([]float64, 0, 10)
+			continue
+		}
+		if !reading {
+			continue
+		}
+		//1O  -0.56520 -0.40915   1.835  4.730  0.000
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			reading = false
+			ret[Mulliken] = mulliken
+			ret[CM5] = cm5
+			continue
+		}
+		m, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		c, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, err
+		}
+		mulliken = append(mulliken, m)
+		cm5 = append(cm5, c)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+//xtbDipole returns the last full dipole moment, in a.u., in an xtb output.
+func xtbDipole(r io.Reader) ([]float64, error) {
+	var dipole []float64
+	reading := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "molecular dipole:") {
+			reading = true
+			continue
+		}
+		if !reading || !strings.HasPrefix(line, "full:") {
+			continue
+		}
+		reading = false
+		//full:        0.000       0.000      -0.890       2.262
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("Malformed dipole line: %s", line)
+		}
+		dipole = make([]float64, 3)
+		for i := range dipole {
+			d, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil {
+				return nil, err
+			}
+			dipole[i] = d
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return dipole, nil
+}
+
 //LargestImaginary returns the absolute value of the wave number (in 1/cm) for the largest imaginary mode in the vibspectrum file
 //produced by a forces calculation with xtb. Returns an error and -1 if unable to check.
 func (O *XTBHandle) LargestImaginary() (float64, error) {
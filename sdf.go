@@ -0,0 +1,546 @@
+/*
+ * sdf.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+/***MDL MOL/SDF part***/
+
+//SDFReader reads the records of an SDF (or MOL) file one by one, so large
+//ligand libraries don't need to be loaded into memory.
+type SDFReader struct {
+	r       *bufio.Reader
+	line    int
+	pending []string //lines read ahead, to be returned by readLine before reading more.
+	name    string
+	props   map[string]string
+}
+
+//NewSDFReader returns an SDFReader that reads from r.
+func NewSDFReader(r io.Reader) *SDFReader {
+	return &SDFReader{r: bufio.NewReader(r)}
+}
+
+//Name returns the name (the first line) of the last record read.
+func (S *SDFReader) Name() string {
+	return S.name
+}
+
+//Properties returns the data items (the "> <NAME>" fields) of the last record read.
+func (S *SDFReader) Properties() map[string]string {
+	return S.props
+}
+
+func (S *SDFReader) readLine() (string, error) {
+	if len(S.pending) > 0 {
+		line := S.pending[0]
+		S.pending = S.pending[1:]
+		S.line++
+		return line, nil
+	}
+	line, err := S.r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	S.line++
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (S *SDFReader) errorf(format string, a ...interface{}) error {
+	return CError{fmt.Sprintf("line %d: ", S.line) + fmt.Sprintf(format, a...), []string{"SDFReader.Next"}}
+}
+
+//Next reads the next record, and returns it as a Molecule with one frame, where the atoms contain
+//the bonds, with their orders, and the formal charges. Aromatic bonds have order 1.5. It returns io.EOF
+//when there are no more records.
+func (S *SDFReader) Next() (*Molecule, error) {
+	var err error
+	//The first line is the title, even if it is empty.
+	S.name, err = S.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(S.name) == "" {
+		//An empty title or just whitespace at the end of the file. We need to look ahead to know.
+		var ahead []string
+		for {
+			line, err := S.readLine()
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			if err != nil {
+				return nil, err
+			}
+			ahead = append(ahead, line)
+			if strings.TrimSpace(line) != "" {
+				break
+			}
+		}
+		S.pending = append(ahead, S.pending...)
+		S.line -= len(ahead)
+	}
+	S.props = make(map[string]string)
+	for i := 0; i < 2; i++ {
+		if _, err := S.readLine(); err != nil {
+			return nil, S.errorf("Truncated header")
+		}
+	}
+	counts, err := S.readLine()
+	if err != nil {
+		return nil, S.errorf("Missing counts line")
+	}
+	var atoms []*Atom
+	var coords *v3.Matrix
+	if strings.Contains(counts, "V3000") {
+		atoms, coords, err = S.readV3000()
+	} else {
+		atoms, coords, err = S.readV2000(counts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := S.readData(); err != nil {
+		return nil, err
+	}
+	top := NewTopology(0, 1, atoms)
+	top.FillIndexes() //needed to walk the bonds.
+	mol, err := NewMolecule([]*v3.Matrix{coords}, top, nil)
+	if err != nil {
+		return nil, errDecorate(err, "SDFReader.Next")
+	}
+	return mol, nil
+}
+
+//sdfAtom returns a new atom with the given symbol and index.
+func sdfAtom(symbol string, i int) *Atom {
+	return &Atom{Symbol: symbol, Name: fmt.Sprintf("%s%d", symbol, i+1), ID: i + 1, Molname: "UNL", MolID: 1, Mass: symbolMass[symbol], Het: true}
+}
+
+//sdfBondOrders maps the MDL bond types to bond orders. The query types are read as order 0.
+var sdfBondOrders = map[int]float64{1: 1, 2: 2, 3: 3, 4: 1.5}
+
+//addBond bonds the atoms i and j in atoms, with the given order, and the distance obtained from coords.
+func addBond(atoms []*Atom, coords *v3.Matrix, i, j, index int, order float64) {
+	t := v3.Zeros(1)
+	t.Sub(coords.VecView(i), coords.VecView(j))
+	b := &Bond{Index: index, At1: atoms[i], At2: atoms[j], Dist: t.Norm(2), Order: order}
+	atoms[i].Bonds = append(atoms[i].Bonds, b)
+	atoms[j].Bonds = append(atoms[j].Bonds, b)
+}
+
+func (S *SDFReader) readV2000(counts string) ([]*Atom, *v3.Matrix, error) {
+	natoms, err1 := strconv.Atoi(pdbCol(counts, 0, 3))
+	nbonds, err2 := strconv.Atoi(pdbCol(counts, 3, 6))
+	if err1 != nil || err2 != nil {
+		return nil, nil, S.errorf("Can't read the counts line: %s", counts)
+	}
+	atoms := make([]*Atom, natoms)
+	coords := v3.Zeros(natoms)
+	//The charges in the atom block are only used if there are no M  CHG lines.
+	blockcharges := make([]float64, natoms)
+	for i := 0; i < natoms; i++ {
+		line, err := S.readLine()
+		if err != nil {
+			return nil, nil, S.errorf("Truncated atom block")
+		}
+		for j := 0; j < 3; j++ {
+			c, err := strconv.ParseFloat(pdbCol(line, j*10, j*10+10), 64)
+			if err != nil {
+				return nil, nil, S.errorf("Can't read coordinates: %s", err.Error())
+			}
+			coords.Set(i, j, c)
+		}
+		atoms[i] = sdfAtom(pdbCol(line, 31, 34), i)
+		if c := pdbInt(line, 36, 39); c != 0 && c != 4 {
+			blockcharges[i] = float64(4 - c)
+		}
+	}
+	for i := 0; i < nbonds; i++ {
+		line, err := S.readLine()
+		if err != nil {
+			return nil, nil, S.errorf("Truncated bond block")
+		}
+		a, b := pdbInt(line, 0, 3)-1, pdbInt(line, 3, 6)-1
+		if a < 0 || b < 0 || a >= natoms || b >= natoms {
+			return nil, nil, S.errorf("Wrong atom in bond: %s", line)
+		}
+		addBond(atoms, coords, a, b, i, sdfBondOrders[pdbInt(line, 6, 9)])
+	}
+	chg := false
+	for {
+		line, err := S.readLine()
+		if err != nil {
+			return nil, nil, S.errorf("Missing M  END line")
+		}
+		if strings.HasPrefix(line, "M  END") {
+			break
+		}
+		if strings.HasPrefix(line, "M  CHG") {
+			chg = true
+			f := strings.Fields(line)
+			for k := 3; k+1 < len(f); k += 2 {
+				at, err1 := strconv.Atoi(f[k])
+				c, err2 := strconv.Atoi(f[k+1])
+				if err1 != nil || err2 != nil || at < 1 || at > natoms {
+					return nil, nil, S.errorf("Wrong M  CHG line: %s", line)
+				}
+				atoms[at-1].Charge = float64(c)
+			}
+		}
+	}
+	if !chg {
+		for i, c := range blockcharges {
+			atoms[i].Charge = c
+		}
+	}
+	return atoms, coords, nil
+}
+
+//readV3000Line reads a "M  V30" line, joining continuation lines, and returns
+//the fields after the "M  V30".
+func (S *SDFReader) readV3000Line() ([]string, error) {
+	var full string
+	for {
+		line, err := S.readLine()
+		if err != nil {
+			return nil, S.errorf("Truncated V3000 block")
+		}
+		if !strings.HasPrefix(line, "M  V30 ") {
+			return nil, S.errorf("Expected a V3000 line, found: %s", line)
+		}
+		line = strings.TrimRight(line[7:], " ")
+		if strings.HasSuffix(line, "-") {
+			full += line[:len(line)-1]
+			continue
+		}
+		return strings.Fields(full + line), nil
+	}
+}
+
+func (S *SDFReader) readV3000() ([]*Atom, *v3.Matrix, error) {
+	var atoms []*Atom
+	var coords *v3.Matrix
+	ids := make(map[int]int) //V3000 atom indexes don't need to be consecutive.
+	nbonds := 0
+	section := ""
+	for {
+		f, err := S.readV3000Line()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(f) == 0 {
+			continue
+		}
+		switch {
+		case f[0] == "BEGIN" && len(f) > 1:
+			section = f[1]
+			continue
+		case f[0] == "END" && len(f) > 1:
+			if f[1] == "CTAB" {
+				line, err := S.readLine()
+				if err != nil || !strings.HasPrefix(line, "M  END") {
+					return nil, nil, S.errorf("Missing M  END line")
+				}
+				if coords == nil {
+					return nil, nil, S.errorf("No COUNTS line found")
+				}
+				return atoms, coords, nil
+			}
+			section = ""
+			continue
+		case f[0] == "COUNTS" && len(f) > 2:
+			natoms, err := strconv.Atoi(f[1])
+			if err != nil {
+				return nil, nil, S.errorf("Wrong COUNTS line")
+			}
+			atoms = make([]*Atom, 0, natoms)
+			coords = v3.Zeros(natoms)
+			continue
+		}
+		switch section {
+		case "ATOM":
+			if len(f) < 5 || coords == nil || len(atoms) >= coords.NVecs() {
+				return nil, nil, S.errorf("Wrong atom line: %v", f)
+			}
+			i := len(atoms)
+			id, _ := strconv.Atoi(f[0])
+			ids[id] = i
+			at := sdfAtom(f[1], i)
+			for j := 0; j < 3; j++ {
+				c, err := strconv.ParseFloat(f[j+2], 64)
+				if err != nil {
+					return nil, nil, S.errorf("Can't read coordinates: %s", err.Error())
+				}
+				coords.Set(i, j, c)
+			}
+			for _, kv := range f[5:] {
+				if strings.HasPrefix(kv, "CHG=") {
+					c, _ := strconv.Atoi(kv[4:])
+					at.Charge = float64(c)
+				}
+			}
+			atoms = append(atoms, at)
+		case "BOND":
+			if len(f) < 4 {
+				return nil, nil, S.errorf("Wrong bond line: %v", f)
+			}
+			t, _ := strconv.Atoi(f[1])
+			a1, _ := strconv.Atoi(f[2])
+			a2, _ := strconv.Atoi(f[3])
+			i, ok1 := ids[a1]
+			j, ok2 := ids[a2]
+			if !ok1 || !ok2 {
+				return nil, nil, S.errorf("Wrong atom in bond: %v", f)
+			}
+			addBond(atoms, coords, i, j, nbonds, sdfBondOrders[t])
+			nbonds++
+		}
+	}
+}
+
+//readData reads the data items of the record, until the $$$$ line or the end of the file.
+func (S *SDFReader) readData() error {
+	var key string
+	var value []string
+	save := func() {
+		if key != "" {
+			S.props[key] = strings.Join(value, "\n")
+		}
+		key, value = "", nil
+	}
+	for {
+		line, err := S.readLine()
+		if err == io.EOF {
+			save()
+			return nil
+		}
+		if err != nil {
+			return CError{err.Error(), []string{"bufio.Reader.ReadString", "SDFReader.readData"}}
+		}
+		switch {
+		case strings.HasPrefix(line, "$$$$"):
+			save()
+			return nil
+		case strings.HasPrefix(line, ">"):
+			save()
+			if a, b := strings.Index(line, "<"), strings.LastIndex(line, ">"); a > 0 && b > a {
+				key = line[a+1 : b]
+			}
+		case strings.TrimSpace(line) == "":
+			if key != "" {
+				save()
+			}
+		default:
+			if key != "" {
+				value = append(value, line)
+			}
+		}
+	}
+}
+
+//SDFRead reads the first record of an SDF or MOL file from r. See SDFReader.Next.
+func SDFRead(r io.Reader) (*Molecule, error) {
+	mol, err := NewSDFReader(r).Next()
+	if err == io.EOF {
+		return nil, CError{"No records found", []string{"SDFRead"}}
+	}
+	return mol, errDecorate(err, "SDFRead")
+}
+
+//SDFFileRead reads the first record of an SDF or MOL file. See SDFReader.Next.
+func SDFFileRead(name string) (*Molecule, error) {
+	f, err := OpenCompressed(name)
+	if err != nil {
+		return nil, errDecorate(err, "SDFFileRead")
+	}
+	defer f.Close()
+	mol, err := SDFRead(f)
+	return mol, errDecorate(err, "SDFFileRead")
+}
+
+//molBonds returns all the bonds among the atoms of mol, sorted by the indexes
+//of their atoms, and a map from each atom to its index. Bonds to atoms not in mol are ignored.
+func molBonds(mol Atomer) ([]*Bond, map[*Atom]int) {
+	index := make(map[*Atom]int, mol.Len())
+	for i := 0; i < mol.Len(); i++ {
+		index[mol.Atom(i)] = i
+	}
+	seen := make(map[*Bond]bool)
+	bonds := make([]*Bond, 0, mol.Len())
+	for i := 0; i < mol.Len(); i++ {
+		for _, b := range mol.Atom(i).Bonds {
+			_, ok1 := index[b.At1]
+			_, ok2 := index[b.At2]
+			if seen[b] || !ok1 || !ok2 {
+				continue
+			}
+			seen[b] = true
+			bonds = append(bonds, b)
+		}
+	}
+	sort.SliceStable(bonds, func(i, j int) bool {
+		a1, a2 := bondIndexes(bonds[i], index)
+		b1, b2 := bondIndexes(bonds[j], index)
+		if a1 != b1 {
+			return a1 < b1
+		}
+		return a2 < b2
+	})
+	return bonds, index
+}
+
+//bondIndexes returns the indexes of the atoms in the bond, the smallest first.
+func bondIndexes(b *Bond, index map[*Atom]int) (int, int) {
+	i, j := index[b.At1], index[b.At2]
+	if i > j {
+		return j, i
+	}
+	return i, j
+}
+
+//sdfBondType returns the MDL bond type for the order.
+func sdfBondType(order float64) int {
+	switch order {
+	case 1, 2, 3:
+		return int(order)
+	case 1.5:
+		return 4
+	}
+	return 8 //"any"
+}
+
+//MOLWrite writes the atoms of mol, with their bonds and formal charges, and the coordinates coords, to out,
+//as an MDL MOL file with the given title. The V2000 format is used, unless there are more than 999 atoms or bonds,
+//in which case V3000 is used. Only integer charges are written.
+func MOLWrite(out io.Writer, coords *v3.Matrix, mol Atomer, title string) error {
+	if coords.NVecs() != mol.Len() {
+		return CError{fmt.Sprintf("%d coordinates for %d atoms", coords.NVecs(), mol.Len()), []string{"MOLWrite"}}
+	}
+	w := bufio.NewWriter(out)
+	bonds, index := molBonds(mol)
+	fmt.Fprintf(w, "%s\n  gochem\n\n", strings.Replace(title, "\n", " ", -1))
+	charge := func(at *Atom) int {
+		if at.Charge == float64(int(at.Charge)) {
+			return int(at.Charge)
+		}
+		return 0
+	}
+	if mol.Len() <= 999 && len(bonds) <= 999 {
+		fmt.Fprintf(w, "%3d%3d  0  0  0  0  0  0  0  0999 V2000\n", mol.Len(), len(bonds))
+		charged := make([]int, 0)
+		for i := 0; i < mol.Len(); i++ {
+			at := mol.Atom(i)
+			fmt.Fprintf(w, "%10.4f%10.4f%10.4f %-3s 0  0  0  0  0  0  0  0  0  0  0  0\n", coords.At(i, 0), coords.At(i, 1), coords.At(i, 2), at.Symbol)
+			if charge(at) != 0 {
+				charged = append(charged, i)
+			}
+		}
+		for _, b := range bonds {
+			i, j := bondIndexes(b, index)
+			fmt.Fprintf(w, "%3d%3d%3d  0\n", i+1, j+1, sdfBondType(b.Order))
+		}
+		for k := 0; k < len(charged); k += 8 {
+			end := minInt(len(charged), k+8)
+			fmt.Fprintf(w, "M  CHG%3d", end-k)
+			for _, i := range charged[k:end] {
+				fmt.Fprintf(w, " %3d %3d", i+1, charge(mol.Atom(i)))
+			}
+			w.WriteString("\n")
+		}
+	} else {
+		fmt.Fprintf(w, "  0  0  0     0  0            999 V3000\n")
+		fmt.Fprintf(w, "M  V30 BEGIN CTAB\nM  V30 COUNTS %d %d 0 0 0\nM  V30 BEGIN ATOM\n", mol.Len(), len(bonds))
+		for i := 0; i < mol.Len(); i++ {
+			at := mol.Atom(i)
+			fmt.Fprintf(w, "M  V30 %d %s %.4f %.4f %.4f 0", i+1, at.Symbol, coords.At(i, 0), coords.At(i, 1), coords.At(i, 2))
+			if c := charge(at); c != 0 {
+				fmt.Fprintf(w, " CHG=%d", c)
+			}
+			w.WriteString("\n")
+		}
+		w.WriteString("M  V30 END ATOM\nM  V30 BEGIN BOND\n")
+		for k, b := range bonds {
+			i, j := bondIndexes(b, index)
+			fmt.Fprintf(w, "M  V30 %d %d %d %d\n", k+1, sdfBondType(b.Order), i+1, j+1)
+		}
+		w.WriteString("M  V30 END BOND\nM  V30 END CTAB\n")
+	}
+	w.WriteString("M  END\n")
+	if err := w.Flush(); err != nil {
+		return CError{"Failed to write in io.Writer: " + err.Error(), []string{"bufio.Writer.Flush", "MOLWrite"}}
+	}
+	return nil
+}
+
+//SDFWrite writes a record of an SDF file to out, with the given title and data items (which can be nil).
+//See MOLWrite. Several records can be written by calling this function many times on the same io.Writer.
+func SDFWrite(out io.Writer, coords *v3.Matrix, mol Atomer, title string, props map[string]string) error {
+	if err := MOLWrite(out, coords, mol, title); err != nil {
+		return errDecorate(err, "SDFWrite")
+	}
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "> <%s>\n%s\n\n", k, props[k])
+	}
+	b.WriteString("$$$$\n")
+	if _, err := out.Write([]byte(b.String())); err != nil {
+		return CError{"Failed to write in io.Writer: " + err.Error(), []string{"io.Writer.Write", "SDFWrite"}}
+	}
+	return nil
+}
+
+//SDFFileWrite writes an SDF file with one record for each set of coordinates in Coords, all with the atoms in mol.
+func SDFFileWrite(name string, Coords []*v3.Matrix, mol Atomer) error {
+	out, err := CreateCompressed(name)
+	if err != nil {
+		return errDecorate(err, "SDFFileWrite")
+	}
+	defer out.Close()
+	for i, c := range Coords {
+		if err := SDFWrite(out, c, mol, fmt.Sprintf("gochem %d", i+1), nil); err != nil {
+			return errDecorate(err, "SDFFileWrite")
+		}
+	}
+	return nil
+}
+
+/***End of MDL MOL/SDF part***/
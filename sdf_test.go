@@ -0,0 +1,238 @@
+/*
+ * sdf_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chem
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+const testSDF = `benzene
+  test
+
+ 12 12  0  0  0  0  0  0  0  0999 V2000
+    1.3900    0.0000    0.0000 C   0  0  0  0  0  0  0  0  0  0  0  0
+    0.6950    1.2038    0.0000 C   0  0  0  0  0  0  0  0  0  0  0  0
+   -0.6950    1.2038    0.0000 C   0  0  0  0  0  0  0  0  0  0  0  0
+   -1.3900    0.0000    0.0000 C   0  0  0  0  0  0  0  0  0  0  0  0
+   -0.6950   -1.2038    0.0000 C   0  0  0  0  0  0  0  0  0  0  0  0
+    0.6950   -1.2038    0.0000 C   0  0  0  0  0  0  0  0  0  0  0  0
+    2.4700    0.0000    0.0000 H   0  0  0  0  0  0  0  0  0  0  0  0
+    1.2350    2.1391    0.0000 H   0  0  0  0  0  0  0  0  0  0  0  0
+   -1.2350    2.1391    0.0000 H   0  0  0  0  0  0  0  0  0  0  0  0
+   -2.4700    0.0000    0.0000 H   0  0  0  0  0  0  0  0  0  0  0  0
+   -1.2350   -2.1391    0.0000 H   0  0  0  0  0  0  0  0  0  0  0  0
+    1.2350   -2.1391    0.0000 H   0  0  0  0  0  0  0  0  0  0  0  0
+  1  2  2  0
+  2  3  1  0
+  3  4  2  0
+  4  5  1  0
+  5  6  2  0
+  6  1  1  0
+  1  7  1  0
+  2  8  1  0
+  3  9  1  0
+  4 10  1  0
+  5 11  1  0
+  6 12  1  0
+M  END
+> <ID>
+BZ1
+
+> <COMMENT>
+two
+lines
+
+$$$$
+ammonium
+  test
+
+  0  0  0     0  0            999 V3000
+M  V30 BEGIN CTAB
+M  V30 COUNTS 5 4 0 0 0
+M  V30 BEGIN ATOM
+M  V30 1 N 0.0000 0.0000 0.0000 0 -
+M  V30 CHG=1
+M  V30 2 H 0.5900 0.5900 0.5900 0
+M  V30 3 H -0.5900 -0.5900 0.5900 0
+M  V30 4 H -0.5900 0.5900 -0.5900 0
+M  V30 5 H 0.5900 -0.5900 -0.5900 0
+M  V30 END ATOM
+M  V30 BEGIN BOND
+M  V30 1 1 1 2
+M  V30 2 1 1 3
+M  V30 3 1 1 4
+M  V30 4 1 1 5
+M  V30 END BOND
+M  V30 END CTAB
+M  END
+$$$$
+hydroxide
+  test
+
+  2  1  0  0  0  0  0  0  0  0999 V2000
+    0.0000    0.0000    0.0000 O   0  5  0  0  0  0  0  0  0  0  0  0
+    0.9600    0.0000    0.0000 H   0  0  0  0  0  0  0  0  0  0  0  0
+  1  2  1  0
+M  END
+$$$$
+`
+
+func TestSDFRead(Te *testing.T) {
+	r := NewSDFReader(strings.NewReader(testSDF))
+	var mols []*Molecule
+	for {
+		mol, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			Te.Fatal(err)
+		}
+		if len(mols) == 0 {
+			if p := r.Properties(); p["ID"] != "BZ1" || p["COMMENT"] != "two\nlines" {
+				Te.Errorf("Wrong properties read: %v", p)
+			}
+		}
+		mols = append(mols, mol)
+	}
+	if len(mols) != 3 {
+		Te.Fatalf("Read %d molecules, expected 3", len(mols))
+	}
+	bz := mols[0]
+	if bz.Len() != 12 || len(bz.Atom(0).Bonds) != 3 || len(bz.Atom(6).Bonds) != 1 {
+		Te.Fatalf("Wrong benzene read")
+	}
+	var order float64
+	for _, b := range bz.Atom(0).Bonds {
+		order += b.Order
+	}
+	if order != 4 {
+		Te.Errorf("Wrong bond orders around the first carbon: %f", order)
+	}
+	if rings := FindRings(bz.Coords[0], bz); len(rings) != 1 {
+		Te.Errorf("Found %d rings in benzene, expected 1", len(rings))
+	}
+	nh4 := mols[1]
+	if nh4.Len() != 5 || nh4.Atom(0).Charge != 1 || len(nh4.Atom(0).Bonds) != 4 || nh4.Coords[0].At(1, 0) != 0.59 {
+		Te.Errorf("Wrong V3000 molecule read: %v", nh4.Atom(0))
+	}
+	if oh := mols[2]; oh.Atom(0).Charge != -1 || oh.Atom(0).Symbol != "O" {
+		Te.Errorf("Wrong charge from the atom block: %v", oh.Atom(0))
+	}
+}
+
+func TestSDFWrite(Te *testing.T) {
+	bz, err := SDFRead(strings.NewReader(testSDF))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	bz.Atom(0).Charge = -1
+	var out bytes.Buffer
+	if err := SDFWrite(&out, bz.Coords[0], bz, "benzene", map[string]string{"ID": "BZ1"}); err != nil {
+		Te.Fatal(err)
+	}
+	//A molecule too large for the V2000 format.
+	atoms := make([]*Atom, 1000)
+	coords := v3.Zeros(len(atoms))
+	for i := range atoms {
+		atoms[i] = &Atom{Symbol: "He"}
+		coords.Set(i, 0, float64(i))
+	}
+	big := NewTopology(0, 1, atoms)
+	addBond(atoms, coords, 0, 1, 0, 2)
+	if err := SDFWrite(&out, coords, big, "helium", nil); err != nil {
+		Te.Fatal(err)
+	}
+	r := NewSDFReader(&out)
+	bz2, err := r.Next()
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if r.Properties()["ID"] != "BZ1" || bz2.Len() != bz.Len() || bz2.Atom(0).Charge != -1 {
+		Te.Errorf("Wrong molecule read back")
+	}
+	bonds, _ := molBonds(bz)
+	bonds2, _ := molBonds(bz2)
+	if len(bonds) != len(bonds2) {
+		Te.Fatalf("Read back %d bonds, expected %d", len(bonds2), len(bonds))
+	}
+	for i := range bonds {
+		if bonds[i].Order != bonds2[i].Order {
+			Te.Errorf("Bond %d changed order in the round-trip", i)
+		}
+	}
+	big2, err := r.Next()
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if big2.Len() != 1000 || len(big2.Atom(0).Bonds) != 1 || big2.Atom(0).Bonds[0].Order != 2 || big2.Coords[0].At(999, 0) != 999 {
+		Te.Errorf("Wrong V3000 molecule read back")
+	}
+	if _, err := r.Next(); err != io.EOF {
+		Te.Errorf("Expected io.EOF after the last molecule, got %v", err)
+	}
+}
+
+func TestSDFEmptyTitle(Te *testing.T) {
+	bz, err := SDFRead(strings.NewReader(testSDF))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := MOLWrite(&out, bz.Coords[0], bz, ""); err != nil {
+		Te.Fatal(err)
+	}
+	bz2, err := SDFRead(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if bz2.Len() != bz.Len() || bz2.Coords[0].At(3, 1) != bz.Coords[0].At(3, 1) {
+		Te.Errorf("Wrong molecule read back from a MOL block without a title")
+	}
+	//Two unnamed records, and whitespace at the end of the file.
+	out.Reset()
+	for i := 0; i < 2; i++ {
+		if err := SDFWrite(&out, bz.Coords[0], bz, "", map[string]string{"ID": "BZ1"}); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	out.WriteString("\n  \n")
+	r := NewSDFReader(&out)
+	for i := 0; i < 2; i++ {
+		mol, err := r.Next()
+		if err != nil {
+			Te.Fatal(err)
+		}
+		if mol.Len() != bz.Len() || r.Name() != "" || r.Properties()["ID"] != "BZ1" {
+			Te.Errorf("Wrong unnamed record %d read", i)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		Te.Errorf("Expected io.EOF after the last molecule, got %v", err)
+	}
+}
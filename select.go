@@ -0,0 +1,668 @@
+/*
+ * select.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rmera/gochem/neighbors"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+/*The selection language is similar to that of VMD and MDAnalysis. A selection is built from:
+
+  all, none
+  protein, backbone, water, hydrogen, hetero
+  name, resname, chain, segid (or segname), type, element (or symbol) followed by one or more words. The shell-like wildcards * and ? can be used.
+  resid, index (0-based), serial (or id, the PDB number), tag followed by one or more integers or ranges (10-50, 10:50 or 10 to 50).
+  resid, index, serial, tag, bfactor (or beta), occupancy, charge, mass, vdw, x, y and z followed by a comparison (<, <=, >, >=, == or !=) and a number.
+  within R of SEL, the atoms at R A or less from any atom in the selection SEL.
+  same residue as SEL and same chain as SEL.
+
+The selections can be combined with and, or, not and parentheses. not, within and same bind as tightly as possible,
+so "within 5 of resname LIG and chain A" means "(within 5 of resname LIG) and chain A".
+*/
+
+//proteinResidues contains the residue names (including common protonation variants) that
+//are considered protein by the selection language.
+var proteinResidues = map[string]bool{
+	"HID": true, "HIE": true, "HIP": true, "HSD": true, "HSE": true, "HSP": true,
+	"CYX": true, "CYM": true, "ASH": true, "GLH": true, "LYN": true, "MSE": true,
+}
+
+var waterResidues = map[string]bool{"SOL": true, "WAT": true, "HOH": true, "TIP3": true, "TIP4": true, "SPC": true, "T3P": true}
+
+func isProteinResidue(name string) bool {
+	_, ok := three2OneLetter[name]
+	return ok || proteinResidues[name]
+}
+
+//selContext contains the data needed to evaluate a selection.
+type selContext struct {
+	mol      Atomer
+	coords   *v3.Matrix
+	bfactors []float64
+	box      *Box
+}
+
+//selNode is a node of the parsed selection. eval returns a slice with
+//one element per atom, true for the selected ones.
+type selNode interface {
+	eval(c *selContext) ([]bool, error)
+}
+
+//Selection is a parsed atom selection, which can be evaluated on many molecules or frames.
+type Selection struct {
+	text string
+	root selNode
+}
+
+//NewSelection parses the selection string sel, and returns the corresponding Selection, or an error
+//if sel is not a valid selection.
+func NewSelection(sel string) (*Selection, error) {
+	p := &selParser{tokens: selTokenize(sel)}
+	if len(p.tokens) == 0 {
+		return nil, CError{"Empty selection", []string{"NewSelection"}}
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, errDecorate(err, "NewSelection")
+	}
+	if p.pos < len(p.tokens) {
+		return nil, CError{fmt.Sprintf("Unexpected '%s' in selection '%s'", p.tokens[p.pos], sel), []string{"NewSelection"}}
+	}
+	return &Selection{text: sel, root: root}, nil
+}
+
+//String returns the selection string from which the Selection was built.
+func (S *Selection) String() string {
+	return S.text
+}
+
+//Eval returns the indexes of the atoms in mol that match the selection. coords is only needed for
+//selections that use positions (within, x, y, z) and bfactors only for selections that use b-factors.
+//Either can be nil otherwise.
+func (S *Selection) Eval(mol Atomer, coords *v3.Matrix, bfactors []float64) ([]int, error) {
+	r, err := S.eval(&selContext{mol: mol, coords: coords, bfactors: bfactors})
+	return r, errDecorate(err, "Eval")
+}
+
+//EvalPBC is like Eval but the distances in "within" selections are minimum image distances in the box box.
+func (S *Selection) EvalPBC(mol Atomer, coords *v3.Matrix, bfactors []float64, box *Box) ([]int, error) {
+	r, err := S.eval(&selContext{mol: mol, coords: coords, bfactors: bfactors, box: box})
+	return r, errDecorate(err, "EvalPBC")
+}
+
+func (S *Selection) eval(c *selContext) ([]int, error) {
+	if c.coords != nil && c.coords.NVecs() != c.mol.Len() {
+		return nil, CError{fmt.Sprintf("%d coordinates given for %d atoms", c.coords.NVecs(), c.mol.Len()), []string{"eval"}}
+	}
+	if c.bfactors != nil && len(c.bfactors) != c.mol.Len() {
+		return nil, CError{fmt.Sprintf("%d b-factors given for %d atoms", len(c.bfactors), c.mol.Len()), []string{"eval"}}
+	}
+	mask, err := S.root.eval(c)
+	if err != nil {
+		return nil, errDecorate(err, "eval")
+	}
+	ret := make([]int, 0, len(mask)/2)
+	for i, v := range mask {
+		if v {
+			ret = append(ret, i)
+		}
+	}
+	return ret, nil
+}
+
+//Select returns the indexes of the atoms in mol that match the selection sel. If mol is a *Molecule
+//and coords is nil, the first frame of mol will be used. For a *Molecule, the b-factors of the
+//first frame are also used, if present. The returned indexes can be used with SomeVecs and SomeAtoms.
+func Select(mol Atomer, coords *v3.Matrix, sel string) ([]int, error) {
+	S, err := NewSelection(sel)
+	if err != nil {
+		return nil, errDecorate(err, "Select")
+	}
+	var bfactors []float64
+	if m, ok := mol.(*Molecule); ok {
+		if coords == nil && len(m.Coords) > 0 {
+			coords = m.Coords[0]
+		}
+		if len(m.Bfactors) > 0 {
+			bfactors = m.Bfactors[0]
+		}
+	}
+	r, err := S.Eval(mol, coords, bfactors)
+	return r, errDecorate(err, "Select")
+}
+
+/**Tokenizer and parser**/
+
+//selTokenize splits the selection in words, parentheses and comparison operators.
+func selTokenize(sel string) []string {
+	tokens := make([]string, 0, 10)
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+	for i := 0; i < len(sel); i++ {
+		c := sel[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+		case c == '(' || c == ')':
+			flush()
+			tokens = append(tokens, string(c))
+		case c == '<' || c == '>' || c == '=' || c == '!':
+			flush()
+			if i+1 < len(sel) && sel[i+1] == '=' {
+				tokens = append(tokens, sel[i:i+2])
+				i++
+			} else {
+				tokens = append(tokens, string(c))
+			}
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type selParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *selParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *selParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *selParser) expect(word string) error {
+	if t := p.next(); t != word {
+		return CError{fmt.Sprintf("Expected '%s', found '%s'", word, t), []string{"expect"}}
+	}
+	return nil
+}
+
+//selReserved contains the words that end a list of values.
+var selReserved = map[string]bool{"and": true, "or": true, "not": true, "(": true, ")": true, "of": true, "as": true}
+
+var selComparisons = map[string]bool{"<": true, "<=": true, ">": true, ">=": true, "==": true, "=": true, "!=": true}
+
+func (p *selParser) parseOr() (selNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &selBinary{left: left, right: right, and: false}
+	}
+	return left, nil
+}
+
+func (p *selParser) parseAnd() (selNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &selBinary{left: left, right: right, and: true}
+	}
+	return left, nil
+}
+
+func (p *selParser) parseUnary() (selNode, error) {
+	switch p.peek() {
+	case "not":
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &selNot{n}, nil
+	case "within":
+		p.next()
+		t := p.next()
+		r, err := strconv.ParseFloat(t, 64)
+		if err != nil || r < 0 {
+			return nil, CError{fmt.Sprintf("Invalid distance in 'within': '%s'", t), []string{"parseUnary"}}
+		}
+		if err := p.expect("of"); err != nil {
+			return nil, errDecorate(err, "parseUnary")
+		}
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &selWithin{r: r, sel: n}, nil
+	case "same":
+		p.next()
+		what := p.next()
+		if what != "residue" && what != "chain" {
+			return nil, CError{fmt.Sprintf("Expected 'residue' or 'chain' after 'same', found '%s'", what), []string{"parseUnary"}}
+		}
+		if err := p.expect("as"); err != nil {
+			return nil, errDecorate(err, "parseUnary")
+		}
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &selSame{chain: what == "chain", sel: n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *selParser) parsePrimary() (selNode, error) {
+	t := p.next()
+	switch t {
+	case "":
+		return nil, CError{"Unexpected end of selection", []string{"parsePrimary"}}
+	case "(":
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, errDecorate(err, "parsePrimary")
+		}
+		return n, nil
+	case "all", "none", "protein", "backbone", "water", "hydrogen", "hetero":
+		return selKeyword(t), nil
+	case "name", "resname", "chain", "segid", "segname", "type", "element", "symbol":
+		vals := p.values()
+		if len(vals) == 0 {
+			return nil, CError{fmt.Sprintf("No values given for '%s'", t), []string{"parsePrimary"}}
+		}
+		for _, v := range vals {
+			if _, err := path.Match(v, ""); err != nil {
+				return nil, CError{fmt.Sprintf("Invalid pattern '%s'", v), []string{"parsePrimary"}}
+			}
+		}
+		return &selString{field: t, patterns: vals}, nil
+	}
+	prop, ok := selProperties[t]
+	if !ok {
+		return nil, CError{fmt.Sprintf("Unknown selection keyword '%s'", t), []string{"parsePrimary"}}
+	}
+	if selComparisons[p.peek()] {
+		op := p.next()
+		num := p.next()
+		val, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return nil, CError{fmt.Sprintf("Invalid number in comparison for '%s': '%s'", t, num), []string{"parsePrimary"}}
+		}
+		return &selCompare{prop: prop, op: op, val: val}, nil
+	}
+	if !prop.integer {
+		return nil, CError{fmt.Sprintf("'%s' requires a comparison", t), []string{"parsePrimary"}}
+	}
+	ranges, err := p.ranges()
+	if err != nil {
+		return nil, errDecorate(err, "parsePrimary")
+	}
+	if len(ranges) == 0 {
+		return nil, CError{fmt.Sprintf("No values given for '%s'", t), []string{"parsePrimary"}}
+	}
+	return &selRange{prop: prop, ranges: ranges}, nil
+}
+
+//values returns the words that follow a keyword, until a reserved word or the end of the selection.
+func (p *selParser) values() []string {
+	vals := make([]string, 0, 3)
+	for t := p.peek(); t != "" && !selReserved[t] && !selComparisons[t]; t = p.peek() {
+		vals = append(vals, p.next())
+	}
+	return vals
+}
+
+var selRangeRe = regexp.MustCompile(`^(-?\d+)[-:](-?\d+)$`)
+
+//ranges parses a list of integers and integer ranges.
+func (p *selParser) ranges() ([][2]int, error) {
+	ret := make([][2]int, 0, 2)
+	vals := p.values()
+	for i := 0; i < len(vals); i++ {
+		v := vals[i]
+		if m := selRangeRe.FindStringSubmatch(v); m != nil {
+			a, _ := strconv.Atoi(m[1])
+			b, _ := strconv.Atoi(m[2])
+			ret = append(ret, [2]int{a, b})
+			continue
+		}
+		a, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, CError{fmt.Sprintf("Invalid integer '%s'", v), []string{"ranges"}}
+		}
+		if i+2 < len(vals) && vals[i+1] == "to" {
+			b, err := strconv.Atoi(vals[i+2])
+			if err != nil {
+				return nil, CError{fmt.Sprintf("Invalid integer '%s'", vals[i+2]), []string{"ranges"}}
+			}
+			ret = append(ret, [2]int{a, b})
+			i += 2
+			continue
+		}
+		ret = append(ret, [2]int{a, a})
+	}
+	return ret, nil
+}
+
+/**Nodes**/
+
+type selBinary struct {
+	left, right selNode
+	and         bool
+}
+
+func (s *selBinary) eval(c *selContext) ([]bool, error) {
+	l, err := s.left.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.right.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	for i := range l {
+		if s.and {
+			l[i] = l[i] && r[i]
+		} else {
+			l[i] = l[i] || r[i]
+		}
+	}
+	return l, nil
+}
+
+type selNot struct {
+	sel selNode
+}
+
+func (s *selNot) eval(c *selContext) ([]bool, error) {
+	m, err := s.sel.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	for i := range m {
+		m[i] = !m[i]
+	}
+	return m, nil
+}
+
+type selKeyword string
+
+func (s selKeyword) eval(c *selContext) ([]bool, error) {
+	m := make([]bool, c.mol.Len())
+	for i := range m {
+		at := c.mol.Atom(i)
+		switch s {
+		case "all":
+			m[i] = true
+		case "protein":
+			m[i] = isProteinResidue(at.Molname)
+		case "backbone":
+			m[i] = isProteinResidue(at.Molname) && isInString([]string{"N", "CA", "C", "O"}, at.Name)
+		case "water":
+			m[i] = waterResidues[at.Molname]
+		case "hydrogen":
+			m[i] = at.Symbol == "H"
+		case "hetero":
+			m[i] = at.Het
+		}
+	}
+	return m, nil
+}
+
+type selString struct {
+	field    string
+	patterns []string
+}
+
+func (s *selString) eval(c *selContext) ([]bool, error) {
+	m := make([]bool, c.mol.Len())
+	for i := range m {
+		at := c.mol.Atom(i)
+		var val string
+		switch s.field {
+		case "name":
+			val = at.Name
+		case "resname":
+			val = at.Molname
+		case "chain":
+			val = at.Chain
+		case "segid", "segname":
+			val = at.Segment
+		case "type":
+			val = at.Type
+		default:
+			val = at.Symbol
+		}
+		for _, p := range s.patterns {
+			if ok, _ := path.Match(p, val); ok {
+				m[i] = true
+				break
+			}
+		}
+	}
+	return m, nil
+}
+
+//selProperty is a numerical property of an atom.
+type selProperty struct {
+	integer bool
+	value   func(c *selContext, i int) (float64, error)
+}
+
+func selCoord(j int) selProperty {
+	return selProperty{false, func(c *selContext, i int) (float64, error) {
+		if c.coords == nil {
+			return 0, CError{"Coordinates are needed for this selection", []string{"selCoord"}}
+		}
+		return c.coords.At(i, j), nil
+	}}
+}
+
+var selProperties = map[string]selProperty{
+	"resid":     {true, func(c *selContext, i int) (float64, error) { return float64(c.mol.Atom(i).MolID), nil }},
+	"index":     {true, func(c *selContext, i int) (float64, error) { return float64(i), nil }},
+	"serial":    {true, func(c *selContext, i int) (float64, error) { return float64(c.mol.Atom(i).ID), nil }},
+	"id":        {true, func(c *selContext, i int) (float64, error) { return float64(c.mol.Atom(i).ID), nil }},
+	"tag":       {true, func(c *selContext, i int) (float64, error) { return float64(c.mol.Atom(i).Tag), nil }},
+	"occupancy": {false, func(c *selContext, i int) (float64, error) { return c.mol.Atom(i).Occupancy, nil }},
+	"charge":    {false, func(c *selContext, i int) (float64, error) { return c.mol.Atom(i).Charge, nil }},
+	"mass":      {false, func(c *selContext, i int) (float64, error) { return c.mol.Atom(i).Mass, nil }},
+	"vdw":       {false, func(c *selContext, i int) (float64, error) { return c.mol.Atom(i).Vdw, nil }},
+	"bfactor":   {false, selBfactor},
+	"beta":      {false, selBfactor},
+	"x":         selCoord(0),
+	"y":         selCoord(1),
+	"z":         selCoord(2),
+}
+
+func selBfactor(c *selContext, i int) (float64, error) {
+	if c.bfactors == nil {
+		return 0, CError{"B-factors are needed for this selection", []string{"selBfactor"}}
+	}
+	return c.bfactors[i], nil
+}
+
+type selCompare struct {
+	prop selProperty
+	op   string
+	val  float64
+}
+
+func (s *selCompare) eval(c *selContext) ([]bool, error) {
+	m := make([]bool, c.mol.Len())
+	for i := range m {
+		v, err := s.prop.value(c, i)
+		if err != nil {
+			return nil, err
+		}
+		switch s.op {
+		case "<":
+			m[i] = v < s.val
+		case "<=":
+			m[i] = v <= s.val
+		case ">":
+			m[i] = v > s.val
+		case ">=":
+			m[i] = v >= s.val
+		case "==", "=":
+			m[i] = v == s.val
+		case "!=":
+			m[i] = v != s.val
+		}
+	}
+	return m, nil
+}
+
+type selRange struct {
+	prop   selProperty
+	ranges [][2]int
+}
+
+func (s *selRange) eval(c *selContext) ([]bool, error) {
+	m := make([]bool, c.mol.Len())
+	for i := range m {
+		v, err := s.prop.value(c, i)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range s.ranges {
+			if int(v) >= r[0] && int(v) <= r[1] {
+				m[i] = true
+				break
+			}
+		}
+	}
+	return m, nil
+}
+
+type selWithin struct {
+	r   float64
+	sel selNode
+}
+
+func (s *selWithin) eval(c *selContext) ([]bool, error) {
+	if c.coords == nil {
+		return nil, CError{"Coordinates are needed for 'within' selections", []string{"selWithin.eval"}}
+	}
+	inner, err := s.sel.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	m := make([]bool, len(inner))
+	ref := make([]int, 0, 10)
+	for i, v := range inner {
+		if v {
+			ref = append(ref, i)
+		}
+	}
+	if len(ref) == 0 {
+		return m, nil
+	}
+	refcoords := v3.Zeros(len(ref))
+	refcoords.SomeVecs(c.coords, ref)
+	var nbox neighbors.Box
+	if c.box != nil {
+		nbox = c.box
+	}
+	grid, err := neighbors.New(refcoords, math.Max(s.r, 0.1), nbox)
+	if err != nil {
+		return nil, errDecorate(err, "selWithin.eval")
+	}
+	for i := range m {
+		if n, _ := grid.Nearest(c.coords.VecView(i), s.r); n >= 0 {
+			m[i] = true
+		}
+	}
+	return m, nil
+}
+
+type selSame struct {
+	chain bool
+	sel   selNode
+}
+
+func (s *selSame) eval(c *selContext) ([]bool, error) {
+	inner, err := s.sel.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	type res struct {
+		id      int
+		chain   string
+		segment string
+	}
+	chains := make(map[string]bool)
+	residues := make(map[res]bool)
+	for i, v := range inner {
+		if v {
+			at := c.mol.Atom(i)
+			chains[at.Chain] = true
+			residues[res{at.MolID, at.Chain, at.Segment}] = true
+		}
+	}
+	m := make([]bool, len(inner))
+	for i := range m {
+		at := c.mol.Atom(i)
+		if s.chain {
+			m[i] = chains[at.Chain]
+		} else {
+			m[i] = residues[res{at.MolID, at.Chain, at.Segment}]
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,98 @@
+/*
+ * select_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package chem
+
+import (
+	"reflect"
+	"testing"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//selTestMol returns a molecule with 2 alanine-like residues in chain A, one in chain B,
+//a ligand and a water. The atoms are placed along the x axis, 2 A apart.
+func selTestMol() *Molecule {
+	ats := make([]*Atom, 0, 20)
+	add := func(name, resname string, resid int, chain string, het bool) {
+		ats = append(ats, &Atom{Name: name, Molname: resname, MolID: resid, Chain: chain, Symbol: name[:1], Het: het, ID: len(ats) + 1})
+	}
+	for _, r := range []struct {
+		id    int
+		chain string
+	}{{10, "A"}, {11, "A"}, {10, "B"}} {
+		for _, n := range []string{"N", "CA", "C", "O", "CB", "HA"} {
+			add(n, "ALA", r.id, r.chain, false)
+		}
+	}
+	add("C1", "LIG", 1, "L", true)
+	add("O1", "LIG", 1, "L", true)
+	add("OW", "HOH", 2, "L", true)
+	coords := v3.Zeros(len(ats))
+	bfac := make([]float64, len(ats))
+	for i := range ats {
+		coords.Set(i, 0, 2*float64(i))
+		bfac[i] = float64(i)
+	}
+	mol, _ := NewMolecule([]*v3.Matrix{coords}, NewTopology(0, 1, ats), [][]float64{bfac})
+	return mol
+}
+
+func TestSelect(Te *testing.T) {
+	mol := selTestMol()
+	cases := []struct {
+		sel      string
+		expected []int
+	}{
+		{"protein and chain A and resid 10-50 and name CA", []int{1, 7}},
+		{"backbone and chain B", []int{12, 13, 14, 15}},
+		{"resname LIG or water", []int{18, 19, 20}},
+		{"hetero and not element O", []int{18}},
+		{"name C*", []int{1, 2, 4, 7, 8, 10, 13, 14, 16, 18}},
+		{"resid 11 and (name N or name HA)", []int{6, 11}},
+		{"within 4 of resname LIG", []int{16, 17, 18, 19, 20}},
+		{"within 4 of resname LIG and not resname LIG", []int{16, 17, 20}},
+		{"same residue as index 3", []int{0, 1, 2, 3, 4, 5}},
+		{"same chain as serial 13", []int{12, 13, 14, 15, 16, 17}},
+		{"bfactor>=19", []int{19, 20}},
+		{"x < 3 or index 5 to 6", []int{0, 1, 5, 6}},
+		{"not all", []int{}},
+	}
+	for _, c := range cases {
+		sel, err := Select(mol, nil, c.sel)
+		if err != nil {
+			Te.Fatalf("Selection '%s': %s", c.sel, err)
+		}
+		if !reflect.DeepEqual(sel, c.expected) {
+			Te.Errorf("Selection '%s': got %v, expected %v", c.sel, sel, c.expected)
+		}
+	}
+	for _, bad := range []string{"", "name", "resid 10 and", "(chain A", "within of chain A", "mass 12", "foo 3", "name CA )"} {
+		if _, err := NewSelection(bad); err == nil {
+			Te.Errorf("Invalid selection '%s' was accepted", bad)
+		}
+	}
+	S, _ := NewSelection("within 3 of index 0")
+	if _, err := S.Eval(mol, nil, nil); err == nil {
+		Te.Errorf("A 'within' selection without coordinates should fail")
+	}
+}
@@ -31,6 +31,7 @@ import (
 
 	//	"sort"
 	//	"strconv"
+	"github.com/rmera/gochem/neighbors"
 	v3 "github.com/rmera/gochem/v3"
 	"gonum.org/v1/gonum/mat"
 )
@@ -109,6 +110,27 @@ func unitRDF(channelin chan *v3.Matrix, channelout chan []float64, mol Atomer, r
 
 //MolRDF calculates the RDF for a trajectory given the indexes of the solute atoms, the solvent molecule name, the step for the "layers" and the cutoff.
 func MolRDF(traj Traj, mol Atomer, refindexes []int, residues []string, step, end float64, frameskip int, com ...bool) ([]float64, []float64, error) {
+	ret, ret2, err := molRDF(traj, nil, mol, refindexes, residues, step, end, frameskip, com...)
+	if err != nil {
+		return nil, nil, errDecorate(err, "MolRDF")
+	}
+	return ret, ret2, nil
+}
+
+//MolRDFPBC is like MolRDF, but it uses minimum image distances, with the periodic box of each frame
+//of the trajectory. It returns an error if a frame has no box. The end of the RDF should not be
+//larger than the MaxCutoff of the boxes in the trajectory.
+func MolRDFPBC(traj BoxTraj, mol Atomer, refindexes []int, residues []string, step, end float64, frameskip int, com ...bool) ([]float64, []float64, error) {
+	ret, ret2, err := molRDF(traj, traj, mol, refindexes, residues, step, end, frameskip, com...)
+	if err != nil {
+		return nil, nil, errDecorate(err, "MolRDFPBC")
+	}
+	return ret, ret2, nil
+}
+
+//molRDF calculates the RDF for the trajectory. If boxes is not nil, minimum image distances
+//are used, with the box of each frame obtained from it.
+func molRDF(traj Traj, boxes BoxTraj, mol Atomer, refindexes []int, residues []string, step, end float64, frameskip int, com ...bool) ([]float64, []float64, error) {
 	var ret []float64
 	coords := v3.Zeros(mol.Len())
 	framesread := 0
@@ -129,14 +151,20 @@ reading:
 			case LastFrameError:
 				break reading
 			case Error:
-				err.Decorate(fmt.Sprintf("MolRDF: Failed while reading the %d th frame", i))
+				err.Decorate(fmt.Sprintf("molRDF: Failed while reading the %d th frame", i))
 				return nil, nil, err
 			default:
 				return nil, nil, err
 
 			}
 		}
-		rdf := FrameUMolCRDF(coords, mol, refindexes, residues, step, end, com...) ///only difference
+		var box *Box
+		if boxes != nil {
+			if box = boxes.Box(); box == nil {
+				return nil, nil, CError{fmt.Sprintf("No periodic box for the %d th frame", i), []string{"molRDF"}}
+			}
+		}
+		rdf := frameUMolCRDF(coords, box, mol, refindexes, residues, step, end, com...) ///only difference
 		if ret == nil {
 			ret = make([]float64, len(rdf))
 		}
@@ -197,6 +225,17 @@ func mdfFromcdf(ret []float64, framesread int, step float64) ([]float64, []float
 
 //FrameUMolCRDF Obtains the Unnormalized "Cummulative Molecular RDF" for one solvated structure. The RDF would be these values averaged over several structures.
 func FrameUMolCRDF(coord *v3.Matrix, mol Atomer, refindexes []int, residues []string, step, end float64, com ...bool) []float64 {
+	return frameUMolCRDF(coord, nil, mol, refindexes, residues, step, end, com...)
+}
+
+//FrameUMolCRDFPBC is like FrameUMolCRDF but it uses minimum image distances in the periodic box box.
+func FrameUMolCRDFPBC(coord *v3.Matrix, box *Box, mol Atomer, refindexes []int, residues []string, step, end float64, com ...bool) []float64 {
+	return frameUMolCRDF(coord, box, mol, refindexes, residues, step, end, com...)
+}
+
+//frameUMolCRDF obtains the unnormalized "cummulative molecular RDF" for one structure, using minimum
+//image distances if box is not nil.
+func frameUMolCRDF(coord *v3.Matrix, box *Box, mol Atomer, refindexes []int, residues []string, step, end float64, com ...bool) []float64 {
 	if step <= 0 {
 		step = 0.1
 	}
@@ -209,7 +248,7 @@ func FrameUMolCRDF(coord *v3.Matrix, mol Atomer, refindexes []int, residues []st
 	//This means that we do more calculation than needed, as every time keep including the solvent that was in previous layers.
 	//	acclen := 0.0
 
-	res := DistRank(coord, mol, refindexes, residues, end, com...)
+	res := distRank(coord, box, mol, refindexes, residues, end, com...)
 	sort.Sort(res)
 	dists := res.Distances()
 	for i := 1; i <= totalsteps; i++ {
@@ -259,7 +298,25 @@ func repeated(id int, chain string, rac []*resAndChain) bool {
 //and any atom from each residue with one of the names given (or the centroid of each residue, if a variadic "com" bool is given)
 //returns a list with ID and distances, which satisfies the sort interface and has several other useful methods.
 func DistRank(coord *v3.Matrix, mol Atomer, refindexes []int, residues []string, cutoff float64, com ...bool) MolDistList {
+	return distRank(coord, nil, mol, refindexes, residues, cutoff, com...)
+}
+
+//DistRankPBC is like DistRank, but it uses minimum image distances in the periodic box box.
+//The residues are made whole before obtaining their centroids, if com is given.
+func DistRankPBC(coord *v3.Matrix, box *Box, mol Atomer, refindexes []int, residues []string, cutoff float64, com ...bool) MolDistList {
+	return distRank(coord, box, mol, refindexes, residues, cutoff, com...)
+}
+
+//distRank is the implementation of DistRank and DistRankPBC. If box is nil, plain
+//distances are used.
+func distRank(coord *v3.Matrix, box *Box, mol Atomer, refindexes []int, residues []string, cutoff float64, com ...bool) MolDistList {
 	ranks := make([]*molDist, 0, 30)
+	if cutoff < 0 {
+		cutoff = 10 //if a negative cutoff is given we use 10 A as the default.
+	}
+	if cutoff == 0 || len(refindexes) == 0 {
+		return MolDistList(ranks) //nothing can be within the cutoff.
+	}
 	//	resIDs := make([]*resAndChain, 0, 30)
 	var molname string
 	var id, molid_skip int //The "skip" variables keep the residue just being read or discarded to avoid reading a residue twice.
@@ -271,12 +328,17 @@ func DistRank(coord *v3.Matrix, mol Atomer, refindexes []int, residues []string,
 	ownresIDs := allResIDandChains(mol, refindexes) //We could call this upstream and just get this numbers, but I suspect
 	ref = v3.Zeros(len(refindexes))
 	ref.SomeVecs(coord, refindexes)
-	if cutoff < 0 {
-		cutoff = 10 //if a negative cutoff is given we use 10 A as the default.
+	//The reference atoms are put in a cell list, so only the nearby ones are
+	//checked for each residue.
+	var nbox neighbors.Box
+	if box != nil {
+		nbox = box
+	}
+	grid, err := neighbors.New(ref, cutoff, nbox)
+	if err != nil {
+		panic(PanicMsg(fmt.Sprintf("distRank: Couldn't build the cell list: %s", err.Error())))
 	}
-	cutoffplus := cutoff + 3
 	//	chunk := NewTopology(0, 1)
-	tmp := v3.Zeros(1)
 	//	fmt.Println("Start looking!") ///////////////////
 	for i := 0; i < mol.Len(); i++ {
 		at := mol.Atom(i)
@@ -284,11 +346,6 @@ func DistRank(coord *v3.Matrix, mol Atomer, refindexes []int, residues []string,
 		id = at.MolID
 		chain = at.Chain
 		var test *v3.Matrix
-		//a little pre-screening for waters
-		if isInString([]string{"SOL", "WAT", "HOH"}, molname) && dist(ref.VecView(0), coord.VecView(i), tmp) > cutoffplus {
-			continue
-
-		}
 		if isInString(residues, molname) && !repeated(id, chain, ownresIDs) && (id != molid_skip || chain != chain_skip) {
 			expectedreslen := 6
 			indexes := make([]int, 1, expectedreslen)
@@ -307,6 +364,9 @@ func DistRank(coord *v3.Matrix, mol Atomer, refindexes []int, residues []string,
 				test = v3.Zeros(len(indexes))
 			}
 			test.SomeVecs(coord, indexes)
+			if box != nil {
+				box.wholeMolecule(test, test)
+			}
 			//This is a bit ugly
 			if len(com) != 0 && com[0] == true {
 				var mass *mat.Dense
@@ -320,11 +380,11 @@ func DistRank(coord *v3.Matrix, mol Atomer, refindexes []int, residues []string,
 				if err != nil { //this really is very unlikely to fail. The ref matrix would have to be wrong. It could even deserve a panic.
 					com[0] = false //if it fails, we don't try again, for consistency. Any previous successful COM use will not comparable with numbers used from now on.
 					log.Println("Couldn't obtain the COM/centroid. Worked with all solvent atoms")
-					distance = MolShortestDist(test, ref) //we don't panic, we just keep going with all atoms
+					distance = gridShortestDist(test, grid, cutoff) //we don't panic, we just keep going with all atoms
 				}
-				distance = MolShortestDist(c, ref)
+				distance = gridShortestDist(c, grid, cutoff)
 			} else {
-				distance = MolShortestDist(test, ref)
+				distance = gridShortestDist(test, grid, cutoff)
 			}
 			if distance <= cutoff {
 				ranks = append(ranks, &molDist{Distance: distance, MolID: id})
@@ -338,6 +398,18 @@ func DistRank(coord *v3.Matrix, mol Atomer, refindexes []int, residues []string,
 
 }
 
+//gridShortestDist returns the shortest distance between the points in test
+//and those in the cell list grid, or +Inf if none is closer than cutoff.
+func gridShortestDist(test *v3.Matrix, grid *neighbors.Grid, cutoff float64) float64 {
+	min := math.Inf(1)
+	for i := 0; i < test.NVecs(); i++ {
+		if n, d := grid.Nearest(test.VecView(i), cutoff); n >= 0 && d < min {
+			min = d
+		}
+	}
+	return min
+}
+
 //A structure for the distance from a residue to a particular point
 type molDist struct {
 	Distance float64
@@ -440,6 +512,16 @@ func (M MolDistList) Merge(list ...MolDistList) {
 //MolShortestDistGiven two sets of coordinates, it obtains the shortest distance from any 2 points
 //in the set. This is probably not a very efficient way to do it.
 func MolShortestDist(test, ref *v3.Matrix) float64 {
+	return molShortestDist(test, ref, nil)
+}
+
+//MolShortestDistPBC is like MolShortestDist, but it uses minimum image distances in the
+//periodic box box.
+func MolShortestDistPBC(test, ref *v3.Matrix, box *Box) float64 {
+	return molShortestDist(test, ref, box)
+}
+
+func molShortestDist(test, ref *v3.Matrix, box *Box) float64 {
 	temp := v3.Zeros(1)
 	var d1, dclosest float64
 	var vt1, vr1 *v3.Matrix // vtclosest,vr1, vrclosest *v3.Matrix
@@ -449,8 +531,7 @@ func MolShortestDist(test, ref *v3.Matrix) float64 {
 		vt1 = test.VecView(i)
 		for j := 0; j < ref.NVecs(); j++ {
 			vr1 = ref.VecView(j)
-			temp.Sub(vr1, vt1)
-			d1 = temp.Norm(2)
+			d1 = pbcDist(vr1, vt1, temp, box)
 			if d1 < dclosest {
 				dclosest = d1
 			}
@@ -463,3 +544,12 @@ func dist(r, t, temp *v3.Matrix) float64 {
 	temp.Sub(r, t)
 	return temp.Norm(2)
 }
+
+//pbcDist returns the minimum image distance between r and t if box is
+//not nil, or the plain distance otherwise.
+func pbcDist(r, t, temp *v3.Matrix, box *Box) float64 {
+	if box == nil {
+		return dist(r, t, temp)
+	}
+	return box.Dist(r, t)
+}
@@ -0,0 +1,341 @@
+/*
+ * trajwrite.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//fileTrajWriter contains what is common to the text trajectory writers. The file is compressed if
+//its extension is that of a compression format, see CreateCompressed.
+type fileTrajWriter struct {
+	filename string
+	mol      Atomer
+	file     io.WriteCloser
+	out      *bufio.Writer
+	frames   int
+	writable bool
+}
+
+func newFileTrajWriter(filename string, mol Atomer, caller string) (*fileTrajWriter, error) {
+	if mol == nil || mol.Len() == 0 {
+		return nil, CError{"A topology with at least one atom is needed", []string{caller}}
+	}
+	f, err := CreateCompressed(filename)
+	if err != nil {
+		return nil, errDecorate(err, caller)
+	}
+	F := &fileTrajWriter{filename: filename, mol: mol, file: f, out: bufio.NewWriter(f), writable: true}
+	runtime.SetFinalizer(F, func(F *fileTrajWriter) {
+		F.file.Close()
+	})
+	return F, nil
+}
+
+//check returns an error if the writer is closed or the coordinates don't match the topology.
+func (F *fileTrajWriter) check(coords *v3.Matrix, caller string) error {
+	if !F.writable {
+		return CError{"The trajectory is not open for writing", []string{caller}}
+	}
+	if coords == nil || coords.NVecs() != F.mol.Len() {
+		return CError{"Ref and Coords dont have the same number of atoms", []string{caller}}
+	}
+	return nil
+}
+
+//flush sends the buffered frame to the file.
+func (F *fileTrajWriter) flush(caller string) error {
+	if err := F.out.Flush(); err != nil {
+		return CError{err.Error(), []string{"bufio.Writer.Flush", caller}}
+	}
+	F.frames++
+	return nil
+}
+
+//close writes footer, if not empty, and closes the file.
+func (F *fileTrajWriter) close(footer, caller string) error {
+	if !F.writable {
+		return nil
+	}
+	F.writable = false
+	if _, err := F.out.WriteString(footer); err != nil {
+		F.file.Close()
+		return CError{err.Error(), []string{"bufio.Writer.WriteString", caller}}
+	}
+	if err := F.out.Flush(); err != nil {
+		F.file.Close()
+		return CError{err.Error(), []string{"bufio.Writer.Flush", caller}}
+	}
+	if err := F.file.Close(); err != nil {
+		return CError{err.Error(), []string{"io.WriteCloser.Close", caller}}
+	}
+	return nil
+}
+
+//PDBTrajWriter writes a multi-model PDB file, one model at a time. It implements BoxTrajWriter.
+type PDBTrajWriter struct {
+	*fileTrajWriter
+}
+
+//NewPDBTrajWriter creates a multi-model PDB file, for frames of the topology mol. The additional records
+//of mol, if it is a *Molecule with records, are written at the beginning of the file, and the CONECT records
+//at the end, when the trajectory is closed.
+func NewPDBTrajWriter(filename string, mol Atomer) (*PDBTrajWriter, error) {
+	F, err := newFileTrajWriter(filename, mol, "NewPDBTrajWriter")
+	if err != nil {
+		return nil, err
+	}
+	F.out.WriteString("REMARK WRITTEN WITH GOCHEM :-)\n")
+	if err := writePDBRecords(F.out, mol); err != nil {
+		F.close("", "NewPDBTrajWriter")
+		return nil, errDecorate(err, "NewPDBTrajWriter")
+	}
+	return &PDBTrajWriter{F}, nil
+}
+
+//WNext writes coords as the next model of the file.
+func (P *PDBTrajWriter) WNext(coords *v3.Matrix) error {
+	return errDecorate(P.WNextBox(coords, nil), "WNext")
+}
+
+//WNextBox writes coords as the next model of the file, with a CRYST1 record for box,
+//unless box is nil.
+func (P *PDBTrajWriter) WNextBox(coords *v3.Matrix, box *Box) error {
+	if err := P.check(coords, "WNextBox"); err != nil {
+		return err
+	}
+	fmt.Fprintf(P.out, "MODEL %d\n", P.frames+1) //The model number starts with one
+	if err := pdbWrite(P.out, coords, P.mol, nil, box, false); err != nil {
+		return errDecorate(err, "WNextBox")
+	}
+	P.out.WriteString("MDL\n")
+	return P.flush("WNextBox")
+}
+
+//Close writes the CONECT records and the end of the file, and closes it.
+func (P *PDBTrajWriter) Close() error {
+	if !P.writable {
+		return nil
+	}
+	var footer strings.Builder
+	if err := writeCONECT(&footer, P.mol); err != nil {
+		P.close("", "Close")
+		return errDecorate(err, "Close")
+	}
+	footer.WriteString("END\n")
+	return P.close(footer.String(), "Close")
+}
+
+//Len returns the number of atoms per frame.
+func (P *PDBTrajWriter) Len() int {
+	return P.mol.Len()
+}
+
+//XYZTrajWriter writes a multi-XYZ file, one frame at a time. Frames with a periodic box, or with properties, are
+//written in the extended XYZ format. It implements BoxTrajWriter.
+type XYZTrajWriter struct {
+	*fileTrajWriter
+}
+
+//NewXYZTrajWriter creates a multi-XYZ file for frames of the topology mol.
+func NewXYZTrajWriter(filename string, mol Atomer) (*XYZTrajWriter, error) {
+	F, err := newFileTrajWriter(filename, mol, "NewXYZTrajWriter")
+	if err != nil {
+		return nil, err
+	}
+	return &XYZTrajWriter{F}, nil
+}
+
+//WNext writes coords as the next frame of the file.
+func (X *XYZTrajWriter) WNext(coords *v3.Matrix) error {
+	return errDecorate(X.WNextProps(coords, nil, nil, nil), "WNext")
+}
+
+//WNextBox writes coords as the next frame of the file, with box, unless it is nil,
+//as the Lattice property in the comment line.
+func (X *XYZTrajWriter) WNextBox(coords *v3.Matrix, box *Box) error {
+	return errDecorate(X.WNextProps(coords, box, nil, nil), "WNextBox")
+}
+
+//WNextProps writes coords as the next frame of the file, with the box, the per-frame
+//properties props and the per-atom arrays atomprops, in the extended XYZ format. If all of them
+//are nil, a regular XYZ frame is written.
+func (X *XYZTrajWriter) WNextProps(coords *v3.Matrix, box *Box, props XYZProps, atomprops map[string]*AtomProp) error {
+	if err := X.check(coords, "WNextProps"); err != nil {
+		return err
+	}
+	var err error
+	if box == nil && props == nil && atomprops == nil {
+		err = xyzWrite(X.out, coords, X.mol, "")
+	} else {
+		err = ExtXYZWrite(X.out, coords, X.mol, box, props, atomprops)
+	}
+	if err != nil {
+		return errDecorate(err, "WNextProps")
+	}
+	return X.flush("WNextProps")
+}
+
+//xyzLattice returns the extended XYZ Lattice property for box, with the 3 cell vectors.
+func xyzLattice(box *Box) string {
+	vals := make([]string, 0, 9)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			vals = append(vals, fmt.Sprintf("%.6f", box.At(i, j)))
+		}
+	}
+	return fmt.Sprintf("Lattice=\"%s\"", strings.Join(vals, " "))
+}
+
+//Close closes the file.
+func (X *XYZTrajWriter) Close() error {
+	return X.close("", "Close")
+}
+
+//Len returns the number of atoms per frame.
+func (X *XYZTrajWriter) Len() int {
+	return X.mol.Len()
+}
+
+//GroTrajWriter writes a multi-frame Gromacs gro file, one frame at a time. It implements BoxTrajWriter.
+type GroTrajWriter struct {
+	*fileTrajWriter
+}
+
+//NewGroTrajWriter creates a multi-frame gro file, for frames of the topology mol.
+func NewGroTrajWriter(filename string, mol Atomer) (*GroTrajWriter, error) {
+	F, err := newFileTrajWriter(filename, mol, "NewGroTrajWriter")
+	if err != nil {
+		return nil, err
+	}
+	return &GroTrajWriter{F}, nil
+}
+
+//WNext writes coords as the next frame of the file, with a box of zeros.
+func (G *GroTrajWriter) WNext(coords *v3.Matrix) error {
+	return errDecorate(G.WNextBox(coords, nil), "WNext")
+}
+
+//WNextBox writes coords as the next frame of the file, with the periodic box box.
+//If box is nil, the box is written as zeros.
+func (G *GroTrajWriter) WNextBox(coords *v3.Matrix, box *Box) error {
+	if err := G.check(coords, "WNextBox"); err != nil {
+		return err
+	}
+	if err := GroSnapBoxWrite(coords, G.mol, box, G.out); err != nil {
+		return errDecorate(err, "WNextBox")
+	}
+	return G.flush("WNextBox")
+}
+
+//Close closes the file.
+func (G *GroTrajWriter) Close() error {
+	return G.close("", "Close")
+}
+
+//Len returns the number of atoms per frame.
+func (G *GroTrajWriter) Len() int {
+	return G.mol.Len()
+}
+
+//ConvertOptions selects the frames and atoms that TrajConvert writes.
+//The zero value selects everything.
+type ConvertOptions struct {
+	First int   //The index of the first frame to write.
+	Skip  int   //The number of frames skipped after each frame written.
+	Max   int   //The maximum number of frames to write. 0 means no limit.
+	Atoms []int //The indexes of the atoms to write, in the order given. nil means all atoms.
+}
+
+//TrajConvert reads the frames in the trajectory in, and writes the ones selected by opts, which can be nil,
+//to out. If opts.Atoms is given, only those atoms are written, so out must have been created for that subset.
+//If in is a BoxTraj and out a BoxTrajWriter, the periodic boxes are also written (so a DCD writer, for instance, needs
+//to have been created with unit cells). out is not closed, so several
+//trajectories can be written to it. Returns the number of frames written.
+func TrajConvert(in Traj, out TrajWriter, opts *ConvertOptions) (int, error) {
+	if opts == nil {
+		opts = &ConvertOptions{}
+	}
+	if opts.First < 0 || opts.Skip < 0 || opts.Max < 0 {
+		return 0, CError{"Negative frame selection", []string{"TrajConvert"}}
+	}
+	natoms := in.Len()
+	for _, v := range opts.Atoms {
+		if v < 0 || v >= natoms {
+			return 0, CError{fmt.Sprintf("Atom %d out of range", v), []string{"TrajConvert"}}
+		}
+	}
+	towrite := v3.Zeros(natoms)
+	frame := towrite
+	if opts.Atoms != nil {
+		towrite = v3.Zeros(len(opts.Atoms))
+	}
+	if l, ok := out.(interface{ Len() int }); ok && l.Len() != towrite.NVecs() {
+		return 0, CError{fmt.Sprintf("The output trajectory has %d atoms per frame, %d given", l.Len(), towrite.NVecs()), []string{"TrajConvert"}}
+	}
+	boxin, _ := in.(BoxTraj)
+	boxout, _ := out.(BoxTrajWriter)
+	written := 0
+	for i := 0; opts.Max == 0 || written < opts.Max; i++ {
+		selected := i >= opts.First && (i-opts.First)%(opts.Skip+1) == 0
+		var err error
+		if selected {
+			err = in.Next(frame)
+		} else {
+			err = in.Next(nil)
+		}
+		if err != nil {
+			if _, ok := err.(LastFrameError); ok {
+				break
+			}
+			return written, errDecorate(err, "TrajConvert")
+		}
+		if !selected {
+			continue
+		}
+		if opts.Atoms != nil {
+			towrite.SomeVecs(frame, opts.Atoms)
+		}
+		if boxin != nil && boxout != nil {
+			err = boxout.WNextBox(towrite, boxin.Box())
+		} else {
+			err = out.WNext(towrite)
+		}
+		if err != nil {
+			return written, errDecorate(err, "TrajConvert")
+		}
+		written++
+	}
+	return written, nil
+}
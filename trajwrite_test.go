@@ -0,0 +1,127 @@
+/*
+ * trajwrite_test.go, part of gochem.
+ *
+ *
+ * Copyright 2021 Raul Mera <rmera{at}chemDOThelsinkiDOTfi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ *
+ * Gochem is developed at the laboratory for instruction in Swedish, Department of Chemistry,
+ * University of Helsinki, Finland.
+ *
+ *
+ */
+
+package chem
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+func TestTrajConvert(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "gochem")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	atoms := []*Atom{
+		{Name: "O", Symbol: "O", Molname: "HOH", MolID: 1, ID: 1},
+		{Name: "H1", Symbol: "H", Molname: "HOH", MolID: 1, ID: 2},
+		{Name: "H2", Symbol: "H", Molname: "HOH", MolID: 1, ID: 3},
+	}
+	nframes := 5
+	var coords []*v3.Matrix
+	var boxes []*Box
+	for f := 0; f < nframes; f++ {
+		c := v3.Zeros(len(atoms))
+		for i := range atoms {
+			c.Set(i, 0, float64(f))
+			c.Set(i, 1, float64(i))
+		}
+		coords = append(coords, c)
+		box, err := NewBoxFromParams(20+float64(f), 20, 20, 90, 90, 90)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		boxes = append(boxes, box)
+	}
+	subset := NewTopology(0, 1, []*Atom{atoms[2], atoms[0]})
+	opts := &ConvertOptions{First: 1, Skip: 1, Atoms: []int{2, 0}}
+	names := []string{"traj.pdb", "traj.gro", "traj.xyz"}
+	for _, name := range names {
+		mol, err := NewMolecule(coords, NewTopology(0, 1, atoms), nil)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		mol.Boxes = boxes
+		name = filepath.Join(dir, name)
+		var w BoxTrajWriter
+		switch filepath.Ext(name) {
+		case ".pdb":
+			w, err = NewPDBTrajWriter(name, subset)
+		case ".gro":
+			w, err = NewGroTrajWriter(name, subset)
+		default:
+			w, err = NewXYZTrajWriter(name, subset)
+		}
+		if err != nil {
+			Te.Fatal(err)
+		}
+		n, err := TrajConvert(mol, w, opts)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			Te.Fatal(err)
+		}
+		if n != 2 {
+			Te.Errorf("%s: wrote %d frames, expected 2", name, n)
+		}
+		var read *Molecule
+		switch filepath.Ext(name) {
+		case ".pdb":
+			read, err = PDBFileRead(name, false)
+		case ".gro":
+			read, err = GroFileRead(name)
+		default:
+			read, err = XYZFileRead(name)
+		}
+		if err != nil {
+			Te.Fatal(err)
+		}
+		if read.Len() != 2 || len(read.Coords) != 2 {
+			Te.Fatalf("%s: read %d atoms and %d frames, expected 2 and 2", name, read.Len(), len(read.Coords))
+		}
+		for i, f := range []int{1, 3} {
+			c := read.Coords[i]
+			if math.Abs(c.At(0, 0)-float64(f)) > 1e-3 || math.Abs(c.At(0, 1)-2) > 1e-3 || math.Abs(c.At(1, 1)) > 1e-3 {
+				Te.Errorf("%s: wrong coordinates in frame %d: %v", name, i, c)
+			}
+			if filepath.Ext(name) == ".xyz" {
+				continue
+			}
+			if b := read.FrameBox(i); b == nil || math.Abs(b.At(0, 0)-20-float64(f)) > 1e-3 {
+				Te.Errorf("%s: wrong box in frame %d: %v", name, i, b)
+			}
+		}
+	}
+}
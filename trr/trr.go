@@ -0,0 +1,534 @@
+/*
+ * trr.go, part of gochem
+ *
+ * Copyright 2021 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+/*
+ *
+ *
+ * Dedicated to the long life of the Ven. Khenpo Phuntzok Tenzin Rinpoche
+ *
+ * ***/
+
+//Package trr implements reading and writing of GROMACS TRR trajectories, which, in addition
+//to the coordinates, can contain velocities, forces and the simulation box for each frame.
+//As in the rest of goChem, coordinates are given in A, velocities in A/ps and forces in kJ/(mol*A).
+package trr
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"runtime"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+const trrMagic = 1993
+
+const trrVersion = "GMX_trn_file"
+
+const nm2A = 10.0
+
+//header contains the information at the begining of each TRR frame.
+type header struct {
+	irSize   int32
+	eSize    int32
+	boxSize  int32
+	virSize  int32
+	presSize int32
+	topSize  int32
+	symSize  int32
+	xSize    int32
+	vSize    int32
+	fSize    int32
+	natoms   int32
+	step     int32
+	nre      int32
+	time     float64
+	lambda   float64
+	double   bool
+}
+
+//frame contains the data of a TRR frame, in GROMACS units.
+//Each slice is nil if the frame doesn't contain that information.
+type frame struct {
+	box []float64
+	x   []float64
+	v   []float64
+	f   []float64
+}
+
+//TRRObj is a container for a GROMACS TRR trajectory file.
+type TRRObj struct {
+	readable   bool
+	natoms     int
+	filename   string
+	file       io.ReadCloser
+	trr        *bufio.Reader
+	header     header
+	buffer     frame  //used by Next
+	last       *frame //the last frame read
+	concBuffer []frame
+}
+
+//New returns a TRRObj from a TRR-formatted trajectory file
+func New(filename string) (*TRRObj, error) {
+	traj := new(TRRObj)
+	if err := traj.initRead(filename); err != nil {
+		return nil, errDecorate(err, "New")
+	}
+	return traj, nil
+}
+
+//The TRR format is registered so chem.OpenTraj can read TRR files.
+func init() {
+	chem.RegisterFormat(&chem.FileFormat{Name: "trr", Extensions: []string{"trr"},
+		Magic: func(header []byte) bool {
+			return len(header) >= 4 && binary.BigEndian.Uint32(header) == trrMagic
+		},
+		Streams: true,
+		OpenTraj: func(name string, top chem.Atomer) (chem.Traj, error) {
+			traj, err := New(name)
+			if err != nil {
+				return nil, err
+			}
+			return traj, nil
+		}})
+}
+
+//Readable returns true if the object is ready to be read from
+//false otherwise. It doesnt guarantee that there is something
+//to read.
+func (T *TRRObj) Readable() bool {
+	return T.readable
+}
+
+//Len returns the number of atoms per frame in the TRRObj.
+//TRRObj must be initialized. 0 means an uninitialized object.
+func (T *TRRObj) Len() int {
+	return T.natoms
+}
+
+//initRead opens the file and reads the number of atoms from the first
+//frame, without consuming it. Compressed files are uncompressed as they are read.
+func (T *TRRObj) initRead(name string) error {
+	var err error
+	T.filename = name
+	T.file, err = chem.OpenCompressed(name)
+	if err != nil {
+		return Error{UnableToOpen + ": " + err.Error(), T.filename, []string{"chem.OpenCompressed", "initRead"}, true}
+	}
+	T.trr = bufio.NewReader(T.file)
+	//The number of atoms is the 11th integer after the 24 bytes
+	//with the magic number and the version string.
+	head, err := T.trr.Peek(24 + 11*4)
+	if err != nil {
+		T.file.Close()
+		return Error{WrongFormat + ": " + err.Error(), T.filename, []string{"initRead"}, true}
+	}
+	if int32(binary.BigEndian.Uint32(head)) != trrMagic {
+		T.file.Close()
+		return Error{WrongFormat + ": Wrong magic number", T.filename, []string{"initRead"}, true}
+	}
+	T.natoms = int(int32(binary.BigEndian.Uint32(head[24+10*4:])))
+	if T.natoms <= 0 {
+		T.file.Close()
+		return Error{WrongFormat + ": No atoms in trajectory", T.filename, []string{"initRead"}, true}
+	}
+	runtime.SetFinalizer(T, func(T *TRRObj) {
+		T.file.Close()
+	})
+	T.readable = true
+	return nil
+}
+
+//noEOF turns EOFs, which are not expected in the middle of a frame, into ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+//readHeader reads the header of a TRR frame into h. It returns io.EOF only if
+//there are no more frames in the file.
+func readHeader(r io.Reader, h *header) error {
+	var start struct {
+		Magic int32
+		Slen  int32
+		Len   int32
+	}
+	if err := binary.Read(r, binary.BigEndian, &start.Magic); err != nil {
+		return err
+	}
+	if start.Magic != trrMagic {
+		return fmt.Errorf("wrong magic number %d", start.Magic)
+	}
+	if err := binary.Read(r, binary.BigEndian, &start.Slen); err != nil {
+		return noEOF(err)
+	}
+	if err := binary.Read(r, binary.BigEndian, &start.Len); err != nil {
+		return noEOF(err)
+	}
+	if start.Slen != int32(len(trrVersion)+1) || start.Len < 0 || start.Len > 128 {
+		return fmt.Errorf("wrong version string")
+	}
+	version := make([]byte, (start.Len+3)/4*4) //XDR strings are padded to 4 bytes.
+	if _, err := io.ReadFull(r, version); err != nil {
+		return noEOF(err)
+	}
+	ints := make([]int32, 13)
+	if err := binary.Read(r, binary.BigEndian, ints); err != nil {
+		return noEOF(err)
+	}
+	h.irSize, h.eSize, h.boxSize, h.virSize, h.presSize = ints[0], ints[1], ints[2], ints[3], ints[4]
+	h.topSize, h.symSize, h.xSize, h.vSize, h.fSize = ints[5], ints[6], ints[7], ints[8], ints[9]
+	h.natoms, h.step, h.nre = ints[10], ints[11], ints[12]
+	//The size of the floating point numbers is not given explicitly,
+	//so we need to obtain it from the size of some of the blocks.
+	var size int32
+	switch {
+	case h.boxSize != 0:
+		size = h.boxSize / 9
+	case h.xSize != 0 && h.natoms != 0:
+		size = h.xSize / (h.natoms * 3)
+	case h.vSize != 0 && h.natoms != 0:
+		size = h.vSize / (h.natoms * 3)
+	case h.fSize != 0 && h.natoms != 0:
+		size = h.fSize / (h.natoms * 3)
+	default:
+		size = 4
+	}
+	if size != 4 && size != 8 {
+		return fmt.Errorf("can't determine the precision of the frame")
+	}
+	h.double = size == 8
+	t, err := readReals(r, 2, h.double, nil)
+	if err != nil {
+		return noEOF(err)
+	}
+	h.time, h.lambda = t[0], t[1]
+	return nil
+}
+
+//readReals reads n floating point numbers, of single or double precision, from r, into
+//buffer, which is allocated if it is nil or too small, and returned.
+func readReals(r io.Reader, n int, double bool, buffer []float64) ([]float64, error) {
+	if len(buffer) < n {
+		buffer = make([]float64, n)
+	}
+	buffer = buffer[:n]
+	size := 4
+	if double {
+		size = 8
+	}
+	raw := make([]byte, n*size)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, err
+	}
+	for i := range buffer {
+		if double {
+			buffer[i] = math.Float64frombits(binary.BigEndian.Uint64(raw[i*8:]))
+		} else {
+			buffer[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(raw[i*4:])))
+		}
+	}
+	return buffer, nil
+}
+
+//readBlock reads a block of the given size in bytes, into buffer, if the size is not zero.
+//It returns the buffer with the read data or nil if the block is not present.
+func readBlock(r io.Reader, size int32, n int, double bool, buffer []float64) ([]float64, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	return readReals(r, n, double, buffer)
+}
+
+//skip discards size bytes from r
+func skip(r io.Reader, size int32) error {
+	_, err := io.CopyN(ioutil.Discard, r, int64(size))
+	return err
+}
+
+//nextRaw reads the next frame into fr, reusing the slices in fr if possible.
+func (T *TRRObj) nextRaw(fr *frame, caller string) error {
+	if !T.readable {
+		return Error{TrajUnIni, T.filename, []string{caller}, true}
+	}
+	err := readHeader(T.trr, &T.header)
+	if err == io.EOF {
+		T.readable = false
+		return newlastFrameError(T.filename, caller) //This is not really an error and should be catched in the calling function
+	}
+	wraperr := func(err error) error {
+		T.readable = false
+		return Error{ReadError + ": " + err.Error(), T.filename, []string{"nextRaw", caller}, true}
+	}
+	if err != nil {
+		return wraperr(err)
+	}
+	h := &T.header
+	if int(h.natoms) != T.natoms {
+		return wraperr(fmt.Errorf("inconsistent number of atoms in frame: %d, expected %d", h.natoms, T.natoms))
+	}
+	//These blocks are not used, at least for now.
+	if err := skip(T.trr, h.irSize+h.eSize); err != nil {
+		return wraperr(noEOF(err))
+	}
+	if fr.box, err = readBlock(T.trr, h.boxSize, 9, h.double, fr.box); err != nil {
+		return wraperr(noEOF(err))
+	}
+	if err := skip(T.trr, h.virSize+h.presSize+h.topSize+h.symSize); err != nil {
+		return wraperr(noEOF(err))
+	}
+	n := T.natoms * 3
+	if fr.x, err = readBlock(T.trr, h.xSize, n, h.double, fr.x); err != nil {
+		return wraperr(noEOF(err))
+	}
+	if fr.v, err = readBlock(T.trr, h.vSize, n, h.double, fr.v); err != nil {
+		return wraperr(noEOF(err))
+	}
+	if fr.f, err = readBlock(T.trr, h.fSize, n, h.double, fr.f); err != nil {
+		return wraperr(noEOF(err))
+	}
+	return nil
+}
+
+//toMatrix puts the data in the slice raw, scaled by factor, in the matrix target.
+func toMatrix(raw []float64, factor float64, target *v3.Matrix) {
+	r, _ := target.Dims()
+	if r*3 < len(raw) {
+		panic("Buffer v3.Matrix too small to hold trajectory frame")
+	}
+	for i, v := range raw {
+		target.Set(i/3, i%3, v*factor)
+	}
+}
+
+//Next Reads the next frame in a TRRObj. If output is not nil, the coordinates of the
+//frame are put there. The velocities, forces, box, step and time of the frame
+//can be later retrieved with the corresponding methods.
+//If the frame contains no coordinates, the output matrix is not modified.
+func (T *TRRObj) Next(output *v3.Matrix) error {
+	if err := T.nextRaw(&T.buffer, "Next"); err != nil {
+		return err
+	}
+	T.last = &T.buffer
+	if output != nil && T.last.x != nil {
+		toMatrix(T.last.x, nm2A, output) //nm to A
+	}
+	return nil
+}
+
+//NextConc takes a slice of matrices and reads as many frames as elements the list has
+//form the trajectory. The frames are discarted if the corresponding element of the slice
+//is nil. The function returns a slice of channels through each of each of which
+//a *v3.Matrix will be transmited.
+//Only the coordinates are returned, the velocities, forces, and the rest of the accessors
+//will refer to the last frame read.
+func (T *TRRObj) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
+	if !T.Readable() {
+		return nil, Error{TrajUnIni, T.filename, []string{"NextConc"}, true}
+	}
+	for len(T.concBuffer) < len(frames) {
+		T.concBuffer = append(T.concBuffer, frame{})
+	}
+	framechans := make([]chan *v3.Matrix, len(frames)) //the slice of chans that will be returned
+	used := false
+	for key, val := range frames {
+		fr := &T.concBuffer[key]
+		err := T.nextRaw(fr, "NextConc")
+		if _, ok := err.(*lastFrameError); ok {
+			if !used {
+				return nil, err
+			}
+			return framechans, err
+		}
+		if err != nil {
+			return nil, err
+		}
+		T.last = fr
+		if val == nil || fr.x == nil {
+			framechans[key] = nil //ignored frame
+			continue
+		}
+		used = true
+		framechans[key] = make(chan *v3.Matrix)
+		go func(raw []float64, keep *v3.Matrix, pipe chan *v3.Matrix) {
+			toMatrix(raw, nm2A, keep)
+			pipe <- keep
+		}(fr.x, val, framechans[key])
+	}
+	return framechans, nil
+}
+
+//matrixOrNil returns a new matrix with the data in raw scaled by factor
+//or nil, if raw is nil.
+func matrixOrNil(raw []float64, factor float64) *v3.Matrix {
+	if len(raw) == 0 {
+		return nil
+	}
+	ret := v3.Zeros(len(raw) / 3)
+	toMatrix(raw, factor, ret)
+	return ret
+}
+
+//lastData returns the last frame read, or an empty frame, if nothing
+//has been read.
+func (T *TRRObj) lastData() *frame {
+	if T.last == nil {
+		return &frame{}
+	}
+	return T.last
+}
+
+//Coords returns the coordinates (in A) of the last frame read, or nil if the frame
+//has no coordinates.
+func (T *TRRObj) Coords() *v3.Matrix {
+	return matrixOrNil(T.lastData().x, nm2A)
+}
+
+//Velocities returns the velocities (in A/ps) of the last frame read, or nil if the frame
+//has no velocities.
+func (T *TRRObj) Velocities() *v3.Matrix {
+	return matrixOrNil(T.lastData().v, nm2A)
+}
+
+//Forces returns the forces (in kJ/(mol*A)) of the last frame read, or nil if the frame
+//has no forces.
+func (T *TRRObj) Forces() *v3.Matrix {
+	return matrixOrNil(T.lastData().f, 1/nm2A)
+}
+
+//Box returns the periodic box of the last frame read, or nil if
+//the frame has no box.
+func (T *TRRObj) Box() *chem.Box {
+	vecs := matrixOrNil(T.lastData().box, nm2A)
+	if vecs == nil {
+		return nil
+	}
+	box, err := chem.NewBox(vecs)
+	if err != nil {
+		return nil //A box of zeros means no box.
+	}
+	return box
+}
+
+//Time returns the simulation time, in ps, of the last frame read.
+func (T *TRRObj) Time() float64 {
+	return T.header.time
+}
+
+//Step returns the simulation step of the last frame read.
+func (T *TRRObj) Step() int {
+	return int(T.header.step)
+}
+
+//Lambda returns the free energy perturbation lambda value of the last frame read.
+func (T *TRRObj) Lambda() float64 {
+	return T.header.lambda
+}
+
+//Errors
+
+//errDecorate is a helper function that asserts that the error is
+//implements chem.Error and decorates the error with the caller's name before returning it.
+//if used with a non-chem.Error error, it will cause a panic.
+func errDecorate(err error, caller string) error {
+	err2 := err.(chem.Error) //I know that is the type returned byt initRead
+	err2.Decorate(caller)
+	return err2
+}
+
+//Error is the general structure for TRR trajectory errors. It fullfills  chem.Error and chem.TrajError
+type Error struct {
+	message  string
+	filename string //the input file that has problems, or empty string if none.
+	deco     []string
+	critical bool
+}
+
+func (err Error) Error() string {
+	return fmt.Sprintf("trr file %s error: %s", err.filename, err.message)
+}
+
+//Decorate Adds new information to the error
+func (E Error) Decorate(deco string) []string {
+	//Even thought this method does not use a pointer as a receiver, and tries to alter the received,
+	//it should work, since E.deco is a slice, and hence a pointer itself.
+	if deco != "" {
+		E.deco = append(E.deco, deco)
+	}
+	return E.deco
+}
+
+//Filename returns the file to which the failing trajectory was associated
+func (err Error) FileName() string { return err.filename }
+
+//Format returns the format of the file (always "trr") associated to the error
+func (err Error) Format() string { return "trr" }
+
+//Critical returns true if the error is critical, false otherwise
+func (err Error) Critical() bool { return err.critical }
+
+const (
+	TrajUnIni    = "Traj object uninitialized to read"
+	ReadError    = "Error reading frame"
+	UnableToOpen = "Unable to open file"
+	WrongFormat  = "Wrong format in the TRR file or frame"
+	EOF          = "EOF"
+)
+
+//lastFrameError implements chem.LastFrameError
+type lastFrameError struct {
+	deco     []string
+	fileName string
+}
+
+//lastFrameError does nothing
+func (E lastFrameError) NormalLastFrameTermination() {}
+
+func (E lastFrameError) FileName() string { return E.fileName }
+
+func (E lastFrameError) Error() string { return "EOF" }
+
+func (E lastFrameError) Critical() bool { return false }
+
+func (E lastFrameError) Format() string { return "trr" }
+
+func (E lastFrameError) Decorate(deco string) []string {
+	//Even thought this method does not use a pointer as a receiver, and tries to alter the received,
+	//it should work, since E.deco is a slice, and hence a pointer itself.
+	if deco != "" {
+		E.deco = append(E.deco, deco)
+	}
+	return E.deco
+}
+
+func newlastFrameError(filename string, caller string) *lastFrameError {
+	e := new(lastFrameError)
+	e.fileName = filename
+	e.deco = []string{caller}
+	return e
+}
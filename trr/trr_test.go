@@ -0,0 +1,212 @@
+/*
+ * trr_test.go, part of gochem
+ *
+ * Copyright 2021 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ */
+
+package trr
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//The writers must be usable with chem.TrajConvert.
+var _ chem.BoxTrajWriter = (*TRRWObj)(nil)
+
+func randMatrix(n int) *v3.Matrix {
+	m := v3.Zeros(n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < 3; j++ {
+			m.Set(i, j, rand.Float64()*30-15)
+		}
+	}
+	return m
+}
+
+func sameMatrix(Te *testing.T, what string, a, b *v3.Matrix, tol float64) {
+	if a == nil || b == nil {
+		if a != b {
+			Te.Fatalf("%s: one matrix is nil and the other isn't", what)
+		}
+		return
+	}
+	r, _ := a.Dims()
+	for i := 0; i < r; i++ {
+		for j := 0; j < 3; j++ {
+			if math.Abs(a.At(i, j)-b.At(i, j)) > tol {
+				Te.Fatalf("%s: element %d %d differs: %f %f", what, i, j, a.At(i, j), b.At(i, j))
+			}
+		}
+	}
+}
+
+//TestTRR writes a TRR trajectory with coordinates, velocities, forces and box
+//and reads it back, checking that everything is recovered.
+func TestTRR(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "gotrr")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "test.trr")
+	natoms := 20
+	w, err := NewWriter(name, natoms)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	type fr struct {
+		x, v, f *v3.Matrix
+		box     *chem.Box
+	}
+	frames := make([]fr, 0, 4)
+	for i := 0; i < 4; i++ {
+		box, err := chem.NewBoxFromParams(30+float64(i), 35, 40, 80, 95, 100)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		f := fr{x: randMatrix(natoms), v: randMatrix(natoms), f: randMatrix(natoms), box: box}
+		if i == 2 {
+			f.v, f.f = nil, nil //a frame with coordinates only.
+		}
+		frames = append(frames, f)
+		if err := w.WNextFull(f.x, f.v, f.f, f.box, i*100, float64(i)*0.2); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if traj.Len() != natoms {
+		Te.Fatalf("Wrong number of atoms: %d", traj.Len())
+	}
+	coords := v3.Zeros(natoms)
+	for i := 0; ; i++ {
+		if err := traj.Next(coords); err != nil {
+			if _, ok := err.(chem.LastFrameError); ok {
+				if i != len(frames) {
+					Te.Fatalf("Read %d frames, wrote %d", i, len(frames))
+				}
+				break
+			}
+			Te.Fatal(err)
+		}
+		tol := 1e-4
+		sameMatrix(Te, "coordinates", coords, frames[i].x, tol)
+		sameMatrix(Te, "velocities", traj.Velocities(), frames[i].v, tol)
+		sameMatrix(Te, "forces", traj.Forces(), frames[i].f, tol)
+		if traj.Box() == nil {
+			Te.Fatalf("No box read in frame %d", i)
+		}
+		sameMatrix(Te, "box", traj.Box().Vecs(), frames[i].box.Vecs(), tol)
+		if traj.Step() != i*100 || math.Abs(traj.Time()-float64(i)*0.2) > 1e-6 {
+			Te.Errorf("Wrong step or time: %d %f", traj.Step(), traj.Time())
+		}
+	}
+}
+
+//TestTRRDouble reads a double-precision TRR file.
+func TestTRRDouble(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "gotrr")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "double.trr")
+	out, err := os.Create(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	x := randMatrix(5)
+	v := randMatrix(5)
+	h := &header{natoms: 5, step: 7, time: 1.5, double: true}
+	if err := writeFrame(out, h, &frame{x: fromMatrix(x, 1/nm2A), v: fromMatrix(v, 1/nm2A)}); err != nil {
+		Te.Fatal(err)
+	}
+	out.Close()
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords := v3.Zeros(5)
+	if err := traj.Next(coords); err != nil {
+		Te.Fatal(err)
+	}
+	sameMatrix(Te, "coordinates", coords, x, 1e-10)
+	sameMatrix(Te, "velocities", traj.Velocities(), v, 1e-10)
+	if traj.Forces() != nil || traj.Box() != nil {
+		Te.Error("Forces or box read from a frame that has none")
+	}
+	if err := traj.Next(coords); err == nil {
+		Te.Error("Read more frames than written")
+	} else if _, ok := err.(chem.LastFrameError); !ok {
+		Te.Error(err)
+	}
+}
+
+//TestTRRCompressed checks that compressed trajectories are read by chem.OpenTraj as they
+//are uncompressed, without a temporary file.
+func TestTRRCompressed(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "gotrr")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "comp.trr.zst")
+	out, err := chem.CreateCompressed(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	frames := []*v3.Matrix{randMatrix(6), randMatrix(6)}
+	for i, x := range frames {
+		h := &header{natoms: 6, step: int32(i)}
+		if err := writeFrame(out, h, &frame{x: fromMatrix(x, 1/nm2A)}); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	out.Close()
+	t, err := chem.OpenTraj(name, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	traj := t.(*TRRObj)
+	if traj.filename != name {
+		Te.Errorf("The trajectory was read from %s, not from the compressed file", traj.filename)
+	}
+	coords := v3.Zeros(6)
+	for i, x := range frames {
+		if err := traj.Next(coords); err != nil {
+			Te.Fatal(err)
+		}
+		sameMatrix(Te, "coordinates", coords, x, 1e-4)
+		if traj.Step() != i {
+			Te.Errorf("Wrong step %d for frame %d", traj.Step(), i)
+		}
+	}
+	if _, ok := traj.Next(coords).(chem.LastFrameError); !ok {
+		Te.Error("Read more frames than written")
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * trr_write.go, part of gochem
+ *
+ * Copyright 2021 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ *
+ */
+
+package trr
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"runtime"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//TRRWObj is a container for a GROMACS TRR trajectory file
+//opened for writing. Frames are always written in single precision.
+type TRRWObj struct {
+	natoms   int
+	writable bool //Is it ready to be written on
+	filename string
+	frames   int
+	trr      *os.File //The TRR file
+}
+
+//NewWriter initializes a TRR trajectory for writing.
+func NewWriter(filename string, natoms int) (*TRRWObj, error) {
+	traj := new(TRRWObj)
+	traj.natoms = natoms
+	traj.filename = filename
+	if natoms <= 0 {
+		return nil, Error{"Trajectory not initialized correctly, the number of atoms must be positive", filename, []string{"NewWriter"}, true}
+	}
+	var err error
+	traj.trr, err = os.Create(filename)
+	if err != nil {
+		return nil, Error{UnableToOpen + ": " + err.Error(), filename, []string{"os.Create", "NewWriter"}, true}
+	}
+	runtime.SetFinalizer(traj, func(T *TRRWObj) {
+		T.trr.Close()
+	})
+	traj.writable = true
+	return traj, nil
+}
+
+//Len returns the number of atoms per frame in the TRRWObj.
+func (T *TRRWObj) Len() int {
+	return T.natoms
+}
+
+//WNext writes the next frame, containing only coordinates, to the trajectory.
+//The frame number is used as step and time (in ps).
+func (T *TRRWObj) WNext(towrite *v3.Matrix) error {
+	err := T.WNextFull(towrite, nil, nil, nil, T.frames, float64(T.frames))
+	if err != nil {
+		return errDecorate(err, "WNext")
+	}
+	return nil
+}
+
+//WNextBox writes the next frame, containing coordinates and the periodic box box,
+//which can be nil, to the trajectory. The frame number is used as step and time (in ps).
+func (T *TRRWObj) WNextBox(towrite *v3.Matrix, box *chem.Box) error {
+	err := T.WNextFull(towrite, nil, nil, box, T.frames, float64(T.frames))
+	if err != nil {
+		return errDecorate(err, "WNextBox")
+	}
+	return nil
+}
+
+//WNextFull writes a frame with coordinates (A), velocities (A/ps), forces (kJ/(mol*A)),
+//and periodic box, for the given step and time (ps). Any of the matrices, and the box, can be nil,
+//in which case, the corresponding information is not written.
+func (T *TRRWObj) WNextFull(coords, vel, forces *v3.Matrix, box *chem.Box, step int, time float64) error {
+	if !T.writable {
+		return Error{TrajUnIni, T.filename, []string{"WNextFull"}, true}
+	}
+	for _, v := range []*v3.Matrix{coords, vel, forces} {
+		if v != nil && v.NVecs() != T.natoms {
+			return Error{"Data doesn't match the trajectory size", T.filename, []string{"WNextFull"}, true}
+		}
+	}
+	var vecs *v3.Matrix
+	if box != nil {
+		vecs = box.Vecs()
+	}
+	h := &header{natoms: int32(T.natoms), step: int32(step), time: time}
+	fr := &frame{
+		box: fromMatrix(vecs, 1/nm2A),
+		x:   fromMatrix(coords, 1/nm2A),
+		v:   fromMatrix(vel, 1/nm2A),
+		f:   fromMatrix(forces, nm2A),
+	}
+	if err := writeFrame(T.trr, h, fr); err != nil {
+		return Error{err.Error(), T.filename, []string{"writeFrame", "WNextFull"}, true}
+	}
+	T.frames++
+	return nil
+}
+
+//Close closes the file associated to the trajectory. After
+//closing, the object can't be written to anymore.
+func (T *TRRWObj) Close() error {
+	if !T.writable {
+		return nil
+	}
+	T.writable = false
+	if err := T.trr.Close(); err != nil {
+		return Error{err.Error(), T.filename, []string{"os.File.Close", "Close"}, true}
+	}
+	return nil
+}
+
+//fromMatrix returns a slice with the data in the matrix m multiplied by factor,
+//or nil if m is nil.
+func fromMatrix(m *v3.Matrix, factor float64) []float64 {
+	if m == nil {
+		return nil
+	}
+	r, _ := m.Dims()
+	ret := make([]float64, 0, r*3)
+	for i := 0; i < r; i++ {
+		for j := 0; j < 3; j++ {
+			ret = append(ret, m.At(i, j)*factor)
+		}
+	}
+	return ret
+}
+
+//writeFrame writes the frame fr, with the header h, to w. The sizes in
+//the header are set according to the data present in fr.
+func writeFrame(w io.Writer, h *header, fr *frame) error {
+	size := int32(4)
+	if h.double {
+		size = 8
+	}
+	h.boxSize = int32(len(fr.box)) * size
+	h.xSize = int32(len(fr.x)) * size
+	h.vSize = int32(len(fr.v)) * size
+	h.fSize = int32(len(fr.f)) * size
+	buf := make([]byte, 0, 100+int(h.boxSize+h.xSize+h.vSize+h.fSize))
+	put := func(v uint32) {
+		buf = append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	putReal := func(f float64) {
+		if !h.double {
+			put(math.Float32bits(float32(f)))
+			return
+		}
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(f))
+		buf = append(buf, b...)
+	}
+	put(trrMagic)
+	put(uint32(len(trrVersion) + 1))
+	put(uint32(len(trrVersion)))
+	buf = append(buf, []byte(trrVersion)...) //12 bytes, so no padding is needed.
+	ints := []int32{h.irSize, h.eSize, h.boxSize, h.virSize, h.presSize, h.topSize, h.symSize,
+		h.xSize, h.vSize, h.fSize, h.natoms, h.step, h.nre}
+	for _, v := range ints {
+		put(uint32(v))
+	}
+	putReal(h.time)
+	putReal(h.lambda)
+	for _, block := range [][]float64{fr.box, fr.x, fr.v, fr.f} {
+		for _, v := range block {
+			putReal(v)
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,690 @@
+/*
+ * xdr.go, part of gochem
+ *
+ * Copyright 2012 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+/*
+ *
+ *
+ * Dedicated to the long life of the Ven. Khenpo Phuntzok Tenzin Rinpoche
+ *
+ * ***/
+
+package xtc
+
+//This file contains a Go implementation of the compression algorithm used by GROMACS
+//for XTC files (xdr3dfcoord). It follows closely the one in the xdrfile library
+//(http://www.gromacs.org), so the produced files should be identical to those
+//produced by GROMACS itself.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const xtcMagic = 1995
+
+//The size in bytes of the header of a frame, including the box.
+const headerSize = 52
+
+const firstIdx = 9
+
+var magicInts = []uint32{
+	0, 0, 0, 0, 0, 0, 0, 0, 0,
+	8, 10, 12, 16, 20, 25, 32, 40, 50, 64,
+	80, 101, 128, 161, 203, 256, 322, 406, 512, 645,
+	812, 1024, 1290, 1625, 2048, 2580, 3250, 4096, 5060, 6501,
+	8192, 10321, 13003, 16384, 20642, 26007, 32768, 41285, 52015, 65536,
+	82570, 104031, 131072, 165140, 208063, 262144, 330280, 416127, 524287, 660561,
+	832255, 1048576, 1321122, 1664510, 2097152, 2642245, 3329021, 4194304, 5284491, 6658042,
+	8388607, 10568983, 13316085, 16777216}
+
+var lastIdx = len(magicInts)
+
+//maxAbs is the largest absolute value an scaled coordinate can have
+//without overflowing a 32-bit integer.
+const maxAbs = float32(math.MaxInt32 - 2)
+
+//frameHeader contains the non-coordinate data of an XTC frame.
+type frameHeader struct {
+	natoms int
+	step   int
+	time   float32
+	box    [9]float32 //in nm, row-major
+}
+
+//bitReader reads integers of arbitrary bit-length from a byte slice, most significant bit first.
+type bitReader struct {
+	data     []byte
+	count    int
+	lastbits uint
+	lastbyte uint32
+}
+
+func (b *bitReader) next() uint32 {
+	if b.count >= len(b.data) {
+		//The stream should never be read past its end, but if the file is
+		//corrupted we just feed zeros instead of panicking.
+		b.count++
+		return 0
+	}
+	r := uint32(b.data[b.count])
+	b.count++
+	return r
+}
+
+//receiveBits reads nbits (at most 32) bits from the stream.
+func (b *bitReader) receiveBits(nbits uint) uint32 {
+	mask := uint32((uint64(1) << nbits) - 1)
+	var num uint32
+	for nbits >= 8 {
+		b.lastbyte = (b.lastbyte << 8) | b.next()
+		num |= (b.lastbyte >> b.lastbits) << (nbits - 8)
+		nbits -= 8
+	}
+	if nbits > 0 {
+		if b.lastbits < nbits {
+			b.lastbits += 8
+			b.lastbyte = (b.lastbyte << 8) | b.next()
+		}
+		b.lastbits -= nbits
+		num |= (b.lastbyte >> b.lastbits) & ((1 << nbits) - 1)
+	}
+	return num & mask
+}
+
+//receiveInts reads 3 integers packed in nbits bits, each of them smaller than the corresponding
+//element of sizes.
+func (b *bitReader) receiveInts(nbits int, sizes [3]uint32, nums []int) {
+	var bytes [32]uint32
+	nbytes := 0
+	for nbits > 8 {
+		bytes[nbytes] = b.receiveBits(8)
+		nbytes++
+		nbits -= 8
+	}
+	if nbits > 0 {
+		bytes[nbytes] = b.receiveBits(uint(nbits))
+		nbytes++
+	}
+	for i := 2; i > 0; i-- {
+		var num uint64
+		for j := nbytes - 1; j >= 0; j-- {
+			num = (num << 8) | uint64(bytes[j])
+			p := num / uint64(sizes[i])
+			bytes[j] = uint32(p)
+			num = num - p*uint64(sizes[i])
+		}
+		nums[i] = int(num)
+	}
+	nums[0] = int(int32(bytes[0] | (bytes[1] << 8) | (bytes[2] << 16) | (bytes[3] << 24)))
+}
+
+//bitWriter is the writing counterpart of bitReader.
+type bitWriter struct {
+	data     []byte
+	lastbits uint
+	lastbyte uint32
+}
+
+//sendBits writes the nbits least significant bits of num to the stream.
+func (b *bitWriter) sendBits(nbits uint, num uint32) {
+	for nbits >= 8 {
+		var chunk uint32
+		if nbits-8 < 32 {
+			chunk = num >> (nbits - 8)
+		}
+		b.lastbyte = (b.lastbyte << 8) | chunk
+		b.data = append(b.data, byte(b.lastbyte>>b.lastbits))
+		nbits -= 8
+	}
+	if nbits > 0 {
+		b.lastbyte = (b.lastbyte << nbits) | num
+		b.lastbits += nbits
+		if b.lastbits >= 8 {
+			b.lastbits -= 8
+			b.data = append(b.data, byte(b.lastbyte>>b.lastbits))
+		}
+	}
+}
+
+//bytes returns the written data, including the last, incomplete byte, if any.
+func (b *bitWriter) bytes() []byte {
+	if b.lastbits > 0 {
+		return append(b.data, byte(b.lastbyte<<(8-b.lastbits)))
+	}
+	return b.data
+}
+
+//sendInts writes 3 integers in nbits bits. Each integer must be smaller than the
+//corresponding element of sizes.
+func (b *bitWriter) sendInts(nbits int, sizes [3]uint32, nums []uint32) {
+	var bytes [32]uint32
+	nbytes := 0
+	tmp := nums[0]
+	for {
+		bytes[nbytes] = tmp & 0xff
+		nbytes++
+		tmp >>= 8
+		if tmp == 0 {
+			break
+		}
+	}
+	for i := 1; i < 3; i++ {
+		t := uint64(nums[i])
+		var bytecnt int
+		for bytecnt = 0; bytecnt < nbytes; bytecnt++ {
+			t = uint64(bytes[bytecnt])*uint64(sizes[i]) + t
+			bytes[bytecnt] = uint32(t & 0xff)
+			t >>= 8
+		}
+		for t != 0 {
+			bytes[bytecnt] = uint32(t & 0xff)
+			bytecnt++
+			t >>= 8
+		}
+		nbytes = bytecnt
+	}
+	if nbits >= nbytes*8 {
+		for i := 0; i < nbytes; i++ {
+			b.sendBits(8, bytes[i])
+		}
+		b.sendBits(uint(nbits-nbytes*8), 0)
+		return
+	}
+	for i := 0; i < nbytes-1; i++ {
+		b.sendBits(8, bytes[i])
+	}
+	b.sendBits(uint(nbits-(nbytes-1)*8), bytes[nbytes-1])
+}
+
+//sizeOfInt returns the number of bits needed to store size.
+func sizeOfInt(size uint32) uint {
+	var num uint64 = 1
+	var nbits uint
+	for uint64(size) >= num && nbits < 32 {
+		nbits++
+		num <<= 1
+	}
+	return nbits
+}
+
+//sizeOfInts returns the number of bits needed to store 3 integers, each smaller than
+//the corresponding element of sizes.
+func sizeOfInts(sizes [3]uint32) int {
+	var bytes [32]uint32
+	nbytes := 1
+	bytes[0] = 1
+	nbits := 0
+	for i := 0; i < 3; i++ {
+		var tmp uint64
+		var bytecnt int
+		for bytecnt = 0; bytecnt < nbytes; bytecnt++ {
+			tmp = uint64(bytes[bytecnt])*uint64(sizes[i]) + tmp
+			bytes[bytecnt] = uint32(tmp & 0xff)
+			tmp >>= 8
+		}
+		for tmp != 0 {
+			bytes[bytecnt] = uint32(tmp & 0xff)
+			bytecnt++
+			tmp >>= 8
+		}
+		nbytes = bytecnt
+	}
+	var num uint32 = 1
+	nbytes--
+	for bytes[nbytes] >= num {
+		nbits++
+		num *= 2
+	}
+	return nbits + nbytes*8
+}
+
+func iabs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+//sizeInfo returns the sizes of the ranges for the 3 coordinates and
+//the number of bits needed to store them. If the ranges are too large, the bits
+//for each coordinate are returned in bitsizeint and bitsize is 0.
+func sizeInfo(minint, maxint [3]int) (sizeint [3]uint32, bitsizeint [3]uint, bitsize int) {
+	for i := 0; i < 3; i++ {
+		sizeint[i] = uint32(maxint[i] - minint[i] + 1)
+	}
+	if (sizeint[0] | sizeint[1] | sizeint[2]) > 0xffffff {
+		for i := 0; i < 3; i++ {
+			bitsizeint[i] = sizeOfInt(sizeint[i])
+		}
+		return sizeint, bitsizeint, 0
+	}
+	return sizeint, bitsizeint, sizeOfInts(sizeint)
+}
+
+//readXDRInt32 reads a big-endian int32 (as XDR requires).
+func readXDRInt32(r io.Reader) (int32, error) {
+	var i int32
+	err := binary.Read(r, binary.BigEndian, &i)
+	return i, err
+}
+
+//readHeader reads the header of an XTC frame, including the box.
+func readHeader(r io.Reader, h *frameHeader) error {
+	magic, err := readXDRInt32(r)
+	if err != nil {
+		return err //an io.EOF here is expected after the last frame.
+	}
+	if magic != xtcMagic {
+		return fmt.Errorf("wrong magic number %d", magic)
+	}
+	var head struct {
+		Natoms int32
+		Step   int32
+		Time   float32
+		Box    [9]float32
+	}
+	if err := binary.Read(r, binary.BigEndian, &head); err != nil {
+		return noEOF(err)
+	}
+	h.natoms = int(head.Natoms)
+	h.step = int(head.Step)
+	h.time = head.Time
+	h.box = head.Box
+	return nil
+}
+
+//noEOF turns EOFs, which are not expected in the middle of a frame, into ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+//readCoords reads the (possibly compressed) coordinates of a frame into
+//coords, which must have place for at least natoms*3 elements. If coords is nil
+//the coordinates are read but not decompressed. It returns the precision of the frame
+//and the number of bytes read.
+func readCoords(r io.Reader, natoms int, coords []float32) (float32, int64, error) {
+	lsize, err := readXDRInt32(r)
+	if err != nil {
+		return 0, 0, noEOF(err)
+	}
+	if int(lsize) != natoms {
+		return 0, 0, fmt.Errorf("inconsistent number of atoms in frame: %d, expected %d", lsize, natoms)
+	}
+	if natoms <= 9 {
+		buf := make([]float32, natoms*3)
+		if err := binary.Read(r, binary.BigEndian, buf); err != nil {
+			return 0, 0, noEOF(err)
+		}
+		if coords != nil {
+			copy(coords, buf)
+		}
+		return -1, 4 + int64(natoms*12), nil
+	}
+	var head struct {
+		Precision float32
+		Minint    [3]int32
+		Maxint    [3]int32
+		Smallidx  int32
+		Nbytes    int32
+	}
+	if err := binary.Read(r, binary.BigEndian, &head); err != nil {
+		return 0, 0, noEOF(err)
+	}
+	if head.Nbytes < 0 || head.Smallidx < firstIdx || int(head.Smallidx) >= lastIdx {
+		return 0, 0, fmt.Errorf("corrupted compressed frame")
+	}
+	//XDR opaque data is padded to 4 bytes.
+	padded := (int(head.Nbytes) + 3) / 4 * 4
+	data := make([]byte, padded)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, 0, noEOF(err)
+	}
+	read := int64(4 + 36 + padded)
+	if coords == nil {
+		return head.Precision, read, nil
+	}
+	if len(coords) < natoms*3 {
+		return 0, 0, fmt.Errorf("buffer too small for frame")
+	}
+	var minint, maxint [3]int
+	for i := 0; i < 3; i++ {
+		minint[i] = int(head.Minint[i])
+		maxint[i] = int(head.Maxint[i])
+	}
+	decompress(data[:head.Nbytes], natoms, head.Precision, minint, maxint, int(head.Smallidx), coords)
+	return head.Precision, read, nil
+}
+
+//frameSize returns the size in bytes of the frame starting at the given offset
+//in r. It returns io.EOF if there is no frame at offset.
+func frameSize(r io.ReaderAt, offset int64) (int64, error) {
+	buf := make([]byte, headerSize+40)
+	n, err := r.ReadAt(buf, offset)
+	if n == 0 && err == io.EOF {
+		return 0, io.EOF
+	}
+	if n < headerSize+4 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int32(binary.BigEndian.Uint32(buf)) != xtcMagic {
+		return 0, fmt.Errorf("wrong magic number")
+	}
+	natoms := int64(int32(binary.BigEndian.Uint32(buf[4:])))
+	if natoms <= 9 {
+		return headerSize + 4 + natoms*12, nil
+	}
+	if n < len(buf) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	nbytes := int64(int32(binary.BigEndian.Uint32(buf[headerSize+36:])))
+	return headerSize + 40 + (nbytes+3)/4*4, nil
+}
+
+//decompress decodes the natoms compressed coordinates in data into coords.
+func decompress(data []byte, natoms int, precision float32, minint, maxint [3]int, smallidx int, coords []float32) {
+	sizeint, bitsizeint, bitsize := sizeInfo(minint, maxint)
+	tmp := smallidx - 1
+	if tmp < firstIdx {
+		tmp = firstIdx
+	}
+	smaller := int(magicInts[tmp] / 2)
+	smallnum := int(magicInts[smallidx] / 2)
+	sizesmall := [3]uint32{magicInts[smallidx], magicInts[smallidx], magicInts[smallidx]}
+	invPrecision := float32(1.0 / float64(precision))
+	b := &bitReader{data: data}
+	thiscoord := make([]int, 3)
+	prevcoord := make([]int, 3)
+	run := 0
+	i := 0
+	out := 0
+	write := func(c []int) {
+		coords[out] = float32(c[0]) * invPrecision
+		coords[out+1] = float32(c[1]) * invPrecision
+		coords[out+2] = float32(c[2]) * invPrecision
+		out += 3
+	}
+	for i < natoms {
+		if bitsize == 0 {
+			for j := 0; j < 3; j++ {
+				thiscoord[j] = int(int32(b.receiveBits(bitsizeint[j])))
+			}
+		} else {
+			b.receiveInts(bitsize, sizeint, thiscoord)
+		}
+		i++
+		for j := 0; j < 3; j++ {
+			thiscoord[j] += minint[j]
+			prevcoord[j] = thiscoord[j]
+		}
+		flag := b.receiveBits(1)
+		isSmaller := 0
+		if flag == 1 {
+			run = int(b.receiveBits(5))
+			isSmaller = run % 3
+			run -= isSmaller
+			isSmaller--
+		}
+		if run > 0 {
+			for k := 0; k < run; k += 3 {
+				if i >= natoms {
+					break //corrupted data, we don't want to write out of bounds.
+				}
+				b.receiveInts(smallidx, sizesmall, thiscoord)
+				i++
+				for j := 0; j < 3; j++ {
+					thiscoord[j] += prevcoord[j] - smallnum
+				}
+				if k == 0 {
+					//The first and second atoms are interchanged for better
+					//compression of water molecules, so we revert that.
+					thiscoord, prevcoord = prevcoord, thiscoord
+					write(prevcoord)
+				} else {
+					copy(prevcoord, thiscoord)
+				}
+				write(thiscoord)
+			}
+		} else {
+			write(thiscoord)
+		}
+		smallidx += isSmaller
+		if smallidx < firstIdx || smallidx >= lastIdx {
+			return //corrupted data
+		}
+		if isSmaller < 0 {
+			smallnum = smaller
+			if smallidx > firstIdx {
+				smaller = int(magicInts[smallidx-1] / 2)
+			} else {
+				smaller = 0
+			}
+		} else if isSmaller > 0 {
+			smaller = smallnum
+			smallnum = int(magicInts[smallidx] / 2)
+		}
+		sizesmall[0], sizesmall[1], sizesmall[2] = magicInts[smallidx], magicInts[smallidx], magicInts[smallidx]
+	}
+}
+
+//writeFrame writes a complete XTC frame, with coordinates in nm, to w.
+func writeFrame(w io.Writer, h *frameHeader, coords []float32, precision float32) error {
+	buf := make([]byte, 0, 64+len(coords)*4)
+	put := func(v uint32) {
+		buf = append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	putf := func(f float32) { put(math.Float32bits(f)) }
+	put(xtcMagic)
+	put(uint32(h.natoms))
+	put(uint32(h.step))
+	putf(h.time)
+	for _, v := range h.box {
+		putf(v)
+	}
+	put(uint32(h.natoms))
+	if h.natoms <= 9 {
+		for _, v := range coords[:h.natoms*3] {
+			putf(v)
+		}
+		_, err := w.Write(buf)
+		return err
+	}
+	minint, maxint, smallidx, data, err := compress(coords[:h.natoms*3], precision)
+	if err != nil {
+		return err
+	}
+	putf(precision)
+	for _, v := range minint {
+		put(uint32(int32(v)))
+	}
+	for _, v := range maxint {
+		put(uint32(int32(v)))
+	}
+	put(uint32(smallidx))
+	put(uint32(len(data)))
+	buf = append(buf, data...)
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+//compress encodes the coordinates in ptr with the given precision. It returns
+//the limits of the scaled coordinates, the initial small index, and the compressed data.
+func compress(ptr []float32, precision float32) (minint, maxint [3]int, smallidx int, data []byte, err error) {
+	ncoord := len(ptr) / 3
+	ints := make([]int, len(ptr))
+	for i := 0; i < 3; i++ {
+		minint[i] = math.MaxInt32
+		maxint[i] = math.MinInt32
+	}
+	mindiff := math.MaxInt32
+	var oldlint [3]int
+	for i := 0; i < ncoord; i++ {
+		var lint [3]int
+		for j := 0; j < 3; j++ {
+			lf := ptr[i*3+j] * precision
+			if lf >= 0 {
+				lf += 0.5
+			} else {
+				lf -= 0.5
+			}
+			if lf > maxAbs || lf < -maxAbs {
+				return minint, maxint, 0, nil, fmt.Errorf("coordinates too large for XTC precision %f", precision)
+			}
+			lint[j] = int(int32(lf))
+			if lint[j] < minint[j] {
+				minint[j] = lint[j]
+			}
+			if lint[j] > maxint[j] {
+				maxint[j] = lint[j]
+			}
+			ints[i*3+j] = lint[j]
+		}
+		diff := iabs(oldlint[0]-lint[0]) + iabs(oldlint[1]-lint[1]) + iabs(oldlint[2]-lint[2])
+		if diff < mindiff && i > 0 {
+			mindiff = diff
+		}
+		oldlint = lint
+	}
+	for i := 0; i < 3; i++ {
+		if float32(maxint[i])-float32(minint[i]) >= maxAbs {
+			return minint, maxint, 0, nil, fmt.Errorf("coordinate range too large for XTC precision %f", precision)
+		}
+	}
+	sizeint, bitsizeint, bitsize := sizeInfo(minint, maxint)
+	smallidx = firstIdx
+	for smallidx < lastIdx-1 && int(magicInts[smallidx]) < mindiff {
+		smallidx++
+	}
+	initialidx := smallidx
+	maxidx := smallidx + 8
+	if maxidx > lastIdx-1 {
+		maxidx = lastIdx - 1
+	}
+	minidx := maxidx - 8
+	tmp := smallidx - 1
+	if tmp < firstIdx {
+		tmp = firstIdx
+	}
+	smaller := int(magicInts[tmp] / 2)
+	smallnum := int(magicInts[smallidx] / 2)
+	sizesmall := [3]uint32{magicInts[smallidx], magicInts[smallidx], magicInts[smallidx]}
+	larger := int(magicInts[maxidx] / 2)
+	b := &bitWriter{data: make([]byte, 0, len(ptr)*2)}
+	var prevcoord [3]int
+	tmpcoord := make([]uint32, 30)
+	prevrun := -1
+	i := 0
+	for i < ncoord {
+		isSmall := false
+		thiscoord := ints[i*3:]
+		var isSmaller int
+		if smallidx < maxidx && i >= 1 &&
+			iabs(thiscoord[0]-prevcoord[0]) < larger &&
+			iabs(thiscoord[1]-prevcoord[1]) < larger &&
+			iabs(thiscoord[2]-prevcoord[2]) < larger {
+			isSmaller = 1
+		} else if smallidx > minidx {
+			isSmaller = -1
+		}
+		if i+1 < ncoord {
+			if iabs(thiscoord[0]-thiscoord[3]) < smallnum &&
+				iabs(thiscoord[1]-thiscoord[4]) < smallnum &&
+				iabs(thiscoord[2]-thiscoord[5]) < smallnum {
+				//interchange the first and second atoms for better
+				//compression of water molecules.
+				thiscoord[0], thiscoord[3] = thiscoord[3], thiscoord[0]
+				thiscoord[1], thiscoord[4] = thiscoord[4], thiscoord[1]
+				thiscoord[2], thiscoord[5] = thiscoord[5], thiscoord[2]
+				isSmall = true
+			}
+		}
+		for j := 0; j < 3; j++ {
+			tmpcoord[j] = uint32(thiscoord[j] - minint[j])
+		}
+		if bitsize == 0 {
+			for j := 0; j < 3; j++ {
+				b.sendBits(bitsizeint[j], tmpcoord[j])
+			}
+		} else {
+			b.sendInts(bitsize, sizeint, tmpcoord)
+		}
+		copy(prevcoord[:], thiscoord[:3])
+		i++
+		run := 0
+		if !isSmall && isSmaller == -1 {
+			isSmaller = 0
+		}
+		for isSmall && run < 8*3 {
+			thiscoord = ints[i*3:]
+			tmpsum := 0
+			for j := 0; j < 3; j++ {
+				t := thiscoord[j] - prevcoord[j]
+				tmpsum += t * t
+			}
+			if isSmaller == -1 && tmpsum >= smaller*smaller {
+				isSmaller = 0
+			}
+			for j := 0; j < 3; j++ {
+				tmpcoord[run] = uint32(thiscoord[j] - prevcoord[j] + smallnum)
+				run++
+			}
+			copy(prevcoord[:], thiscoord[:3])
+			i++
+			isSmall = false
+			if i < ncoord {
+				next := ints[i*3:]
+				if iabs(next[0]-prevcoord[0]) < smallnum &&
+					iabs(next[1]-prevcoord[1]) < smallnum &&
+					iabs(next[2]-prevcoord[2]) < smallnum {
+					isSmall = true
+				}
+			}
+		}
+		if run != prevrun || isSmaller != 0 {
+			prevrun = run
+			b.sendBits(1, 1) //flag the change in run-length
+			b.sendBits(5, uint32(run+isSmaller+1))
+		} else {
+			b.sendBits(1, 0) //the run-length did not change
+		}
+		for k := 0; k < run; k += 3 {
+			b.sendInts(smallidx, sizesmall, tmpcoord[k:k+3])
+		}
+		if isSmaller != 0 {
+			smallidx += isSmaller
+			if isSmaller < 0 {
+				smallnum = smaller
+				smaller = int(magicInts[smallidx-1] / 2)
+			} else {
+				smaller = smallnum
+				smallnum = int(magicInts[smallidx] / 2)
+			}
+			sizesmall[0], sizesmall[1], sizesmall[2] = magicInts[smallidx], magicInts[smallidx], magicInts[smallidx]
+		}
+	}
+	return minint, maxint, initialidx, b.bytes(), nil
+}
@@ -27,19 +27,15 @@
 
 package xtc
 
-// #cgo CFLAGS:  -I.
-// #cgo LDFLAGS:  -L.  -lnsl -lm   -lxdrfile
-//#include <xtc.h>
-//#include <stdio.h>
-//#include <stdlib.h>
-//#include <xdrfile_xtc.h>
-//#include <xdrfile_trr.h>
-//#include <xdrfile.h>
-import "C"
 import (
+	"bufio"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"os"
 	"runtime"
 
+	chem "github.com/rmera/gochem"
 	v3 "github.com/rmera/gochem/v3"
 )
 
@@ -48,10 +44,15 @@ type XTCObj struct {
 	readable   bool
 	natoms     int
 	filename   string
-	fp         *C.XDRFILE //pointer to the XDRFILE
-	goCoords   []float64
-	concBuffer [][]C.float
-	cCoords    []C.float
+	file       *os.File  //nil if the file is compressed
+	src        io.Closer //the file, or the reader for the compressed file
+	xtc        *bufio.Reader
+	header     frameHeader
+	current    int     //the index of the next frame to be read
+	offsets    []int64 //the offsets of the frames found so far
+	indexed    bool    //true if offsets contains all the frames in the file
+	concBuffer [][]float32
+	cCoords    []float32
 	buffSize   int
 }
 
@@ -67,6 +68,22 @@ func New(filename string) (*XTCObj, error) {
 
 }
 
+//The XTC format is registered so chem.OpenTraj can read XTC files.
+func init() {
+	chem.RegisterFormat(&chem.FileFormat{Name: "xtc", Extensions: []string{"xtc"},
+		Magic: func(header []byte) bool {
+			return len(header) >= 4 && int32(binary.BigEndian.Uint32(header)) == xtcMagic
+		},
+		Streams: true,
+		OpenTraj: func(name string, top chem.Atomer) (chem.Traj, error) {
+			traj, err := New(name)
+			if err != nil {
+				return nil, err
+			}
+			return traj, nil
+		}})
+}
+
 //Readable returns true if the object is ready to be read from
 //false otherwise. IT doesnt guarantee that there is something
 //to read.
@@ -78,29 +95,90 @@ func (X *XTCObj) Readable() bool {
 }
 
 //InitRead initializes a XTCObj for reading.
-//It requires only the filename, which must be valid
+//It requires only the filename, which must be valid. If the file
+//doesn't start with the XTC magic number, it is read as a compressed file.
 func (X *XTCObj) initRead(name string) error {
-	Cfilename := C.CString(name)
-	Cnatoms := C.read_natoms(Cfilename)
-	X.natoms = int(Cnatoms)
-	totalcoords := X.natoms * 3
-	X.fp = C.openfile(Cfilename)
-	if X.fp == nil {
-		return Error{UnableToOpen, X.filename, []string{"initRead"}, true}
+	var err error
+	X.filename = name
+	X.file, err = os.Open(name)
+	if err != nil {
+		return Error{UnableToOpen, X.filename, []string{"os.Open", "initRead"}, true}
+	}
+	X.src = X.file
+	X.xtc = bufio.NewReader(X.file)
+	if head, err := X.xtc.Peek(4); err != nil || int32(binary.BigEndian.Uint32(head)) != xtcMagic {
+		//Compressed files are uncompressed as they are read, so they can't be seeked.
+		X.file.Close()
+		X.file = nil
+		r, err := chem.OpenCompressed(name)
+		if err != nil {
+			return Error{UnableToOpen + ": " + err.Error(), X.filename, []string{"chem.OpenCompressed", "initRead"}, true}
+		}
+		X.src = r
+		X.xtc = bufio.NewReader(r)
+	}
+	//We peek at the first header to get the number of atoms, without
+	//consuming it.
+	head, err := X.xtc.Peek(8)
+	if err != nil {
+		X.src.Close()
+		return Error{UnableToOpen + ": " + err.Error(), X.filename, []string{"initRead"}, true}
+	}
+	magic := int32(binary.BigEndian.Uint32(head[:4]))
+	if magic != xtcMagic {
+		X.src.Close()
+		return Error{WrongFormat + ": Wrong magic number", X.filename, []string{"initRead"}, true}
 	}
+	X.natoms = int(int32(binary.BigEndian.Uint32(head[4:8])))
+	if X.natoms <= 0 {
+		X.src.Close()
+		return Error{WrongFormat, X.filename, []string{"initRead"}, true}
+	}
+	totalcoords := X.natoms * 3
 	//The idea is to reserve less memory, using the same buffer many times.
-	//X.goCoords = make([]float64, totalcoords, totalcoords)
-	X.cCoords = make([]C.float, totalcoords, totalcoords)
+	X.cCoords = make([]float32, totalcoords, totalcoords)
+	X.offsets = []int64{0}
 	X.concBuffer = append(X.concBuffer, X.cCoords)
 	X.buffSize = 1
 	//This should close the file.
 	runtime.SetFinalizer(X, func(X *XTCObj) {
-		C.xtc_close(X.fp)
+		X.src.Close()
 	})
 	X.readable = true
 	return nil
 }
 
+//nextRaw reads the next frame into buffer, in nm. If buffer is nil
+//the frame is read but not decompressed.
+//It returns a lastFrameError if there are no more frames to read.
+func (X *XTCObj) nextRaw(buffer []float32, caller string) error {
+	err := readHeader(X.xtc, &X.header)
+	if err == io.EOF {
+		X.readable = false
+		X.indexed = true
+		return newlastFrameError(X.filename, caller) //This is not really an error and should be catched in the calling function
+	}
+	if err != nil {
+		X.readable = false
+		return Error{ReadError + ": " + err.Error(), X.filename, []string{"readHeader", caller}, true}
+	}
+	if X.header.natoms != X.natoms {
+		X.readable = false
+		return Error{WrongFormat + ": Inconsistent number of atoms", X.filename, []string{caller}, true}
+	}
+	_, size, err := readCoords(X.xtc, X.natoms, buffer)
+	if err != nil {
+		X.readable = false
+		return Error{ReadError + ": " + err.Error(), X.filename, []string{"readCoords", caller}, true}
+	}
+	//The frame index is built as we read.
+	if X.current == len(X.offsets)-1 {
+		X.offsets = append(X.offsets, X.offsets[X.current]+headerSize+size)
+	}
+	X.current++
+	return nil
+}
+
 //Next Reads the next frame in a XTCObj that has been initialized for read
 //With initread. If keep is true, returns a pointer to matrix.DenseMatrix
 //With the coordinates read, otherwiser, it discards the coordinates and
@@ -109,30 +187,23 @@ func (X *XTCObj) Next(output *v3.Matrix) error {
 	if !X.Readable() {
 		return Error{TrajUnIni, X.filename, []string{"Next"}, true}
 	}
-	cnatoms := C.int(X.natoms)
-	worked := C.get_coords(X.fp, &X.cCoords[0], cnatoms)
-	if worked == 11 {
-		X.readable = false
-		return newlastFrameError(X.filename, "Next") //This is not really an error and should be catched in the calling function
+	if output == nil {
+		return X.nextRaw(nil, "Next") //Just drop the frame
 	}
-	if worked != 0 {
-		X.readable = false
-		return Error{ReadError, X.filename, []string{"Next"}, true}
+	if err := X.nextRaw(X.cCoords, "Next"); err != nil {
+		return err
 	}
-	if output != nil { //col the frame
-		r, c := output.Dims()
-		if r < (X.natoms) {
-			panic("Buffer v3.Matrix too small to hold trajectory frame")
-		}
-		for j := 0; j < r; j++ {
-			for k := 0; k < c; k++ {
-				l := k + (3 * j)
-				output.Set(j, k, (10 * float64(X.cCoords[l]))) //nm to Angstroms
-			}
+	r, c := output.Dims()
+	if r < (X.natoms) {
+		panic("Buffer v3.Matrix too small to hold trajectory frame")
+	}
+	for j := 0; j < X.natoms; j++ {
+		for k := 0; k < c; k++ {
+			l := k + (3 * j)
+			output.Set(j, k, (10 * float64(X.cCoords[l]))) //nm to Angstroms
 		}
-		return nil
 	}
-	return nil //Just drop the frame
+	return nil
 }
 
 //SetConcBuffer
@@ -149,7 +220,7 @@ func (X *XTCObj) setConcBuffer(batchsize int) error {
 		return nil
 	}
 	for i := 0; i < batchsize-l; i++ {
-		tmp := make([]C.float, X.Len()*3)
+		tmp := make([]float32, X.Len()*3)
 		X.concBuffer = append(X.concBuffer, tmp)
 	}
 	X.buffSize = batchsize
@@ -168,24 +239,23 @@ func (X *XTCObj) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
 		return nil, Error{TrajUnIni, X.filename, []string{"NextConc"}, true}
 	}
 	framechans := make([]chan *v3.Matrix, len(frames)) //the slice of chans that will be returned
-	cnatoms := C.int(X.natoms)
 	used := false
 	for key, val := range frames {
-		//cCoords:=X.concBuffer[key]
-		worked := C.get_coords(X.fp, &X.concBuffer[key][0], cnatoms)
+		var buffer []float32
+		if val != nil {
+			buffer = X.concBuffer[key]
+		}
+		err := X.nextRaw(buffer, "NextConc")
 		//Error handling
-		if worked == 11 {
+		if _, ok := err.(*lastFrameError); ok {
 			if used == false {
-				X.readable = false
-				return nil, newlastFrameError(X.filename, "NextConc") //This is not really an error and
+				return nil, err //This is not really an error and
 			} else { //should be catched in the calling function
-				X.readable = false
-				return framechans, newlastFrameError(X.filename, "NextConc") //same
+				return framechans, err //same
 			}
 		}
-		if worked != 0 {
-			X.readable = false
-			return nil, Error{ReadError, X.filename, []string{"NextConc"}, true}
+		if err != nil {
+			return nil, err
 		}
 		if val == nil {
 			framechans[key] = nil //ignored frame
@@ -194,9 +264,9 @@ func (X *XTCObj) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
 		used = true
 		framechans[key] = make(chan *v3.Matrix)
 		//Now the parallel part
-		go func(natoms int, cCoords []C.float, goCoords *v3.Matrix, pipe chan *v3.Matrix) {
-			r, c := goCoords.Dims()
-			for j := 0; j < r; j++ {
+		go func(natoms int, cCoords []float32, goCoords *v3.Matrix, pipe chan *v3.Matrix) {
+			_, c := goCoords.Dims()
+			for j := 0; j < natoms; j++ {
 				for k := 0; k < c; k++ {
 					l := k + (3 * j)
 					goCoords.Set(j, k, (10 * float64(cCoords[l]))) //nm to Angstroms
@@ -208,6 +278,96 @@ func (X *XTCObj) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
 	return framechans, nil
 }
 
+//buildIndex finds the offsets of all the frames in the trajectory that have not
+//been found yet. Only the frame headers are read, so this is rather fast.
+//If the file ends with an incomplete frame, that frame is ignored.
+func (X *XTCObj) buildIndex() error {
+	for !X.indexed {
+		last := X.offsets[len(X.offsets)-1]
+		size, err := frameSize(X.file, last)
+		if err == io.EOF {
+			X.indexed = true
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			X.indexed = true //a truncated last frame, which we ignore.
+			break
+		}
+		if err != nil {
+			return Error{ReadError + ": " + err.Error(), X.filename, []string{"frameSize", "buildIndex"}, true}
+		}
+		X.offsets = append(X.offsets, last+size)
+	}
+	return nil
+}
+
+//NFrames returns the number of frames in the trajectory. The first time it is
+//called (unless the whole trajectory has been read already) it needs to scan the
+//file to find all the frames. For compressed files, the frames can't be counted
+//without reading them, so it returns -1.
+func (X *XTCObj) NFrames() int {
+	if X.file == nil {
+		return -1
+	}
+	X.buildIndex() //on error, we just return the frames found until then.
+	return len(X.offsets) - 1
+}
+
+//Seek sets the trajectory so the next call to Next or NextConc
+//will read the frame with the given index (starting from 0).
+//It returns an error for compressed files.
+func (X *XTCObj) Seek(frame int) error {
+	if X.file == nil {
+		return Error{"Compressed trajectories can't be seeked", X.filename, []string{"Seek"}, true}
+	}
+	if frame >= len(X.offsets)-1 {
+		if err := X.buildIndex(); err != nil {
+			return errDecorate(err, "Seek")
+		}
+	}
+	if frame < 0 || frame >= len(X.offsets)-1 {
+		return Error{fmt.Sprintf("Frame %d out of range", frame), X.filename, []string{"Seek"}, true}
+	}
+	if _, err := X.file.Seek(X.offsets[frame], io.SeekStart); err != nil {
+		return Error{err.Error(), X.filename, []string{"os.File.Seek", "Seek"}, true}
+	}
+	X.xtc.Reset(X.file)
+	X.current = frame
+	X.readable = true
+	return nil
+}
+
+//CurrentFrame returns the index of the next frame to be read.
+func (X *XTCObj) CurrentFrame() int {
+	return X.current
+}
+
+//Box returns the periodic box of the last frame read, or nil if the frame
+//has no box.
+func (X *XTCObj) Box() *chem.Box {
+	vecs := v3.Zeros(3)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			vecs.Set(i, j, 10*float64(X.header.box[i*3+j])) //nm to A
+		}
+	}
+	box, err := chem.NewBox(vecs)
+	if err != nil {
+		return nil //A box of zeros means no box.
+	}
+	return box
+}
+
+//Time returns the simulation time, in ps, of the last frame read.
+func (X *XTCObj) Time() float64 {
+	return float64(X.header.time)
+}
+
+//Step returns the simulation step of the last frame read.
+func (X *XTCObj) Step() int {
+	return X.header.step
+}
+
 //Len returns the number of atoms per frame in the XTCObj.
 //XTCObj must be initialized. 0 means an uninitialized object.
 func (X *XTCObj) Len() int {
@@ -216,6 +376,15 @@ func (X *XTCObj) Len() int {
 
 //Errors
 
+//errDecorate is a helper function that asserts that the error is
+//implements chem.Error and decorates the error with the caller's name before returning it.
+//if used with a non-chem.Error error, it will cause a panic.
+func errDecorate(err error, caller string) error {
+	err2 := err.(chem.Error) //I know that is the type returned byt initRead
+	err2.Decorate(caller)
+	return err2
+}
+
 //Error is an error with xtb trajectories, compatible with goChem
 type Error struct {
 	message  string
@@ -248,6 +417,7 @@ const (
 	TrajUnIni    = "Traj object uninitialized to read"
 	ReadError    = "Error reading frame"
 	UnableToOpen = "Unable to open file"
+	WrongFormat  = "Wrong format in the XTC file or frame"
 	EOF          = "EOF"
 )
 
@@ -26,10 +26,18 @@
 
 package xtc
 
-import "fmt"
-import "testing"
-import "github.com/rmera/gochem"
-import "github.com/rmera/gochem/v3"
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rmera/gochem"
+	"github.com/rmera/gochem/v3"
+)
 
 /*TestXTC reads the frames of the test xtc file using the
  * "interactive" or "low level" functions, i.e. one frame at a time
@@ -145,3 +153,374 @@ func SecondRow(channelin, channelout chan *v3.Matrix, current, other int) {
 	}
 	return
 }
+
+//The writers must be usable with chem.TrajConvert.
+var _ chem.BoxTrajWriter = (*XTCWObj)(nil)
+
+//waterBox returns the coordinates, in A, for nwat water-like
+//triads randomly placed in a box of side side.
+func waterBox(nwat int, side float64) *v3.Matrix {
+	coords := v3.Zeros(nwat * 3)
+	for i := 0; i < nwat; i++ {
+		o := []float64{rand.Float64() * side, rand.Float64() * side, rand.Float64() * side}
+		for j := 0; j < 3; j++ {
+			coords.Set(i*3, j, o[j])
+			coords.Set(i*3+1, j, o[j]+rand.Float64()-0.5)
+			coords.Set(i*3+2, j, o[j]+rand.Float64()-0.5)
+		}
+	}
+	return coords
+}
+
+//xtcWriteRead writes frames to an XTC file, reads them back and checks that
+//the read coordinates are equal to the written ones within tol.
+func xtcWriteRead(Te *testing.T, name string, frames []*v3.Matrix, tol float64) {
+	natoms := frames[0].NVecs()
+	w, err := NewWriter(name, natoms)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for _, v := range frames {
+		if err := w.WNext(v); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if traj.Len() != natoms {
+		Te.Fatalf("Wrong number of atoms read: %d, expected %d", traj.Len(), natoms)
+	}
+	read := v3.Zeros(natoms)
+	for i := 0; ; i++ {
+		err := traj.Next(read)
+		if err != nil {
+			if _, ok := err.(chem.LastFrameError); ok {
+				if i != len(frames) {
+					Te.Errorf("Read %d frames, wrote %d", i, len(frames))
+				}
+				break
+			}
+			Te.Fatal(err)
+		}
+		for j := 0; j < natoms; j++ {
+			for k := 0; k < 3; k++ {
+				if d := math.Abs(read.At(j, k) - frames[i].At(j, k)); d > tol {
+					Te.Fatalf("Frame %d, atom %d: read %v, wrote %v", i, j, read.VecView(j), frames[i].VecView(j))
+				}
+			}
+		}
+	}
+}
+
+//TestXTCWrite writes and reads back  compressed (many atoms) and
+//uncompressed (few atoms) trajectories.
+func TestXTCWrite(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "goxtc")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	frames := make([]*v3.Matrix, 0, 5)
+	for i := 0; i < 5; i++ {
+		frames = append(frames, waterBox(300, 40))
+	}
+	//an atom far away from the rest forces the use of large sizes.
+	frames[4].Set(0, 0, 90000)
+	xtcWriteRead(Te, filepath.Join(dir, "water.xtc"), frames, 0.0101)
+	small := []*v3.Matrix{waterBox(3, 10), waterBox(3, 10)}
+	xtcWriteRead(Te, filepath.Join(dir, "small.xtc"), small, 1e-4)
+}
+
+//TestXTCRoundTrip reads the test trajectory, writes it back and
+//checks that both trajectories are identical.
+func TestXTCRoundTrip(Te *testing.T) {
+	if _, err := os.Stat("../test/test.xtc"); err != nil {
+		Te.Skip("test trajectory not available")
+	}
+	traj, err := New("../test/test.xtc")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	frames := make([]*v3.Matrix, 0, 10)
+	for {
+		c := v3.Zeros(traj.Len())
+		if err := traj.Next(c); err != nil {
+			if _, ok := err.(chem.LastFrameError); ok {
+				break
+			}
+			Te.Fatal(err)
+		}
+		frames = append(frames, c)
+	}
+	dir, err := ioutil.TempDir("", "goxtc")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	xtcWriteRead(Te, filepath.Join(dir, "test.xtc"), frames, 1e-5)
+}
+
+//TestXTCSeek checks random access to the frames of a trajectory,
+//both using an index built while reading and one built on demand.
+func TestXTCSeek(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "goxtc")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "seek.xtc")
+	nframes := 7
+	frames := make([]*v3.Matrix, 0, nframes)
+	w, err := NewWriter(name, 90)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for i := 0; i < nframes; i++ {
+		frames = append(frames, waterBox(30, 20))
+		if err := w.WNext(frames[i]); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	check := func(traj *XTCObj, f int) {
+		c := v3.Zeros(traj.Len())
+		if err := traj.Seek(f); err != nil {
+			Te.Fatal(err)
+		}
+		if err := traj.Next(c); err != nil {
+			Te.Fatal(err)
+		}
+		for j := 0; j < traj.Len(); j++ {
+			for k := 0; k < 3; k++ {
+				if d := math.Abs(c.At(j, k) - frames[f].At(j, k)); d > 0.0101 {
+					Te.Fatalf("Frame %d, atom %d: read %v, wrote %v", f, j, c.VecView(j), frames[f].VecView(j))
+				}
+			}
+		}
+		if traj.CurrentFrame() != f+1 || traj.Step() != f || traj.Time() != float64(f) {
+			Te.Errorf("Wrong frame information for frame %d: current %d, step %d, time %f", f, traj.CurrentFrame(), traj.Step(), traj.Time())
+		}
+	}
+	//index built on demand
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if n := traj.NFrames(); n != nframes {
+		Te.Fatalf("NFrames returned %d, expected %d", n, nframes)
+	}
+	for _, f := range []int{4, 0, 6, 2} {
+		check(traj, f)
+	}
+	//index built while reading
+	traj, err = New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for {
+		if err := traj.Next(nil); err != nil {
+			if _, ok := err.(chem.LastFrameError); ok {
+				break
+			}
+			Te.Fatal(err)
+		}
+	}
+	if n := traj.NFrames(); n != nframes {
+		Te.Fatalf("NFrames returned %d, expected %d", n, nframes)
+	}
+	check(traj, 5)
+	check(traj, 1)
+	if err := traj.Seek(nframes); err == nil {
+		Te.Errorf("Seeking out of range should fail")
+	}
+}
+
+//TestXTCBox checks that the periodic box is written and read back.
+func TestXTCBox(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "goxtc")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "box.xtc")
+	box, err := chem.NewBoxFromParams(30, 35, 40, 90, 90, 60)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	w, err := NewWriter(name, 30)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if err := w.WNextBox(waterBox(10, 20), box); err != nil {
+		Te.Fatal(err)
+	}
+	if err := w.WNext(waterBox(10, 20)); err != nil {
+		Te.Fatal(err)
+	}
+	w.Close()
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if err := traj.Next(nil); err != nil {
+		Te.Fatal(err)
+	}
+	read := traj.Box()
+	if read == nil {
+		Te.Fatal("No box read")
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if math.Abs(read.At(i, j)-box.At(i, j)) > 1e-4 {
+				Te.Fatalf("Wrong box read:\n%v\nexpected\n%v", read, box)
+			}
+		}
+	}
+	if err := traj.Next(nil); err != nil {
+		Te.Fatal(err)
+	}
+	if traj.Box() != nil {
+		Te.Errorf("Read a box from a frame without one")
+	}
+}
+
+//TestXTCCompressed checks that compressed trajectories are read by chem.OpenTraj
+//as they are uncompressed, without a temporary file.
+func TestXTCCompressed(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "goxtc")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "comp.xtc")
+	frames := make([]*v3.Matrix, 0, 3)
+	w, err := NewWriter(name, 90)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		frames = append(frames, waterBox(30, 20))
+		if err := w.WNext(frames[i]); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	gz, err := chem.CreateCompressed(name + ".gz")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	gz.Write(data)
+	gz.Close()
+	//Any temporary file would end up here.
+	tmp := filepath.Join(dir, "tmp")
+	os.Mkdir(tmp, 0755)
+	oldtmp := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", tmp)
+	defer os.Setenv("TMPDIR", oldtmp)
+	t, err := chem.OpenTraj(name+".gz", nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir(tmp); len(files) != 0 {
+		Te.Errorf("A temporary file was created for a compressed XTC file")
+	}
+	traj := t.(*XTCObj)
+	if traj.filename != name+".gz" {
+		Te.Errorf("The trajectory was read from %s, not from the compressed file", traj.filename)
+	}
+	if traj.NFrames() != -1 || traj.Seek(0) == nil {
+		Te.Errorf("Compressed trajectories should not be seekable")
+	}
+	c := v3.Zeros(traj.Len())
+	for i := 0; ; i++ {
+		if err := traj.Next(c); err != nil {
+			if _, ok := err.(chem.LastFrameError); ok && i == len(frames) {
+				break
+			}
+			Te.Fatalf("Frame %d: %v", i, err)
+		}
+		for j := 0; j < traj.Len(); j++ {
+			for k := 0; k < 3; k++ {
+				if d := math.Abs(c.At(j, k) - frames[i].At(j, k)); d > 0.0101 {
+					Te.Fatalf("Frame %d, atom %d: read %v, wrote %v", i, j, c.VecView(j), frames[i].VecView(j))
+				}
+			}
+		}
+	}
+}
+
+//TestXTCSparse writes frames where consecutive atoms are further apart than the
+//largest of the magic integers used by the compression algorithm.
+func TestXTCSparse(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "goxtc")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	frames := make([]*v3.Matrix, 2)
+	for f := range frames {
+		frames[f] = v3.Zeros(20)
+		for i := 0; i < 20; i++ {
+			//20000 nm between consecutive atoms, in A.
+			frames[f].Set(i, 0, float64(i%2)*200000+float64(f))
+			frames[f].Set(i, 1, float64(i)*10)
+			frames[f].Set(i, 2, float64((i+1)%2)*200000)
+		}
+	}
+	xtcWriteRead(Te, filepath.Join(dir, "sparse.xtc"), frames, 0.05)
+}
+
+//TestXTCStepPrecision checks that the step, time and precision given are written.
+func TestXTCStepPrecision(Te *testing.T) {
+	dir, err := ioutil.TempDir("", "goxtc")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "step.xtc")
+	w, err := NewWriter(name, 30)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if err := w.SetPrecision(0); err == nil {
+		Te.Error("A zero precision should give an error")
+	}
+	if err := w.SetPrecision(100); err != nil {
+		Te.Fatal(err)
+	}
+	frames := []*v3.Matrix{waterBox(10, 20), waterBox(10, 20)}
+	for i, f := range frames {
+		if err := w.WNextStep(f, nil, 500*(i+1), 2.5*float64(i+1)); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	c := v3.Zeros(30)
+	for i, f := range frames {
+		if err := traj.Next(c); err != nil {
+			Te.Fatal(err)
+		}
+		if traj.Step() != 500*(i+1) || traj.Time() != 2.5*float64(i+1) {
+			Te.Errorf("Wrong step or time for frame %d: %d %f", i, traj.Step(), traj.Time())
+		}
+		for j := 0; j < 30; j++ {
+			for k := 0; k < 3; k++ {
+				//0.01 nm precision means errors of up to 0.05 A.
+				if d := math.Abs(c.At(j, k) - f.At(j, k)); d > 0.0501 {
+					Te.Fatalf("Frame %d, atom %d: read %v, wrote %v", i, j, c.VecView(j), f.VecView(j))
+				}
+			}
+		}
+	}
+}
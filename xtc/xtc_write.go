@@ -0,0 +1,149 @@
+/*
+ * xtc_write.go, part of gochem
+ *
+ * Copyright 2021 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ *
+ */
+
+package xtc
+
+import (
+	"os"
+	"runtime"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+//The default precision used by GROMACS
+const defaultPrecision float32 = 1000
+
+//XTCWObj is a container for a GROMACS XTC binary trajectory file
+//opened for writing
+type XTCWObj struct {
+	natoms    int
+	writable  bool //Is it ready to be written on
+	filename  string
+	frames    int
+	precision float32
+	xtc       *os.File //The XTC file
+	cCoords   []float32
+}
+
+//NewWriter initializes a XTC trajectory for writing.
+func NewWriter(filename string, natoms int) (*XTCWObj, error) {
+	traj := new(XTCWObj)
+	traj.natoms = natoms
+	traj.filename = filename
+	if natoms <= 0 {
+		return nil, Error{"Trajectory not initialized correctly, the number of atoms must be positive", filename, []string{"NewWriter"}, true}
+	}
+	var err error
+	traj.xtc, err = os.Create(filename)
+	if err != nil {
+		return nil, Error{UnableToOpen + ": " + err.Error(), filename, []string{"os.Create", "NewWriter"}, true}
+	}
+	traj.precision = defaultPrecision
+	traj.cCoords = make([]float32, natoms*3, natoms*3)
+	runtime.SetFinalizer(traj, func(X *XTCWObj) {
+		X.xtc.Close()
+	})
+	traj.writable = true
+	return traj, nil
+}
+
+//Len returns the number of atoms per frame in the XTCWObj.
+func (X *XTCWObj) Len() int {
+	return X.natoms
+}
+
+//SetPrecision sets the precision for the frames written after the call, as the number of
+//intervals per nm (1000, the GROMACS default, means 0.001 nm). Lower values give smaller files.
+func (X *XTCWObj) SetPrecision(precision float64) error {
+	if precision <= 0 {
+		return Error{"The precision must be positive", X.filename, []string{"SetPrecision"}, true}
+	}
+	X.precision = float32(precision)
+	return nil
+}
+
+//WNext writes the next frame to the trajectory.
+//The coordinates are expected in A, and will be written
+//in nm, with the precision set (by default, the GROMACS default of 0.001 nm).
+//The frame index is used as step, and as time, in ps.
+func (X *XTCWObj) WNext(towrite *v3.Matrix) error {
+	if err := X.WNextBox(towrite, nil); err != nil {
+		return errDecorate(err, "WNext")
+	}
+	return nil
+}
+
+//WNextBox writes the next frame to the trajectory, with the periodic
+//box box, which can be nil. Otherwise, it is equivalent to WNext.
+func (X *XTCWObj) WNextBox(towrite *v3.Matrix, box *chem.Box) error {
+	if err := X.WNextStep(towrite, box, X.frames, float64(X.frames)); err != nil {
+		return errDecorate(err, "WNextBox")
+	}
+	return nil
+}
+
+//WNextStep writes the next frame to the trajectory, with the periodic box box,
+//which can be nil, and the given simulation step and time, in ps.
+//Otherwise, it is equivalent to WNext.
+func (X *XTCWObj) WNextStep(towrite *v3.Matrix, box *chem.Box, step int, time float64) error {
+	if !X.writable {
+		return Error{TrajUnIni, X.filename, []string{"WNextStep"}, true}
+	}
+	if towrite == nil {
+		return Error{"got nil coordinates", X.filename, []string{"WNextStep"}, true}
+	}
+	if towrite.NVecs() != X.natoms {
+		return Error{"Coordinates don't match the trajectory size", X.filename, []string{"WNextStep"}, true}
+	}
+	for j := 0; j < X.natoms; j++ {
+		for k := 0; k < 3; k++ {
+			X.cCoords[j*3+k] = float32(0.1 * towrite.At(j, k)) //A to nm
+		}
+	}
+	h := &frameHeader{natoms: X.natoms, step: step, time: float32(time)}
+	if box != nil {
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				h.box[i*3+j] = float32(0.1 * box.At(i, j)) //A to nm
+			}
+		}
+	}
+	if err := writeFrame(X.xtc, h, X.cCoords, X.precision); err != nil {
+		return Error{err.Error(), X.filename, []string{"writeFrame", "WNextStep"}, true}
+	}
+	X.frames++
+	return nil
+}
+
+//Close closes the file associated to the trajectory. After
+//closing, the object can't be written to anymore.
+func (X *XTCWObj) Close() error {
+	if !X.writable {
+		return nil
+	}
+	X.writable = false
+	if err := X.xtc.Close(); err != nil {
+		return Error{err.Error(), X.filename, []string{"os.File.Close", "Close"}, true}
+	}
+	return nil
+}